@@ -4,12 +4,14 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/tls"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"sort"
@@ -25,17 +27,19 @@ import (
 )
 
 const (
-	maxOperationsPerRoom   = 1000             // Maximum operations to keep per room
-	maxChatMessagesPerRoom = 100              // Maximum chat messages to keep per room
-	roomCleanupInterval    = 30 * time.Minute // Check for empty rooms every 30 minutes
-	userTimeout            = 24 * time.Hour   // Remove inactive users after 24 hours
-	inviteTimeout          = 30 * time.Second // Pending invites expire after 30 seconds
-	defaultJWTExpiry       = 24 * time.Hour
+	maxOperationsPerRoom   = 1000                // Maximum operations to keep per room
+	maxChatMessagesPerRoom = 100                 // Maximum chat messages to keep per room
+	roomCleanupInterval    = 30 * time.Minute    // Check for empty rooms every 30 minutes
+	userTimeout            = 24 * time.Hour      // Remove users that stay offline for 24 hours
+	inviteTimeout          = 30 * time.Second    // Pending invites expire after 30 seconds
+	accessTokenExpiry      = 15 * time.Minute    // Access tokens are renewed through /api/auth/refresh
+	refreshTokenExpiry     = 30 * 24 * time.Hour // Sessions idle longer than this must log in again
 )
 
-// JWTClaims captures the authenticated user ID for HMAC tokens.
+// JWTClaims captures the authenticated user and session for HMAC tokens.
 type JWTClaims struct {
-	UserID string `json:"uid"`
+	UserID    string `json:"uid"`
+	SessionID string `json:"sid"`
 	jwt.RegisteredClaims
 }
 
@@ -61,11 +65,17 @@ func (a *App) initializeMode(mode string) error {
 			IsOnline: true,
 		}
 
+		if a.dataDir != "" {
+			if err := a.enablePersistence(); err != nil {
+				fmt.Printf("Failed to enable persistence in %s: %v\n", a.dataDir, err)
+			}
+		}
+
 		// Start HTTP server for central server functionality
 		a.StartHTTPServer("8080")
 
 		// Register zeroconf service for discovery
-		server, err := zeroconf.Register("GoTeamWork", "_http._tcp", "local.", 8080, []string{"version=1.0"}, nil)
+		server, err := zeroconf.Register(zeroconfInstance, zeroconfService, zeroconfDomain, 8080, a.zeroconfText(), nil)
 		if err != nil {
 			fmt.Printf("Failed to register zeroconf service: %v\n", err)
 		} else {
@@ -78,6 +88,7 @@ func (a *App) initializeMode(mode string) error {
 	} else if a.Mode == "client" {
 		// Initialize network client for client mode (URL will be set when user connects)
 		a.networkClient = NewNetworkClient("")
+		a.initOutbox(a.ctx)
 		fmt.Println("Client mode initialized. Please enter server address to connect.")
 	}
 
@@ -89,11 +100,20 @@ func NewHistoryPool() *HistoryPool {
 	return &HistoryPool{
 		operations: make(map[string][]*Operation),
 		counter:    0,
+		index:      newSearchIndex(),
+		refs:       newOpIndex(),
+		limits:     make(map[string]int),
 	}
 }
 
 // AddOperation adds an operation to the history pool with size limits
 func (hp *HistoryPool) AddOperation(roomID string, opType OperationType, itemID string, item *Item, userID, userName string) *Operation {
+	op, _ := hp.addOperation(roomID, opType, itemID, item, userID, userName)
+	return op
+}
+
+// addOperation appends an operation and reports whether the room was compacted as a result.
+func (hp *HistoryPool) addOperation(roomID string, opType OperationType, itemID string, item *Item, userID, userName string) (*Operation, bool) {
 	hp.mu.Lock()
 	defer hp.mu.Unlock()
 
@@ -127,26 +147,31 @@ func (hp *HistoryPool) AddOperation(roomID string, opType OperationType, itemID
 
 	// Add operation to room history
 	hp.operations[roomID] = append(hp.operations[roomID], op)
+	hp.refs.add(roomID, op, len(hp.operations[roomID])-1)
+	hp.index.apply(roomID, op)
 
 	// Enforce size limits
-	hp.enforceLimits(roomID)
+	compacted := hp.enforceLimits(roomID)
 
-	return op
+	return op, compacted
 }
 
-// enforceLimits ensures room operation counts stay within limits
-func (hp *HistoryPool) enforceLimits(roomID string) {
+// enforceLimits ensures room operation counts stay within limits by folding the
+// oldest operations into a snapshot of the state they produced
+func (hp *HistoryPool) enforceLimits(roomID string) bool {
 	ops := hp.operations[roomID]
-	if len(ops) <= maxOperationsPerRoom {
-		return
+	limit := hp.operationLimitLocked(roomID)
+	if len(ops) <= limit {
+		return false
 	}
 
-	// Keep only the most recent operations
-	keepStart := len(ops) - maxOperationsPerRoom
-	hp.operations[roomID] = ops[keepStart:]
+	compacted := hp.compactLocked(roomID, compactionTail(limit))
+	// Snapshots cap chat history, so items may have dropped out of the live set
+	hp.index.rebuild(roomID, hp.operations[roomID])
 
-	fmt.Printf("Trimmed operations for room %s to %d (removed %d old operations)\n",
-		roomID, maxOperationsPerRoom, keepStart)
+	fmt.Printf("Compacted %d old operations for room %s into a snapshot (%d operations retained)\n",
+		compacted, roomID, len(hp.operations[roomID]))
+	return compacted > 0
 }
 
 // computeOperationHash builds a stable hash for an operation to support incremental sync.
@@ -208,6 +233,12 @@ func buildItemFingerprint(item *Item) interface{} {
 			Timestamp: v.Timestamp,
 		}
 	case *clip_helper.ClipboardItem:
+		text := v.Text
+		if v.Type == clip_helper.ClipboardFile {
+			// File items rewrite Text with upload status once the archive lands,
+			// so it stays out of the fingerprint to keep stored hashes verifiable.
+			text = ""
+		}
 		return struct {
 			Base       interface{} `json:"base"`
 			Text       string      `json:"text"`
@@ -216,11 +247,19 @@ func buildItemFingerprint(item *Item) interface{} {
 			ImageBytes int         `json:"imageBytes"`
 		}{
 			Base:       base,
-			Text:       v.Text,
+			Text:       text,
 			FileCount:  len(v.Files),
 			ZipBytes:   len(v.ZipData),
 			ImageBytes: len(v.Image),
 		}
+	case *ImportProvenance:
+		return struct {
+			Base       interface{}       `json:"base"`
+			Provenance *ImportProvenance `json:"provenance"`
+		}{
+			Base:       base,
+			Provenance: v,
+		}
 	default:
 		return base
 	}
@@ -239,27 +278,19 @@ func (hp *HistoryPool) GetOperations(roomID, sinceID, sinceHash string) []*Opera
 	startIdx := 0
 
 	if sinceHash != "" {
-		startIdx = -1
-		for i, op := range ops {
-			if op.Hash == sinceHash {
-				startIdx = i + 1
-				break
-			}
-		}
-		if startIdx == -1 {
-			// Unknown hash (likely trimmed); return full list so the client can resync
-			startIdx = 0
-		}
+		startIdx = hp.positionLocked(roomID, sinceHash) + 1
+		// An unknown hash (likely compacted) yields 0: snapshot + tail so the client can resync
 	} else if sinceID != "" {
 		startIdx = -1
-		for i, op := range ops {
-			if op.ID == sinceID {
-				startIdx = i + 1
-				break
-			}
+		if pos := hp.positionLocked(roomID, sinceID); pos >= 0 {
+			startIdx = pos + 1
 		}
 		if startIdx == -1 {
-			return []*Operation{}
+			if !behindSnapshot(ops, sinceID) {
+				return []*Operation{}
+			}
+			// The client is behind the compaction horizon; resync from the snapshot
+			startIdx = 0
 		}
 	}
 
@@ -281,11 +312,19 @@ func (hp *HistoryPool) GetCurrentChatMessages(roomID string) []*ChatMessage {
 	messages := make(map[string]*ChatMessage)
 
 	for _, op := range ops {
+		if op.OpType == OpSnapshot {
+			snapshotChatMessages(op, messages)
+			continue
+		}
 		if op.Item != nil && op.Item.Type == ItemChat {
 			if op.OpType == OpAdd {
 				if msg, ok := op.Item.Data.(*ChatMessage); ok {
 					messages[op.ItemID] = msg
 				}
+			} else if op.OpType == OpModify {
+				if msg, ok := op.Item.Data.(*ChatMessage); ok && messages[op.ItemID] != nil {
+					messages[op.ItemID] = msg
+				}
 			} else if op.OpType == OpRemove {
 				delete(messages, op.ItemID)
 			}
@@ -325,11 +364,19 @@ func (hp *HistoryPool) GetCurrentClipboardItems(roomID string) []*clip_helper.Cl
 	items := make(map[string]*clip_helper.ClipboardItem)
 
 	for _, op := range ops {
+		if op.OpType == OpSnapshot {
+			snapshotClipboardItems(op, items)
+			continue
+		}
 		if op.Item != nil && op.Item.Type == ItemClipboard {
 			if op.OpType == OpAdd {
 				if item, ok := op.Item.Data.(*clip_helper.ClipboardItem); ok {
 					items[op.ItemID] = item
 				}
+			} else if op.OpType == OpModify {
+				if item, ok := op.Item.Data.(*clip_helper.ClipboardItem); ok && items[op.ItemID] != nil {
+					items[op.ItemID] = item
+				}
 			} else if op.OpType == OpRemove {
 				delete(items, op.ItemID)
 			}
@@ -355,11 +402,17 @@ type App struct {
 	userCounter    int
 	roomCounter    int
 	inviteCounter  int
-	historyPool    *HistoryPool
+	historyStore   HistoryStore
 	mu             sync.RWMutex
 	networkClient  *NetworkClient // For client mode
+	outbox         *Outbox        // For client mode: requests waiting for the host
+	idempotency    *idempotencyCache
 	sseManager     *SSEManager    // For SSE events
 	pendingInvites map[string]*PendingInvite
+	joinCodes      map[string]*JoinCode                 // normalized code -> join code
+	roomKeys       map[string]map[string]map[int]string // roomID -> member public key -> epoch -> wrapped room key
+	deviceKeys     map[string]string                    // device key hash -> user ID
+	e2e            *e2eClient                           // For client mode: identity and unwrapped room keys
 
 	clipboardMonitorOnce  sync.Once
 	clipboardHotkeyCancel context.CancelFunc
@@ -370,13 +423,23 @@ type App struct {
 	tempDir    string
 	useFastTar bool // Use high-performance tar library for large files
 
+	dataDir            string // Persistence root for host history and the client outbox; empty keeps both in memory only
+	persistenceEnabled bool
+
 	// Timing for performance measurement
 	shareStartTime        time.Time
 	totalBytesTransferred int64
 
 	jwtSecret      []byte
+	sessions       *sessionStore
 	zeroconfServer *zeroconf.Server
 	httpServer     *http.Server
+
+	tlsOptions     TLSOptions
+	tlsFingerprint string      // For host mode: SHA-256 of the served certificate, empty over plain HTTP
+	knownHosts     *knownHosts // For client mode: certificate pins per host
+	hostProxyURL   string      // For client mode: loopback proxy carrying UI traffic to an HTTPS host
+	rateLimiter    *rateLimiter
 }
 
 const (
@@ -409,8 +472,14 @@ func NewApp(mode string) *App {
 		users:          make(map[string]*User),
 		rooms:          make(map[string]*Room),
 		pendingInvites: make(map[string]*PendingInvite),
-		historyPool:    NewHistoryPool(),
+		joinCodes:      make(map[string]*JoinCode),
+		roomKeys:       make(map[string]map[string]map[int]string),
+		deviceKeys:     make(map[string]string),
+		historyStore:   NewHistoryPool(),
 		sseManager:     NewSSEManager(),
+		idempotency:    newIdempotencyCache(),
+		sessions:       newSessionStore(),
+		rateLimiter:    newRateLimiter(),
 		jwtSecret:      []byte(secret),
 		useFastTar:     os.Getenv("FAST_TAR") == "true", // Enable fast tar for large files
 	}
@@ -470,6 +539,10 @@ func (a *App) shutdown(ctx context.Context) {
 		fmt.Println("Shutting down zeroconf server...")
 		a.zeroconfServer.Shutdown()
 	}
+
+	if err := a.historyStore.Close(); err != nil {
+		fmt.Printf("Failed to close history journal: %v\n", err)
+	}
 }
 
 // Greet returns a greeting for the given name
@@ -537,6 +610,11 @@ func (a *App) Invite(userID string) string {
 			UserIDs: []string{},
 		}
 		a.rooms[roomID] = a.currentRoom
+		a.persistStateLocked()
+	}
+
+	if contains(a.currentRoom.BannedUserIDs, userID) {
+		return "Error: User is banned from this room"
 	}
 
 	// Add user to current room if not already there
@@ -562,6 +640,10 @@ func (a *App) Invite(userID string) string {
 
 // CreateRoom creates a new room with the given name and owner
 func (a *App) CreateRoom(name, ownerID string) *Room {
+	return a.createRoom(name, ownerID, false)
+}
+
+func (a *App) createRoom(name, ownerID string, encrypted bool) *Room {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
@@ -573,12 +655,14 @@ func (a *App) CreateRoom(name, ownerID string) *Room {
 	a.roomCounter++
 	roomID := fmt.Sprintf("room_%d", a.roomCounter)
 	room := &Room{
-		ID:      roomID,
-		Name:    cleanName,
-		OwnerID: ownerID,
-		UserIDs: []string{},
+		ID:        roomID,
+		Name:      cleanName,
+		OwnerID:   ownerID,
+		UserIDs:   []string{},
+		Encrypted: encrypted,
 	}
 	a.rooms[roomID] = room
+	a.persistStateLocked()
 
 	// Notify via SSE
 	a.sseManager.BroadcastToAll(EventRoomCreated, room)
@@ -734,8 +818,15 @@ func (a *App) AcceptInvite(inviteID, inviteeID string) (string, string) {
 			return "", "Error: Room not found"
 		}
 
+		if contains(room.BannedUserIDs, inviteeID) {
+			delete(a.pendingInvites, inviteID)
+			a.mu.Unlock()
+			return "", "Error: Invitee is banned from this room"
+		}
+
 		if room.OwnerID != "" && room.OwnerID != "host" && !contains(room.ApprovedUserIDs, inviteeID) {
 			room.ApprovedUserIDs = append(room.ApprovedUserIDs, inviteeID)
+			a.persistStateLocked()
 		}
 
 		delete(a.pendingInvites, inviteID)
@@ -766,6 +857,7 @@ func (a *App) AcceptInvite(inviteID, inviteeID string) (string, string) {
 	}
 	a.rooms[roomID] = room
 	delete(a.pendingInvites, inviteID)
+	a.persistStateLocked()
 	a.mu.Unlock()
 
 	_, err := a.JoinRoom(inviter.ID, roomID)
@@ -826,6 +918,7 @@ func (a *App) CreateUser(name string) *User {
 		IsOnline: true,
 	}
 	a.users[userID] = user
+	a.persistStateLocked()
 	fmt.Printf("Created user: %s (ID: %s)\n", name, userID)
 
 	// Notify via SSE
@@ -896,11 +989,49 @@ func (a *App) SetServerURL(url string) {
 	// URL should already be properly formatted from frontend
 	// Just set it directly
 	a.networkClient.serverURL = url
+	a.pinServerCertificate(url)
 	fmt.Printf("Server URL set to: %s\n", url)
 
 	// Don't auto-connect here - connection will happen when user creates account
 }
 
+// SetSession hands the backend network client its own session on the host, so
+// clipboard uploads and the outbox are authenticated as the connected user.
+func (a *App) SetSession(tokens SessionTokens) {
+	if a.Mode != "client" || a.networkClient == nil {
+		fmt.Println("SetSession: Not in client mode or network client not initialized")
+		return
+	}
+	a.networkClient.SetSession(&tokens)
+}
+
+// DownloadURL returns an authenticated link to the files shared by an operation,
+// for opening in the system browser.
+func (a *App) DownloadURL(userID, opID string) (string, error) {
+	path := "/api/download/" + url.PathEscape(opID)
+	if a.Mode == "client" {
+		if a.networkClient == nil {
+			return "", errors.New("network client not initialized")
+		}
+		// The system browser would not know the host's pinned certificate
+		base, err := a.HostAPIURL()
+		if err != nil {
+			return "", err
+		}
+		return base + path + "?token=" + url.QueryEscape(a.networkClient.accessToken()), nil
+	}
+
+	_, roomID := a.findOperationByID(opID)
+	if roomID == "" || !a.userInRoom(userID, roomID) {
+		return "", errors.New("operation not found in your rooms")
+	}
+	token, err := a.localToken(userID)
+	if err != nil {
+		return "", err
+	}
+	return a.localBaseURL() + path + "?token=" + url.QueryEscape(token), nil
+}
+
 // startCleanupTasks starts background cleanup goroutines for memory management
 func (a *App) startCleanupTasks(ctx context.Context) {
 	// Room cleanup ticker
@@ -930,7 +1061,20 @@ func (a *App) startCleanupTasks(ctx context.Context) {
 		}
 	}()
 
-	fmt.Printf("Started cleanup tasks: room cleanup every %v, invite cleanup every 10s\n", roomCleanupInterval)
+	retentionTicker := time.NewTicker(retentionEnforceInterval)
+	go func() {
+		defer retentionTicker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-retentionTicker.C:
+				a.enforceRetention()
+			}
+		}
+	}()
+
+	fmt.Printf("Started cleanup tasks: room cleanup every %v, invite cleanup every 10s, retention every %v\n", roomCleanupInterval, retentionEnforceInterval)
 }
 
 // cleanupEmptyRooms removes rooms with no active users
@@ -941,9 +1085,15 @@ func (a *App) cleanupEmptyRooms() {
 	roomsToDelete := make([]string, 0)
 
 	for roomID, room := range a.rooms {
-		if len(room.UserIDs) == 0 {
-			roomsToDelete = append(roomsToDelete, roomID)
+		if len(room.UserIDs) > 0 {
+			continue
+		}
+		// A room with persisted history is kept so it can be rejoined after a
+		// restart or an import; deleting it would orphan its journal.
+		if a.persistenceEnabled && len(a.historyStore.GetOperations(roomID, "", "")) > 0 {
+			continue
 		}
+		roomsToDelete = append(roomsToDelete, roomID)
 	}
 
 	for _, roomID := range roomsToDelete {
@@ -952,6 +1102,7 @@ func (a *App) cleanupEmptyRooms() {
 	}
 
 	if len(roomsToDelete) > 0 {
+		a.persistStateLocked()
 		fmt.Printf("Cleanup completed: removed %d empty rooms\n", len(roomsToDelete))
 	}
 }
@@ -992,6 +1143,14 @@ func (a *App) LeaveRoom(userID string) string {
 		return "Error: Room not found"
 	}
 
+	return a.leaveRoomLocked(user, room)
+}
+
+// leaveRoomLocked removes user from room, handing ownership on or deleting the
+// room as LeaveRoom describes. Callers must hold a.mu.
+func (a *App) leaveRoomLocked(user *User, room *Room) string {
+	userID := user.ID
+
 	// Remove user from room
 	for i, uid := range room.UserIDs {
 		if uid == userID {
@@ -999,14 +1158,25 @@ func (a *App) LeaveRoom(userID string) string {
 			break
 		}
 	}
+	a.persistStateLocked()
 
 	// Clear user's room reference
 	user.RoomID = nil
 	remainingMembers := append([]string{}, room.UserIDs...)
 
-	// If the leaver was the owner, assign a new owner
+	// If the leaver was the owner, assign a new owner, preferring a moderator
 	if room.OwnerID == user.ID && len(remainingMembers) > 0 {
 		room.OwnerID = remainingMembers[0]
+		for _, uid := range remainingMembers {
+			if contains(room.ModeratorIDs, uid) {
+				room.OwnerID = uid
+				break
+			}
+		}
+		room.ModeratorIDs = without(room.ModeratorIDs, room.OwnerID)
+		room.ViewerIDs = without(room.ViewerIDs, room.OwnerID)
+		room.MutedUserIDs = without(room.MutedUserIDs, room.OwnerID)
+		a.persistStateLocked()
 		fmt.Printf("Room %s owner changed to %s\n", room.ID, room.OwnerID)
 	}
 
@@ -1022,6 +1192,8 @@ func (a *App) LeaveRoom(userID string) string {
 	// If room has less than 2 users, delete it
 	if len(room.UserIDs) < 2 {
 		delete(a.rooms, room.ID)
+		delete(a.roomKeys, room.ID)
+		a.persistStateLocked()
 		// Remove room reference from remaining users
 		for _, uid := range remainingMembers {
 			if u, exists := a.users[uid]; exists {
@@ -1061,6 +1233,10 @@ func (a *App) JoinRoom(userID, roomID string) (*Room, error) {
 		return nil, fmt.Errorf("room not found")
 	}
 
+	if contains(room.BannedUserIDs, userID) {
+		return nil, errBannedFromRoom
+	}
+
 	// Check permissions for non-host rooms
 	if room.OwnerID != "" && room.OwnerID != "host" {
 		isApproved := false
@@ -1088,6 +1264,7 @@ func (a *App) JoinRoom(userID, roomID string) (*Room, error) {
 	// Add user to room
 	room.UserIDs = append(room.UserIDs, userID)
 	user.RoomID = &room.ID
+	a.persistStateLocked()
 
 	fmt.Printf("User %s joined room %s\n", userID, roomID)
 
@@ -1106,6 +1283,7 @@ func (a *App) JoinRoom(userID, roomID string) (*Room, error) {
 			fmt.Printf("SUCCESS: Sent join notification to %s\n", memberID)
 		}
 	}
+	a.requestRoomKeysLocked(room)
 
 	return room, nil
 }
@@ -1126,8 +1304,12 @@ func (a *App) SendChatMessage(roomID, userID, message string) string {
 	}
 
 	members := make([]string, 0)
+	var postErr error
+	encrypted := false
 	if roomExists {
 		members = append(members, room.UserIDs...)
+		postErr = postingError(room, userID)
+		encrypted = room.Encrypted
 	}
 
 	a.mu.RUnlock()
@@ -1144,7 +1326,14 @@ func (a *App) SendChatMessage(roomID, userID, message string) string {
 		return "Error: User is not in this room"
 	}
 
-	safeMessage := sanitizeChatMessage(message)
+	if postErr != nil {
+		return fmt.Sprintf("Error: %v", postErr)
+	}
+
+	safeMessage, err := chatMessageContent(message, encrypted)
+	if err != nil {
+		return fmt.Sprintf("Error: %v", err)
+	}
 	if safeMessage == "" {
 		return "Error: Message cannot be empty"
 	}
@@ -1167,7 +1356,7 @@ func (a *App) SendChatMessage(roomID, userID, message string) string {
 	}
 
 	// Add operation and notify consumers about the delta (not the entire history)
-	a.historyPool.AddOperation(roomID, OpAdd, msg.ID, item, userID, userName)
+	a.historyStore.AddOperation(roomID, OpAdd, msg.ID, item, userID, userName)
 	// Broadcast to all members except the sender
 	a.sseManager.BroadcastToUsers(members, EventChatMessage, msg, userID)
 
@@ -1175,6 +1364,180 @@ func (a *App) SendChatMessage(roomID, userID, message string) string {
 	return fmt.Sprintf("Message sent: %s", msg.ID)
 }
 
+// EditChatMessage replaces the text of a message the user authored. The edit is
+// recorded as an OpModify operation so earlier versions stay in the op log.
+func (a *App) EditChatMessage(roomID, userID, messageID, message string) string {
+	a.mu.RLock()
+	user, userExists := a.users[userID]
+	room, roomExists := a.rooms[roomID]
+
+	userInRoom := userExists && user.RoomID != nil && *user.RoomID == roomID
+	userName := ""
+	if userExists {
+		userName = user.Name
+	}
+
+	members := make([]string, 0)
+	var postErr error
+	encrypted := false
+	if roomExists {
+		members = append(members, room.UserIDs...)
+		postErr = postingError(room, userID)
+		encrypted = room.Encrypted
+	}
+	a.mu.RUnlock()
+
+	if !userExists {
+		return "Error: User not found"
+	}
+
+	if !roomExists {
+		return "Error: Room not found"
+	}
+
+	if !userInRoom {
+		return "Error: User is not in this room"
+	}
+
+	if postErr != nil {
+		return fmt.Sprintf("Error: %v", postErr)
+	}
+
+	item, _ := a.historyStore.GetCurrentItem(roomID, messageID)
+	if item == nil || item.Type != ItemChat {
+		return "Error: Message not found"
+	}
+	current, ok := item.Data.(*ChatMessage)
+	if !ok {
+		return "Error: Message not found"
+	}
+	if current.UserID != userID {
+		return "Error: Only the author can edit this message"
+	}
+
+	safeMessage, err := chatMessageContent(message, encrypted)
+	if err != nil {
+		return fmt.Sprintf("Error: %v", err)
+	}
+	if safeMessage == "" {
+		return "Error: Message cannot be empty"
+	}
+
+	edited := *current
+	edited.Message = safeMessage
+	edited.EditedAt = time.Now().Unix()
+
+	a.historyStore.AddOperation(roomID, OpModify, messageID, &Item{ID: messageID, Type: ItemChat, Data: &edited}, userID, userName)
+	a.sseManager.BroadcastToUsers(members, EventChatMessageEdited, &edited, userID)
+
+	fmt.Printf("Chat message %s edited by %s in room %s\n", messageID, userName, roomID)
+	return fmt.Sprintf("Message edited: %s", messageID)
+}
+
+// DeleteChatMessage removes a message on behalf of its author, a moderator or the room owner.
+func (a *App) DeleteChatMessage(roomID, userID, messageID string) string {
+	if _, err := a.removeItem(roomID, userID, messageID, ItemChat); err != nil {
+		switch {
+		case errors.Is(err, errItemNotFound):
+			return "Error: Message not found"
+		case errors.Is(err, errNotItemAuthor):
+			return "Error: Only the author, a moderator or the room owner can delete this message"
+		default:
+			return "Error: " + err.Error()
+		}
+	}
+	return fmt.Sprintf("Message deleted: %s", messageID)
+}
+
+// removeItem records an OpRemove for a live item, scrubs its earlier content from
+// history, deletes any host-side files and notifies the room. Only the item's
+// author, a moderator or the room owner may remove it.
+func (a *App) removeItem(roomID, userID, itemID string, itemType ItemType) (*Operation, error) {
+	a.mu.RLock()
+	user, userExists := a.users[userID]
+	room, roomExists := a.rooms[roomID]
+	userInRoom := userExists && user.RoomID != nil && *user.RoomID == roomID
+	var members []string
+	canModerate := false
+	if roomExists {
+		members = append(members, room.UserIDs...)
+		canModerate = roleOf(room, userID).rank() >= RoleModerator.rank()
+	}
+	a.mu.RUnlock()
+
+	if !userExists {
+		return nil, errors.New("user not found")
+	}
+	if !roomExists {
+		return nil, errors.New("room not found")
+	}
+	if !userInRoom {
+		return nil, errors.New("user is not in this room")
+	}
+
+	item, origin := a.historyStore.GetCurrentItem(roomID, itemID)
+	if item == nil || item.Type != itemType {
+		return nil, errItemNotFound
+	}
+	if origin.UserID != userID && !canModerate {
+		return nil, errNotItemAuthor
+	}
+
+	op := a.retireItem(roomID, item, userID, user.Name, members)
+	fmt.Printf("Item %s removed by %s in room %s\n", itemID, user.Name, roomID)
+	return op, nil
+}
+
+// retireItem records the removal of a live item, scrubs its stored content and
+// shared files, and tells the room members. Callers have already checked permissions.
+func (a *App) retireItem(roomID string, item *Item, userID, userName string, members []string) *Operation {
+	return a.retireItems(roomID, []*Item{item}, userID, userName, members)[0]
+}
+
+// retireItems is retireItem for several items of one room, scrubbing their
+// content in a single pass so a file-backed store rewrites the room log once.
+func (a *App) retireItems(roomID string, items []*Item, userID, userName string, members []string) []*Operation {
+	if len(items) == 0 {
+		return nil
+	}
+
+	// Gather file locations from every version before the payloads are scrubbed
+	retiring := make(map[string]bool, len(items))
+	itemIDs := make([]string, 0, len(items))
+	var files []string
+	for _, item := range items {
+		retiring[item.ID] = true
+		itemIDs = append(itemIDs, item.ID)
+		files = append(files, sharedFilePaths(item)...)
+	}
+	for _, op := range a.historyStore.GetOperations(roomID, "", "") {
+		if retiring[op.ItemID] && op.Item != nil {
+			files = append(files, sharedFilePaths(op.Item)...)
+		}
+	}
+
+	ops := make([]*Operation, 0, len(items))
+	for _, item := range items {
+		ops = append(ops, a.historyStore.AddOperation(roomID, OpRemove, item.ID, &Item{ID: item.ID, Type: item.Type}, userID, userName))
+	}
+	a.historyStore.RedactItems(roomID, itemIDs...)
+	a.removeSharedFiles(files)
+
+	for i, item := range items {
+		evt := EventChatMessageDeleted
+		if item.Type == ItemClipboard {
+			evt = EventClipboardRemoved
+		}
+		a.sseManager.BroadcastToUsers(members, evt, ItemRemovedEvent{
+			RoomID:      roomID,
+			ItemID:      item.ID,
+			OperationID: ops[i].ID,
+			RemovedBy:   userID,
+		}, "")
+	}
+	return ops
+}
+
 // GetChatHistory returns chat history for a room
 func (a *App) GetChatHistory(roomID string) []*ChatMessage {
 	a.mu.RLock()
@@ -1185,7 +1548,23 @@ func (a *App) GetChatHistory(roomID string) []*ChatMessage {
 		return []*ChatMessage{}
 	}
 
-	return a.historyPool.GetCurrentChatMessages(roomID)
+	return a.historyStore.GetCurrentChatMessages(roomID)
+}
+
+// SearchRoom runs a full-text search over a room's live chat and clipboard items.
+func (a *App) SearchRoom(roomID string, q SearchQuery) ([]*SearchResult, error) {
+	a.mu.RLock()
+	_, exists := a.rooms[roomID]
+	a.mu.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("room %s not found", roomID)
+	}
+	if q.Type != "" && q.Type != ItemChat && q.Type != ItemClipboard {
+		return nil, fmt.Errorf("unknown item type %q", q.Type)
+	}
+
+	return a.historyStore.Search(roomID, q), nil
 }
 
 // GetOperations returns operations for a room since a given ID or hash.
@@ -1200,64 +1579,54 @@ func (a *App) GetOperations(roomID, sinceID, sinceHash string) []*Operation {
 		}
 	}
 
-	return a.historyPool.GetOperations(roomID, sinceID, sinceHash)
+	return a.historyStore.GetOperations(roomID, sinceID, sinceHash)
 }
 
-func (a *App) issueToken(userID string) (string, error) {
-	claims := JWTClaims{
-		UserID: userID,
-		RegisteredClaims: jwt.RegisteredClaims{
-			Subject:   userID,
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(defaultJWTExpiry)),
-		},
+// VerifyOperations recomputes the hash chain for a room's retained history.
+func (a *App) VerifyOperations(roomID string) (*ChainVerification, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if roomID != "global" {
+		if _, exists := a.rooms[roomID]; !exists {
+			return nil, fmt.Errorf("room %s not found", roomID)
+		}
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(a.jwtSecret)
+	return a.historyStore.VerifyChain(roomID), nil
 }
 
-func (a *App) authenticateRequest(r *http.Request) (*User, error) {
-	authHeader := strings.TrimSpace(r.Header.Get("Authorization"))
-	if authHeader == "" {
-		return nil, errors.New("missing Authorization header")
-	}
-
-	parts := strings.SplitN(authHeader, " ", 2)
+// bearerToken returns the token from an "Authorization: Bearer" header, or "".
+func bearerToken(r *http.Request) string {
+	parts := strings.SplitN(strings.TrimSpace(r.Header.Get("Authorization")), " ", 2)
 	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
-		return nil, errors.New("invalid Authorization header")
-	}
-
-	tokenString := strings.TrimSpace(parts[1])
-	if tokenString == "" {
-		return nil, errors.New("empty token")
+		return ""
 	}
+	return strings.TrimSpace(parts[1])
+}
 
-	claims := &JWTClaims{}
-	parsed, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
-		}
-		return a.jwtSecret, nil
-	})
-	if err != nil || parsed == nil || !parsed.Valid {
-		return nil, errors.New("invalid token")
+func (a *App) authenticateRequest(r *http.Request) (*User, error) {
+	if strings.TrimSpace(r.Header.Get("Authorization")) == "" {
+		return nil, errors.New("missing Authorization header")
 	}
-
-	a.mu.RLock()
-	user, ok := a.users[claims.UserID]
-	a.mu.RUnlock()
-	if !ok {
-		return nil, errors.New("user not found")
+	tokenString := bearerToken(r)
+	if tokenString == "" {
+		return nil, errors.New("invalid Authorization header")
 	}
-
-	return user, nil
+	return a.authenticateToken(tokenString)
 }
 
 func (a *App) authenticateToken(tokenString string) (*User, error) {
+	user, _, err := a.verifyToken(tokenString)
+	return user, err
+}
+
+// verifyToken checks an access token's signature and expiry, that its session
+// and ID have not been revoked, and that its user still exists.
+func (a *App) verifyToken(tokenString string) (*User, *JWTClaims, error) {
 	tokenString = strings.TrimSpace(tokenString)
 	if tokenString == "" {
-		return nil, errors.New("empty token")
+		return nil, nil, errors.New("empty token")
 	}
 
 	claims := &JWTClaims{}
@@ -1268,17 +1637,20 @@ func (a *App) authenticateToken(tokenString string) (*User, error) {
 		return a.jwtSecret, nil
 	})
 	if err != nil || parsed == nil || !parsed.Valid {
-		return nil, errors.New("invalid token")
+		return nil, nil, errors.New("invalid token")
+	}
+	if !a.sessions.allows(claims) {
+		return nil, nil, errors.New("token revoked")
 	}
 
 	a.mu.RLock()
 	user, ok := a.users[claims.UserID]
 	a.mu.RUnlock()
 	if !ok {
-		return nil, errors.New("user not found")
+		return nil, nil, errors.New("user not found")
 	}
 
-	return user, nil
+	return user, claims, nil
 }
 
 func enforceUserMatch(reqUserID string, authUser *User) (string, error) {
@@ -1296,9 +1668,10 @@ func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Set CORS headers for all requests
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Requested-With")
 		w.Header().Set("Access-Control-Max-Age", "86400") // 24 hours
+		w.Header().Set("Access-Control-Expose-Headers", "Retry-After")
 
 		// Handle preflight OPTIONS request
 		if r.Method == "OPTIONS" {
@@ -1327,22 +1700,30 @@ func generateJWTSecret() (string, error) {
 
 // StartHTTPServer starts the HTTP server for REST API
 func (a *App) StartHTTPServer(port string) {
-	http.HandleFunc("/api/users", corsMiddleware(a.handleUsers))
-	http.HandleFunc("/api/users/", corsMiddleware(a.handleUserByID))
-	http.HandleFunc("/api/rooms", corsMiddleware(a.handleRooms))
-	http.HandleFunc("/api/invite", corsMiddleware(a.handleInvite))
-	http.HandleFunc("/api/invite/accept", corsMiddleware(a.handleAcceptInvite))
-	http.HandleFunc("/api/join", corsMiddleware(a.handleJoinRoom))
-	http.HandleFunc("/api/chat", corsMiddleware(a.handleChat))
-	http.HandleFunc("/api/chat/", corsMiddleware(a.handleChat))
-	http.HandleFunc("/api/operations/", corsMiddleware(a.handleOperations))
-	http.HandleFunc("/api/join/request", corsMiddleware(a.handleJoinRequest))
-	http.HandleFunc("/api/join/approve", corsMiddleware(a.handleApproveJoin))
-	http.HandleFunc("/api/download/", corsMiddleware(a.handleDownload))
-	http.HandleFunc("/api/clipboard", corsMiddleware(a.handleClipboardUpload))
-	http.HandleFunc("/api/clipboard/", corsMiddleware(a.handleZipUpload))
-	http.HandleFunc("/api/leave", corsMiddleware(a.handleLeave))
-	http.HandleFunc("/api/sse", corsMiddleware(a.handleSSE))
+	http.HandleFunc("/api/users", corsMiddleware(a.rateLimited(RateClassSignup, a.handleUsers)))
+	http.HandleFunc("/api/users/", corsMiddleware(a.rateLimited(RateClassDefault, a.handleUserByID)))
+	http.HandleFunc("/api/auth/refresh", corsMiddleware(a.rateLimited(RateClassAuth, a.handleAuthRefresh)))
+	http.HandleFunc("/api/auth/session", corsMiddleware(a.rateLimited(RateClassAuth, a.handleAuthSession)))
+	http.HandleFunc("/api/auth/logout", corsMiddleware(a.rateLimited(RateClassAuth, a.handleAuthLogout)))
+	http.HandleFunc("/api/rooms", corsMiddleware(a.rateLimited(RateClassDefault, a.handleRooms)))
+	http.HandleFunc("/api/rooms/import", corsMiddleware(a.rateLimited(RateClassDefault, a.handleRoomImport)))
+	http.HandleFunc("/api/rooms/", corsMiddleware(a.rateLimited(RateClassDefault, a.handleRoomRoutes)))
+	http.HandleFunc("/api/invite", corsMiddleware(a.rateLimited(RateClassInvite, a.handleInvite)))
+	http.HandleFunc("/api/invite/accept", corsMiddleware(a.rateLimited(RateClassInvite, a.handleAcceptInvite)))
+	http.HandleFunc("/api/join", corsMiddleware(a.rateLimited(RateClassInvite, a.handleJoinRoom)))
+	http.HandleFunc("/api/chat", corsMiddleware(a.rateLimited(RateClassChat, a.handleChat)))
+	http.HandleFunc("/api/chat/", corsMiddleware(a.rateLimited(RateClassChat, a.handleChat)))
+	http.HandleFunc("/api/operations/", corsMiddleware(a.rateLimited(RateClassDefault, a.handleOperations)))
+	http.HandleFunc("/api/join/request", corsMiddleware(a.rateLimited(RateClassInvite, a.handleJoinRequest)))
+	http.HandleFunc("/api/join/code", corsMiddleware(a.rateLimited(RateClassInvite, a.handleJoinCode)))
+	http.HandleFunc("/api/join/approve", corsMiddleware(a.rateLimited(RateClassInvite, a.handleApproveJoin)))
+	http.HandleFunc("/api/download/", corsMiddleware(a.rateLimited(RateClassDefault, a.handleDownload)))
+	http.HandleFunc("/api/clipboard", corsMiddleware(a.rateLimited(RateClassClipboard, a.handleClipboardUpload)))
+	http.HandleFunc("/api/clipboard/", corsMiddleware(a.rateLimited(RateClassClipboard, a.handleClipboardItem)))
+	http.HandleFunc("/api/leave", corsMiddleware(a.rateLimited(RateClassDefault, a.handleLeave)))
+	http.HandleFunc("/api/keys", corsMiddleware(a.rateLimited(RateClassDefault, a.handleKeys)))
+	http.HandleFunc("/api/sse", corsMiddleware(a.rateLimited(RateClassDefault, a.handleSSE)))
+	http.HandleFunc("/api/ws", a.rateLimited(RateClassDefault, a.handleWebSocket))
 
 	fmt.Printf("Starting HTTP server on port %s\n", port)
 	listener, err := net.Listen("tcp4", "0.0.0.0:"+port)
@@ -1350,6 +1731,16 @@ func (a *App) StartHTTPServer(port string) {
 		fmt.Printf("Failed to listen on port %s: %v\n", port, err)
 		return
 	}
+	if a.tlsOptions.Enabled {
+		config, err := a.hostTLSConfig()
+		if err != nil {
+			listener.Close()
+			fmt.Printf("Failed to set up TLS, not serving: %v\n", err)
+			return
+		}
+		listener = tls.NewListener(listener, config)
+		fmt.Printf("Serving HTTPS with certificate %s\n", a.tlsFingerprint)
+	}
 	go http.Serve(listener, nil)
 }
 
@@ -1371,6 +1762,9 @@ func (a *App) RequestJoinRoom(userID, roomID string) (string, error) {
 	if contains(room.UserIDs, userID) {
 		return "Already in room", nil
 	}
+	if contains(room.BannedUserIDs, userID) {
+		return "", errBannedFromRoom
+	}
 
 	// Notify owner
 	payload := map[string]interface{}{
@@ -1401,10 +1795,15 @@ func (a *App) ApproveJoinRequest(ownerID, requesterID, roomID string) error {
 		a.mu.Unlock()
 		return fmt.Errorf("permission denied: not room owner")
 	}
+	if contains(room.BannedUserIDs, requesterID) {
+		a.mu.Unlock()
+		return errBannedFromRoom
+	}
 
 	// Add to approved list
 	if !contains(room.ApprovedUserIDs, requesterID) {
 		room.ApprovedUserIDs = append(room.ApprovedUserIDs, requesterID)
+		a.persistStateLocked()
 	}
 	a.mu.Unlock()
 
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
 )
 
 // Ensures JWT issuance and authentication succeed and enforce user matching.
@@ -34,3 +39,216 @@ func TestJWTIssueAndAuthenticate(t *testing.T) {
 		t.Fatalf("enforceUserMatch should fail for mismatched IDs")
 	}
 }
+
+func postAuth(app *App, handler http.HandlerFunc, token string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/auth", strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+	return rr
+}
+
+// Ensures refresh tokens rotate, and that replaying a rotated one revokes the session.
+func TestAuthRefreshRotation(t *testing.T) {
+	app := newTestApp()
+	user := app.CreateUser("Refresher")
+	first, err := app.issueSession(user.ID)
+	if err != nil {
+		t.Fatalf("issueSession error: %v", err)
+	}
+
+	rr := postAuth(app, app.handleAuthRefresh, "", `{"refreshToken":"`+first.RefreshToken+`"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected refresh to succeed, got %d", rr.Code)
+	}
+	second := decodeResponseBody[SessionTokens](t, rr)
+	if second.Token == "" || second.RefreshToken == first.RefreshToken {
+		t.Fatalf("expected new tokens, got %+v", second)
+	}
+	if authUser, err := app.authenticateToken(second.Token); err != nil || authUser.ID != user.ID {
+		t.Fatalf("refreshed token rejected: %v", err)
+	}
+
+	rr = postAuth(app, app.handleAuthRefresh, "", `{"refreshToken":"`+first.RefreshToken+`"}`)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected reused refresh token to be rejected, got %d", rr.Code)
+	}
+	if _, err := app.authenticateToken(second.Token); err == nil {
+		t.Fatalf("expected refresh token reuse to revoke the session")
+	}
+	if rr := postAuth(app, app.handleAuthRefresh, "", `{"refreshToken":"`+second.RefreshToken+`"}`); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected revoked session to stop refreshing, got %d", rr.Code)
+	}
+}
+
+// Verifies logout revokes the session's tokens, closes its event stream and
+// leaves the user's other sessions alone.
+func TestAuthLogoutRevokesSession(t *testing.T) {
+	app := newTestApp()
+	user := app.CreateUser("Leaver")
+	session, _ := app.issueSession(user.ID)
+	other, _ := app.issueToken(user.ID)
+
+	conn := attachClient(app, user.ID)
+	_, claims, err := app.verifyToken(session.Token)
+	if err != nil {
+		t.Fatalf("verifyToken error: %v", err)
+	}
+	app.sseManager.bindSession(conn.client, claims.SessionID)
+
+	if rr := postAuth(app, app.handleAuthLogout, session.Token, ""); rr.Code != http.StatusOK {
+		t.Fatalf("expected logout to succeed, got %d", rr.Code)
+	}
+	if _, err := app.authenticateToken(session.Token); err == nil {
+		t.Fatalf("expected logged-out token to be rejected")
+	}
+	if rr := postAuth(app, app.handleAuthRefresh, "", `{"refreshToken":"`+session.RefreshToken+`"}`); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected logged-out refresh token to be rejected, got %d", rr.Code)
+	}
+	select {
+	case <-conn.client.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("expected the session's event stream to be closed")
+	}
+	if _, err := app.authenticateToken(other); err != nil {
+		t.Fatalf("expected other session to stay valid: %v", err)
+	}
+	if rr := postAuth(app, app.handleAuthLogout, session.Token, ""); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected second logout to be unauthorized, got %d", rr.Code)
+	}
+}
+
+// Ensures an additional session is only opened for a caller that also holds the
+// current refresh token of its access token's session.
+func TestAuthSessionRequiresRefreshToken(t *testing.T) {
+	app := newTestApp()
+	user := app.CreateUser("Desktop")
+	session, _ := app.issueSession(user.ID)
+	other, _ := app.issueSession(user.ID)
+
+	if rr := postAuth(app, app.handleAuthSession, session.Token, `{}`); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected an access token alone to be refused, got %d", rr.Code)
+	}
+	if rr := postAuth(app, app.handleAuthSession, session.Token, `{"refreshToken":"`+other.RefreshToken+`"}`); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected another session's refresh token to be refused, got %d", rr.Code)
+	}
+	rr := postAuth(app, app.handleAuthSession, session.Token, `{"refreshToken":"`+session.RefreshToken+`"}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected a new session, got %d", rr.Code)
+	}
+	var opened SessionTokens
+	json.Unmarshal(rr.Body.Bytes(), &opened)
+	if opened.RefreshToken == "" || sessionIDFromRefresh(opened.RefreshToken) == sessionIDFromRefresh(session.RefreshToken) {
+		t.Fatalf("expected an independent session, got %+v", opened)
+	}
+
+	refreshed, err := app.refreshSession(session.RefreshToken)
+	if err != nil {
+		t.Fatalf("expected opening a session not to rotate the caller's refresh token: %v", err)
+	}
+	if rr := postAuth(app, app.handleAuthSession, refreshed.Token, `{"refreshToken":"`+session.RefreshToken+`"}`); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected a rotated refresh token to be refused, got %d", rr.Code)
+	}
+}
+
+// Ensures expired sessions and revoked token IDs are dropped as new sessions
+// are opened, rather than only when a session rotates.
+func TestSessionStoreSweepsExpiredEntries(t *testing.T) {
+	store := newSessionStore()
+	stale, _, _ := store.create("user_1")
+	live, _, _ := store.create("user_1")
+
+	now := time.Now()
+	store.mu.Lock()
+	store.sessions[stale.ID].expires = now.Add(-time.Minute)
+	store.revoked["old"] = now.Add(-time.Minute)
+	store.revoked["recent"] = now.Add(time.Minute)
+	store.mu.Unlock()
+
+	store.create("user_2")
+	if len(store.sessions) != 3 || len(store.revoked) != 2 {
+		t.Fatalf("expected no sweep before the interval passed")
+	}
+
+	store.mu.Lock()
+	store.lastSweep = now.Add(-sessionSweepInterval)
+	store.mu.Unlock()
+	store.create("user_2")
+	if _, exists := store.sessions[stale.ID]; exists || len(store.sessions) != 3 {
+		t.Fatalf("expected only the expired session to be dropped, got %d sessions", len(store.sessions))
+	}
+	if _, exists := store.sessions[live.ID]; !exists {
+		t.Fatalf("expected the live session to be kept")
+	}
+	if _, exists := store.revoked["old"]; exists || len(store.revoked) != 1 {
+		t.Fatalf("expected only the expired token ID to be dropped, got %v", store.revoked)
+	}
+}
+
+// Ensures a token stops working once its user is removed, even if a user with
+// the same ID is created again, and that tokens without a session are refused.
+func TestAuthTokenBoundToSession(t *testing.T) {
+	app := newTestApp()
+	user := app.CreateUser("Ghost")
+	token, _ := app.issueToken(user.ID)
+
+	app.removeUser(user.ID)
+	app.mu.Lock()
+	app.users[user.ID] = &User{ID: user.ID, Name: "Impostor"}
+	app.mu.Unlock()
+	if _, err := app.authenticateToken(token); err == nil {
+		t.Fatalf("expected token of a removed user to stay revoked")
+	}
+
+	legacy := jwt.NewWithClaims(jwt.SigningMethodHS256, JWTClaims{
+		UserID: user.ID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	})
+	signed, _ := legacy.SignedString(app.jwtSecret)
+	if _, err := app.authenticateToken(signed); err == nil {
+		t.Fatalf("expected a token without a session to be rejected")
+	}
+}
+
+// Exercises NetworkClient renewing an expiring access token and logging out.
+func TestNetworkClientSessionRefresh(t *testing.T) {
+	app := newTestApp()
+	user := app.CreateUser("Renewer")
+	session, _ := app.issueSession(user.ID)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/auth/refresh", app.handleAuthRefresh)
+	mux.HandleFunc("/api/auth/logout", app.handleAuthLogout)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	nc := NewNetworkClient(ts.URL)
+	expiring := *session
+	expiring.ExpiresAt = time.Now().Unix()
+	nc.SetSession(&expiring)
+
+	token := nc.accessToken()
+	if token == session.Token {
+		t.Fatalf("expected an expiring token to be refreshed")
+	}
+	if _, err := app.authenticateToken(token); err != nil {
+		t.Fatalf("refreshed token rejected: %v", err)
+	}
+	if again := nc.accessToken(); again != token {
+		t.Fatalf("expected a fresh token to be reused")
+	}
+
+	if err := nc.Logout(); err != nil {
+		t.Fatalf("Logout error: %v", err)
+	}
+	if _, err := app.authenticateToken(token); err == nil {
+		t.Fatalf("expected logout to revoke the token")
+	}
+	if nc.accessToken() != "" {
+		t.Fatalf("expected logout to clear the client's tokens")
+	}
+}
@@ -19,6 +19,11 @@ import (
 	"golang.design/x/clipboard"
 )
 
+var (
+	errItemNotFound  = errors.New("item not found")
+	errNotItemAuthor = errors.New("not allowed to change this item")
+)
+
 type fileShareKind string
 
 const (
@@ -193,10 +198,23 @@ func (a *App) handleClipboardCopy(item *clip_helper.ClipboardItem) {
 
 	if a.Mode == "client" {
 		fmt.Println("[DEBUG] Client mode: uploading clipboard item")
-		// Upload to server
-		op, err := a.networkClient.UploadClipboardItem(item, a.currentUser.ID, a.currentUser.Name)
-		if err != nil {
-			fmt.Printf("[DEBUG] Failed to upload clipboard item: %v\n", err)
+		// Upload to server, queueing behind anything the outbox has not delivered yet
+		var op *Operation
+		var err error
+		if a.outbox.Pending() == 0 {
+			op, err = a.uploadClipboard(item, a.currentUser.ID, a.currentUser.Name, "")
+		}
+		if op == nil {
+			if err != nil {
+				fmt.Printf("[DEBUG] Failed to upload clipboard item: %v\n", err)
+				if isRejectedByHost(err) {
+					return
+				}
+			}
+			entry := a.queueClipboardUpload(item)
+			if item.Type == clip_helper.ClipboardFile && item.ArchiveFilePath == "" && len(item.Files) > 0 {
+				go a.processFileArchive("global", entry.ID, item, "")
+			}
 			return
 		}
 		fmt.Printf("[DEBUG] Upload successful, op ID: %s\n", op.ID)
@@ -216,6 +234,23 @@ func (a *App) handleClipboardCopy(item *clip_helper.ClipboardItem) {
 		return
 	}
 	roomID := *a.currentUser.RoomID
+	if err := a.canPost(a.currentUser.ID, roomID); err != nil {
+		fmt.Printf("[DEBUG] Host mode: cannot share to room %s: %v\n", roomID, err)
+		return
+	}
+	if a.roomEncrypted(roomID) {
+		// Files the host shares would be stored unsealed, so encrypted rooms only take text and images from it
+		if item.Type == clip_helper.ClipboardFile {
+			fmt.Printf("[DEBUG] Host mode: file shares to encrypted room %s must come from a client\n", roomID)
+			return
+		}
+		sealed, err := a.sealClipboardItem(roomID, item)
+		if err != nil {
+			fmt.Printf("[DEBUG] Host mode: cannot seal clipboard item for room %s: %v\n", roomID, err)
+			return
+		}
+		item = sealed
+	}
 	fmt.Printf("[DEBUG] Host mode: sharing to room %s\n", roomID)
 
 	// Create item ID
@@ -229,7 +264,7 @@ func (a *App) handleClipboardCopy(item *clip_helper.ClipboardItem) {
 	}
 
 	// Add operation
-	op := a.historyPool.AddOperation(roomID, OpAdd, itemID, histItem, a.currentUser.ID, a.currentUser.Name)
+	op := a.historyStore.AddOperation(roomID, OpAdd, itemID, histItem, a.currentUser.ID, a.currentUser.Name)
 
 	// Broadcast to room members
 	a.mu.RLock()
@@ -278,7 +313,7 @@ func (a *App) processFileArchive(roomID, itemID string, item *clip_helper.Clipbo
 	fmt.Printf("[DEBUG] Total file size: %d bytes, proceeding with tar archiving\n", totalSize)
 
 	// Stream directly to final tar file (no temp file overhead)
-	archiveFilePath := filepath.Join(a.tempDir, itemID+".tar")
+	archiveFilePath := filepath.Join(a.fileStoreDir(), itemID+".tar")
 	archiveFile, err := os.Create(archiveFilePath)
 	if err != nil {
 		fmt.Printf("[DEBUG] Failed to create tar file: %v\n", err)
@@ -339,15 +374,22 @@ func (a *App) processFileArchive(roomID, itemID string, item *clip_helper.Clipbo
 	fmt.Printf("[DEBUG] Direct tar archive completed for item %s, size: %d bytes\n", itemID, archiveSize)
 
 	if a.Mode == "client" {
+		upload := OutboxUpload{FilePath: archiveFilePath}
 		if serverOpID != "" {
 			// Upload archive file from disk instead of loading into memory
 			fmt.Printf("[DEBUG] Uploading archive file to server op %s\n", serverOpID)
-			if err := a.networkClient.UploadZipFile(serverOpID, archiveFilePath); err != nil {
+			if err := a.uploadSharedFile(serverOpID, upload); err != nil {
 				fmt.Printf("[DEBUG] Failed to upload archive file: %v\n", err)
+				if !isRejectedByHost(err) {
+					a.queueFileUpload(serverOpID, "", upload)
+				}
 				return
 			}
 			fmt.Printf("[DEBUG] Uploaded archive file for op %s\n", serverOpID)
 			a.reportTransferTiming("Archive upload")
+		} else if a.outbox != nil && a.outbox.has(itemID) {
+			// The clipboard item itself is still queued; the archive follows it
+			a.queueFileUpload("", itemID, upload)
 		}
 		return
 	}
@@ -372,7 +414,7 @@ func (a *App) processSingleFileShare(roomID, itemID string, item *clip_helper.Cl
 	fmt.Printf("[DEBUG] Single file: %s (%s, %d bytes)\n", fileName, mimeType, info.Size())
 
 	// Save the single file to temp directory without loading into memory
-	singleFilePath := filepath.Join(a.tempDir, itemID+"_"+fileName)
+	singleFilePath := filepath.Join(a.fileStoreDir(), itemID+"_"+fileName)
 	fmt.Printf("[DEBUG] Copying single file to temp: %s -> %s\n", filePath, singleFilePath)
 	src, err := os.Open(filePath)
 	if err != nil {
@@ -411,14 +453,21 @@ func (a *App) processSingleFileShare(roomID, itemID string, item *clip_helper.Cl
 	fmt.Printf("[DEBUG] Prepared single file share %s (%d bytes)\n", fileName, info.Size())
 
 	if a.Mode == "client" {
+		upload := OutboxUpload{FilePath: singleFilePath, Single: true, Name: fileName, Mime: mimeType, Size: info.Size(), Thumb: thumb}
 		if serverOpID != "" {
 			fmt.Printf("[DEBUG] Client mode: uploading single file for op %s\n", serverOpID)
 			// Upload single file from disk instead of loading into memory
-			if err := a.networkClient.UploadSingleFile(serverOpID, singleFilePath, fileName, mimeType, info.Size(), thumb); err != nil {
+			if err := a.uploadSharedFile(serverOpID, upload); err != nil {
 				fmt.Printf("[DEBUG] Failed to upload single file: %v\n", err)
+				if !isRejectedByHost(err) {
+					a.queueFileUpload(serverOpID, "", upload)
+				}
+				return
 			}
 			fmt.Printf("[DEBUG] Uploaded single file for op %s\n", serverOpID)
 			a.reportTransferTiming("Single file upload")
+		} else if a.outbox != nil && a.outbox.has(itemID) {
+			a.queueFileUpload("", itemID, upload)
 		}
 		return
 	}
@@ -427,22 +476,152 @@ func (a *App) processSingleFileShare(roomID, itemID string, item *clip_helper.Cl
 	a.broadcastClipboardUpdate(roomID, itemID)
 }
 
-func (a *App) broadcastClipboardUpdate(roomID, itemID string) {
-	fmt.Printf("[DEBUG] Broadcasting clipboard update for item %s in room %s\n", itemID, roomID)
-	ops := a.historyPool.GetOperations(roomID, "", "")
-	var targetOp *Operation
-	for _, op := range ops {
-		if op.ItemID == itemID {
-			targetOp = op
-			break
+// EditClipboardItem applies a text or metadata change to a clipboard item the user
+// shared, recording it as an OpModify operation so earlier versions stay in the op log.
+func (a *App) EditClipboardItem(roomID, userID, itemID string, req EditClipboardItemRequest) (*Operation, error) {
+	a.mu.RLock()
+	user, userExists := a.users[userID]
+	room, roomExists := a.rooms[roomID]
+	userInRoom := userExists && user.RoomID != nil && *user.RoomID == roomID
+	var members []string
+	var postErr error
+	encrypted := false
+	if roomExists {
+		members = append(members, room.UserIDs...)
+		postErr = postingError(room, userID)
+		encrypted = room.Encrypted
+	}
+	a.mu.RUnlock()
+
+	if !roomExists {
+		return nil, fmt.Errorf("room %s not found", roomID)
+	}
+	if !userInRoom {
+		return nil, fmt.Errorf("user %s is not in room %s", userID, roomID)
+	}
+	if postErr != nil {
+		return nil, postErr
+	}
+
+	item, origin := a.historyStore.GetCurrentItem(roomID, itemID)
+	if item == nil || item.Type != ItemClipboard {
+		return nil, errItemNotFound
+	}
+	current, ok := item.Data.(*clip_helper.ClipboardItem)
+	if !ok {
+		return nil, errItemNotFound
+	}
+	if origin.UserID != userID {
+		return nil, errNotItemAuthor
+	}
+
+	edited := *current
+	changed := false
+	if encrypted && req.SingleFileName != nil {
+		return nil, errors.New("file names are sealed in encrypted rooms; replace the sealed text instead")
+	}
+	if req.Text != nil && encrypted {
+		// The sealed text carries the whole item, whatever its type
+		if !isSealedPayload(*req.Text) {
+			return nil, errNotSealed
+		}
+		edited.Text = *req.Text
+		changed = true
+	} else if req.Text != nil {
+		if current.Type != clip_helper.ClipboardText {
+			return nil, errors.New("only text items have editable text")
+		}
+		text := sanitizeClipboardText(*req.Text)
+		if text == "" {
+			return nil, errors.New("text cannot be empty")
+		}
+		edited.Text = text
+		changed = true
+	}
+	if req.SingleFileName != nil {
+		if !current.IsSingleFile {
+			return nil, errors.New("only single-file shares can be renamed")
+		}
+		name := filepath.Base(sanitizePlainText(*req.SingleFileName, 255))
+		if name == "" || name == "." || name == string(filepath.Separator) {
+			return nil, errors.New("file name cannot be empty")
 		}
+		edited.SingleFileName = name
+		changed = true
+	}
+	if !changed {
+		return nil, errors.New("no changes provided")
 	}
 
+	op := a.historyStore.AddOperation(roomID, OpModify, itemID, &Item{ID: itemID, Type: ItemClipboard, Data: &edited}, userID, user.Name)
+	a.sseManager.BroadcastToUsers(members, EventClipboardModified, op, "")
+
+	fmt.Printf("Clipboard item %s edited by %s in room %s\n", itemID, user.Name, roomID)
+	return op, nil
+}
+
+// DeleteClipboardItem removes a shared clipboard item and its files on behalf of
+// its author, a moderator or the room owner.
+func (a *App) DeleteClipboardItem(roomID, userID, itemID string) error {
+	_, err := a.removeItem(roomID, userID, itemID, ItemClipboard)
+	return err
+}
+
+// sharedFilePaths returns the host-side files backing a clipboard item.
+func sharedFilePaths(item *Item) []string {
+	clip, ok := item.Data.(*clip_helper.ClipboardItem)
+	if !ok {
+		return nil
+	}
+	var paths []string
+	for _, p := range []string{clip.ArchiveFilePath, clip.SingleFilePath} {
+		if p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+// removeSharedFiles deletes uploaded files, ignoring anything outside the host's file store.
+func (a *App) removeSharedFiles(paths []string) {
+	roots := []string{a.fileStoreDir(), a.tempDir}
+	removed := make(map[string]bool)
+	for _, p := range paths {
+		if removed[p] || !pathWithinAny(p, roots) {
+			continue
+		}
+		removed[p] = true
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			fmt.Printf("Failed to remove shared file %s: %v\n", p, err)
+		}
+	}
+}
+
+func pathWithinAny(path string, roots []string) bool {
+	for _, root := range roots {
+		if root == "" {
+			continue
+		}
+		rel, err := filepath.Rel(root, path)
+		if err == nil && rel != "." && !strings.HasPrefix(rel, "..") && !filepath.IsAbs(rel) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *App) broadcastClipboardUpdate(roomID, itemID string) {
+	fmt.Printf("[DEBUG] Broadcasting clipboard update for item %s in room %s\n", itemID, roomID)
+	targetOp := a.historyStore.FindItemOperation(roomID, itemID)
 	if targetOp == nil {
 		fmt.Printf("[DEBUG] Warning: Could not find operation for item %s to broadcast update\n", itemID)
 		return
 	}
 
+	if err := a.historyStore.UpdateOperation(roomID, targetOp.ID); err != nil {
+		fmt.Printf("[DEBUG] Failed to persist clipboard update for item %s: %v\n", itemID, err)
+	}
+
 	a.mu.RLock()
 	room, roomExists := a.rooms[roomID]
 	var members []string
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grandcat/zeroconf"
+)
+
+const (
+	zeroconfInstance = "GoTeamWork"
+	zeroconfService  = "_http._tcp"
+	zeroconfDomain   = "local."
+	discoveryTimeout = 3 * time.Second
+)
+
+// DiscoveredHost is a host found on the LAN through zeroconf.
+type DiscoveredHost struct {
+	Name        string `json:"name"`
+	URL         string `json:"url"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// FingerprintChanged is set when the host announces a different certificate
+	// than the one pinned for it; the pin is kept until it is forgotten.
+	FingerprintChanged bool `json:"fingerprintChanged,omitempty"`
+}
+
+// DiscoverHosts browses the LAN for hosts for a few seconds. The certificate
+// fingerprint an HTTPS host announces is pinned for it unless one already is.
+func (a *App) DiscoverHosts() ([]DiscoveredHost, error) {
+	resolver, err := zeroconf.NewResolver(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start discovery: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), discoveryTimeout)
+	defer cancel()
+	entries := make(chan *zeroconf.ServiceEntry)
+	if err := resolver.Browse(ctx, zeroconfService, zeroconfDomain, entries); err != nil {
+		return nil, fmt.Errorf("failed to browse for hosts: %w", err)
+	}
+
+	hosts := []DiscoveredHost{}
+	seen := make(map[string]bool)
+	for {
+		select {
+		case entry, ok := <-entries:
+			if !ok {
+				return hosts, nil
+			}
+			host, ok := discoveredHost(entry)
+			if !ok || seen[host.URL] {
+				continue
+			}
+			seen[host.URL] = true
+			if host.Fingerprint != "" {
+				pinned := a.hostPins().remember(hostKey(host.URL), host.Fingerprint)
+				host.FingerprintChanged = pinned != host.Fingerprint
+			}
+			hosts = append(hosts, host)
+		case <-ctx.Done():
+			return hosts, nil
+		}
+	}
+}
+
+// discoveredHost turns a zeroconf entry announced by a host into its API URL and
+// certificate fingerprint.
+func discoveredHost(entry *zeroconf.ServiceEntry) (DiscoveredHost, bool) {
+	if entry == nil || !strings.HasPrefix(entry.Instance, zeroconfInstance) || entry.Port == 0 {
+		return DiscoveredHost{}, false
+	}
+	var ip net.IP
+	if len(entry.AddrIPv4) > 0 {
+		ip = entry.AddrIPv4[0]
+	} else if len(entry.AddrIPv6) > 0 {
+		ip = entry.AddrIPv6[0]
+	} else {
+		return DiscoveredHost{}, false
+	}
+
+	scheme := "http"
+	host := DiscoveredHost{Name: entry.Instance}
+	for _, field := range entry.Text {
+		key, value, _ := strings.Cut(field, "=")
+		switch key {
+		case "scheme":
+			if value == "https" {
+				scheme = value
+			}
+		case "fingerprint":
+			host.Fingerprint = value
+		}
+	}
+	host.URL = scheme + "://" + net.JoinHostPort(ip.String(), strconv.Itoa(entry.Port))
+	return host, true
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Encrypted rooms keep chat text, clipboard content and shared files sealed with
+// a per-room AES-256-GCM key that only members hold. Each member publishes an
+// X25519 public key through the host; the room key is wrapped separately for
+// every member's public key, so the host only ever stores and relays ciphertext.
+
+const (
+	e2eKeySize       = 32
+	e2eNonceSize     = 12
+	e2eTagSize       = 16
+	e2ePayloadPrefix = "e2e1." // sealed payloads are e2e1.<epoch>.<base64url(nonce|ciphertext)>
+	e2eStreamMagic   = "GTE1"
+	e2eStreamChunk   = 64 * 1024
+	e2eWrapInfo      = "GoTeamWork e2e room key wrap v1"
+
+	// maxSealedChatLen bounds sealed chat messages, which skip plain-text sanitizing.
+	maxSealedChatLen = 16 * 1024
+)
+
+// e2eWrappedKeyLen is the decoded size of a wrapped room key: ephemeral public key then sealed key.
+const e2eWrappedKeyLen = 32 + e2eKeySize + e2eTagSize
+
+var (
+	errNotSealed      = errors.New("content in encrypted rooms must be sealed by the client")
+	errNoRoomKey      = errors.New("no key for this encrypted room yet")
+	errSealedTampered = errors.New("sealed content could not be opened")
+)
+
+// RoomKeysResponse is returned by GET /api/rooms/{id}/keys.
+type RoomKeysResponse struct {
+	RoomID    string         `json:"roomId"`
+	OwnerID   string         `json:"ownerId"`
+	Encrypted bool           `json:"encrypted"`
+	Epoch     int            `json:"epoch"`          // current key epoch; 0 until the owner sets the first key
+	Keys      map[int]string `json:"keys,omitempty"` // the caller's wrapped room key for each epoch it holds
+	Members   []MemberKey    `json:"members"`
+}
+
+// MemberKey lists a room member's public key and the key epochs wrapped for it.
+type MemberKey struct {
+	UserID    string `json:"userId"`
+	PublicKey string `json:"publicKey,omitempty"`
+	Epochs    []int  `json:"epochs,omitempty"`
+}
+
+// RoomKeyUpload is the body of POST /api/rooms/{id}/keys. An epoch one past the
+// room's current epoch rotates the key; the current or an older epoch hands an
+// existing key to members that lack it.
+type RoomKeyUpload struct {
+	Epoch int               `json:"epoch"`
+	Keys  map[string]string `json:"keys"` // userID -> wrapped room key
+}
+
+// PublicKeyRequest is the body of PUT /api/keys.
+type PublicKeyRequest struct {
+	PublicKey string `json:"publicKey"` // base64 X25519 public key
+}
+
+// RoomKeyEvent is the payload of room_key_needed and room_key_updated events.
+type RoomKeyEvent struct {
+	RoomID    string `json:"roomId"`
+	Epoch     int    `json:"epoch"`
+	UserID    string `json:"userId,omitempty"`
+	PublicKey string `json:"publicKey,omitempty"`
+}
+
+func decodePublicKey(encoded string) (*ecdh.PublicKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("public key is not base64: %w", err)
+	}
+	return ecdh.X25519().NewPublicKey(raw)
+}
+
+func encodePublicKey(key *ecdh.PublicKey) string {
+	return base64.StdEncoding.EncodeToString(key.Bytes())
+}
+
+func newRoomKey() ([]byte, error) {
+	key := make([]byte, e2eKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// wrapKeyFor derives the key-encryption key for one wrap from an X25519 exchange.
+func wrapKeyFor(shared, ephemeralPub, recipientPub []byte, roomID string) ([]byte, error) {
+	salt := append(append([]byte{}, ephemeralPub...), recipientPub...)
+	return hkdf.Key(sha256.New, shared, salt, e2eWrapInfo+" "+roomID, e2eKeySize)
+}
+
+// wrapRoomKey seals roomKey for recipient with a fresh ephemeral X25519 key. A
+// zero nonce is safe because every wrap uses a key derived from a new ephemeral key.
+func wrapRoomKey(recipient *ecdh.PublicKey, roomKey []byte, roomID string) (string, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return "", err
+	}
+	ephemeralPub := ephemeral.PublicKey().Bytes()
+	kek, err := wrapKeyFor(shared, ephemeralPub, recipient.Bytes(), roomID)
+	if err != nil {
+		return "", err
+	}
+	aead, err := newGCM(kek)
+	if err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nil, make([]byte, e2eNonceSize), roomKey, []byte(roomID))
+	return base64.StdEncoding.EncodeToString(append(ephemeralPub, sealed...)), nil
+}
+
+// unwrapRoomKey opens a key wrapped for identity by wrapRoomKey.
+func unwrapRoomKey(identity *ecdh.PrivateKey, wrapped, roomID string) ([]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil || len(raw) != e2eWrappedKeyLen {
+		return nil, errSealedTampered
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(raw[:32])
+	if err != nil {
+		return nil, errSealedTampered
+	}
+	shared, err := identity.ECDH(ephemeral)
+	if err != nil {
+		return nil, errSealedTampered
+	}
+	kek, err := wrapKeyFor(shared, raw[:32], identity.PublicKey().Bytes(), roomID)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	key, err := aead.Open(nil, make([]byte, e2eNonceSize), raw[32:], []byte(roomID))
+	if err != nil {
+		return nil, errSealedTampered
+	}
+	return key, nil
+}
+
+// validWrappedKey checks the shape of a wrapped key without being able to open it.
+func validWrappedKey(wrapped string) bool {
+	raw, err := base64.StdEncoding.DecodeString(wrapped)
+	return err == nil && len(raw) == e2eWrappedKeyLen
+}
+
+func payloadAAD(roomID string, epoch int) []byte {
+	return []byte(roomID + "/" + strconv.Itoa(epoch))
+}
+
+// sealPayload encrypts plaintext with the room key of the given epoch.
+func sealPayload(roomKey []byte, epoch int, roomID string, plaintext []byte) (string, error) {
+	aead, err := newGCM(roomKey)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, e2eNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, plaintext, payloadAAD(roomID, epoch))
+	return e2ePayloadPrefix + strconv.Itoa(epoch) + "." + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// parseSealedPayload splits a sealed payload into its key epoch and nonce|ciphertext.
+func parseSealedPayload(payload string) (int, []byte, bool) {
+	rest, ok := strings.CutPrefix(payload, e2ePayloadPrefix)
+	if !ok {
+		return 0, nil, false
+	}
+	epochText, body, ok := strings.Cut(rest, ".")
+	if !ok {
+		return 0, nil, false
+	}
+	epoch, err := strconv.Atoi(epochText)
+	if err != nil || epoch < 1 {
+		return 0, nil, false
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(body)
+	if err != nil || len(sealed) < e2eNonceSize+e2eTagSize {
+		return 0, nil, false
+	}
+	return epoch, sealed, true
+}
+
+// isSealedPayload is the host's structural check that content was sealed by a client.
+func isSealedPayload(payload string) bool {
+	_, _, ok := parseSealedPayload(payload)
+	return ok
+}
+
+// openPayload decrypts a sealed payload; keyFor returns the room key of an epoch, or nil.
+func openPayload(keyFor func(epoch int) []byte, roomID, payload string) ([]byte, error) {
+	epoch, sealed, ok := parseSealedPayload(payload)
+	if !ok {
+		return nil, errNotSealed
+	}
+	key := keyFor(epoch)
+	if key == nil {
+		return nil, errNoRoomKey
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, sealed[:e2eNonceSize], sealed[e2eNonceSize:], payloadAAD(roomID, epoch))
+	if err != nil {
+		return nil, errSealedTampered
+	}
+	return plaintext, nil
+}
+
+// Sealed file streams start with e2eStreamMagic, the key epoch (uint32) and a
+// random 7-byte nonce prefix. The file follows in GCM-sealed chunks of
+// e2eStreamChunk bytes; each chunk's nonce is the prefix, a big-endian chunk
+// counter and a final-chunk flag, so chunks cannot be reordered or truncated.
+const e2eStreamHeaderLen = len(e2eStreamMagic) + 4 + 7
+
+func streamNonce(prefix []byte, counter uint32, final bool) []byte {
+	nonce := make([]byte, e2eNonceSize)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[7:11], counter)
+	if final {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// sealStream encrypts src into dst as a sealed file stream.
+func sealStream(dst io.Writer, src io.Reader, roomKey []byte, epoch int, roomID string) error {
+	aead, err := newGCM(roomKey)
+	if err != nil {
+		return err
+	}
+	header := make([]byte, e2eStreamHeaderLen)
+	copy(header, e2eStreamMagic)
+	binary.BigEndian.PutUint32(header[4:8], uint32(epoch))
+	prefix := header[8:]
+	if _, err := rand.Read(prefix); err != nil {
+		return err
+	}
+	if _, err := dst.Write(header); err != nil {
+		return err
+	}
+
+	aad := payloadAAD(roomID, epoch)
+	br := bufio.NewReaderSize(src, e2eStreamChunk)
+	buf := make([]byte, e2eStreamChunk)
+	for counter := uint32(0); ; counter++ {
+		n, err := io.ReadFull(br, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		final, err := streamChunkIsFinal(br, err)
+		if err != nil {
+			return err
+		}
+		if _, err := dst.Write(aead.Seal(nil, streamNonce(prefix, counter, final), buf[:n], aad)); err != nil {
+			return err
+		}
+		if final {
+			return nil
+		}
+	}
+}
+
+// streamChunkIsFinal reports whether the chunk just read with readErr is the
+// last one, peeking ahead when the chunk was full.
+func streamChunkIsFinal(br *bufio.Reader, readErr error) (bool, error) {
+	if readErr != nil {
+		return true, nil
+	}
+	if _, err := br.Peek(1); err != nil {
+		if err == io.EOF {
+			return true, nil
+		}
+		return false, err
+	}
+	return false, nil
+}
+
+// openStream decrypts a sealed file stream from src into dst.
+func openStream(dst io.Writer, src io.Reader, keyFor func(epoch int) []byte, roomID string) error {
+	br := bufio.NewReaderSize(src, e2eStreamChunk+e2eTagSize)
+	header := make([]byte, e2eStreamHeaderLen)
+	if _, err := io.ReadFull(br, header); err != nil || string(header[:4]) != e2eStreamMagic {
+		return errSealedTampered
+	}
+	epoch := int(binary.BigEndian.Uint32(header[4:8]))
+	key := keyFor(epoch)
+	if key == nil {
+		return errNoRoomKey
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	prefix := header[8:]
+	aad := payloadAAD(roomID, epoch)
+
+	buf := make([]byte, e2eStreamChunk+e2eTagSize)
+	for counter := uint32(0); ; counter++ {
+		n, err := io.ReadFull(br, buf)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			// Either a read failure or a stream cut off after a non-final chunk
+			return errSealedTampered
+		}
+		final, err := streamChunkIsFinal(br, err)
+		if err != nil {
+			return err
+		}
+		plain, err := aead.Open(nil, streamNonce(prefix, counter, final), buf[:n], aad)
+		if err != nil {
+			return errSealedTampered
+		}
+		if _, err := dst.Write(plain); err != nil {
+			return err
+		}
+		if final {
+			return nil
+		}
+	}
+}
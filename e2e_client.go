@@ -0,0 +1,564 @@
+package main
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"GOproject/clip_helper"
+)
+
+const (
+	e2eDirName      = "e2e"
+	e2eIdentityFile = "identity.key"
+)
+
+// e2eClient is this app's side of encrypted rooms: the member's X25519 identity
+// and the room keys unwrapped with it. Room keys only ever live in memory.
+type e2eClient struct {
+	identity *ecdh.PrivateKey
+
+	mu         sync.Mutex
+	activeRoom string // encrypted room that clipboard shares from this app are sealed for
+	rooms      map[string]*e2eRoom
+}
+
+type e2eRoom struct {
+	epoch int
+	keys  map[int][]byte
+}
+
+// newE2EClient loads the identity kept in dir, creating it on first use. Without
+// a dir the identity lasts until the app exits.
+func newE2EClient(dir string) *e2eClient {
+	c := &e2eClient{rooms: make(map[string]*e2eRoom)}
+	if dir != "" {
+		identity, err := loadE2EIdentity(filepath.Join(dir, e2eIdentityFile))
+		if err == nil {
+			c.identity = identity
+			return c
+		}
+		fmt.Printf("Failed to load encryption identity, using a temporary one: %v\n", err)
+	}
+	identity, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		panic(fmt.Sprintf("failed to generate encryption identity: %v", err))
+	}
+	c.identity = identity
+	return c
+}
+
+func loadE2EIdentity(path string) (*ecdh.PrivateKey, error) {
+	raw, err := os.ReadFile(path)
+	if err == nil {
+		return ecdh.X25519().NewPrivateKey(raw)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	identity, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, identity.Bytes(), 0o600); err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
+
+func (c *e2eClient) publicKey() string {
+	return encodePublicKey(c.identity.PublicKey())
+}
+
+// absorb unwraps the keys in resp that this client does not hold yet.
+func (c *e2eClient) absorb(resp *RoomKeysResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	room := c.rooms[resp.RoomID]
+	if room == nil {
+		room = &e2eRoom{keys: make(map[int][]byte)}
+		c.rooms[resp.RoomID] = room
+	}
+	room.epoch = resp.Epoch
+	for epoch, wrapped := range resp.Keys {
+		if _, held := room.keys[epoch]; held {
+			continue
+		}
+		key, err := unwrapRoomKey(c.identity, wrapped, resp.RoomID)
+		if err != nil {
+			fmt.Printf("Failed to unwrap key epoch %d for room %s: %v\n", epoch, resp.RoomID, err)
+			continue
+		}
+		room.keys[epoch] = key
+	}
+}
+
+func (c *e2eClient) storeKey(roomID string, epoch int, key []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	room := c.rooms[roomID]
+	if room == nil {
+		room = &e2eRoom{keys: make(map[int][]byte)}
+		c.rooms[roomID] = room
+	}
+	room.keys[epoch] = key
+	if epoch > room.epoch {
+		room.epoch = epoch
+	}
+}
+
+// keyFor returns a lookup of roomID's keys by epoch for openPayload and openStream.
+func (c *e2eClient) keyFor(roomID string) func(int) []byte {
+	return func(epoch int) []byte {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if room := c.rooms[roomID]; room != nil {
+			return room.keys[epoch]
+		}
+		return nil
+	}
+}
+
+// currentKey returns the key new content for roomID is sealed with.
+func (c *e2eClient) currentKey(roomID string) ([]byte, int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	room := c.rooms[roomID]
+	if room == nil || room.keys[room.epoch] == nil {
+		return nil, 0, errNoRoomKey
+	}
+	return room.keys[room.epoch], room.epoch, nil
+}
+
+func (c *e2eClient) sealingRoom() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.activeRoom
+}
+
+// e2eState returns the app's encrypted-room client, loading its identity on first use.
+func (a *App) e2eState() *e2eClient {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.e2e == nil {
+		dir := ""
+		if a.dataDir != "" {
+			dir = filepath.Join(a.dataDir, e2eDirName)
+		}
+		a.e2e = newE2EClient(dir)
+	}
+	return a.e2e
+}
+
+func (a *App) e2eUserID() (string, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.currentUser == nil {
+		return "", errors.New("no current user")
+	}
+	return a.currentUser.ID, nil
+}
+
+// The key exchange goes to the host over HTTP in client mode and straight to the
+// app's own state in host mode.
+
+func (a *App) publishPublicKey(publicKey string) error {
+	if a.Mode == "client" {
+		return a.networkClient.PublishPublicKey(publicKey)
+	}
+	userID, err := a.e2eUserID()
+	if err != nil {
+		return err
+	}
+	return a.SetPublicKey(userID, publicKey)
+}
+
+func (a *App) fetchRoomKeys(roomID string) (*RoomKeysResponse, error) {
+	if a.Mode == "client" {
+		return a.networkClient.FetchRoomKeys(roomID)
+	}
+	userID, err := a.e2eUserID()
+	if err != nil {
+		return nil, err
+	}
+	return a.GetRoomKeys(roomID, userID)
+}
+
+func (a *App) uploadRoomKeys(roomID string, upload RoomKeyUpload) error {
+	if a.Mode == "client" {
+		return a.networkClient.UploadRoomKeys(roomID, upload)
+	}
+	userID, err := a.e2eUserID()
+	if err != nil {
+		return err
+	}
+	return a.StoreRoomKeys(roomID, userID, upload)
+}
+
+// EnterRoom prepares this app for the room the user is viewing: it publishes the
+// user's public key and, for encrypted rooms, fetches the room keys, sets the
+// first key if the user owns a room that has none, and shares the current key
+// with members still missing it. It reports whether the room is encrypted.
+func (a *App) EnterRoom(roomID string) (bool, error) {
+	c := a.e2eState()
+	if err := a.publishPublicKey(c.publicKey()); err != nil {
+		return false, fmt.Errorf("failed to publish public key: %w", err)
+	}
+	resp, err := a.fetchRoomKeys(roomID)
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.Lock()
+	c.activeRoom = ""
+	if resp.Encrypted {
+		c.activeRoom = roomID
+	}
+	c.mu.Unlock()
+	if !resp.Encrypted {
+		return false, nil
+	}
+
+	c.absorb(resp)
+	if resp.Epoch == 0 {
+		userID, err := a.e2eUserID()
+		if err == nil && resp.OwnerID == userID {
+			return true, a.RotateRoomKey(roomID)
+		}
+		return true, nil
+	}
+	return true, a.shareRoomKey(c, resp)
+}
+
+// ExitRoom stops sealing clipboard shares for the room entered last.
+func (a *App) ExitRoom() {
+	c := a.e2eState()
+	c.mu.Lock()
+	c.activeRoom = ""
+	c.mu.Unlock()
+}
+
+// ShareRoomKey wraps the room's current key for every member that has published
+// a public key but does not hold the key yet, e.g. on a room_key_needed event.
+func (a *App) ShareRoomKey(roomID string) error {
+	c := a.e2eState()
+	resp, err := a.fetchRoomKeys(roomID)
+	if err != nil {
+		return err
+	}
+	if !resp.Encrypted {
+		return errRoomNotEncrypted
+	}
+	c.absorb(resp)
+	return a.shareRoomKey(c, resp)
+}
+
+func (a *App) shareRoomKey(c *e2eClient, resp *RoomKeysResponse) error {
+	key := c.keyFor(resp.RoomID)(resp.Epoch)
+	if key == nil {
+		return nil // someone else holding the key has to share it
+	}
+	upload := RoomKeyUpload{Epoch: resp.Epoch, Keys: make(map[string]string)}
+	for _, member := range resp.Members {
+		if member.PublicKey == "" || containsInt(member.Epochs, resp.Epoch) {
+			continue
+		}
+		wrapped, err := wrapForMember(member, key, resp.RoomID)
+		if err != nil {
+			fmt.Printf("Skipping room key for %s: %v\n", member.UserID, err)
+			continue
+		}
+		upload.Keys[member.UserID] = wrapped
+	}
+	if len(upload.Keys) == 0 {
+		return nil
+	}
+	return a.uploadRoomKeys(resp.RoomID, upload)
+}
+
+// RotateRoomKey replaces an encrypted room's key with a new one wrapped for the
+// current members only, so members who left or were removed cannot read what
+// is shared from now on. The owner sets the first key; afterwards the owner or
+// a moderator may rotate.
+func (a *App) RotateRoomKey(roomID string) error {
+	c := a.e2eState()
+	userID, err := a.e2eUserID()
+	if err != nil {
+		return err
+	}
+	resp, err := a.fetchRoomKeys(roomID)
+	if err != nil {
+		return err
+	}
+	if !resp.Encrypted {
+		return errRoomNotEncrypted
+	}
+
+	key, err := newRoomKey()
+	if err != nil {
+		return err
+	}
+	upload := RoomKeyUpload{Epoch: resp.Epoch + 1, Keys: make(map[string]string)}
+	for _, member := range resp.Members {
+		if member.PublicKey == "" {
+			continue
+		}
+		wrapped, err := wrapForMember(member, key, roomID)
+		if err != nil {
+			fmt.Printf("Skipping room key for %s: %v\n", member.UserID, err)
+			continue
+		}
+		upload.Keys[member.UserID] = wrapped
+	}
+	if _, ok := upload.Keys[userID]; !ok {
+		return errors.New("your public key is not registered with the host yet")
+	}
+	if err := a.uploadRoomKeys(roomID, upload); err != nil {
+		return err
+	}
+	c.absorb(resp)
+	c.storeKey(roomID, upload.Epoch, key)
+	fmt.Printf("Room %s now uses key epoch %d\n", roomID, upload.Epoch)
+	return nil
+}
+
+func wrapForMember(member MemberKey, roomKey []byte, roomID string) (string, error) {
+	publicKey, err := decodePublicKey(member.PublicKey)
+	if err != nil {
+		return "", err
+	}
+	return wrapRoomKey(publicKey, roomKey, roomID)
+}
+
+func containsInt(values []int, v int) bool {
+	for _, existing := range values {
+		if existing == v {
+			return true
+		}
+	}
+	return false
+}
+
+// SealForRoom seals text, such as a chat message, with the room's current key.
+func (a *App) SealForRoom(roomID, plaintext string) (string, error) {
+	key, epoch, err := a.e2eState().currentKey(roomID)
+	if err != nil {
+		return "", err
+	}
+	return sealPayload(key, epoch, roomID, []byte(plaintext))
+}
+
+// OpenSealed decrypts a sealed chat message or clipboard text. Keys this app has
+// not seen yet, e.g. right after a rotation, are fetched once before giving up.
+func (a *App) OpenSealed(roomID, payload string) (string, error) {
+	plaintext, err := a.openSealed(roomID, payload)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+func (a *App) openSealed(roomID, payload string) ([]byte, error) {
+	c := a.e2eState()
+	plaintext, err := openPayload(c.keyFor(roomID), roomID, payload)
+	if errors.Is(err, errNoRoomKey) {
+		if resp, fetchErr := a.fetchRoomKeys(roomID); fetchErr == nil {
+			c.absorb(resp)
+			plaintext, err = openPayload(c.keyFor(roomID), roomID, payload)
+		}
+	}
+	return plaintext, err
+}
+
+// OpenClipboardItem decrypts the sealed text of a clipboard item shared in an
+// encrypted room back into the item it stands for. Files hold base names only.
+func (a *App) OpenClipboardItem(roomID, payload string) (*clip_helper.ClipboardItem, error) {
+	plaintext, err := a.openSealed(roomID, payload)
+	if err != nil {
+		return nil, err
+	}
+	var item clip_helper.ClipboardItem
+	if err := json.Unmarshal(plaintext, &item); err != nil {
+		return nil, fmt.Errorf("sealed clipboard item is malformed: %w", err)
+	}
+	return &item, nil
+}
+
+// sealClipboardItem returns the stand-in the host stores for item in an encrypted
+// room: only its type stays readable; everything else is sealed into Text.
+func (a *App) sealClipboardItem(roomID string, item *clip_helper.ClipboardItem) (*clip_helper.ClipboardItem, error) {
+	key, epoch, err := a.e2eState().currentKey(roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.RLock()
+	inner := *item
+	a.mu.RUnlock()
+	// Local paths mean nothing to other members and would leak the sharer's layout
+	inner.Files = make([]string, len(item.Files))
+	for i, path := range item.Files {
+		inner.Files[i] = filepath.Base(path)
+	}
+	data, err := json.Marshal(&inner)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := sealPayload(key, epoch, roomID, data)
+	if err != nil {
+		return nil, err
+	}
+	return &clip_helper.ClipboardItem{Type: item.Type, Text: sealed}, nil
+}
+
+// uploadClipboard sends a clipboard item to the host, sealing it first while an
+// encrypted room is active.
+func (a *App) uploadClipboard(item *clip_helper.ClipboardItem, userID, userName, idempotencyKey string) (*Operation, error) {
+	if roomID := a.e2eState().sealingRoom(); roomID != "" {
+		sealed, err := a.sealClipboardItem(roomID, item)
+		if err != nil {
+			return nil, err
+		}
+		item = sealed
+	}
+	return a.networkClient.uploadClipboardItem(item, userID, userName, idempotencyKey)
+}
+
+// uploadSharedFile sends the file behind a clipboard operation to the host. In an
+// encrypted room the file is sealed as it streams and its name and type stay local.
+func (a *App) uploadSharedFile(opID string, upload OutboxUpload) error {
+	roomID := a.e2eState().sealingRoom()
+	if roomID == "" {
+		if upload.Single {
+			return a.networkClient.UploadSingleFile(opID, upload.FilePath, upload.Name, upload.Mime, upload.Size, upload.Thumb)
+		}
+		return a.networkClient.UploadZipFile(opID, upload.FilePath)
+	}
+
+	key, epoch, err := a.e2eState().currentKey(roomID)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(upload.FilePath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(sealStream(pw, file, key, epoch, roomID))
+	}()
+	err = a.networkClient.uploadFileData(opID, pr, upload.Single, nil)
+	pr.Close()
+	return err
+}
+
+// SaveSealedFile downloads the sealed file of a clipboard operation in an
+// encrypted room, decrypts it into the user's Downloads folder as fileName and
+// returns the saved path.
+func (a *App) SaveSealedFile(roomID, opID, fileName string) (string, error) {
+	var src io.ReadCloser
+	if a.Mode == "client" {
+		body, err := a.networkClient.DownloadOperationFile(opID)
+		if err != nil {
+			return "", err
+		}
+		src = body
+	} else {
+		file, err := a.openSharedFile(roomID, opID)
+		if err != nil {
+			return "", err
+		}
+		src = file
+	}
+	defer src.Close()
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(home, "Downloads")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	name := filepath.Base(sanitizePlainText(fileName, 255))
+	if name == "" || name == "." || name == string(filepath.Separator) {
+		name = "shared_file_" + opID
+	}
+
+	tmp, err := os.CreateTemp(dir, ".gtw-*")
+	if err != nil {
+		return "", err
+	}
+	if err := openStream(tmp, src, a.e2eState().keyFor(roomID), roomID); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	dest := availablePath(dir, name)
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return dest, nil
+}
+
+// openSharedFile opens the host's copy of an operation's file for the current user.
+func (a *App) openSharedFile(roomID, opID string) (*os.File, error) {
+	userID, err := a.e2eUserID()
+	if err != nil {
+		return nil, err
+	}
+	op, opRoom := a.findOperationByID(opID)
+	if op == nil || op.Item == nil || opRoom != roomID || !a.userInRoom(userID, roomID) {
+		return nil, errors.New("operation not found in your rooms")
+	}
+	item, ok := op.Item.Data.(*clip_helper.ClipboardItem)
+	if !ok {
+		return nil, errors.New("operation has no file")
+	}
+	a.mu.RLock()
+	path := item.ArchiveFilePath
+	if item.IsSingleFile {
+		path = item.SingleFilePath
+	}
+	a.mu.RUnlock()
+	if path == "" {
+		return nil, errors.New("file is not uploaded yet")
+	}
+	return os.Open(path)
+}
+
+// availablePath returns dir/name, numbering the name if that file already exists.
+func availablePath(dir, name string) string {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	candidate := filepath.Join(dir, name)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+		candidate = filepath.Join(dir, base+" ("+strconv.Itoa(i)+")"+ext)
+	}
+}
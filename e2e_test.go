@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"GOproject/clip_helper"
+)
+
+// Round-trips room key wraps, sealed payloads and sealed file streams, and checks
+// that a payload or stream cannot be moved to another room or cut short.
+func TestE2ESealRoundTrip(t *testing.T) {
+	identity, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	roomKey, _ := newRoomKey()
+
+	wrapped, err := wrapRoomKey(identity.PublicKey(), roomKey, "room_1")
+	if err != nil || !validWrappedKey(wrapped) {
+		t.Fatalf("wrapRoomKey error: %v", err)
+	}
+	if got, err := unwrapRoomKey(identity, wrapped, "room_1"); err != nil || !bytes.Equal(got, roomKey) {
+		t.Fatalf("expected the room key back, got err %v", err)
+	}
+	if _, err := unwrapRoomKey(identity, wrapped, "room_2"); err == nil {
+		t.Fatalf("expected a key wrapped for another room to be refused")
+	}
+
+	keyFor := func(epoch int) []byte {
+		if epoch == 3 {
+			return roomKey
+		}
+		return nil
+	}
+	sealed, err := sealPayload(roomKey, 3, "room_1", []byte("hello"))
+	if err != nil || !isSealedPayload(sealed) || strings.Contains(sealed, "hello") {
+		t.Fatalf("unexpected sealed payload %q, err %v", sealed, err)
+	}
+	if plain, err := openPayload(keyFor, "room_1", sealed); err != nil || string(plain) != "hello" {
+		t.Fatalf("expected to open the payload, got %q err %v", plain, err)
+	}
+	if _, err := openPayload(keyFor, "room_2", sealed); !errors.Is(err, errSealedTampered) {
+		t.Fatalf("expected a payload moved to another room to fail, got %v", err)
+	}
+	if isSealedPayload("hello") || isSealedPayload("e2e1.0.AAAA") {
+		t.Fatalf("expected plain text and epoch 0 to be rejected as sealed payloads")
+	}
+
+	for _, size := range []int{0, 1, e2eStreamChunk, 2*e2eStreamChunk + 5} {
+		plain := make([]byte, size)
+		rand.Read(plain)
+		var stream bytes.Buffer
+		if err := sealStream(&stream, bytes.NewReader(plain), roomKey, 3, "room_1"); err != nil {
+			t.Fatalf("sealStream(%d) error: %v", size, err)
+		}
+		var opened bytes.Buffer
+		if err := openStream(&opened, bytes.NewReader(stream.Bytes()), keyFor, "room_1"); err != nil || !bytes.Equal(opened.Bytes(), plain) {
+			t.Fatalf("openStream(%d) did not round-trip, err %v", size, err)
+		}
+		if size > e2eStreamChunk {
+			// Dropping the final chunk leaves a stream that ends on a full, non-final chunk
+			cut := stream.Bytes()[:e2eStreamHeaderLen+e2eStreamChunk+e2eTagSize]
+			if err := openStream(&bytes.Buffer{}, bytes.NewReader(cut), keyFor, "room_1"); !errors.Is(err, errSealedTampered) {
+				t.Fatalf("expected a truncated %d-byte stream to fail, got %v", size, err)
+			}
+		}
+	}
+}
+
+// Verifies that an encrypted room refuses plaintext chat, clipboard items and
+// edits, and enforces who may set, rotate and hand on room keys.
+func TestEncryptedRoomHostRules(t *testing.T) {
+	app := newTestApp()
+	owner := app.CreateUser("Owner")
+	room := app.CreateEncryptedRoom("Secret", owner.ID)
+	if _, err := app.JoinRoom(owner.ID, room.ID); err != nil {
+		t.Fatalf("JoinRoom error: %v", err)
+	}
+	code, _ := app.CreateJoinCode(room.ID, owner.ID, 0, 0)
+	bob := app.CreateUser("Bob")
+	if rr := redeemJoinCode(app, bob.ID, code.Code); rr.Code != http.StatusOK {
+		t.Fatalf("redeem failed: %d", rr.Code)
+	}
+
+	if result := app.SendChatMessage(room.ID, bob.ID, "plain"); chatResultStatus(result) != http.StatusBadRequest {
+		t.Fatalf("expected plaintext chat to be refused, got %q", result)
+	}
+	roomKey, _ := newRoomKey()
+	sealed, _ := sealPayload(roomKey, 1, room.ID, []byte("hi"))
+	if result := app.SendChatMessage(room.ID, bob.ID, sealed); strings.HasPrefix(result, "Error") {
+		t.Fatalf("expected sealed chat to be stored, got %q", result)
+	}
+	if history := app.GetChatHistory(room.ID); len(history) != 1 || history[0].Message != sealed {
+		t.Fatalf("expected the sealed message to be stored verbatim, got %+v", history)
+	}
+
+	bobToken, _ := app.issueToken(bob.ID)
+	upload := func(body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/clipboard", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+bobToken)
+		rr := httptest.NewRecorder()
+		app.handleClipboardUpload(rr, req)
+		return rr.Code
+	}
+	if code := upload(`{"item":{"type":"text","text":"plain"}}`); code != http.StatusBadRequest {
+		t.Fatalf("expected plaintext clipboard item to be refused, got %d", code)
+	}
+	if code := upload(`{"item":{"type":"file","text":"` + sealed + `","files":["/home/bob/report.pdf"]}}`); code != http.StatusBadRequest {
+		t.Fatalf("expected readable file paths to be refused, got %d", code)
+	}
+	if code := upload(`{"item":{"type":"text","text":"` + sealed + `"}}`); code != http.StatusOK {
+		t.Fatalf("expected sealed clipboard item to be accepted, got %d", code)
+	}
+	item := app.historyStore.GetOperations(room.ID, "", "")[1].ItemID
+	plain := "edited"
+	if _, err := app.EditClipboardItem(room.ID, bob.ID, item, EditClipboardItemRequest{Text: &plain}); !errors.Is(err, errNotSealed) {
+		t.Fatalf("expected a plaintext edit to be refused, got %v", err)
+	}
+
+	ownerKey, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	bobKey, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	app.SetPublicKey(owner.ID, encodePublicKey(ownerKey.PublicKey()))
+	app.SetPublicKey(bob.ID, encodePublicKey(bobKey.PublicKey()))
+	wrapFor := func(key *ecdh.PrivateKey) string {
+		wrapped, _ := wrapRoomKey(key.PublicKey(), roomKey, room.ID)
+		return wrapped
+	}
+
+	if err := app.StoreRoomKeys(room.ID, bob.ID, RoomKeyUpload{Epoch: 1, Keys: map[string]string{bob.ID: wrapFor(bobKey)}}); !errors.Is(err, errNotModerator) {
+		t.Fatalf("expected a member to be unable to set the first key, got %v", err)
+	}
+	if err := app.StoreRoomKeys(room.ID, owner.ID, RoomKeyUpload{Epoch: 1, Keys: map[string]string{bob.ID: wrapFor(bobKey)}}); err == nil {
+		t.Fatalf("expected a new key without the owner's own copy to be refused")
+	}
+	ownerConn := attachClient(app, owner.ID)
+	if err := app.StoreRoomKeys(room.ID, owner.ID, RoomKeyUpload{Epoch: 1, Keys: map[string]string{owner.ID: wrapFor(ownerKey)}}); err != nil {
+		t.Fatalf("StoreRoomKeys error: %v", err)
+	}
+	evt, ok := findEvent(ownerConn.Events(), EventRoomKeyNeeded)
+	if !ok || decodeEventPayload[RoomKeyEvent](t, evt).UserID != bob.ID {
+		t.Fatalf("expected the owner to be asked to share the key with bob")
+	}
+	if err := app.StoreRoomKeys(room.ID, bob.ID, RoomKeyUpload{Epoch: 1, Keys: map[string]string{bob.ID: wrapFor(bobKey)}}); err == nil {
+		t.Fatalf("expected bob to be unable to hand on a key he does not hold")
+	}
+	if err := app.StoreRoomKeys(room.ID, owner.ID, RoomKeyUpload{Epoch: 1, Keys: map[string]string{bob.ID: "not-a-key"}}); err == nil {
+		t.Fatalf("expected a malformed wrapped key to be refused")
+	}
+	if err := app.StoreRoomKeys(room.ID, owner.ID, RoomKeyUpload{Epoch: 1, Keys: map[string]string{bob.ID: wrapFor(bobKey)}}); err != nil {
+		t.Fatalf("expected the owner to share the key with bob, got %v", err)
+	}
+
+	resp, err := app.GetRoomKeys(room.ID, bob.ID)
+	if err != nil || resp.Epoch != 1 || resp.Keys[1] == "" {
+		t.Fatalf("expected bob to receive the wrapped key, got %+v err %v", resp, err)
+	}
+	if got, err := unwrapRoomKey(bobKey, resp.Keys[1], room.ID); err != nil || !bytes.Equal(got, roomKey) {
+		t.Fatalf("expected bob to unwrap the room key, got err %v", err)
+	}
+	if err := app.StoreRoomKeys(room.ID, bob.ID, RoomKeyUpload{Epoch: 2, Keys: map[string]string{bob.ID: wrapFor(bobKey)}}); !errors.Is(err, errNotModerator) {
+		t.Fatalf("expected a member to be unable to rotate the key, got %v", err)
+	}
+}
+
+// Runs two client-mode apps against a host: the owner sets the first key and
+// shares it, content and files travel sealed, and a ban followed by a rotation
+// locks the banned member out of new content.
+func TestEncryptedRoomClients(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	host := newTestApp()
+	host.tempDir = t.TempDir()
+	alice := host.CreateUser("Alice")
+	room := host.CreateEncryptedRoom("Secret", alice.ID)
+	if _, err := host.JoinRoom(alice.ID, room.ID); err != nil {
+		t.Fatalf("JoinRoom error: %v", err)
+	}
+	code, _ := host.CreateJoinCode(room.ID, alice.ID, 0, 0)
+	bob, carol := host.CreateUser("Bob"), host.CreateUser("Carol")
+	for _, user := range []*User{bob, carol} {
+		if rr := redeemJoinCode(host, user.ID, code.Code); rr.Code != http.StatusOK {
+			t.Fatalf("redeem failed: %d", rr.Code)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/keys", host.handleKeys)
+	mux.HandleFunc("/api/rooms/", host.handleRoomRoutes)
+	mux.HandleFunc("/api/clipboard", host.handleClipboardUpload)
+	mux.HandleFunc("/api/clipboard/", host.handleClipboardItem)
+	mux.HandleFunc("/api/download/", host.handleDownload)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	newClient := func(user *User) *App {
+		client := newTestApp()
+		client.Mode = "client"
+		client.currentUser = user
+		client.networkClient = NewNetworkClient(ts.URL)
+		token, _ := host.issueToken(user.ID)
+		client.networkClient.SetAuthToken(token)
+		return client
+	}
+	aliceApp, bobApp := newClient(alice), newClient(bob)
+
+	if encrypted, err := aliceApp.EnterRoom(room.ID); err != nil || !encrypted {
+		t.Fatalf("expected alice to enter the encrypted room, got %v err %v", encrypted, err)
+	}
+	if host.rooms[room.ID].KeyEpoch != 1 {
+		t.Fatalf("expected the owner to set key epoch 1, got %d", host.rooms[room.ID].KeyEpoch)
+	}
+	if _, err := bobApp.EnterRoom(room.ID); err != nil {
+		t.Fatalf("bob EnterRoom error: %v", err)
+	}
+	if _, err := bobApp.SealForRoom(room.ID, "too early"); !errors.Is(err, errNoRoomKey) {
+		t.Fatalf("expected bob to have no key before it is shared, got %v", err)
+	}
+	if err := aliceApp.ShareRoomKey(room.ID); err != nil {
+		t.Fatalf("ShareRoomKey error: %v", err)
+	}
+	if _, err := bobApp.EnterRoom(room.ID); err != nil {
+		t.Fatalf("bob EnterRoom error: %v", err)
+	}
+
+	sealed, err := bobApp.SealForRoom(room.ID, "meet at noon")
+	if err != nil {
+		t.Fatalf("SealForRoom error: %v", err)
+	}
+	if result := host.SendChatMessage(room.ID, bob.ID, sealed); strings.HasPrefix(result, "Error") {
+		t.Fatalf("SendChatMessage error: %s", result)
+	}
+	if plain, err := aliceApp.OpenSealed(room.ID, host.GetChatHistory(room.ID)[0].Message); err != nil || plain != "meet at noon" {
+		t.Fatalf("expected alice to read bob's message, got %q err %v", plain, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "plan.txt")
+	os.WriteFile(path, []byte("the secret plan"), 0o644)
+	op, err := bobApp.uploadClipboard(&clip_helper.ClipboardItem{Type: clip_helper.ClipboardFile, Files: []string{path}}, bob.ID, bob.Name, "")
+	if err != nil {
+		t.Fatalf("uploadClipboard error: %v", err)
+	}
+	if err := bobApp.uploadSharedFile(op.ID, OutboxUpload{FilePath: path, Single: true, Name: "plan.txt"}); err != nil {
+		t.Fatalf("uploadSharedFile error: %v", err)
+	}
+	stored, _ := host.findOperationByID(op.ID)
+	storedItem := stored.Item.Data.(*clip_helper.ClipboardItem)
+	onDisk, _ := os.ReadFile(storedItem.SingleFilePath)
+	if storedItem.SingleFileName != "" || len(storedItem.Files) != 0 || bytes.Contains(onDisk, []byte("secret plan")) {
+		t.Fatalf("expected the host to hold only sealed data, got %+v", storedItem)
+	}
+	opened, err := aliceApp.OpenClipboardItem(room.ID, storedItem.Text)
+	if err != nil || len(opened.Files) != 1 || opened.Files[0] != "plan.txt" {
+		t.Fatalf("expected alice to see the file name, got %+v err %v", opened, err)
+	}
+	saved, err := aliceApp.SaveSealedFile(room.ID, op.ID, opened.Files[0])
+	if err != nil {
+		t.Fatalf("SaveSealedFile error: %v", err)
+	}
+	if data, _ := os.ReadFile(saved); string(data) != "the secret plan" {
+		t.Fatalf("expected the decrypted file, got %q", data)
+	}
+
+	if _, err := host.ModerateMember(room.ID, alice.ID, bob.ID, ModerationRequest{Action: ModerationBan}); err != nil {
+		t.Fatalf("ban error: %v", err)
+	}
+	if err := aliceApp.RotateRoomKey(room.ID); err != nil {
+		t.Fatalf("RotateRoomKey error: %v", err)
+	}
+	after, _ := aliceApp.SealForRoom(room.ID, "bob is gone")
+	if epoch, _, _ := parseSealedPayload(after); epoch != 2 {
+		t.Fatalf("expected new content to use key epoch 2, got %d", epoch)
+	}
+	if _, err := bobApp.EnterRoom(room.ID); err == nil {
+		t.Fatalf("expected the banned member to be refused the room keys")
+	}
+	if _, err := bobApp.OpenSealed(room.ID, after); err == nil {
+		t.Fatalf("expected the banned member to be unable to read new content")
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"GOproject/clip_helper"
+)
+
+// ExportFormat selects how a room's history is written by exportRoom.
+type ExportFormat string
+
+const (
+	ExportJSONL    ExportFormat = "jsonl"    // raw operation log, one operation per line
+	ExportMarkdown ExportFormat = "markdown" // human-readable chat and clipboard transcript
+	ExportBundle   ExportFormat = "bundle"   // tar of the log, transcript, manifest and shared files
+)
+
+// Entry names inside an export bundle.
+const (
+	bundleManifestName   = "manifest.json"
+	bundleOperationsName = "operations.jsonl"
+	bundleTranscriptName = "transcript.md"
+	bundleFilesDir       = "files"
+)
+
+// ExportManifest describes an export bundle. Files maps clipboard item IDs to the
+// bundle entry holding their shared file, since host paths are not part of the log.
+type ExportManifest struct {
+	RoomID     string            `json:"roomId"`
+	RoomName   string            `json:"roomName"`
+	ExportedAt int64             `json:"exportedAt"`
+	HeadHash   string            `json:"headHash,omitempty"`
+	Operations int               `json:"operations"`
+	Files      map[string]string `json:"files,omitempty"`
+}
+
+// ContentType returns the MIME type served for the format.
+func (f ExportFormat) ContentType() string {
+	switch f {
+	case ExportMarkdown:
+		return "text/markdown; charset=utf-8"
+	case ExportBundle:
+		return "application/x-tar"
+	default:
+		return "application/x-ndjson"
+	}
+}
+
+// FileName returns a download name for the room export.
+func (f ExportFormat) FileName(roomID string) string {
+	switch f {
+	case ExportMarkdown:
+		return roomID + "-transcript.md"
+	case ExportBundle:
+		return roomID + "-export.tar"
+	default:
+		return roomID + "-operations.jsonl"
+	}
+}
+
+func parseExportFormat(raw string) (ExportFormat, error) {
+	switch f := ExportFormat(strings.ToLower(strings.TrimSpace(raw))); f {
+	case "":
+		return ExportJSONL, nil
+	case ExportJSONL, ExportMarkdown, ExportBundle:
+		return f, nil
+	case "md":
+		return ExportMarkdown, nil
+	case "tar":
+		return ExportBundle, nil
+	default:
+		return "", fmt.Errorf("unknown export format %q", raw)
+	}
+}
+
+// exportRoom streams the room's retained history to w in the requested format.
+// History outlives the room itself, so a room that was cleaned up is exported
+// from the history store under its ID.
+func (a *App) exportRoom(roomID string, format ExportFormat, w io.Writer) error {
+	roomName := roomID
+	a.mu.RLock()
+	room, exists := a.rooms[roomID]
+	if exists {
+		roomName = room.Name
+	}
+	a.mu.RUnlock()
+
+	ops := a.historyStore.GetOperations(roomID, "", "")
+	if !exists && len(ops) == 0 {
+		return fmt.Errorf("room %s not found", roomID)
+	}
+	switch format {
+	case ExportJSONL:
+		return writeOperationsJSONL(w, ops)
+	case ExportMarkdown:
+		return writeMarkdownTranscript(w, roomName, ops)
+	case ExportBundle:
+		manifest := &ExportManifest{
+			RoomID:     roomID,
+			RoomName:   roomName,
+			ExportedAt: time.Now().Unix(),
+			Operations: len(ops),
+		}
+		if len(ops) > 0 {
+			manifest.HeadHash = ops[len(ops)-1].Hash
+		}
+		return writeExportBundle(w, manifest, ops)
+	default:
+		return fmt.Errorf("unknown export format %q", format)
+	}
+}
+
+// writeOperationsJSONL writes each operation, hashes included, as one JSON line.
+func writeOperationsJSONL(w io.Writer, ops []*Operation) error {
+	enc := json.NewEncoder(w)
+	for _, op := range ops {
+		if err := enc.Encode(op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeMarkdownTranscript renders the room's live chat and clipboard items.
+// Edited items show their latest content; deleted items are left out.
+func writeMarkdownTranscript(w io.Writer, roomName string, ops []*Operation) error {
+	state := materializeHistory(ops)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", roomName)
+	fmt.Fprintf(&b, "Exported %s\n\n", formatExportTime(time.Now().Unix()))
+	if len(ops) > 0 && ops[0].OpType == OpSnapshot {
+		b.WriteString("_Older history was compacted; only items still live at that point are included._\n\n")
+	}
+
+	b.WriteString("## Chat\n\n")
+	if len(state.Chat) == 0 {
+		b.WriteString("_No messages._\n\n")
+	}
+	for _, e := range state.Chat {
+		msg, ok := e.Item.Data.(*ChatMessage)
+		if !ok {
+			continue
+		}
+		edited := ""
+		if msg.EditedAt > 0 {
+			edited = " _(edited)_"
+		}
+		author := exportAuthor(msg.UserName, msg.UserID)
+		if msg.ImportedFrom != "" {
+			author = msg.ImportedFrom + " (imported)"
+		}
+		fmt.Fprintf(&b, "**%s** — %s%s\n\n", author, formatExportTime(msg.Timestamp), edited)
+		fmt.Fprintf(&b, "%s\n\n", quoteMarkdown(msg.Message))
+	}
+
+	b.WriteString("## Clipboard\n\n")
+	if len(state.Clipboard) == 0 {
+		b.WriteString("_No shared items._\n\n")
+	}
+	for _, e := range state.Clipboard {
+		clip, ok := e.Item.Data.(*clip_helper.ClipboardItem)
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(&b, "### %s — %s\n\n", exportAuthor(e.UserName, e.UserID), formatExportTime(e.Timestamp))
+		switch clip.Type {
+		case clip_helper.ClipboardText:
+			fence := codeFence(clip.Text)
+			fmt.Fprintf(&b, "%s\n%s\n%s\n\n", fence, clip.Text, fence)
+		case clip_helper.ClipboardImage:
+			b.WriteString("_Image_\n\n")
+		case clip_helper.ClipboardFile:
+			if clip.IsSingleFile && clip.SingleFileName != "" {
+				fmt.Fprintf(&b, "File: `%s` (%d bytes)\n\n", clip.SingleFileName, clip.SingleFileSize)
+			} else {
+				for _, f := range clip.Files {
+					fmt.Fprintf(&b, "- `%s`\n", filepath.Base(f))
+				}
+				b.WriteString("\n")
+			}
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeExportBundle writes a tar containing the manifest, the raw operation log,
+// the transcript and every shared file still on disk for a live clipboard item.
+func writeExportBundle(w io.Writer, manifest *ExportManifest, ops []*Operation) error {
+	tw := tar.NewWriter(w)
+
+	type bundleFile struct{ name, src string }
+	var files []bundleFile
+	manifest.Files = make(map[string]string)
+	for _, e := range materializeHistory(ops).Clipboard {
+		clip, ok := e.Item.Data.(*clip_helper.ClipboardItem)
+		if !ok {
+			continue
+		}
+		src, name := clip.SingleFilePath, clip.SingleFileName
+		if src == "" {
+			src, name = clip.ArchiveFilePath, "archive.tar"
+		}
+		if src == "" {
+			continue
+		}
+		if _, err := os.Stat(src); err != nil {
+			fmt.Printf("Export skipping missing shared file %s: %v\n", src, err)
+			continue
+		}
+		if name == "" {
+			name = filepath.Base(src)
+		}
+		entry := path.Join(bundleFilesDir, e.ItemID, filepath.Base(name))
+		manifest.Files[e.ItemID] = entry
+		files = append(files, bundleFile{name: entry, src: src})
+	}
+
+	var opsLog, transcript strings.Builder
+	if err := writeOperationsJSONL(&opsLog, ops); err != nil {
+		return err
+	}
+	if err := writeMarkdownTranscript(&transcript, manifest.RoomName, ops); err != nil {
+		return err
+	}
+
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	modTime := time.Unix(manifest.ExportedAt, 0)
+	for _, entry := range []struct {
+		name string
+		data string
+	}{
+		{bundleManifestName, string(manifestJSON)},
+		{bundleOperationsName, opsLog.String()},
+		{bundleTranscriptName, transcript.String()},
+	} {
+		if err := writeTarBytes(tw, entry.name, []byte(entry.data), modTime); err != nil {
+			return err
+		}
+	}
+	for _, f := range files {
+		if err := writeTarFile(tw, f.name, f.src); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func writeTarBytes(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: modTime, Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+func writeTarFile(tw *tar.Writer, name, src string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: info.Size(), ModTime: info.ModTime(), Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+func formatExportTime(ts int64) string {
+	return time.Unix(ts, 0).UTC().Format("2006-01-02 15:04:05 UTC")
+}
+
+func exportAuthor(name, id string) string {
+	switch {
+	case name != "":
+		return name
+	case id != "":
+		return id
+	default:
+		return "Unknown"
+	}
+}
+
+// codeFence returns a backtick fence longer than any backtick run in text, so
+// the text cannot close the block early.
+func codeFence(text string) string {
+	longest, run := 0, 0
+	for _, r := range text {
+		if r == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	return strings.Repeat("`", max(3, longest+1))
+}
+
+func quoteMarkdown(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = "> " + line
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"GOproject/clip_helper"
+)
+
+// Ensures the JSONL export is the raw op log, still verifies as a hash chain and
+// outlives the room.
+func TestExportRoomJSONL(t *testing.T) {
+	app, room, members := newTestRoom(t, "Archive", "", "Alice")
+	alice := members[0]
+	app.SendChatMessage(room.ID, alice.ID, "first")
+	app.SendChatMessage(room.ID, alice.ID, "second")
+
+	var out strings.Builder
+	if err := app.exportRoom(room.ID, ExportJSONL, &out); err != nil {
+		t.Fatalf("exportRoom error: %v", err)
+	}
+
+	var ops []*Operation
+	scanner := bufio.NewScanner(strings.NewReader(out.String()))
+	for scanner.Scan() {
+		var op Operation
+		if err := json.Unmarshal(scanner.Bytes(), &op); err != nil {
+			t.Fatalf("invalid JSONL line %q: %v", scanner.Text(), err)
+		}
+		ops = append(ops, &op)
+	}
+	if len(ops) != len(app.GetOperations(room.ID, "", "")) {
+		t.Fatalf("expected every operation exported, got %d", len(ops))
+	}
+	if report := verifyOperationChain(room.ID, ops); !report.Valid {
+		t.Fatalf("expected exported chain to verify, got %+v", report)
+	}
+
+	app.mu.Lock()
+	delete(app.rooms, room.ID)
+	app.mu.Unlock()
+	var again strings.Builder
+	if err := app.exportRoom(room.ID, ExportJSONL, &again); err != nil || again.String() != out.String() {
+		t.Fatalf("expected a cleaned up room to export from its history, got %v", err)
+	}
+	if err := app.exportRoom("room_missing", ExportJSONL, io.Discard); err == nil {
+		t.Fatalf("expected a room without history to be reported missing")
+	}
+}
+
+// Verifies the transcript shows edited content and leaves out deleted messages.
+func TestExportRoomMarkdown(t *testing.T) {
+	app, room, members := newTestRoom(t, "Archive", "", "Alice")
+	alice := members[0]
+	app.SendChatMessage(room.ID, alice.ID, "typo here")
+	app.SendChatMessage(room.ID, alice.ID, "please forget this")
+	msgs := app.GetChatHistory(room.ID)
+	if result := app.EditChatMessage(room.ID, alice.ID, msgs[0].ID, "fixed text"); strings.HasPrefix(result, "Error") {
+		t.Fatalf("EditChatMessage: %s", result)
+	}
+	if result := app.DeleteChatMessage(room.ID, alice.ID, msgs[1].ID); strings.HasPrefix(result, "Error") {
+		t.Fatalf("DeleteChatMessage: %s", result)
+	}
+	snippet := &clip_helper.ClipboardItem{Type: clip_helper.ClipboardText, Text: "```\n# not a heading\n```"}
+	app.historyStore.AddOperation(room.ID, OpAdd, "clip_1", &Item{ID: "clip_1", Type: ItemClipboard, Data: snippet}, alice.ID, alice.Name)
+
+	var out strings.Builder
+	if err := app.exportRoom(room.ID, ExportMarkdown, &out); err != nil {
+		t.Fatalf("exportRoom error: %v", err)
+	}
+	md := out.String()
+	if !strings.Contains(md, "# Archive") || !strings.Contains(md, "> fixed text") || !strings.Contains(md, "(edited)") {
+		t.Fatalf("expected edited message in transcript, got:\n%s", md)
+	}
+	if strings.Contains(md, "typo here") || strings.Contains(md, "please forget this") {
+		t.Fatalf("expected superseded and deleted content left out, got:\n%s", md)
+	}
+	if !strings.Contains(md, "````\n"+snippet.Text+"\n````") {
+		t.Fatalf("expected a fence longer than the backticks in the clip, got:\n%s", md)
+	}
+}
+
+// Exercises the bundle endpoint, checking membership and that shared files are packed.
+func TestHandleRoomExportBundle(t *testing.T) {
+	app, room, members := newTestRoom(t, "Archive", "", "Alice")
+	alice := members[0]
+	outsider := app.CreateUser("Outsider")
+	app.SendChatMessage(room.ID, alice.ID, "notes attached")
+
+	filePath := filepath.Join(t.TempDir(), "notes.txt")
+	if err := os.WriteFile(filePath, []byte("meeting notes"), 0644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	clip := &clip_helper.ClipboardItem{Type: clip_helper.ClipboardFile, Files: []string{"notes.txt"}, IsSingleFile: true, SingleFileName: "notes.txt", SingleFilePath: filePath}
+	app.historyStore.AddOperation(room.ID, OpAdd, "clip_1", &Item{ID: "clip_1", Type: ItemClipboard, Data: clip}, alice.ID, alice.Name)
+
+	outsiderToken, _ := app.issueToken(outsider.ID)
+	req := httptest.NewRequest(http.MethodGet, "/api/rooms/"+room.ID+"/export?format=bundle", nil)
+	req.Header.Set("Authorization", "Bearer "+outsiderToken)
+	rr := httptest.NewRecorder()
+	app.handleRoomRoutes(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("non-member export expected 403, got %d", rr.Code)
+	}
+
+	token, _ := app.issueToken(alice.ID)
+	req = httptest.NewRequest(http.MethodGet, "/api/rooms/"+room.ID+"/export?format=pdf", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr = httptest.NewRecorder()
+	app.handleRoomRoutes(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("unknown format expected 400, got %d", rr.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/rooms/"+room.ID+"/export?format=bundle", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr = httptest.NewRecorder()
+	app.handleRoomRoutes(rr, req)
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/x-tar" {
+		t.Fatalf("bundle export expected 200 tar, got %d %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+
+	entries := make(map[string]string)
+	tr := tar.NewReader(rr.Body)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("read bundle: %v", err)
+		}
+		data, _ := io.ReadAll(tr)
+		entries[hdr.Name] = string(data)
+	}
+
+	for _, name := range []string{bundleManifestName, bundleOperationsName, bundleTranscriptName} {
+		if _, ok := entries[name]; !ok {
+			t.Fatalf("bundle missing %s, has %v", name, entries)
+		}
+	}
+	var manifest ExportManifest
+	if err := json.Unmarshal([]byte(entries[bundleManifestName]), &manifest); err != nil {
+		t.Fatalf("decode manifest: %v", err)
+	}
+	entry, ok := manifest.Files["clip_1"]
+	if !ok || entries[entry] != "meeting notes" {
+		t.Fatalf("expected shared file packed at %q, got manifest %+v", entry, manifest)
+	}
+	if !strings.Contains(entries[bundleTranscriptName], "notes.txt") {
+		t.Fatalf("expected transcript to list the shared file")
+	}
+}
@@ -33,6 +33,7 @@ func TestSSEManagerBasics(t *testing.T) {
 	if err := sm.SendHeartbeat("user1"); err != nil {
 		t.Fatalf("SendHeartbeat error: %v", err)
 	}
+	client.waitIdle()
 	if mw.buf.Len() == 0 || !mw.flushed {
 		t.Fatalf("expected heartbeat to write and flush")
 	}
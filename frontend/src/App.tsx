@@ -13,7 +13,7 @@ import HostDashboard from './components/HostDashboard';
 import TitleBar from './components/TitleBar';
 import { SettingsModal, AboutModal } from './components/Modals';
 import { AppState } from './types/fsm';
-import { User, Room, InviteEventPayload } from './api/types';
+import { User, Room, InviteEventPayload, ModerationEvent } from './api/types';
 import { connectSSE, addSSEListener, removeSSEListener } from './sse';
 import { httpAcceptInvite, httpFetchRooms, httpApproveJoin } from './api/httpClient';
 import { hostApproveJoin } from './api/wailsBridge';
@@ -414,16 +414,25 @@ function App() {
           console.warn("SSE Disconnected");
       };
 
+      const onModeration = (evt: ModerationEvent) => {
+          if (evt.targetId !== currentUser.id || (evt.action !== 'kick' && evt.action !== 'ban')) return;
+          setCurrentRoom(null);
+          setState('LOBBY');
+          alert(evt.action === 'ban' ? 'You were banned from the room' : 'You were removed from the room');
+      };
+
       addSSEListener('user_invited', onInvite);
       addSSEListener('user_joined', onJoin);
       addSSEListener('join_request', onJoinRequest);
       addSSEListener('disconnected', onDisconnect);
+      addSSEListener('room_moderation', onModeration);
 
       return () => {
           removeSSEListener('user_invited', onInvite);
           removeSSEListener('user_joined', onJoin);
           removeSSEListener('join_request', onJoinRequest);
           removeSSEListener('disconnected', onDisconnect);
+          removeSSEListener('room_moderation', onModeration);
       };
     }
   }, [appMode, currentUser, fetchAndJoinRoom]);
@@ -478,9 +487,13 @@ function App() {
     }
   };
 
-  const handleUserCreated = (user: { id: string; name: string }) => {
-    setCurrentUser({ ...user, roomId: null, isOnline: true });
+  const handleUserCreated = (user: { id: string; name: string; roomId?: string | null }) => {
+    setCurrentUser({ ...user, roomId: user.roomId ?? null, isOnline: true });
     setState('LOBBY');
+    // A restored identity goes straight back to the room it was in
+    if (user.roomId) {
+      void fetchAndJoinRoom(user.roomId);
+    }
   };
 
   const handleJoinRoom = (room: Room) => {
@@ -6,11 +6,19 @@ import type {
   CreateUserRequest,
   CreateUserResponse,
   InviteUserRequest,
+  JoinCode,
   JoinRoomRequest,
   LeaveRoomRequest,
+  ModerationAction,
+  ModerationEvent,
+  RoomRole,
   User,
   Room,
   Operation,
+  OperationPage,
+  SearchFilters,
+  SearchResult,
+  SessionTokens,
 } from "./types";
 
 let API_BASE_URL = "http://localhost:8080";
@@ -73,12 +81,71 @@ export function setAuthToken(token: string | null) {
   }
 }
 
+// Access tokens are short-lived; the refresh token renews them shortly before they expire
+let refreshToken: string | null = null;
+let tokenExpiresAt = 0;
+let refreshing: Promise<boolean> | null = null;
+const TOKEN_REFRESH_MARGIN_MS = 60_000;
+
+export function setSession(tokens: SessionTokens | null) {
+  setAuthToken(tokens ? tokens.token : null);
+  refreshToken = tokens ? tokens.refreshToken : null;
+  tokenExpiresAt = tokens ? tokens.expiresAt * 1000 : 0;
+  if (typeof localStorage !== "undefined") {
+    if (tokens) {
+      localStorage.setItem("refreshToken", tokens.refreshToken);
+      localStorage.setItem("tokenExpiresAt", String(tokenExpiresAt));
+    } else {
+      localStorage.removeItem("refreshToken");
+      localStorage.removeItem("tokenExpiresAt");
+    }
+  }
+}
+
+/**
+ * Exchange the refresh token for new session tokens. Concurrent callers share one
+ * request, since a refresh token is single use.
+ */
+export function refreshSession(): Promise<boolean> {
+  if (!refreshToken) return Promise.resolve(false);
+  if (!refreshing) {
+    const presented = refreshToken;
+    refreshing = fetch(`${API_BASE_URL}/api/auth/refresh`, {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ refreshToken: presented }),
+    })
+      .then(async (response) => {
+        if (!response.ok) {
+          if (response.status === 401) setSession(null);
+          return false;
+        }
+        setSession((await response.json()) as SessionTokens);
+        return true;
+      })
+      .catch(() => false)
+      .finally(() => {
+        refreshing = null;
+      });
+  }
+  return refreshing;
+}
+
+/** Refresh the access token if it expires within the next minute. */
+export async function ensureFreshToken(): Promise<void> {
+  if (refreshToken && tokenExpiresAt - Date.now() < TOKEN_REFRESH_MARGIN_MS) {
+    await refreshSession();
+  }
+}
+
 function loadAuthTokenFromStorage() {
   if (typeof localStorage === "undefined") return;
   const stored = localStorage.getItem("authToken");
   if (stored) {
     authToken = stored;
   }
+  refreshToken = localStorage.getItem("refreshToken");
+  tokenExpiresAt = Number(localStorage.getItem("tokenExpiresAt") || 0);
 }
 
 // Initialize auth token from storage on module load (browser context)
@@ -92,7 +159,9 @@ export class HttpError extends Error {
   }
 }
 
-async function request<T>(path: string, init: RequestInit = {}): Promise<T> {
+async function request<T>(path: string, init: RequestInit = {}, retried = false): Promise<T> {
+  await ensureFreshToken();
+
   const baseHeaders: HeadersInit = {
     "Content-Type": "application/json",
     ...(authToken ? { Authorization: `Bearer ${authToken}` } : {}),
@@ -106,6 +175,15 @@ async function request<T>(path: string, init: RequestInit = {}): Promise<T> {
     ...init,
   });
 
+  // The access token may have been revoked or expired early; renew it once and retry
+  if (response.status === 401 && !retried && (await refreshSession())) {
+    return request<T>(path, init, true);
+  }
+
+  if (response.status === 429) {
+    const retryAfter = response.headers.get("Retry-After");
+    throw new HttpError(429, `Too many requests${retryAfter ? `, try again in ${retryAfter}s` : ""}`);
+  }
   if (!response.ok) {
     throw new HttpError(response.status, `Request to ${path} failed with status ${response.status}`);
   }
@@ -117,15 +195,52 @@ export async function httpFetchUsers(): Promise<User[]> {
   return request<User[]>("/api/users");
 }
 
+/**
+ * The random key this browser identifies itself with, created on first use.
+ * Sending it again restores the same user and rooms while the host still
+ * remembers them.
+ */
+export function getDeviceKey(): string {
+  let key = typeof localStorage !== "undefined" ? localStorage.getItem("deviceKey") : null;
+  if (!key) {
+    const bytes = new Uint8Array(32);
+    crypto.getRandomValues(bytes);
+    key = btoa(String.fromCharCode(...bytes)).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+    if (typeof localStorage !== "undefined") {
+      localStorage.setItem("deviceKey", key);
+    }
+  }
+  return key;
+}
+
 export async function httpCreateUser(payload: CreateUserRequest): Promise<CreateUserResponse> {
   const resp = await request<CreateUserResponse>("/api/users", {
     method: "POST",
-    body: JSON.stringify(payload),
+    body: JSON.stringify({ deviceKey: getDeviceKey(), ...payload }),
   });
-  setAuthToken(resp.token);
+  setSession(resp);
   return resp;
 }
 
+/**
+ * Open an additional session for the current user, e.g. for the desktop backend.
+ * The host requires the current session's refresh token as proof of the session.
+ */
+export async function httpOpenSession(): Promise<SessionTokens> {
+  return request<SessionTokens>("/api/auth/session", {
+    method: "POST",
+    body: JSON.stringify({ refreshToken }),
+  });
+}
+
+export async function httpLogout(): Promise<ApiMessageResponse> {
+  try {
+    return await request<ApiMessageResponse>("/api/auth/logout", { method: "POST" });
+  } finally {
+    setSession(null);
+  }
+}
+
 export async function httpInviteUser(payload: InviteUserRequest): Promise<ApiMessageResponse> {
   return request<ApiMessageResponse>("/api/invite", {
     method: "POST",
@@ -161,6 +276,37 @@ export async function httpApproveJoin(ownerId: string, requesterId: string, room
   });
 }
 
+export async function httpRedeemJoinCode(code: string): Promise<ApiMessageResponse> {
+  return request<ApiMessageResponse>("/api/join/code", {
+    method: "POST",
+    body: JSON.stringify({ code }),
+  });
+}
+
+export async function httpCreateJoinCode(roomId: string, expiresInSeconds?: number, maxUses?: number): Promise<JoinCode> {
+  return request<JoinCode>(`/api/rooms/${roomId}/codes`, {
+    method: "POST",
+    body: JSON.stringify({ expiresInSeconds, maxUses }),
+  });
+}
+
+export async function httpListJoinCodes(roomId: string): Promise<JoinCode[]> {
+  return request<JoinCode[]>(`/api/rooms/${roomId}/codes`);
+}
+
+export async function httpRevokeJoinCode(roomId: string, code: string): Promise<ApiMessageResponse> {
+  return request<ApiMessageResponse>(`/api/rooms/${roomId}/codes/${encodeURIComponent(code)}`, {
+    method: "DELETE",
+  });
+}
+
+export async function httpModerateMember(roomId: string, userId: string, action: ModerationAction, role?: RoomRole): Promise<ModerationEvent> {
+  return request<ModerationEvent>(`/api/rooms/${roomId}/members/${encodeURIComponent(userId)}/${action}`, {
+    method: "POST",
+    body: role ? JSON.stringify({ role }) : undefined,
+  });
+}
+
 export async function httpLeaveRoom(payload: LeaveRoomRequest): Promise<ApiMessageResponse> {
   return request<ApiMessageResponse>("/api/leave", {
     method: "POST",
@@ -172,10 +318,10 @@ export async function httpFetchRooms(): Promise<Room[]> {
   return request<Room[]>("/api/rooms");
 }
 
-export async function httpCreateRoom(name: string): Promise<Room> {
+export async function httpCreateRoom(name: string, encrypted = false): Promise<Room> {
   return request<Room>("/api/rooms", {
     method: "POST",
-    body: JSON.stringify({ name }),
+    body: JSON.stringify({ name, encrypted }),
   });
 }
 
@@ -190,6 +336,14 @@ export async function httpSendChatMessage(payload: ChatMessageRequest): Promise<
   });
 }
 
+export async function httpSearchRoom(roomId: string, query: string, filters: SearchFilters = {}): Promise<SearchResult[]> {
+  const params = new URLSearchParams({ q: query });
+  for (const [key, value] of Object.entries(filters)) {
+    if (value !== undefined && value !== "") params.set(key, String(value));
+  }
+  return request<SearchResult[]>(`/api/rooms/${roomId}/search?${params.toString()}`);
+}
+
 export async function httpFetchOperations(roomId: string, sinceId: string = ""): Promise<Operation[]> {
   let url = `/api/operations/${roomId}`;
   if (sinceId) {
@@ -198,3 +352,14 @@ export async function httpFetchOperations(roomId: string, sinceId: string = ""):
   return request<Operation[]>(url);
 }
 
+export async function httpFetchOperationPage(
+  roomId: string,
+  cursor: { after?: string; before?: string } = {},
+  limit: number = 100,
+): Promise<OperationPage> {
+  const params = new URLSearchParams({ limit: String(limit) });
+  if (cursor.after) params.set("after", cursor.after);
+  if (cursor.before) params.set("before", cursor.before);
+  return request<OperationPage>(`/api/operations/${roomId}?${params.toString()}`);
+}
+
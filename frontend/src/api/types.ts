@@ -3,6 +3,8 @@ export interface User {
   name: string;
   roomId?: string | null;
   isOnline: boolean;
+  publicKey?: string; // X25519 key for encrypted rooms, base64
+  offlineSince?: number; // unix seconds; offline users are removed after a day
 }
 
 export interface Room {
@@ -10,6 +12,25 @@ export interface Room {
   name: string;
   ownerId?: string;
   userIds: string[];
+  retention?: RoomRetention;
+  moderatorIds?: string[];
+  viewerIds?: string[]; // read-only members
+  bannedUserIds?: string[];
+  mutedUserIds?: string[];
+  encrypted?: boolean; // chat and clipboard content is sealed by members' clients
+  keyEpoch?: number; // current room key; 0 until the owner sets the first one
+}
+
+// Per-room retention limits; omitted fields use the host defaults
+export interface RoomRetention {
+  maxOperations?: number;
+  maxAgeSeconds?: number;
+  maxFileBytes?: number;
+}
+
+export interface RoomSettings {
+  roomId?: string;
+  retention: RoomRetention;
 }
 
 export interface ChatMessage {
@@ -19,6 +40,8 @@ export interface ChatMessage {
   userName: string;
   message: string;
   timestamp: number;
+  editedAt?: number;
+  importedFrom?: string; // author named in an imported bundle
 }
 
 export interface ApiMessageResponse {
@@ -39,18 +62,42 @@ export interface InviteEventPayload {
 }
 
 export interface SSEEnvelope<T> {
+  id: number;
   type: string;
   data: T;
   timestamp: number;
 }
 
+export interface TypingEvent {
+  roomId: string;
+  userId: string;
+  userName: string;
+  typing: boolean;
+}
+
+export interface PresenceEvent {
+  userId: string;
+  status: string;
+}
+
+export interface ResyncRequiredEvent {
+  lastEventId: number;
+  reason: string;
+}
+
 export interface CreateUserRequest {
   name: string;
+  deviceKey?: string; // restores the user created with the same key, if the host still has it
 }
 
-export interface CreateUserResponse {
-  user: User;
+export interface SessionTokens {
   token: string;
+  refreshToken: string;
+  expiresAt: number; // access token expiry, unix seconds
+}
+
+export interface CreateUserResponse extends SessionTokens {
+  user: User;
 }
 
 export interface InviteUserRequest {
@@ -81,6 +128,7 @@ export interface JoinRoomRequest {
 
 export interface CreateRoomRequest {
   name: string;
+  encrypted?: boolean;
 }
 
 export interface CopiedItem {
@@ -110,4 +158,118 @@ export interface Operation {
   userName?: string;
 }
 
+// Page of operations from GET /api/operations/{roomId}?limit=&after=|before=
+export interface OperationPage {
+  operations: Operation[];
+  hasMore: boolean;
+  next?: string;
+}
+
+export interface SearchFilters {
+  author?: string;
+  type?: "chat" | "clipboard";
+  since?: number;
+  until?: number;
+  limit?: number;
+}
+
+export interface SearchResult {
+  operationId: string;
+  latestOperationId?: string;
+  itemId: string;
+  type: "chat" | "clipboard";
+  userId?: string;
+  userName?: string;
+  timestamp: number;
+  snippet: string;
+}
+
+// Payload of chat_message_deleted / clipboard_removed SSE events
+export interface ItemRemovedEvent {
+  roomId: string;
+  itemId: string;
+  operationId: string;
+  removedBy: string;
+}
+
+// Payload of retention_expired SSE events
+export interface RetentionNotice {
+  roomId: string;
+  reason: "max_age" | "max_file_bytes";
+  itemIds: string[];
+  freedBytes?: number;
+}
+
+// Entry in a compaction snapshot (operation with opType "snapshot")
+export interface SnapshotEntry {
+  opId: string;
+  itemId: string;
+  userId?: string;
+  userName?: string;
+  timestamp: number;
+  item: Operation["item"];
+}
+
 export type AppMode = "host" | "client" | "pending";
+
+// Host found on the LAN through zeroconf
+export interface DiscoveredHost {
+  name: string;
+  url: string;
+  fingerprint?: string; // sha256:<hex> of the host's TLS certificate, pinned on discovery
+  fingerprintChanged?: boolean; // the host announced a different certificate than the pinned one
+}
+
+// Code that lets anyone join a room without the owner approving them
+export interface JoinCode {
+  code: string; // XXXX-XXXX
+  roomId: string;
+  createdBy: string;
+  createdAt: string;
+  expiresAt: string;
+  maxUses?: number; // omitted allows any number of uses until expiry
+  uses: number;
+}
+
+export type RoomRole = "owner" | "moderator" | "member" | "viewer";
+
+export type ModerationAction = "role" | "kick" | "ban" | "unban" | "mute" | "unmute";
+
+// Payload of the room_moderation SSE event
+export interface ModerationEvent {
+  roomId: string;
+  action: ModerationAction;
+  actorId: string;
+  targetId: string;
+  role?: RoomRole; // set for role changes
+}
+
+export function roleOf(room: Room, userId: string): RoomRole {
+  if (room.ownerId === userId) return "owner";
+  if (room.moderatorIds?.includes(userId)) return "moderator";
+  if (room.viewerIds?.includes(userId)) return "viewer";
+  return "member";
+}
+
+// A client-mode request queued for the host, as reported by the outbox:changed event
+export interface OutboxEntry {
+  id: string; // also the request's idempotency key
+  kind: 'chat' | 'clipboard' | 'file_upload';
+  state: 'queued' | 'sending' | 'delivered' | 'failed';
+  roomId?: string;
+  userId: string;
+  userName?: string;
+  message?: string;
+  attempts: number;
+  lastError?: string;
+  createdAt: number;
+  deliveredAt?: number;
+}
+
+// Payload of the room_key_needed and room_key_updated SSE events
+export interface RoomKeyEvent {
+  roomId: string;
+  epoch: number;
+  userId?: string; // member still missing the key (room_key_needed)
+  publicKey?: string;
+}
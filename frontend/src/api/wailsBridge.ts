@@ -15,7 +15,7 @@ import {
   SetUser,
 } from "../../wailsjs/go/main/App";
 import type { main } from "../../wailsjs/go/models";
-import type { AppMode, ChatMessage, Room, User, Operation } from "./types";
+import type { AppMode, ChatMessage, DiscoveredHost, Room, User, Operation, SessionTokens, JoinCode, ModerationAction, ModerationEvent, RoomRole, OutboxEntry } from "./types";
 
 function mapUser(user: main.User): User {
   return {
@@ -32,6 +32,8 @@ function mapRoom(room: main.Room): Room {
     name: room.name,
     ownerId: (room as any).ownerId, // Cast to any because bindings might not be updated yet
     userIds: [...room.userIds],
+    encrypted: (room as any).encrypted,
+    keyEpoch: (room as any).keyEpoch,
   };
 }
 
@@ -145,3 +147,115 @@ export function shareSystemClipboard(): Promise<boolean> {
 export async function hostSetServerURL(url: string): Promise<void> {
   await SetServerURL(url);
 }
+
+/** Browses the LAN for hosts for a few seconds, pinning the certificates HTTPS hosts announce. */
+export function hostDiscoverHosts(): Promise<DiscoveredHost[]> {
+  // @ts-ignore
+  return window.go?.main?.App?.DiscoverHosts?.() ?? Promise.reject(new Error("DiscoverHosts binding unavailable"));
+}
+
+/** Base URL for the UI's API traffic: the server itself, or the app's pinned proxy to an HTTPS server. */
+export function hostAPIURL(): Promise<string> {
+  // @ts-ignore
+  return window.go?.main?.App?.HostAPIURL?.() ?? Promise.reject(new Error("HostAPIURL binding unavailable"));
+}
+
+export function hostForgetServerFingerprint(): Promise<void> {
+  // @ts-ignore
+  return window.go?.main?.App?.ForgetServerFingerprint?.() ?? Promise.reject(new Error("ForgetServerFingerprint binding unavailable"));
+}
+
+/** Client mode: queues a chat message in the outbox, which delivers it once the host answers. */
+export function hostPostChatMessage(roomId: string, message: string): Promise<OutboxEntry> {
+  // @ts-ignore
+  return window.go?.main?.App?.PostChatMessage?.(roomId, message) ?? Promise.reject(new Error("PostChatMessage binding unavailable"));
+}
+
+export function hostGetOutbox(): Promise<OutboxEntry[]> {
+  // @ts-ignore
+  return window.go?.main?.App?.GetOutbox?.() ?? Promise.reject(new Error("GetOutbox binding unavailable"));
+}
+
+export function hostRetryOutboxEntry(id: string): Promise<void> {
+  // @ts-ignore
+  return window.go?.main?.App?.RetryOutboxEntry?.(id) ?? Promise.reject(new Error("RetryOutboxEntry binding unavailable"));
+}
+
+export function hostDiscardOutboxEntry(id: string): Promise<void> {
+  // @ts-ignore
+  return window.go?.main?.App?.DiscardOutboxEntry?.(id) ?? Promise.reject(new Error("DiscardOutboxEntry binding unavailable"));
+}
+
+export async function hostSetSession(tokens: SessionTokens): Promise<void> {
+  // @ts-ignore
+  await window.go?.main?.App?.SetSession?.(tokens);
+}
+
+/** Authenticated link to an operation's shared files, for the system browser. */
+export function hostDownloadURL(userId: string, opId: string): Promise<string> {
+  // @ts-ignore
+  return window.go?.main?.App?.DownloadURL?.(userId, opId) ?? Promise.reject(new Error("DownloadURL binding unavailable"));
+}
+
+export function hostRedeemJoinCode(userId: string, code: string): Promise<Room> {
+  // @ts-ignore
+  return window.go?.main?.App?.RedeemJoinCode?.(userId, code).then(mapRoom) ?? Promise.reject(new Error("RedeemJoinCode binding unavailable"));
+}
+
+export function hostCreateJoinCode(roomId: string, ownerId: string): Promise<JoinCode> {
+  // Durations cross the Wails bridge as nanoseconds; 0 uses the host defaults
+  // @ts-ignore
+  return window.go?.main?.App?.CreateJoinCode?.(roomId, ownerId, 0, 0) ?? Promise.reject(new Error("CreateJoinCode binding unavailable"));
+}
+
+export function hostModerateMember(roomId: string, actorId: string, userId: string, action: ModerationAction, role?: RoomRole): Promise<ModerationEvent> {
+  // @ts-ignore
+  return window.go?.main?.App?.ModerateMember?.(roomId, actorId, userId, { action, role }) ?? Promise.reject(new Error("ModerateMember binding unavailable"));
+}
+
+export function hostCreateEncryptedRoom(name: string, ownerId: string): Promise<Room> {
+  // @ts-ignore
+  return window.go?.main?.App?.CreateEncryptedRoom?.(name, ownerId).then(mapRoom) ?? Promise.reject(new Error("CreateEncryptedRoom binding unavailable"));
+}
+
+/** Publishes this app's public key and loads the room's keys; resolves to whether the room is encrypted. */
+export function e2eEnterRoom(roomId: string): Promise<boolean> {
+  // @ts-ignore
+  return window.go?.main?.App?.EnterRoom?.(roomId) ?? Promise.reject(new Error("EnterRoom binding unavailable"));
+}
+
+export function e2eExitRoom(): Promise<void> {
+  // @ts-ignore
+  return window.go?.main?.App?.ExitRoom?.() ?? Promise.resolve();
+}
+
+export function e2eShareRoomKey(roomId: string): Promise<void> {
+  // @ts-ignore
+  return window.go?.main?.App?.ShareRoomKey?.(roomId) ?? Promise.reject(new Error("ShareRoomKey binding unavailable"));
+}
+
+export function e2eRotateRoomKey(roomId: string): Promise<void> {
+  // @ts-ignore
+  return window.go?.main?.App?.RotateRoomKey?.(roomId) ?? Promise.reject(new Error("RotateRoomKey binding unavailable"));
+}
+
+export function e2eSeal(roomId: string, plaintext: string): Promise<string> {
+  // @ts-ignore
+  return window.go?.main?.App?.SealForRoom?.(roomId, plaintext) ?? Promise.reject(new Error("SealForRoom binding unavailable"));
+}
+
+export function e2eOpen(roomId: string, payload: string): Promise<string> {
+  // @ts-ignore
+  return window.go?.main?.App?.OpenSealed?.(roomId, payload) ?? Promise.reject(new Error("OpenSealed binding unavailable"));
+}
+
+export function e2eOpenClipboardItem(roomId: string, payload: string): Promise<Operation["item"]["data"]> {
+  // @ts-ignore
+  return window.go?.main?.App?.OpenClipboardItem?.(roomId, payload) ?? Promise.reject(new Error("OpenClipboardItem binding unavailable"));
+}
+
+/** Downloads and decrypts a sealed file into the Downloads folder; resolves to the saved path. */
+export function e2eSaveSealedFile(roomId: string, opId: string, fileName: string): Promise<string> {
+  // @ts-ignore
+  return window.go?.main?.App?.SaveSealedFile?.(roomId, opId, fileName) ?? Promise.reject(new Error("SaveSealedFile binding unavailable"));
+}
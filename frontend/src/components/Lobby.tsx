@@ -1,6 +1,6 @@
 import React, { useEffect, useState } from 'react';
-import { hostListUsers, hostListRooms, hostCreateRoom, hostJoinRoom, hostInviteUser, hostRequestJoin } from '../api/wailsBridge';
-import { httpFetchUsers, httpFetchRooms, httpCreateRoom, httpJoinRoom, httpInviteUser, httpRequestJoin } from '../api/httpClient';
+import { hostListUsers, hostListRooms, hostCreateRoom, hostCreateEncryptedRoom, hostJoinRoom, hostInviteUser, hostRequestJoin, hostRedeemJoinCode } from '../api/wailsBridge';
+import { httpFetchUsers, httpFetchRooms, httpCreateRoom, httpJoinRoom, httpInviteUser, httpRequestJoin, httpRedeemJoinCode } from '../api/httpClient';
 import { User, Room } from '../api/types';
 
 interface LobbyProps {
@@ -14,6 +14,8 @@ const Lobby: React.FC<LobbyProps> = ({ currentUser, onJoinRoom, appMode, onInvit
   const [users, setUsers] = useState<User[]>([]);
   const [rooms, setRooms] = useState<Room[]>([]);
   const [newRoomName, setNewRoomName] = useState('');
+  const [newRoomEncrypted, setNewRoomEncrypted] = useState(false);
+  const [joinCode, setJoinCode] = useState('');
 
   const refreshData = async () => {
     try {
@@ -43,11 +45,14 @@ const Lobby: React.FC<LobbyProps> = ({ currentUser, onJoinRoom, appMode, onInvit
     try {
       let room: Room;
       if (appMode === 'client') {
-        room = await httpCreateRoom(newRoomName);
+        room = await httpCreateRoom(newRoomName, newRoomEncrypted);
+      } else if (newRoomEncrypted) {
+        room = await hostCreateEncryptedRoom(newRoomName, currentUser.id);
       } else {
         room = await hostCreateRoom(newRoomName);
       }
       setNewRoomName('');
+      setNewRoomEncrypted(false);
       refreshData();
       // Auto-join created room (owner joins directly)
       onJoinRoom(room);
@@ -86,6 +91,25 @@ const Lobby: React.FC<LobbyProps> = ({ currentUser, onJoinRoom, appMode, onInvit
       }
   }
 
+  const handleRedeemCode = async () => {
+    if (!joinCode.trim()) return;
+    try {
+      let roomId: string | undefined;
+      if (appMode === 'client') {
+        roomId = (await httpRedeemJoinCode(joinCode)).roomId;
+      } else {
+        roomId = (await hostRedeemJoinCode(currentUser.id, joinCode)).id;
+      }
+      setJoinCode('');
+      const latest = appMode === 'client' ? await httpFetchRooms() : await hostListRooms();
+      const room = latest.find(r => r.id === roomId);
+      if (room) onJoinRoom(room);
+    } catch (err) {
+      console.error("Failed to redeem join code", err);
+      alert("Join code is invalid or has expired");
+    }
+  };
+
   const handleInvite = async (userId: string) => {
     try {
       let response;
@@ -137,7 +161,22 @@ const Lobby: React.FC<LobbyProps> = ({ currentUser, onJoinRoom, appMode, onInvit
               placeholder="New Room Name"
               className="text-input"
             />
+            <label title="Chat and clipboard content is encrypted so only members can read it">
+              <input
+                type="checkbox"
+                checked={newRoomEncrypted}
+                onChange={e => setNewRoomEncrypted(e.target.checked)}
+              />
+              Encrypted
+            </label>
             <button onClick={handleCreateRoom} className="primary-btn">Create</button>
+            <input
+              value={joinCode}
+              onChange={e => setJoinCode(e.target.value)}
+              placeholder="Join code"
+              className="text-input"
+            />
+            <button onClick={handleRedeemCode} className="secondary-btn">Join with code</button>
           </div>
         </div>
         <div className="list-grid">
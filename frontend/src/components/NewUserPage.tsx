@@ -1,9 +1,10 @@
 import React, { useState } from 'react';
-import { hostCreateUser, hostSetUser, hostSetServerURL } from '../api/wailsBridge';
-import { httpCreateUser, setApiBaseUrl, parseServerUrl, setAuthToken } from '../api/httpClient';
+import { hostCreateUser, hostSetUser, hostSetServerURL, hostSetSession, hostDiscoverHosts, hostAPIURL } from '../api/wailsBridge';
+import { httpCreateUser, httpOpenSession, setApiBaseUrl, parseServerUrl } from '../api/httpClient';
+import type { DiscoveredHost } from '../api/types';
 
 interface NewUserPageProps {
-  onUserCreated: (user: { id: string; name: string; token?: string }) => void;
+  onUserCreated: (user: { id: string; name: string; roomId?: string | null; token?: string }) => void;
   appMode: 'host' | 'client';
 }
 
@@ -12,6 +13,20 @@ const NewUserPage: React.FC<NewUserPageProps> = ({ onUserCreated, appMode }) =>
   const [serverAddress, setServerAddress] = useState('');
   const [error, setError] = useState('');
   const [connecting, setConnecting] = useState(false);
+  const [discovering, setDiscovering] = useState(false);
+  const [discovered, setDiscovered] = useState<DiscoveredHost[] | null>(null);
+
+  const handleDiscover = async () => {
+    setError('');
+    setDiscovering(true);
+    try {
+      setDiscovered(await hostDiscoverHosts());
+    } catch (err: any) {
+      setError(`Discovery failed: ${err.message || 'Unknown error'}`);
+    } finally {
+      setDiscovering(false);
+    }
+  };
 
   const handleSubmit = async (e: React.FormEvent) => {
     e.preventDefault();
@@ -24,22 +39,23 @@ const NewUserPage: React.FC<NewUserPageProps> = ({ onUserCreated, appMode }) =>
     setConnecting(true);
 
     try {
-      let user: { id: string; name: string; token?: string };
+      let user: { id: string; name: string; roomId?: string | null; token?: string };
       
       if (appMode === 'client') {
         // Parse and set server URL for both frontend HTTP client and backend network client
         const serverUrl = parseServerUrl(serverAddress);
         console.log('Connecting to server:', serverUrl);
         
-        setApiBaseUrl(serverUrl); // Frontend HTTP client
         await hostSetServerURL(serverUrl); // Backend network client
+        // HTTPS hosts are reached through the backend's proxy, which checks the pinned certificate
+        setApiBaseUrl(await hostAPIURL()); // Frontend HTTP client
         
         // Create user on remote Host server
         const resp = await httpCreateUser({ name: username });
-        setAuthToken(resp.token);
         user = { ...resp.user, token: resp.token };
-        // Sync user to local Wails backend
+        // Sync user to local Wails backend, with a session of its own for uploads
         await hostSetUser(user.id, user.name);
+        await hostSetSession(await httpOpenSession());
       } else {
         // Host mode - create user locally
         user = await hostCreateUser(username);
@@ -86,6 +102,25 @@ const NewUserPage: React.FC<NewUserPageProps> = ({ onUserCreated, appMode }) =>
                   <p style={{ fontSize: '12px', color: 'var(--text-muted)', margin: '4px 0 12px 0' }}>
                     Examples: <code>192.168.1.100</code> (LAN) or <code>https://xxx.trycloudflare.com</code> (tunnel)
                   </p>
+                  <button type="button" className="secondary-btn" onClick={handleDiscover} disabled={connecting || discovering}>
+                    {discovering ? 'Searching...' : 'Find hosts on LAN'}
+                  </button>
+                  {discovered && discovered.length === 0 && (
+                    <p style={{ fontSize: '12px', color: 'var(--text-muted)', margin: '4px 0 12px 0' }}>No hosts found.</p>
+                  )}
+                  {discovered && discovered.map((host) => (
+                    <button
+                      key={host.url}
+                      type="button"
+                      className="secondary-btn"
+                      onClick={() => setServerAddress(host.url)}
+                      disabled={connecting}
+                      title={host.fingerprint}
+                    >
+                      {host.name} · {host.url}
+                      {host.fingerprintChanged && ' (certificate changed, not trusted)'}
+                    </button>
+                  ))}
                 </>
               )}
               <input
@@ -1,9 +1,9 @@
 import React, { useState, useEffect, useRef } from 'react';
-import { hostSendChatMessage, hostFetchChatHistory, hostLeaveRoom, hostFetchOperations, hostInviteUser } from '../api/wailsBridge';
-import { httpSendChatMessage, httpFetchChatHistory, httpLeaveRoom, httpFetchOperations, getApiBaseUrl, httpFetchUsers, httpInviteUser } from '../api/httpClient';
-import { ChatMessage, Room, Operation, CopiedItem, User } from '../api/types';
+import { hostSendChatMessage, hostPostChatMessage, hostGetOutbox, hostRetryOutboxEntry, hostDiscardOutboxEntry, hostFetchChatHistory, hostLeaveRoom, hostFetchOperations, hostInviteUser, hostDownloadURL, hostCreateJoinCode, hostModerateMember, e2eEnterRoom, e2eExitRoom, e2eShareRoomKey, e2eRotateRoomKey, e2eSeal, e2eOpen, e2eOpenClipboardItem, e2eSaveSealedFile } from '../api/wailsBridge';
+import { httpFetchChatHistory, httpLeaveRoom, httpFetchOperations, httpFetchUsers, httpInviteUser, httpCreateJoinCode, httpModerateMember } from '../api/httpClient';
+import { ChatMessage, Room, Operation, CopiedItem, User, SnapshotEntry, ItemRemovedEvent, ModerationAction, ModerationEvent, RoomRole, RoomKeyEvent, OutboxEntry, roleOf } from '../api/types';
 import { addSSEListener, removeSSEListener } from '../sse';
-import { BrowserOpenURL } from '../../wailsjs/runtime/runtime';
+import { BrowserOpenURL, EventsOn } from '../../wailsjs/runtime/runtime';
 
 interface RoomProps {
   currentUser: { id: string; name: string };
@@ -21,7 +21,17 @@ const RoomView: React.FC<RoomProps> = ({ currentUser, currentRoom, onLeave, appM
   const [inviteLoading, setInviteLoading] = useState(false);
   const [inviteError, setInviteError] = useState<string | null>(null);
   const [invitedIds, setInvitedIds] = useState<Set<string>>(new Set());
+  // Roles, mutes and membership as updated by room_moderation events
+  const [roomState, setRoomState] = useState<Room>(currentRoom);
+  // Encrypted rooms: decrypted chat text and clipboard items by sealed payload; null could not be opened yet
+  const [encrypted, setEncrypted] = useState(Boolean(currentRoom.encrypted));
+  const [openedText, setOpenedText] = useState<Record<string, string | null>>({});
+  const [openedItems, setOpenedItems] = useState<Record<string, CopiedItem | null>>({});
+  const opening = useRef<Set<string>>(new Set());
   const chatEndRef = useRef<HTMLDivElement>(null);
+  // Client mode: this room's chat messages still in the outbox (queued, sending or failed)
+  const [outbox, setOutbox] = useState<OutboxEntry[]>([]);
+  const deliveredIds = useRef<Set<string> | null>(null);
 
   const refreshChat = async () => {
     try {
@@ -47,14 +57,88 @@ const RoomView: React.FC<RoomProps> = ({ currentUser, currentRoom, onLeave, appM
       } else {
         ops = await hostFetchOperations(roomIdToFetch);
       }
+      // Expand compaction snapshots back into the clipboard adds they captured
+      const expanded = ops.flatMap(op => {
+        if (op.opType !== 'snapshot') return [op];
+        const entries = ((op.item.data as any)?.clipboard ?? []) as SnapshotEntry[];
+        return entries.map(e => ({
+          id: e.opId,
+          parentId: "",
+          opType: "add",
+          itemId: e.itemId,
+          item: e.item,
+          timestamp: e.timestamp,
+          userId: e.userId,
+          userName: e.userName,
+        }));
+      });
+      // Fold edits into the item they modify so each item is listed once
+      const folded: Operation[] = [];
+      for (const op of expanded) {
+        if (op.opType === 'modify') {
+          const idx = folded.findIndex(o => o.itemId === op.itemId);
+          if (idx !== -1) folded[idx] = { ...folded[idx], item: op.item };
+          continue;
+        }
+        if (op.opType === 'remove') {
+          const idx = folded.findIndex(o => o.itemId === op.itemId);
+          if (idx !== -1) folded.splice(idx, 1);
+          continue;
+        }
+        folded.push(op);
+      }
       // Filter for clipboard items only
-      const clipboardOps = ops.filter(op => op.item.type === 'clipboard');
+      const clipboardOps = folded.filter(op => op.item.type === 'clipboard');
       setOperations(clipboardOps);
     } catch (err) {
       console.error(err);
     }
   };
 
+  useEffect(() => {
+    e2eEnterRoom(currentRoom.id)
+      .then(setEncrypted)
+      .catch((err) => console.error('Failed to load room keys', err));
+    return () => { e2eExitRoom(); };
+  }, [currentRoom.id]);
+
+  const isSealed = (text?: string) => Boolean(text && text.startsWith('e2e1.'));
+
+  // Decrypt sealed payloads as they arrive; failures are retried after the next key update
+  useEffect(() => {
+    if (!encrypted) return;
+    const open = <T,>(payload: string, opener: (p: string) => Promise<T>, store: React.Dispatch<React.SetStateAction<Record<string, T | null>>>) => {
+      if (opening.current.has(payload)) return;
+      opening.current.add(payload);
+      opener(payload)
+        .then((plain) => store(prev => ({ ...prev, [payload]: plain })))
+        .catch(() => store(prev => ({ ...prev, [payload]: null })))
+        .finally(() => opening.current.delete(payload));
+    };
+    messages.forEach(m => {
+      if (isSealed(m.message) && openedText[m.message] === undefined) open(m.message, p => e2eOpen(currentRoom.id, p), setOpenedText);
+    });
+    operations.forEach(op => {
+      const text = (op.item.data as CopiedItem)?.text;
+      if (text && isSealed(text) && openedItems[text] === undefined) {
+        open(text, p => e2eOpenClipboardItem(currentRoom.id, p) as Promise<CopiedItem>, setOpenedItems);
+      }
+    });
+  }, [encrypted, messages, operations, openedText, openedItems]);
+
+  const retryFailedOpens = () => {
+    const dropFailed = <T,>(prev: Record<string, T | null>) =>
+      Object.fromEntries(Object.entries(prev).filter(([, v]) => v !== null)) as Record<string, T | null>;
+    setOpenedText(dropFailed);
+    setOpenedItems(dropFailed);
+  };
+
+  const chatText = (message: string) => {
+    if (!encrypted || !isSealed(message)) return message;
+    const plain = openedText[message];
+    return plain === undefined ? '🔒 Decrypting...' : plain ?? '🔒 Unable to decrypt this message';
+  };
+
   useEffect(() => {
     refreshChat();
     refreshOperations();
@@ -133,31 +217,146 @@ const RoomView: React.FC<RoomProps> = ({ currentUser, currentRoom, onLeave, appM
         });
     };
 
+    const onChatEdited = (msg: ChatMessage) => {
+        if (msg.roomId !== currentRoom.id) return;
+        setMessages(prev => prev.map(m => m.id === msg.id ? msg : m));
+    };
+
+    const onClipboardModified = (op: Operation) => {
+        setOperations(prev => prev.map(o => o.itemId === op.itemId ? { ...o, item: op.item } : o));
+    };
+
+    const onChatDeleted = (evt: ItemRemovedEvent) => {
+        if (evt.roomId !== currentRoom.id) return;
+        setMessages(prev => prev.filter(m => m.id !== evt.itemId));
+    };
+
+    const onClipboardRemoved = (evt: ItemRemovedEvent) => {
+        if (evt.roomId !== currentRoom.id) return;
+        setOperations(prev => prev.filter(o => o.itemId !== evt.itemId));
+    };
+
+    const onModeration = (evt: ModerationEvent) => {
+        if (evt.roomId !== currentRoom.id) return;
+        setRoomState(prev => {
+            const drop = (ids?: string[]) => (ids ?? []).filter(id => id !== evt.targetId);
+            switch (evt.action) {
+                case 'role':
+                    return {
+                        ...prev,
+                        moderatorIds: evt.role === 'moderator' ? [...drop(prev.moderatorIds), evt.targetId] : drop(prev.moderatorIds),
+                        viewerIds: evt.role === 'viewer' ? [...drop(prev.viewerIds), evt.targetId] : drop(prev.viewerIds),
+                    };
+                case 'mute':
+                    return { ...prev, mutedUserIds: [...drop(prev.mutedUserIds), evt.targetId] };
+                case 'unmute':
+                    return { ...prev, mutedUserIds: drop(prev.mutedUserIds) };
+                case 'kick':
+                    return { ...prev, userIds: drop(prev.userIds) };
+                case 'ban':
+                    return {
+                        ...prev,
+                        userIds: drop(prev.userIds),
+                        moderatorIds: drop(prev.moderatorIds),
+                        viewerIds: drop(prev.viewerIds),
+                        mutedUserIds: drop(prev.mutedUserIds),
+                        bannedUserIds: [...drop(prev.bannedUserIds), evt.targetId],
+                    };
+                case 'unban':
+                    return { ...prev, bannedUserIds: drop(prev.bannedUserIds) };
+            }
+            return prev;
+        });
+    };
+
+    // Missed events could not be replayed, so reload the room from scratch
+    const onResync = () => {
+        refreshChat();
+        refreshOperations();
+    };
+
+    // A member is missing the room key; any member holding it may wrap it for them
+    const onRoomKeyNeeded = (evt: RoomKeyEvent) => {
+        if (evt.roomId !== currentRoom.id) return;
+        e2eShareRoomKey(evt.roomId).catch((err) => console.error('Failed to share room key', err));
+    };
+
+    const onRoomKeyUpdated = (evt: RoomKeyEvent) => {
+        if (evt.roomId !== currentRoom.id) return;
+        e2eEnterRoom(evt.roomId)
+          .then(() => retryFailedOpens())
+          .catch((err) => console.error('Failed to load room keys', err));
+    };
+
     addSSEListener('chat_message', onChatMsg);
     addSSEListener('clipboard_copied', onClipboard);
     addSSEListener('clipboard_updated', onClipboardUpdated);
+    addSSEListener('chat_message_edited', onChatEdited);
+    addSSEListener('clipboard_modified', onClipboardModified);
+    addSSEListener('chat_message_deleted', onChatDeleted);
+    addSSEListener('clipboard_removed', onClipboardRemoved);
+    addSSEListener('resync_required', onResync);
+    addSSEListener('room_moderation', onModeration);
+    addSSEListener('room_key_needed', onRoomKeyNeeded);
+    addSSEListener('room_key_updated', onRoomKeyUpdated);
 
     return () => {
         removeSSEListener('chat_message', onChatMsg);
         removeSSEListener('clipboard_copied', onClipboard);
         removeSSEListener('clipboard_updated', onClipboardUpdated);
+        removeSSEListener('chat_message_edited', onChatEdited);
+        removeSSEListener('clipboard_modified', onClipboardModified);
+        removeSSEListener('chat_message_deleted', onChatDeleted);
+        removeSSEListener('clipboard_removed', onClipboardRemoved);
+        removeSSEListener('resync_required', onResync);
+        removeSSEListener('room_moderation', onModeration);
+        removeSSEListener('room_key_needed', onRoomKeyNeeded);
+        removeSSEListener('room_key_updated', onRoomKeyUpdated);
     };
   }, [currentRoom.id]);
 
+  useEffect(() => {
+    if (appMode !== 'client') return;
+    const applyOutbox = (entries: OutboxEntry[]) => {
+      const chat = (entries ?? []).filter(e => e.kind === 'chat' && e.roomId === currentRoom.id);
+      const delivered = chat.filter(e => e.state === 'delivered').map(e => e.id);
+      // Delivered messages come back from the host's history under their real IDs
+      if (deliveredIds.current && delivered.some(id => !deliveredIds.current!.has(id))) {
+        void refreshChat();
+      }
+      deliveredIds.current = new Set(delivered);
+      setOutbox(chat.filter(e => e.state !== 'delivered'));
+    };
+    hostGetOutbox().then(applyOutbox).catch(err => console.error('Failed to load outbox', err));
+    const cancelListener = EventsOn('outbox:changed', applyOutbox);
+    return () => {
+      cancelListener();
+      deliveredIds.current = null;
+    };
+  }, [currentRoom.id, appMode]);
+
   useEffect(() => {
     chatEndRef.current?.scrollIntoView({ behavior: 'smooth' });
-  }, [messages]);
+  }, [messages, outbox]);
 
   const handleSend = async (e: React.FormEvent) => {
     e.preventDefault();
     if (!newMessage.trim()) return;
-    const messageToSend = newMessage.trim();
+    let messageToSend = newMessage.trim();
     try {
+      if (encrypted) {
+        // The host only ever sees the sealed message
+        const sealed = await e2eSeal(currentRoom.id, messageToSend);
+        setOpenedText(prev => ({ ...prev, [sealed]: messageToSend }));
+        messageToSend = sealed;
+      }
       if (appMode === 'client') {
-        await httpSendChatMessage({ roomId: currentRoom.id, userId: currentUser.id, message: messageToSend });
-      } else {
-        await hostSendChatMessage(currentRoom.id, currentUser.id, messageToSend);
+        // The outbox shows it as pending until the host has it, and retries while offline
+        await hostPostChatMessage(currentRoom.id, messageToSend);
+        setNewMessage('');
+        return;
       }
+      await hostSendChatMessage(currentRoom.id, currentUser.id, messageToSend);
       // Immediately add the message to local state
       const sentMessage: ChatMessage = {
         id: `sent_${Date.now()}_${Math.random().toString(36).substr(2, 9)}`,
@@ -174,6 +373,22 @@ const RoomView: React.FC<RoomProps> = ({ currentUser, currentRoom, onLeave, appM
     }
   };
 
+  const handleRetryOutbox = async (id: string) => {
+    try {
+      await hostRetryOutboxEntry(id);
+    } catch (err) {
+      console.error('Failed to retry message', err);
+    }
+  };
+
+  const handleDiscardOutbox = async (id: string) => {
+    try {
+      await hostDiscardOutboxEntry(id);
+    } catch (err) {
+      console.error('Failed to discard message', err);
+    }
+  };
+
   const handleLeave = async () => {
       try {
           if (appMode === 'client') {
@@ -208,11 +423,19 @@ const RoomView: React.FC<RoomProps> = ({ currentUser, currentRoom, onLeave, appM
   };
 
   const renderClipboardItem = (op: Operation) => {
-      const item = op.item.data as CopiedItem;
-      if (!item) {
+      const stored = op.item.data as CopiedItem;
+      if (!stored) {
           console.log("Clipboard item data is null for operation:", op.id);
           return null;
       }
+      // In encrypted rooms the host keeps only the type and, once uploaded, the sealed file's size
+      const sealedItem = encrypted && isSealed(stored.text);
+      const opened = sealedItem ? openedItems[stored.text!] : undefined;
+      const item: CopiedItem = sealedItem
+        ? opened
+          ? { ...opened, type: stored.type, isSingleFile: stored.isSingleFile, singleFileName: opened.singleFileName ?? opened.files?.[0], singleFileSize: stored.singleFileSize }
+          : { ...stored, type: 'text', text: opened === null ? '🔒 Unable to decrypt this item' : '🔒 Decrypting...' }
+        : stored;
 
       const handleDragStart = (event: React.DragEvent<HTMLDivElement>) => {
         event.dataTransfer.effectAllowed = 'copy';
@@ -253,8 +476,8 @@ const RoomView: React.FC<RoomProps> = ({ currentUser, currentRoom, onLeave, appM
       const singleFileThumb = getFileThumb(singleFileName, (item as any)?.singleFileThumb as string | undefined);
 
         const hasErrorText = item.text && (item.text.includes('too large') || item.text.includes('exceeds limit'));
-        const readyByText = item.text && item.text.includes('(ready)');
-        const readySingleFile = isSingleFile && typeof singleFileSize === 'number' && singleFileSize > 0;
+        const readyByText = !sealedItem && item.text && item.text.includes('(ready)');
+        const readySingleFile = (isSingleFile || sealedItem) && typeof singleFileSize === 'number' && singleFileSize > 0;
         const downloadReady = Boolean(readyByText || readySingleFile);
         const downloadOpId = op.id || op.itemId;
 
@@ -386,8 +609,15 @@ const RoomView: React.FC<RoomProps> = ({ currentUser, currentRoom, onLeave, appM
                           {downloadReady ? (
                                   <button
                                       onClick={() => {
-                                          if (downloadOpId) {
-                                            BrowserOpenURL(`${getApiBaseUrl()}/api/download/${downloadOpId}`);
+                                          if (downloadOpId && sealedItem) {
+                                            const name = isSingleFile && singleFileName ? singleFileName : `shared_items_${downloadOpId}.tar`;
+                                            e2eSaveSealedFile(currentRoom.id, downloadOpId, name)
+                                              .then((path) => alert(`Saved to ${path}`))
+                                              .catch((err) => console.error('Failed to save sealed file:', err));
+                                          } else if (downloadOpId) {
+                                            hostDownloadURL(currentUser.id, downloadOpId)
+                                              .then(BrowserOpenURL)
+                                              .catch((err) => console.error('Failed to open download:', err));
                                           }
                                       }}
                                       style={{
@@ -505,6 +735,43 @@ const RoomView: React.FC<RoomProps> = ({ currentUser, currentRoom, onLeave, appM
     }
   };
 
+  const roleRank: Record<RoomRole, number> = { owner: 3, moderator: 2, member: 1, viewer: 0 };
+  const myRole = roleOf(roomState, currentUser.id);
+  const canModerate = (userId: string) =>
+    roleRank[myRole] >= roleRank.moderator && roleRank[roleOf(roomState, userId)] < roleRank[myRole];
+  const postingBlocked = myRole === 'viewer'
+    ? 'Viewers can read but not post'
+    : roomState.mutedUserIds?.includes(currentUser.id) ? 'You are muted in this room' : null;
+
+  const handleModerate = async (userId: string, action: ModerationAction, role?: RoomRole) => {
+    try {
+      if (appMode === 'client') {
+        await httpModerateMember(currentRoom.id, userId, action, role);
+      } else {
+        await hostModerateMember(currentRoom.id, currentUser.id, userId, action, role);
+      }
+      if (encrypted && (action === 'kick' || action === 'ban')) {
+        // Whoever was removed keeps the old key, so new content uses a fresh one
+        await e2eRotateRoomKey(currentRoom.id);
+      }
+    } catch (err) {
+      console.error(`Failed to ${action} member`, err);
+      alert(`Failed to ${action} member`);
+    }
+  };
+
+  const handleCreateJoinCode = async () => {
+    try {
+      const joinCode = appMode === 'client'
+        ? await httpCreateJoinCode(currentRoom.id)
+        : await hostCreateJoinCode(currentRoom.id, currentUser.id);
+      alert(`Join code: ${joinCode.code}\nValid until ${new Date(joinCode.expiresAt).toLocaleString()}`);
+    } catch (err) {
+      console.error('Failed to create join code', err);
+      alert('Failed to create join code');
+    }
+  };
+
   return (
     <div className="room-shell">
       {/* Chat */}
@@ -512,10 +779,13 @@ const RoomView: React.FC<RoomProps> = ({ currentUser, currentRoom, onLeave, appM
         <div className="panel-header">
           <div>
             <p className="pill" style={{ display: 'inline-block', marginBottom: '4px' }}>Chat</p>
-            <h3 style={{ margin: 0 }}>{currentRoom.name}</h3>
+            <h3 style={{ margin: 0 }}>{encrypted ? '🔒 ' : ''}{currentRoom.name}</h3>
           </div>
           <div style={{ display: 'flex', gap: '8px' }}>
             <button className="icon-btn" onClick={openInviteModal} title="Invite users">➕ Invite</button>
+            {currentRoom.ownerId === currentUser.id && (
+              <button className="icon-btn" onClick={handleCreateJoinCode} title="Create a join code">🔑 Code</button>
+            )}
             <button className="secondary-btn" onClick={handleLeave}>Leave Room</button>
           </div>
         </div>
@@ -524,13 +794,47 @@ const RoomView: React.FC<RoomProps> = ({ currentUser, currentRoom, onLeave, appM
             <div key={msg.id} className={`chat-bubble ${msg.userId === currentUser.id ? 'chat-bubble-me' : 'chat-bubble-other'}`}>
               {msg.userId === currentUser.id ? (
                 <div>
-                  <div className="chat-sender" style={{ textAlign: 'right' }}>You</div>
-                  <div className="chat-message">{msg.message}</div>
+                  <div className="chat-sender" style={{ textAlign: 'right' }}>{msg.importedFrom ? `${msg.importedFrom} (imported)` : 'You'}</div>
+                  <div className="chat-message">{chatText(msg.message)}</div>
                 </div>
               ) : (
                 <div>
-                  <div className="chat-sender">{msg.userName}</div>
-                  <div className="chat-message">{msg.message}</div>
+                  <div className="chat-sender">{msg.importedFrom ? `${msg.importedFrom} (imported by ${msg.userName})` : msg.userName}</div>
+                  <div className="chat-message">{chatText(msg.message)}</div>
+                  {canModerate(msg.userId) && (
+                    <div style={{ display: 'flex', gap: '4px', marginTop: '4px' }}>
+                      {roomState.mutedUserIds?.includes(msg.userId)
+                        ? <button className="icon-btn" onClick={() => handleModerate(msg.userId, 'unmute')} title="Unmute">🔊</button>
+                        : <button className="icon-btn" onClick={() => handleModerate(msg.userId, 'mute')} title="Mute">🔇</button>}
+                      {myRole === 'owner' && (
+                        roleOf(roomState, msg.userId) === 'moderator'
+                          ? <button className="icon-btn" onClick={() => handleModerate(msg.userId, 'role', 'member')} title="Remove moderator">⭐</button>
+                          : <button className="icon-btn" onClick={() => handleModerate(msg.userId, 'role', 'moderator')} title="Make moderator">☆</button>
+                      )}
+                      {myRole === 'owner' && (
+                        roleOf(roomState, msg.userId) === 'viewer'
+                          ? <button className="icon-btn" onClick={() => handleModerate(msg.userId, 'role', 'member')} title="Allow posting">✏️</button>
+                          : <button className="icon-btn" onClick={() => handleModerate(msg.userId, 'role', 'viewer')} title="Make read-only">👁</button>
+                      )}
+                      <button className="icon-btn" onClick={() => handleModerate(msg.userId, 'kick')} title="Kick">👢</button>
+                      <button className="icon-btn" onClick={() => handleModerate(msg.userId, 'ban')} title="Ban">⛔</button>
+                    </div>
+                  )}
+                </div>
+              )}
+            </div>
+          ))}
+          {outbox.map(entry => (
+            <div key={entry.id} className="chat-bubble chat-bubble-me" style={{ opacity: entry.state === 'failed' ? 1 : 0.6 }}>
+              <div className="chat-sender" style={{ textAlign: 'right' }}>
+                You · {entry.state === 'failed' ? 'Not delivered' : entry.state === 'sending' ? 'Sending…' : 'Waiting for host'}
+              </div>
+              <div className="chat-message">{chatText(entry.message ?? '')}</div>
+              {entry.state === 'failed' && (
+                <div style={{ display: 'flex', gap: '4px', marginTop: '4px', justifyContent: 'flex-end', alignItems: 'center' }}>
+                  {entry.lastError && <span className="invite-sub">{entry.lastError}</span>}
+                  <button className="icon-btn" onClick={() => handleRetryOutbox(entry.id)} title="Retry">↻</button>
+                  <button className="icon-btn" onClick={() => handleDiscardOutbox(entry.id)} title="Discard">✕</button>
                 </div>
               )}
             </div>
@@ -542,9 +846,10 @@ const RoomView: React.FC<RoomProps> = ({ currentUser, currentRoom, onLeave, appM
             value={newMessage} 
             onChange={e => setNewMessage(e.target.value)}
             className="text-input"
-            placeholder="Type a message..."
+            placeholder={postingBlocked ?? "Type a message..."}
+            disabled={postingBlocked !== null}
           />
-          <button type="submit" className="primary-btn">Send</button>
+          <button type="submit" className="primary-btn" disabled={postingBlocked !== null}>Send</button>
         </form>
         <div className="muted" style={{ fontSize: '0.9rem' }}>
             Logged in as: <strong>{currentUser.name}</strong>
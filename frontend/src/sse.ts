@@ -1,22 +1,44 @@
 import { globalState } from "./state";
-import { getApiBaseUrl, getAuthToken } from "./api/httpClient";
+import { ensureFreshToken, getApiBaseUrl, getAuthToken } from "./api/httpClient";
 import type {
   ChatMessage,
   CopiedItem,
   InviteEventPayload,
+  ItemRemovedEvent,
+  ModerationEvent,
+  PresenceEvent,
+  ResyncRequiredEvent,
+  RoomKeyEvent,
+  RetentionNotice,
+  RoomSettings,
   SSEEnvelope,
+  TypingEvent,
   User,
 } from "./api/types";
 
 export type SSEEventType = 
   | 'user_created' 
   | 'user_offline' 
+  | 'user_online'
+  | 'user_removed'
   | 'user_invited' 
   | 'user_joined' 
   | 'chat_message' 
   | 'clipboard_copied' 
   | 'clipboard_updated'
+  | 'chat_message_edited'
+  | 'clipboard_modified'
+  | 'chat_message_deleted'
+  | 'clipboard_removed'
+  | 'room_settings_updated'
+  | 'room_moderation'
+  | 'room_key_needed'
+  | 'room_key_updated'
+  | 'retention_expired'
   | 'join_request'
+  | 'resync_required'
+  | 'typing'
+  | 'presence'
   | 'connected' 
   | 'disconnected';
 
@@ -24,6 +46,10 @@ type Listener<T = any> = (data: T) => void;
 
 const listeners: Record<string, Listener[]> = {};
 
+// ID of the last event received, sent back on reconnect so the host can replay what was missed
+let lastEventId = "";
+let lastEventUserId = "";
+
 export function addSSEListener<T>(event: SSEEventType, callback: Listener<T>) {
     if (!listeners[event]) {
         listeners[event] = [];
@@ -43,6 +69,9 @@ function dispatch<T>(event: SSEEventType, data: T) {
 }
 
 function parseEnvelope<T>(event: MessageEvent<string>): T {
+  if (event.lastEventId) {
+    lastEventId = event.lastEventId;
+  }
   const parsed = JSON.parse(event.data) as SSEEnvelope<T>;
   return parsed.data;
 }
@@ -54,16 +83,27 @@ export function connectSSE(userId: string, token?: string): void {
       globalState.sseConnection.close();
   }
 
-  const resolvedToken = token || getAuthToken();
-  if (!resolvedToken) {
+  if (lastEventUserId !== userId) {
+    lastEventId = "";
+    lastEventUserId = userId;
+  }
+
+  if (!(token || getAuthToken())) {
     console.warn("SSE connect aborted: missing auth token");
     return;
   }
 
-  const url = `${getApiBaseUrl()}/api/sse?userId=${encodeURIComponent(userId)}&token=${encodeURIComponent(resolvedToken)}`;
-
   const setup = (): void => {
-    const source = new EventSource(url);
+    // Prefer the stored token: it is renewed while the passed-in one expires
+    const resolvedToken = getAuthToken() || token;
+    if (!resolvedToken) {
+      console.warn("SSE reconnect aborted: session ended");
+      return;
+    }
+    const url = `${getApiBaseUrl()}/api/sse?userId=${encodeURIComponent(userId)}&token=${encodeURIComponent(resolvedToken)}`;
+    // A new EventSource does not send Last-Event-ID itself, so pass it along explicitly
+    const resumeUrl = lastEventId ? `${url}&lastEventId=${encodeURIComponent(lastEventId)}` : url;
+    const source = new EventSource(resumeUrl);
     globalState.sseConnection = source;
 
     source.addEventListener("user_created", (event) => {
@@ -74,6 +114,14 @@ export function connectSSE(userId: string, token?: string): void {
       dispatch('user_offline', parseEnvelope<{ userId: string }>(event as MessageEvent<string>));
     });
 
+    source.addEventListener("user_online", (event) => {
+      dispatch('user_online', parseEnvelope<User>(event as MessageEvent<string>));
+    });
+
+    source.addEventListener("user_removed", (event) => {
+      dispatch('user_removed', parseEnvelope<{ userId: string }>(event as MessageEvent<string>));
+    });
+
     source.addEventListener("user_invited", (event) => {
       console.log("SSE user_invited event received:", event.data);
       const payload = parseEnvelope<InviteEventPayload>(event as MessageEvent<string>);
@@ -99,12 +147,65 @@ export function connectSSE(userId: string, token?: string): void {
       dispatch('clipboard_updated', parseEnvelope<any>(event as MessageEvent<string>));
     });
 
+    source.addEventListener("chat_message_edited", (event) => {
+      dispatch('chat_message_edited', parseEnvelope<ChatMessage>(event as MessageEvent<string>));
+    });
+
+    source.addEventListener("clipboard_modified", (event) => {
+      dispatch('clipboard_modified', parseEnvelope<any>(event as MessageEvent<string>));
+    });
+
+    source.addEventListener("chat_message_deleted", (event) => {
+      dispatch('chat_message_deleted', parseEnvelope<ItemRemovedEvent>(event as MessageEvent<string>));
+    });
+
+    source.addEventListener("clipboard_removed", (event) => {
+      dispatch('clipboard_removed', parseEnvelope<ItemRemovedEvent>(event as MessageEvent<string>));
+    });
+
+    source.addEventListener("room_settings_updated", (event) => {
+      dispatch('room_settings_updated', parseEnvelope<RoomSettings>(event as MessageEvent<string>));
+    });
+
+    source.addEventListener("room_moderation", (event) => {
+      dispatch('room_moderation', parseEnvelope<ModerationEvent>(event as MessageEvent<string>));
+    });
+
+    source.addEventListener("room_key_needed", (event) => {
+      dispatch('room_key_needed', parseEnvelope<RoomKeyEvent>(event as MessageEvent<string>));
+    });
+
+    source.addEventListener("room_key_updated", (event) => {
+      dispatch('room_key_updated', parseEnvelope<RoomKeyEvent>(event as MessageEvent<string>));
+    });
+
+    source.addEventListener("retention_expired", (event) => {
+      dispatch('retention_expired', parseEnvelope<RetentionNotice>(event as MessageEvent<string>));
+    });
+
     source.addEventListener("join_request", (event) => {
       console.log("SSE join_request event received:", event.data);
       dispatch('join_request', parseEnvelope<any>(event as MessageEvent<string>));
     });
 
-    source.addEventListener("connected", () => {
+    source.addEventListener("typing", (event) => {
+      dispatch('typing', parseEnvelope<TypingEvent>(event as MessageEvent<string>));
+    });
+
+    source.addEventListener("presence", (event) => {
+      dispatch('presence', parseEnvelope<PresenceEvent>(event as MessageEvent<string>));
+    });
+
+    source.addEventListener("resync_required", (event) => {
+      console.warn("SSE resync required:", event.data);
+      dispatch('resync_required', parseEnvelope<ResyncRequiredEvent>(event as MessageEvent<string>));
+    });
+
+    source.addEventListener("connected", (event) => {
+      const id = (event as MessageEvent<string>).lastEventId;
+      if (id) {
+        lastEventId = id;
+      }
       dispatch('connected', null);
     });
 
@@ -116,7 +217,9 @@ export function connectSSE(userId: string, token?: string): void {
       source.close();
       globalState.sseConnection = null;
       dispatch('disconnected', null);
-      window.setTimeout(setup, 5000);
+      window.setTimeout(() => {
+        void ensureFreshToken().then(setup);
+      }, 5000);
     };
   };
 
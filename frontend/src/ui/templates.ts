@@ -75,7 +75,7 @@ export function createChatMessageElement(message: ChatMessage): HTMLDivElement {
 
   const userSpan = document.createElement("span");
   userSpan.className = "message-user";
-  userSpan.textContent = message.userName;
+  userSpan.textContent = message.importedFrom ? `${message.importedFrom} (imported)` : message.userName;
 
   const timeSpan = document.createElement("span");
   timeSpan.className = "message-time";
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -38,6 +39,22 @@ func (a *App) handleUsers(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		if req.DeviceKey != "" && !validDeviceKey(req.DeviceKey) {
+			http.Error(w, "Device key is invalid", http.StatusBadRequest)
+			return
+		}
+
+		// A known device key gets its user back, with the same ID and rooms
+		if user := a.RestoreUser(req.DeviceKey); user != nil {
+			tokens, err := a.issueSession(user.ID)
+			if err != nil {
+				http.Error(w, "Failed to issue token", http.StatusInternalServerError)
+				return
+			}
+			json.NewEncoder(w).Encode(CreateUserResponse{User: user, SessionTokens: *tokens})
+			return
+		}
+
 		if req.Name == "" {
 			http.Error(w, "Name is required", http.StatusBadRequest)
 			return
@@ -61,14 +78,17 @@ func (a *App) handleUsers(w http.ResponseWriter, r *http.Request) {
 		a.mu.RUnlock()
 
 		user := a.CreateUser(sanitizedName)
-		token, err := a.issueToken(user.ID)
+		if req.DeviceKey != "" {
+			a.BindDeviceKey(user.ID, req.DeviceKey)
+		}
+		tokens, err := a.issueSession(user.ID)
 		if err != nil {
 			http.Error(w, "Failed to issue token", http.StatusInternalServerError)
 			return
 		}
 
 		w.WriteHeader(http.StatusCreated)
-		json.NewEncoder(w).Encode(CreateUserResponse{User: user, Token: token})
+		json.NewEncoder(w).Encode(CreateUserResponse{User: user, SessionTokens: *tokens})
 		return
 	}
 
@@ -126,6 +146,110 @@ func (a *App) handleUserByID(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(user)
 }
 
+// handleAuthRefresh handles POST /api/auth/refresh, exchanging a refresh token
+// for a new access token and a new refresh token
+func (a *App) handleAuthRefresh(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := a.refreshSession(req.RefreshToken)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// handleAuthSession handles POST /api/auth/session, opening an additional session
+// for the caller. The desktop backend must send a token with its clipboard
+// uploads, and refresh tokens are single-use, so it cannot share its web view's
+// session without one of them losing it on the other's next refresh; the web
+// view opens a session for it here instead. The caller proves it holds its
+// session with the session's current refresh token, as an access token alone
+// may have leaked through a URL
+func (a *App) handleAuthSession(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	authUser, claims, err := a.verifyToken(bearerToken(r))
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if !a.sessions.holdsRefresh(claims.SessionID, req.RefreshToken) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	tokens, err := a.issueSession(authUser.ID)
+	if err != nil {
+		http.Error(w, "Failed to issue token", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// handleAuthLogout handles POST /api/auth/logout, revoking the caller's session
+func (a *App) handleAuthLogout(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	_, claims, err := a.verifyToken(bearerToken(r))
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	a.logout(claims)
+	json.NewEncoder(w).Encode(APIResponse{Message: "Logged out"})
+}
+
 // handleRooms handles GET /api/rooms (list rooms) and POST /api/rooms (create room)
 func (a *App) handleRooms(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -167,7 +291,7 @@ func (a *App) handleRooms(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		room := a.CreateRoom(roomName, authUser.ID)
+		room := a.createRoom(roomName, authUser.ID, req.Encrypted)
 		w.WriteHeader(http.StatusCreated)
 		json.NewEncoder(w).Encode(room)
 		return
@@ -176,6 +300,439 @@ func (a *App) handleRooms(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 }
 
+// handleRoomRoutes dispatches /api/rooms/{id}/{action} requests
+func (a *App) handleRoomRoutes(w http.ResponseWriter, r *http.Request) {
+	roomID, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/rooms/"), "/")
+	if roomID == "" {
+		http.Error(w, "Room ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if code, ok := strings.CutPrefix(action, "codes/"); ok {
+		a.handleRoomJoinCodes(w, r, roomID, code)
+		return
+	}
+
+	if member, ok := strings.CutPrefix(action, "members/"); ok {
+		targetID, moderation, _ := strings.Cut(member, "/")
+		a.handleRoomModeration(w, r, roomID, targetID, ModerationAction(moderation))
+		return
+	}
+
+	switch action {
+	case "codes":
+		a.handleRoomJoinCodes(w, r, roomID, "")
+	case "search":
+		a.handleRoomSearch(w, r, roomID)
+	case "export":
+		a.handleRoomExport(w, r, roomID)
+	case "settings":
+		a.handleRoomSettings(w, r, roomID)
+	case "keys":
+		a.handleRoomKeys(w, r, roomID)
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+	}
+}
+
+// handleRoomImport handles POST /api/rooms/import?name= with an export bundle as the body
+func (a *App) handleRoomImport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	authUser, err := a.authenticateRequest(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	if name != "" && sanitizeRoomName(name) == "" {
+		http.Error(w, "Room name is invalid", http.StatusBadRequest)
+		return
+	}
+
+	result, err := a.importRoomBundle(r.Body, sanitizeRoomName(name), authUser.ID)
+	if err != nil {
+		if errors.Is(err, errInvalidBundle) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fmt.Printf("Room import failed: %v\n", err)
+		http.Error(w, "Failed to import room", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(result)
+}
+
+// handleRoomSettings handles GET/PUT /api/rooms/{id}/settings
+func (a *App) handleRoomSettings(w http.ResponseWriter, r *http.Request, roomID string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, PUT, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != "GET" && r.Method != "PUT" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	authUser, err := a.authenticateRequest(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !a.userInRoom(authUser.ID, roomID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if r.Method == "GET" {
+		settings, err := a.GetRoomSettings(roomID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(settings)
+		return
+	}
+
+	var req RoomSettings
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	settings, err := a.UpdateRoomSettings(roomID, authUser.ID, req.Retention)
+	if err != nil {
+		if errors.Is(err, errNotRoomOwner) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(settings)
+}
+
+// handleRoomJoinCodes handles GET and POST /api/rooms/{id}/codes (list and create)
+// and DELETE /api/rooms/{id}/codes/{code} (revoke) for the room owner
+func (a *App) handleRoomJoinCodes(w http.ResponseWriter, r *http.Request, roomID, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if (code == "" && r.Method != "GET" && r.Method != "POST") || (code != "" && r.Method != "DELETE") {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	authUser, err := a.authenticateRequest(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	writeErr := func(err error) {
+		switch {
+		case errors.Is(err, errNotCodeOwner):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case errors.Is(err, errJoinCodeInvalid):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}
+
+	switch r.Method {
+	case "GET":
+		codes, err := a.ListJoinCodes(roomID, authUser.ID)
+		if err != nil {
+			writeErr(err)
+			return
+		}
+		json.NewEncoder(w).Encode(codes)
+	case "POST":
+		var req CreateJoinCodeRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid JSON", http.StatusBadRequest)
+				return
+			}
+		}
+		joinCode, err := a.CreateJoinCode(roomID, authUser.ID, time.Duration(req.ExpiresInSeconds)*time.Second, req.MaxUses)
+		if err != nil {
+			writeErr(err)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(joinCode)
+	case "DELETE":
+		if err := a.RevokeJoinCode(roomID, authUser.ID, code); err != nil {
+			writeErr(err)
+			return
+		}
+		json.NewEncoder(w).Encode(APIResponse{Message: "Join code revoked", RoomID: roomID})
+	}
+}
+
+// handleRoomModeration handles POST /api/rooms/{id}/members/{userId}/{action},
+// where action is role, kick, ban, unban, mute or unmute
+func (a *App) handleRoomModeration(w http.ResponseWriter, r *http.Request, roomID, targetID string, action ModerationAction) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if targetID == "" || action == "" {
+		http.Error(w, "User ID and action are required", http.StatusBadRequest)
+		return
+	}
+
+	authUser, err := a.authenticateRequest(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req ModerationRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+	}
+	req.Action = action
+
+	event, err := a.ModerateMember(roomID, authUser.ID, targetID, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, errNotModerator), errors.Is(err, errNotRoleManager), errors.Is(err, errOutranked):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		default:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+	json.NewEncoder(w).Encode(event)
+}
+
+// handleKeys handles PUT /api/keys, publishing the caller's public key for encrypted rooms
+func (a *App) handleKeys(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "PUT, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != "PUT" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	authUser, err := a.authenticateRequest(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req PublicKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := a.SetPublicKey(authUser.ID, req.PublicKey); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(APIResponse{Message: "Public key saved"})
+}
+
+// handleRoomKeys handles GET and POST /api/rooms/{id}/keys
+func (a *App) handleRoomKeys(w http.ResponseWriter, r *http.Request, roomID string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	authUser, err := a.authenticateRequest(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		resp, err := a.GetRoomKeys(roomID, authUser.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		json.NewEncoder(w).Encode(resp)
+	case "POST":
+		var upload RoomKeyUpload
+		if err := json.NewDecoder(r.Body).Decode(&upload); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if err := a.StoreRoomKeys(roomID, authUser.ID, upload); err != nil {
+			if errors.Is(err, errNotModerator) {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(APIResponse{Message: "Room keys saved"})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleRoomSearch handles GET /api/rooms/{id}/search?q=&author=&type=&since=&until=&limit=
+func (a *App) handleRoomSearch(w http.ResponseWriter, r *http.Request, roomID string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	authUser, err := a.authenticateRequest(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !a.userInRoom(authUser.ID, roomID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	params := r.URL.Query()
+	q := SearchQuery{
+		Text:   strings.TrimSpace(params.Get("q")),
+		UserID: strings.TrimSpace(params.Get("author")),
+		Type:   ItemType(strings.TrimSpace(params.Get("type"))),
+	}
+	if q.Text == "" {
+		http.Error(w, "Query parameter q is required", http.StatusBadRequest)
+		return
+	}
+	for name, dst := range map[string]*int64{"since": &q.Since, "until": &q.Until} {
+		if raw := params.Get(name); raw != "" {
+			v, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil {
+				http.Error(w, "Invalid "+name+" timestamp", http.StatusBadRequest)
+				return
+			}
+			*dst = v
+		}
+	}
+	if raw := params.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		q.Limit = limit
+	}
+
+	results, err := a.SearchRoom(roomID, q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(results)
+}
+
+// handleRoomExport handles GET /api/rooms/{id}/export?format=jsonl|markdown|bundle
+func (a *App) handleRoomExport(w http.ResponseWriter, r *http.Request, roomID string) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	authUser, err := a.authenticateRequest(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !a.userInRoom(authUser.ID, roomID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	format, err := parseExportFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", format.FileName(roomID)))
+	if err := a.exportRoom(roomID, format, w); err != nil {
+		// Headers are already sent once streaming starts, so the error can only be logged.
+		fmt.Printf("Export of room %s failed: %v\n", roomID, err)
+	}
+}
+
 // handleInvite handles POST /api/invite
 func (a *App) handleInvite(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -271,11 +828,12 @@ func (a *App) handleAcceptInvite(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-// handleChat handles POST /api/chat (send message) and GET /api/chat/{roomId}
+// handleChat handles POST /api/chat (send message), GET /api/chat/{roomId},
+// PATCH /api/chat/{roomId}/{messageId} (edit message) and DELETE /api/chat/{roomId}/{messageId}
 func (a *App) handleChat(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, DELETE, OPTIONS")
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 
 	if r.Method == "OPTIONS" {
@@ -305,8 +863,39 @@ func (a *App) handleChat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if r.Method == "POST" {
-		var req ChatMessageRequest
+	if r.Method == "POST" {
+		var req ChatMessageRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		reqUserID, err := enforceUserMatch(req.UserID, authUser)
+		if err != nil {
+			http.Error(w, "Forbidden: userId does not match token", http.StatusForbidden)
+			return
+		}
+		req.UserID = reqUserID
+
+		key := idempotencyKey(r, "chat", req.UserID)
+		if a.replayIdempotent(w, key) {
+			return
+		}
+		defer a.releaseIdempotent(key)
+
+		result := a.SendChatMessage(req.RoomID, req.UserID, req.Message)
+		a.respondIdempotent(w, key, http.StatusOK, APIResponse{Message: result})
+		return
+	}
+
+	if r.Method == "PATCH" {
+		roomID, messageID, _ := strings.Cut(roomID, "/")
+		if roomID == "" || messageID == "" {
+			http.Error(w, "Room ID and message ID are required", http.StatusBadRequest)
+			return
+		}
+
+		var req EditChatMessageRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, "Invalid JSON", http.StatusBadRequest)
 			return
@@ -317,17 +906,44 @@ func (a *App) handleChat(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Forbidden: userId does not match token", http.StatusForbidden)
 			return
 		}
-		req.UserID = reqUserID
 
-		result := a.SendChatMessage(req.RoomID, req.UserID, req.Message)
-		response := APIResponse{Message: result}
-		json.NewEncoder(w).Encode(response)
+		result := a.EditChatMessage(roomID, reqUserID, messageID, req.Message)
+		w.WriteHeader(chatResultStatus(result))
+		json.NewEncoder(w).Encode(APIResponse{Message: result, RoomID: roomID})
+		return
+	}
+
+	if r.Method == "DELETE" {
+		roomID, messageID, _ := strings.Cut(roomID, "/")
+		if roomID == "" || messageID == "" {
+			http.Error(w, "Room ID and message ID are required", http.StatusBadRequest)
+			return
+		}
+
+		result := a.DeleteChatMessage(roomID, authUser.ID, messageID)
+		w.WriteHeader(chatResultStatus(result))
+		json.NewEncoder(w).Encode(APIResponse{Message: result, RoomID: roomID})
 		return
 	}
 
 	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 }
 
+// chatResultStatus maps an edit or delete result string to an HTTP status.
+func chatResultStatus(result string) int {
+	switch {
+	case strings.HasPrefix(result, "Error: Only the"), strings.HasPrefix(result, "Error: User is not in"), strings.HasPrefix(result, "Error: user is not in"):
+		return http.StatusForbidden
+	case strings.HasSuffix(result, errMemberMuted.Error()), strings.HasSuffix(result, errViewerReadOnly.Error()):
+		return http.StatusForbidden
+	case strings.HasSuffix(result, "not found"):
+		return http.StatusNotFound
+	case strings.HasPrefix(result, "Error"):
+		return http.StatusBadRequest
+	}
+	return http.StatusOK
+}
+
 // handleJoinRoom handles POST /api/join
 func (a *App) handleJoinRoom(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -374,6 +990,56 @@ func (a *App) handleJoinRoom(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// handleJoinCode handles POST /api/join/code, joining the room a join code belongs to
+func (a *App) handleJoinCode(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	authUser, err := a.authenticateRequest(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req RedeemJoinCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	reqUserID, err := enforceUserMatch(req.UserID, authUser)
+	if err != nil {
+		http.Error(w, "Forbidden: userId does not match token", http.StatusForbidden)
+		return
+	}
+
+	room, err := a.RedeemJoinCode(reqUserID, req.Code)
+	if err != nil {
+		if errors.Is(err, errJoinCodeInvalid) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, errBannedFromRoom) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(APIResponse{Message: fmt.Sprintf("Joined room %s", room.Name), RoomID: room.ID})
+}
+
 // handleJoinRequest handles POST /api/join/request
 func (a *App) handleJoinRequest(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -410,6 +1076,10 @@ func (a *App) handleJoinRequest(w http.ResponseWriter, r *http.Request) {
 	req.UserID = reqUserID
 
 	msg, err := a.RequestJoinRoom(req.UserID, req.RoomID)
+	if errors.Is(err, errBannedFromRoom) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -510,7 +1180,8 @@ func (a *App) handleLeave(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-// handleOperations handles GET /api/operations/{roomId}?since={operationId}
+// handleOperations handles GET /api/operations/{roomId}?since={operationId},
+// the paged form ?limit=&after=|before= and GET /api/operations/{roomId}/verify
 func (a *App) handleOperations(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -539,6 +1210,9 @@ func (a *App) handleOperations(w http.ResponseWriter, r *http.Request) {
 		roomID = path[len("/api/operations/"):]
 	}
 
+	verify := strings.HasSuffix(roomID, "/verify")
+	roomID = strings.TrimSuffix(roomID, "/verify")
+
 	if roomID == "" {
 		http.Error(w, "Room ID is required", http.StatusBadRequest)
 		return
@@ -549,11 +1223,52 @@ func (a *App) handleOperations(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	sinceID := strings.TrimSpace(r.URL.Query().Get("since"))
-	sinceHash := strings.TrimSpace(r.URL.Query().Get("sinceHash"))
+	if verify {
+		report, err := a.VerifyOperations(roomID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(report)
+		return
+	}
+
+	params := r.URL.Query()
+	sinceID := strings.TrimSpace(params.Get("since"))
+	sinceHash := strings.TrimSpace(params.Get("sinceHash"))
+
+	// Paging is opt-in so existing clients keep receiving a plain array
+	if !params.Has("limit") && !params.Has("after") && !params.Has("before") {
+		operations := a.GetOperations(roomID, sinceID, sinceHash)
+		json.NewEncoder(w).Encode(operations)
+		return
+	}
+
+	q := OperationPageQuery{
+		After:  strings.TrimSpace(params.Get("after")),
+		Before: strings.TrimSpace(params.Get("before")),
+	}
+	if q.After == "" && q.Before == "" {
+		q.After = sinceHash
+		if q.After == "" {
+			q.After = sinceID
+		}
+	}
+	if raw := params.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		q.Limit = limit
+	}
 
-	operations := a.GetOperations(roomID, sinceID, sinceHash)
-	json.NewEncoder(w).Encode(operations)
+	page, err := a.GetOperationPage(roomID, q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(page)
 }
 
 // handleDownload handles GET /api/download/{operationId}
@@ -570,6 +1285,18 @@ func (a *App) handleDownload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Downloads are often opened in a browser, which cannot set headers, so the
+	// token may also come as a query param
+	token := bearerToken(r)
+	if token == "" {
+		token = r.URL.Query().Get("token")
+	}
+	authUser, err := a.authenticateToken(token)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
 	// Extract operation ID from URL path
 	path := r.URL.Path
 	opID := ""
@@ -583,33 +1310,18 @@ func (a *App) handleDownload(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Find the operation in any room
-	var targetOp *Operation
-
-	a.mu.RLock()
-	roomIDs := make([]string, 0, len(a.rooms))
-	for id := range a.rooms {
-		roomIDs = append(roomIDs, id)
-	}
-	a.mu.RUnlock()
-
-	for _, rid := range roomIDs {
-		ops := a.GetOperations(rid, "", "")
-		for _, op := range ops {
-			if op.ID == opID {
-				targetOp = op
-				break
-			}
-		}
-		if targetOp != nil {
-			break
-		}
-	}
+	targetOp, roomID := a.findOperationByID(opID)
 
 	if targetOp == nil {
 		http.Error(w, "File not found", http.StatusNotFound)
 		return
 	}
 
+	if !a.userInRoom(authUser.ID, roomID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
 	if targetOp.Item == nil || targetOp.Item.Type != ItemClipboard {
 		http.Error(w, "Invalid item type", http.StatusBadRequest)
 		return
@@ -689,27 +1401,55 @@ func (a *App) handleClipboardUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	authUser, err := a.authenticateRequest(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
 	var req ClipboardUploadRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
 
-	// Add to history pool
+	reqUserID, err := enforceUserMatch(req.UserID, authUser)
+	if err != nil {
+		http.Error(w, "Forbidden: userId does not match token", http.StatusForbidden)
+		return
+	}
+	req.UserID = reqUserID
+
+	key := idempotencyKey(r, "clipboard", req.UserID)
+	if a.replayIdempotent(w, key) {
+		return
+	}
+	defer a.releaseIdempotent(key)
+
 	a.mu.RLock()
-	user, exists := a.users[req.UserID]
+	var roomID string
+	if authUser.RoomID != nil {
+		roomID = *authUser.RoomID
+	}
+	req.UserName = authUser.Name
 	a.mu.RUnlock()
 
-	if !exists {
-		http.Error(w, "User not found", http.StatusForbidden)
+	if roomID == "" || !a.userInRoom(req.UserID, roomID) {
+		http.Error(w, "User not in a room", http.StatusForbidden)
 		return
 	}
 
-	if user.RoomID == nil {
-		http.Error(w, "User not in a room", http.StatusForbidden)
+	if err := a.canPost(req.UserID, roomID); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
 		return
 	}
-	roomID := *user.RoomID
+
+	if a.roomEncrypted(roomID) {
+		if err := sealedClipboardError(&req.Item); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 
 	itemID := fmt.Sprintf("clip_%d", time.Now().UnixNano())
 	histItem := &Item{
@@ -720,7 +1460,7 @@ func (a *App) handleClipboardUpload(w http.ResponseWriter, r *http.Request) {
 
 	fmt.Printf("Received clipboard upload from %s in room %s: %d files\n", req.UserName, roomID, len(req.Item.Files))
 
-	op := a.historyPool.AddOperation(roomID, OpAdd, itemID, histItem, req.UserID, req.UserName)
+	op := a.historyStore.AddOperation(roomID, OpAdd, itemID, histItem, req.UserID, req.UserName)
 
 	// Get room members for broadcast
 	a.mu.RLock()
@@ -735,9 +1475,98 @@ func (a *App) handleClipboardUpload(w http.ResponseWriter, r *http.Request) {
 		a.sseManager.BroadcastToUsers(members, EventClipboardCopied, op, "")
 	}
 
+	a.respondIdempotent(w, key, http.StatusOK, op)
+}
+
+// handleClipboardItem routes /api/clipboard/ requests: archive uploads to
+// handleZipUpload and item edits or deletes to handleClipboardEdit
+func (a *App) handleClipboardItem(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "PATCH" || r.Method == "DELETE" {
+		a.handleClipboardEdit(w, r)
+		return
+	}
+	a.handleZipUpload(w, r)
+}
+
+// handleClipboardEdit handles PATCH and DELETE /api/clipboard/{roomId}/{itemId}
+func (a *App) handleClipboardEdit(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "PATCH, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != "PATCH" && r.Method != "DELETE" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	authUser, err := a.authenticateRequest(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	roomID, itemID, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/clipboard/"), "/")
+	if roomID == "" || itemID == "" {
+		http.Error(w, "Room ID and item ID are required", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == "DELETE" {
+		if !a.userInRoom(authUser.ID, roomID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if err := a.DeleteClipboardItem(roomID, authUser.ID, itemID); err != nil {
+			writeItemError(w, err)
+			return
+		}
+		json.NewEncoder(w).Encode(APIResponse{Message: "Clipboard item deleted", RoomID: roomID})
+		return
+	}
+
+	var req EditClipboardItemRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	reqUserID, err := enforceUserMatch(req.UserID, authUser)
+	if err != nil {
+		http.Error(w, "Forbidden: userId does not match token", http.StatusForbidden)
+		return
+	}
+
+	if !a.userInRoom(reqUserID, roomID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	op, err := a.EditClipboardItem(roomID, reqUserID, itemID, req)
+	if err != nil {
+		writeItemError(w, err)
+		return
+	}
+
 	json.NewEncoder(w).Encode(op)
 }
 
+// writeItemError maps item edit/delete errors to HTTP statuses.
+func writeItemError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errItemNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, errNotItemAuthor), errors.Is(err, errMemberMuted), errors.Is(err, errViewerReadOnly):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
 // handleZipUpload handles POST /api/clipboard/{opID}/zip
 func (a *App) handleZipUpload(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -768,6 +1597,43 @@ func (a *App) handleZipUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	authUser, err := a.authenticateRequest(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Check the target before accepting the body: only the member who shared the
+	// clipboard item may attach its files
+	targetOp, roomID := a.findOperationByID(opID)
+
+	if targetOp == nil {
+		fmt.Printf("Operation not found: %s\n", opID)
+		http.Error(w, "Operation not found", http.StatusNotFound)
+		return
+	}
+
+	if targetOp.UserID != authUser.ID || !a.userInRoom(authUser.ID, roomID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if err := a.canPost(authUser.ID, roomID); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if targetOp.Item == nil || targetOp.Item.Type != ItemClipboard {
+		http.Error(w, "Invalid item type", http.StatusBadRequest)
+		return
+	}
+
+	itemData, ok := targetOp.Item.Data.(*clip_helper.ClipboardItem)
+	if !ok {
+		http.Error(w, "Invalid item data", http.StatusInternalServerError)
+		return
+	}
+
 	isSingle := r.URL.Query().Get("single") == "1"
 	fileName := r.Header.Get("X-Clipboard-File-Name")
 	fileMime := r.Header.Get("X-Clipboard-File-Mime")
@@ -780,14 +1646,19 @@ func (a *App) handleZipUpload(w http.ResponseWriter, r *http.Request) {
 	const maxDataSize = 100 * 1024 * 1024 * 1024 // 100GB
 	limitedReader := io.LimitReader(r.Body, maxDataSize+1)
 
+	// Files for encrypted rooms arrive sealed; the host keeps no name or type for them
+	encrypted := a.roomEncrypted(roomID)
+
 	var destPath string
-	if isSingle {
+	if encrypted {
+		destPath = filepath.Join(a.fileStoreDir(), opID+".e2e")
+	} else if isSingle {
 		if fileName == "" {
 			fileName = fmt.Sprintf("shared_file_%s", opID)
 		}
-		destPath = filepath.Join(a.tempDir, opID+"_"+fileName)
+		destPath = filepath.Join(a.fileStoreDir(), opID+"_"+fileName)
 	} else {
-		destPath = filepath.Join(a.tempDir, opID+".tar")
+		destPath = filepath.Join(a.fileStoreDir(), opID+".tar")
 	}
 
 	destFile, err := os.Create(destPath)
@@ -817,55 +1688,17 @@ func (a *App) handleZipUpload(w http.ResponseWriter, r *http.Request) {
 	fmt.Printf("Zip data size: %d bytes\n", nWritten)
 
 	// Update item in history pool
-	// Find operation in any room
-	var targetOp *Operation
-	var roomID string
-
-	a.mu.RLock()
-	roomIDs := make([]string, 0, len(a.rooms))
-	for id := range a.rooms {
-		roomIDs = append(roomIDs, id)
-	}
-	a.mu.RUnlock()
-
-	for _, rid := range roomIDs {
-		ops := a.GetOperations(rid, "", "")
-		for _, op := range ops {
-			if op.ID == opID {
-				targetOp = op
-				roomID = rid
-				break
-			}
-		}
-		if targetOp != nil {
-			break
-		}
-	}
-
-	if targetOp == nil {
-		fmt.Printf("Operation not found: %s\n", opID)
-		http.Error(w, "Operation not found", http.StatusNotFound)
-		return
-	}
-
-	if roomID != "" && !a.userInRoom(targetOp.UserID, roomID) {
-		http.Error(w, "Forbidden", http.StatusForbidden)
-		return
-	}
-
-	if targetOp.Item == nil || targetOp.Item.Type != ItemClipboard {
-		http.Error(w, "Invalid item type", http.StatusBadRequest)
-		return
-	}
-
-	itemData, ok := targetOp.Item.Data.(*clip_helper.ClipboardItem)
-	if !ok {
-		http.Error(w, "Invalid item data", http.StatusInternalServerError)
-		return
-	}
-
 	a.mu.Lock()
-	if isSingle {
+	if encrypted {
+		itemData.IsSingleFile = isSingle
+		itemData.SingleFileSize = nWritten
+		itemData.ZipData = nil
+		if isSingle {
+			itemData.SingleFilePath = destPath
+		} else {
+			itemData.ArchiveFilePath = destPath
+		}
+	} else if isSingle {
 		if fileMime == "" {
 			fileMime = "application/octet-stream"
 		}
@@ -896,6 +1729,12 @@ func (a *App) handleZipUpload(w http.ResponseWriter, r *http.Request) {
 
 	fmt.Printf("Updated operation %s with archive data. Text: %s\n", opID, itemData.Text)
 
+	if roomID != "" {
+		if err := a.historyStore.UpdateOperation(roomID, opID); err != nil {
+			fmt.Printf("Failed to persist archive data for %s: %v\n", opID, err)
+		}
+	}
+
 	// Broadcast to room members
 	if roomID != "" {
 		a.mu.RLock()
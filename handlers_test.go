@@ -213,7 +213,7 @@ func TestHandleInviteJoinChatLeaveFlow(t *testing.T) {
 		t.Fatalf("expected persisted chat history, got %#v", history)
 	}
 
-	ops := app.historyPool.GetOperations(roomID, "", "")
+	ops := app.historyStore.GetOperations(roomID, "", "")
 	if len(ops) == 0 {
 		t.Fatalf("expected at least one operation after chat")
 	}
@@ -246,12 +246,12 @@ func TestHandleInviteJoinChatLeaveFlow(t *testing.T) {
 	if len(incremental) != 1 || incremental[0].Item == nil {
 		t.Fatalf("expected single incremental operation, got %#v", incremental)
 	}
-	msgPayload, ok := incremental[0].Item.Data.(map[string]interface{})
+	msgPayload, ok := incremental[0].Item.Data.(*ChatMessage)
 	if !ok {
 		t.Fatalf("operation payload should be ChatMessage object, got %#v", incremental[0].Item.Data)
 	}
-	if msgPayload["message"] != secondMsg {
-		t.Fatalf("expected operation message %q, got %v", secondMsg, msgPayload["message"])
+	if msgPayload.Message != secondMsg {
+		t.Fatalf("expected operation message %q, got %v", secondMsg, msgPayload.Message)
 	}
 
 	inviterConn.Reset()
@@ -319,7 +319,7 @@ func TestTempDirectoryLargeFile(t *testing.T) {
 			Files: []string{"/nonexistent/file1.txt", "/nonexistent/file2.txt"}, // Dummy files
 		},
 	}
-	op := app.historyPool.AddOperation(room.ID, OpAdd, itemID, histItem, user.ID, user.Name)
+	op := app.historyStore.AddOperation(room.ID, OpAdd, itemID, histItem, user.ID, user.Name)
 
 	// Simulate archive upload (large file)
 	largeData := make([]byte, 1024*1024) // 1MB "large" file for test
@@ -349,6 +349,8 @@ func TestTempDirectoryLargeFile(t *testing.T) {
 	url := "/api/clipboard/" + op.ID + "/zip"
 	req := httptest.NewRequest(http.MethodPost, url, bytes.NewReader(buf.Bytes()))
 	req.Header.Set("Content-Type", "application/x-tar")
+	token, _ := app.issueToken(user.ID)
+	req.Header.Set("Authorization", "Bearer "+token)
 
 	rr := httptest.NewRecorder()
 	app.handleZipUpload(rr, req)
@@ -379,7 +381,7 @@ func TestTempDirectoryLargeFile(t *testing.T) {
 	}
 
 	// Check that the item has ArchiveFilePath set
-	ops := app.historyPool.GetOperations(room.ID, "", "")
+	ops := app.historyStore.GetOperations(room.ID, "", "")
 	var targetOp *Operation
 	for _, o := range ops {
 		if o.ID == op.ID {
@@ -401,11 +403,15 @@ func TestTempDirectoryLargeFile(t *testing.T) {
 	// Simulate download by another user
 	// Create another user
 	user2 := app.CreateUser("TestUser2")
-	app.JoinRoom(user2.ID, room.ID)
+	if err := app.ApproveJoinRequest(user.ID, user2.ID, room.ID); err != nil {
+		t.Fatalf("ApproveJoinRequest error: %v", err)
+	}
 
 	// Download request
 	downloadURL := "/api/download/" + op.ID
 	req2 := httptest.NewRequest(http.MethodGet, downloadURL, nil)
+	token2, _ := app.issueToken(user2.ID)
+	req2.Header.Set("Authorization", "Bearer "+token2)
 	rr2 := httptest.NewRecorder()
 	app.handleDownload(rr2, req2)
 
@@ -435,3 +441,264 @@ func TestTempDirectoryLargeFile(t *testing.T) {
 		t.Fatalf("downloaded data mismatch")
 	}
 }
+
+// Ensures authors can edit chat messages and clipboard text, that edits are broadcast,
+// and that both the original and edited versions remain in the op log.
+func TestHandleEditChatAndClipboard(t *testing.T) {
+	app := newTestApp()
+	author := app.CreateUser("Author")
+	other := app.CreateUser("Other")
+	room := app.CreateRoom("Edits", "host")
+	for _, u := range []*User{author, other} {
+		if _, err := app.JoinRoom(u.ID, room.ID); err != nil {
+			t.Fatalf("JoinRoom error: %v", err)
+		}
+	}
+	authorToken, _ := app.issueToken(author.ID)
+	otherToken, _ := app.issueToken(other.ID)
+	otherConn := attachClient(app, other.ID)
+
+	app.SendChatMessage(room.ID, author.ID, "frist")
+	msgID := app.GetChatHistory(room.ID)[0].ID
+
+	editBody := mustLoadTestJSON(t, "chat_edit_template.json", map[string]string{"userId": other.ID, "message": "hijack"})
+	req := httptest.NewRequest(http.MethodPatch, "/api/chat/"+room.ID+"/"+msgID, bytes.NewReader(editBody))
+	req.Header.Set("Authorization", "Bearer "+otherToken)
+	rr := httptest.NewRecorder()
+	app.handleChat(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("non-author edit expected 403, got %d", rr.Code)
+	}
+
+	otherConn.Reset()
+	editBody = mustLoadTestJSON(t, "chat_edit_template.json", map[string]string{"userId": author.ID, "message": "first"})
+	req = httptest.NewRequest(http.MethodPatch, "/api/chat/"+room.ID+"/"+msgID, bytes.NewReader(editBody))
+	req.Header.Set("Authorization", "Bearer "+authorToken)
+	rr = httptest.NewRecorder()
+	app.handleChat(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("PATCH /api/chat expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	evt, ok := findEvent(otherConn.Events(), EventChatMessageEdited)
+	if !ok {
+		t.Fatalf("room members should receive chat_message_edited")
+	}
+	if edited := decodeEventPayload[ChatMessage](t, evt); edited.ID != msgID || edited.Message != "first" || edited.EditedAt == 0 {
+		t.Fatalf("unexpected edited payload %+v", edited)
+	}
+	if msgs := app.GetChatHistory(room.ID); len(msgs) != 1 || msgs[0].Message != "first" {
+		t.Fatalf("expected materialised chat to reflect edit, got %#v", msgs)
+	}
+
+	clip := &clip_helper.ClipboardItem{Type: clip_helper.ClipboardText, Text: "draft"}
+	app.historyStore.AddOperation(room.ID, OpAdd, "clip_1", &Item{ID: "clip_1", Type: ItemClipboard, Data: clip}, author.ID, author.Name)
+
+	otherConn.Reset()
+	clipBody := mustLoadTestJSON(t, "clipboard_edit_template.json", map[string]string{"userId": author.ID, "text": "final"})
+	req = httptest.NewRequest(http.MethodPatch, "/api/clipboard/"+room.ID+"/clip_1", bytes.NewReader(clipBody))
+	req.Header.Set("Authorization", "Bearer "+authorToken)
+	rr = httptest.NewRecorder()
+	app.handleClipboardItem(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("PATCH /api/clipboard expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if _, ok := findEvent(otherConn.Events(), EventClipboardModified); !ok {
+		t.Fatalf("room members should receive clipboard_modified")
+	}
+	if items := app.historyStore.GetCurrentClipboardItems(room.ID); len(items) != 1 || items[0].Text != "final" {
+		t.Fatalf("expected materialised clipboard to reflect edit, got %#v", items)
+	}
+
+	var kinds []OperationType
+	for _, op := range app.GetOperations(room.ID, "", "") {
+		kinds = append(kinds, op.OpType)
+	}
+	if fmt.Sprint(kinds) != fmt.Sprint([]OperationType{OpAdd, OpModify, OpAdd, OpModify}) {
+		t.Fatalf("expected edit history in op log, got %v", kinds)
+	}
+	if clip.Text != "draft" {
+		t.Fatalf("original clipboard version should be preserved, got %q", clip.Text)
+	}
+	if report := app.historyStore.VerifyChain(room.ID); !report.Valid {
+		t.Fatalf("expected chain to stay valid after edits, got %+v", report)
+	}
+}
+
+// Ensures authors and room owners can delete items, that deletes broadcast a removal
+// event, scrub the stored payload and remove the uploaded file from the host.
+func TestHandleDeleteChatAndClipboard(t *testing.T) {
+	app := newTestApp()
+	owner := app.CreateUser("Owner")
+	author := app.CreateUser("Author")
+	bystander := app.CreateUser("Bystander")
+	room := app.CreateRoom("Deletes", owner.ID)
+	for _, u := range []*User{author, bystander} {
+		if err := app.ApproveJoinRequest(owner.ID, u.ID, room.ID); err != nil {
+			t.Fatalf("ApproveJoinRequest error: %v", err)
+		}
+	}
+	for _, u := range []*User{owner, author, bystander} {
+		if _, err := app.JoinRoom(u.ID, room.ID); err != nil {
+			t.Fatalf("JoinRoom error: %v", err)
+		}
+	}
+	ownerToken, _ := app.issueToken(owner.ID)
+	authorToken, _ := app.issueToken(author.ID)
+	bystanderToken, _ := app.issueToken(bystander.ID)
+	bystanderConn := attachClient(app, bystander.ID)
+
+	app.SendChatMessage(room.ID, author.ID, "my password is hunter2")
+	msgID := app.GetChatHistory(room.ID)[0].ID
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/chat/"+room.ID+"/"+msgID, nil)
+	req.Header.Set("Authorization", "Bearer "+bystanderToken)
+	rr := httptest.NewRecorder()
+	app.handleChat(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("bystander delete expected 403, got %d", rr.Code)
+	}
+
+	bystanderConn.Reset()
+	req = httptest.NewRequest(http.MethodDelete, "/api/chat/"+room.ID+"/"+msgID, nil)
+	req.Header.Set("Authorization", "Bearer "+authorToken)
+	rr = httptest.NewRecorder()
+	app.handleChat(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("author delete expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	evt, ok := findEvent(bystanderConn.Events(), EventChatMessageDeleted)
+	if !ok {
+		t.Fatalf("room members should receive chat_message_deleted")
+	}
+	if removed := decodeEventPayload[ItemRemovedEvent](t, evt); removed.ItemID != msgID || removed.RemovedBy != author.ID {
+		t.Fatalf("unexpected removal payload %+v", removed)
+	}
+	if msgs := app.GetChatHistory(room.ID); len(msgs) != 0 {
+		t.Fatalf("expected deleted message to disappear, got %#v", msgs)
+	}
+	for _, op := range app.GetOperations(room.ID, "", "") {
+		if msg, ok := op.Item.Data.(*ChatMessage); ok && strings.Contains(msg.Message, "hunter2") {
+			t.Fatalf("deleted content still served from op log: %+v", op)
+		}
+	}
+
+	filePath := filepath.Join(app.fileStoreDir(), "clip_secret.txt")
+	if err := os.WriteFile(filePath, []byte("secret"), 0o644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	clip := &clip_helper.ClipboardItem{Type: clip_helper.ClipboardFile, IsSingleFile: true, SingleFileName: "secret.txt", SingleFilePath: filePath}
+	app.historyStore.AddOperation(room.ID, OpAdd, "clip_secret", &Item{ID: "clip_secret", Type: ItemClipboard, Data: clip}, author.ID, author.Name)
+
+	bystanderConn.Reset()
+	req = httptest.NewRequest(http.MethodDelete, "/api/clipboard/"+room.ID+"/clip_secret", nil)
+	req.Header.Set("Authorization", "Bearer "+ownerToken)
+	rr = httptest.NewRecorder()
+	app.handleClipboardItem(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("owner delete expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if _, ok := findEvent(bystanderConn.Events(), EventClipboardRemoved); !ok {
+		t.Fatalf("room members should receive clipboard_removed")
+	}
+	if _, err := os.Stat(filePath); !os.IsNotExist(err) {
+		t.Fatalf("expected shared file to be deleted, stat err %v", err)
+	}
+	if items := app.historyStore.GetCurrentClipboardItems(room.ID); len(items) != 0 {
+		t.Fatalf("expected clipboard item removed, got %#v", items)
+	}
+
+	report := app.historyStore.VerifyChain(room.ID)
+	if !report.Valid || report.Redacted != 2 {
+		t.Fatalf("expected valid chain with 2 redacted ops, got %+v", report)
+	}
+}
+
+// Ensures clipboard uploads, file attachments and downloads require a token and
+// room membership, and that only the author attaches files to an item.
+func TestClipboardEndpointsRequireMembership(t *testing.T) {
+	app, room, members := newTestRoom(t, "Archive", "", "Alice")
+	alice := members[0]
+	bob := app.CreateUser("Bob")
+	if _, err := app.JoinRoom(bob.ID, room.ID); err != nil {
+		t.Fatalf("JoinRoom error: %v", err)
+	}
+	mallory := app.CreateUser("Mallory")
+	aliceToken, _ := app.issueToken(alice.ID)
+	bobToken, _ := app.issueToken(bob.ID)
+	malloryToken, _ := app.issueToken(mallory.ID)
+
+	upload := func(token, userID string) *httptest.ResponseRecorder {
+		body := `{"item":{"type":"file","text":"report"},"userId":"` + userID + `","userName":"Spoofed"}`
+		req := httptest.NewRequest(http.MethodPost, "/api/clipboard", strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		app.handleClipboardUpload(rr, req)
+		return rr
+	}
+	if rr := upload("", alice.ID); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without token, got %d", rr.Code)
+	}
+	if rr := upload(malloryToken, alice.ID); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for mismatched userId, got %d", rr.Code)
+	}
+	if rr := upload(malloryToken, mallory.ID); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 outside a room, got %d", rr.Code)
+	}
+	rr := upload(aliceToken, alice.ID)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 for member upload, got %d: %s", rr.Code, rr.Body.String())
+	}
+	op := decodeResponseBody[Operation](t, rr)
+	if op.UserID != alice.ID || op.UserName != alice.Name {
+		t.Fatalf("expected operation attributed to the token's user, got %s/%s", op.UserID, op.UserName)
+	}
+
+	attach := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/clipboard/"+op.ID+"/zip?single=1", strings.NewReader("payload"))
+		req.Header.Set("X-Clipboard-File-Name", "report.txt")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		app.handleZipUpload(rr, req)
+		return rr
+	}
+	for name, tc := range map[string]struct {
+		token string
+		code  int
+	}{
+		"anonymous": {"", http.StatusUnauthorized},
+		"outsider":  {malloryToken, http.StatusForbidden},
+		"member":    {bobToken, http.StatusForbidden},
+	} {
+		if rr := attach(tc.token); rr.Code != tc.code {
+			t.Fatalf("%s attach: expected %d, got %d", name, tc.code, rr.Code)
+		}
+	}
+	if rr := attach(aliceToken); rr.Code != http.StatusOK {
+		t.Fatalf("expected author attach to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	download := func(target string, bearer string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		rr := httptest.NewRecorder()
+		app.handleDownload(rr, req)
+		return rr
+	}
+	if rr := download("/api/download/"+op.ID, ""); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for anonymous download, got %d", rr.Code)
+	}
+	if rr := download("/api/download/"+op.ID, malloryToken); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for outsider download, got %d", rr.Code)
+	}
+	rr = download("/api/download/"+op.ID+"?token="+bobToken, "")
+	if rr.Code != http.StatusOK || rr.Body.String() != "payload" {
+		t.Fatalf("expected member download via query token, got %d: %q", rr.Code, rr.Body.String())
+	}
+}
@@ -45,3 +45,54 @@ func TestGetOperationsSinceHash(t *testing.T) {
 		t.Fatalf("expected op2 after sinceID, got %+v", byID)
 	}
 }
+
+// Verifies VerifyChain accepts an intact chain and pinpoints tampered, relinked or
+// falsely redacted operations.
+func TestHistoryVerifyChain(t *testing.T) {
+	hp := NewHistoryPool()
+	room := "room-verify"
+
+	var ops []*Operation
+	for i, text := range []string{"a", "b", "c"} {
+		id := "item" + text
+		ops = append(ops, hp.AddOperation(room, OpAdd, id, &Item{ID: id, Type: ItemChat, Data: &ChatMessage{ID: id, Message: text, Timestamp: int64(i)}}, "u1", "Alice"))
+	}
+
+	report := hp.VerifyChain(room)
+	if !report.Valid || report.Checked != 3 || report.HeadHash != ops[2].Hash || report.TrimmedPrefix {
+		t.Fatalf("expected intact chain, got %+v", report)
+	}
+
+	ops[1].Item.Data.(*ChatMessage).Message = "tampered"
+	report = hp.VerifyChain(room)
+	if report.Valid || report.BrokenAt != ops[1].ID || report.Reason != chainBrokenHashMismatch || report.Checked != 1 {
+		t.Fatalf("expected hash mismatch at %s, got %+v", ops[1].ID, report)
+	}
+	ops[1].Item.Data.(*ChatMessage).Message = "b"
+
+	ops[2].ParentHash = ops[0].Hash
+	report = hp.VerifyChain(room)
+	if report.Valid || report.BrokenAt != ops[2].ID || report.Reason != chainBrokenParentMismatch {
+		t.Fatalf("expected parent mismatch at %s, got %+v", ops[2].ID, report)
+	}
+
+	ops[2].ParentHash = ""
+	report = hp.VerifyChain(room)
+	if report.Valid || report.Reason != chainBrokenMissingParent {
+		t.Fatalf("expected missing parent at %s, got %+v", ops[2].ID, report)
+	}
+	ops[2].ParentHash = ops[1].Hash
+
+	ops[1].Redacted = true
+	report = hp.VerifyChain(room)
+	if report.Valid || report.BrokenAt != ops[1].ID || report.Reason != chainBrokenRedactedPayload {
+		t.Fatalf("expected a redacted op with content to break the chain, got %+v", report)
+	}
+	ops[1].Redacted = false
+
+	hp.Trim(room, 2)
+	report = hp.VerifyChain(room)
+	if !report.Valid || !report.TrimmedPrefix || report.BaseHash != ops[0].Hash || report.Checked != 2 {
+		t.Fatalf("expected valid chain with trimmed prefix, got %+v", report)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+// opIndex resolves operation references without scanning room histories. It is
+// owned by a HistoryPool and guarded by the pool's mutex; positions are rebuilt
+// whenever a room's retained prefix changes (compaction or trim).
+type opIndex struct {
+	rooms map[string]*roomOpIndex
+	owner map[string]string // operation ID -> room ID, including ops folded into a snapshot
+}
+
+type roomOpIndex struct {
+	refs  map[string]int    // operation ID or hash -> position in the room's slice
+	items map[string]string // live item ID -> ID of the operation that added it
+}
+
+func newOpIndex() *opIndex {
+	return &opIndex{
+		rooms: make(map[string]*roomOpIndex),
+		owner: make(map[string]string),
+	}
+}
+
+func (ix *opIndex) room(roomID string) *roomOpIndex {
+	r, ok := ix.rooms[roomID]
+	if !ok {
+		r = &roomOpIndex{refs: make(map[string]int), items: make(map[string]string)}
+		ix.rooms[roomID] = r
+	}
+	return r
+}
+
+// add records op at position pos of the room's slice.
+func (ix *opIndex) add(roomID string, op *Operation, pos int) {
+	r := ix.room(roomID)
+	r.refs[op.ID] = pos
+	if op.Hash != "" {
+		r.refs[op.Hash] = pos
+	}
+	ix.owner[op.ID] = roomID
+
+	switch op.OpType {
+	case OpAdd:
+		r.items[op.ItemID] = op.ID
+	case OpRemove:
+		delete(r.items, op.ItemID)
+	case OpSnapshot:
+		if snap := snapshotData(op); snap != nil {
+			for _, entries := range [][]*SnapshotEntry{snap.Chat, snap.Clipboard} {
+				for _, e := range entries {
+					r.items[e.ItemID] = e.OpID
+					ix.owner[e.OpID] = roomID
+				}
+			}
+		}
+	}
+}
+
+// rebuild re-indexes a room after operations were dropped from the front.
+func (ix *opIndex) rebuild(roomID string, ops []*Operation) {
+	if r, ok := ix.rooms[roomID]; ok {
+		for ref := range r.refs {
+			if ix.owner[ref] == roomID {
+				delete(ix.owner, ref)
+			}
+		}
+		for _, opID := range r.items {
+			if ix.owner[opID] == roomID {
+				delete(ix.owner, opID)
+			}
+		}
+		delete(ix.rooms, roomID)
+	}
+	for i, op := range ops {
+		ix.add(roomID, op, i)
+	}
+}
+
+// position returns where ref sits in ops, or -1.
+func (ix *opIndex) position(roomID string, ops []*Operation, ref string) int {
+	r, ok := ix.rooms[roomID]
+	if !ok {
+		return -1
+	}
+	pos, ok := r.refs[ref]
+	if !ok || pos >= len(ops) || (ops[pos].ID != ref && ops[pos].Hash != ref) {
+		return -1
+	}
+	return pos
+}
+
+// positionLocked resolves ref against a room's retained operations. Callers must hold hp.mu.
+func (hp *HistoryPool) positionLocked(roomID, ref string) int {
+	return hp.refs.position(roomID, hp.operations[roomID], ref)
+}
+
+// resolveLocked returns the retained operation with opID, or the add operation
+// rebuilt from the room's snapshot if it was compacted away.
+func (hp *HistoryPool) resolveLocked(roomID, opID string) *Operation {
+	ops := hp.operations[roomID]
+	pos := hp.refs.position(roomID, ops, opID)
+	if pos >= 0 && ops[pos].OpType != OpSnapshot {
+		return ops[pos]
+	}
+	// A snapshot shares its ID with the last operation it folded in, so an
+	// add captured in it takes precedence over the snapshot itself
+	if len(ops) > 0 && ops[0].OpType == OpSnapshot {
+		if e := snapshotData(ops[0]).entryForOp(opID); e != nil {
+			return e.operation()
+		}
+	}
+	if pos >= 0 {
+		return ops[pos]
+	}
+	return nil
+}
+
+// FindOperation looks an operation up by ID across all rooms.
+func (hp *HistoryPool) FindOperation(opID string) (*Operation, string) {
+	hp.mu.RLock()
+	defer hp.mu.RUnlock()
+
+	roomID, ok := hp.refs.owner[opID]
+	if !ok {
+		return nil, ""
+	}
+	if op := hp.resolveLocked(roomID, opID); op != nil {
+		return op, roomID
+	}
+	return nil, ""
+}
+
+// FindItemOperation returns the operation that added a live item.
+func (hp *HistoryPool) FindItemOperation(roomID, itemID string) *Operation {
+	hp.mu.RLock()
+	defer hp.mu.RUnlock()
+
+	r, ok := hp.refs.rooms[roomID]
+	if !ok {
+		return nil
+	}
+	opID, ok := r.items[itemID]
+	if !ok {
+		return nil
+	}
+	return hp.resolveLocked(roomID, opID)
+}
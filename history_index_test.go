@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"GOproject/clip_helper"
+)
+
+func addTestChat(hp HistoryStore, roomID, id string) *Operation {
+	return hp.AddOperation(roomID, OpAdd, id, &Item{ID: id, Type: ItemChat, Data: &ChatMessage{ID: id, Message: id}}, "u1", "")
+}
+
+// Ensures the global index finds operations in any room and tracks trims.
+func TestHistoryPoolFindOperation(t *testing.T) {
+	hp := NewHistoryPool()
+	a := addTestChat(hp, "room-a", "msg_a")
+	b := addTestChat(hp, "room-b", "msg_b")
+	addTestChat(hp, "room-b", "msg_c")
+
+	if op, room := hp.FindOperation(b.ID); op != b || room != "room-b" {
+		t.Fatalf("expected %s in room-b, got %v in %q", b.ID, op, room)
+	}
+	if op, room := hp.FindOperation(a.ID); op != a || room != "room-a" {
+		t.Fatalf("expected %s in room-a, got %v in %q", a.ID, op, room)
+	}
+	if op := hp.FindItemOperation("room-b", "msg_b"); op != b {
+		t.Fatalf("expected item lookup to return the add op, got %v", op)
+	}
+
+	hp.Trim("room-b", 1)
+	if op, _ := hp.FindOperation(b.ID); op != nil {
+		t.Fatalf("expected trimmed op to drop out of the index")
+	}
+	if ops := hp.GetOperations("room-b", "", ""); len(ops) != 1 || hp.GetOperationRange("room-b", ops[0].Hash, "")[0] != ops[0] {
+		t.Fatalf("expected positions to be rebuilt after trim")
+	}
+
+	hp.AddOperation("room-a", OpRemove, "msg_a", &Item{ID: "msg_a", Type: ItemChat}, "u1", "")
+	if op := hp.FindItemOperation("room-a", "msg_a"); op != nil {
+		t.Fatalf("expected removed item to have no add op, got %v", op)
+	}
+}
+
+// Verifies lookups stay correct when enforceLimits folds history into a snapshot.
+func TestHistoryPoolIndexAcrossCompaction(t *testing.T) {
+	for name, newStore := range historyStoreImplementations(t) {
+		t.Run(name, func(t *testing.T) {
+			store := newStore()
+			room := "room-index"
+			// Clipboard items survive compaction; the oldest chat would be capped out
+			first := store.AddOperation(room, OpAdd, "clip_first", &Item{ID: "clip_first", Type: ItemClipboard, Data: &clip_helper.ClipboardItem{Type: clip_helper.ClipboardText, Text: "kept"}}, "u1", "")
+			var last *Operation
+			for i := 0; i <= maxOperationsPerRoom; i++ {
+				last = addTestChat(store, room, fmt.Sprintf("msg_%d", i))
+			}
+
+			ops := store.GetOperations(room, "", "")
+			if ops[0].OpType != OpSnapshot {
+				t.Fatalf("expected history to be compacted")
+			}
+			if op, got := store.FindOperation(first.ID); op == nil || got != room || op.OpType != OpAdd || op.ItemID != "clip_first" {
+				t.Fatalf("expected folded add op to resolve through the snapshot, got %+v", op)
+			}
+			if op := store.FindItemOperation(room, "clip_first"); op == nil || op.ID != first.ID {
+				t.Fatalf("expected folded item to resolve to %s, got %+v", first.ID, op)
+			}
+			if op, _ := store.FindOperation(last.ID); op != last {
+				t.Fatalf("expected retained op to resolve directly")
+			}
+			if tail := store.GetOperations(room, "", ops[len(ops)-2].Hash); len(tail) != 1 || tail[0] != last {
+				t.Fatalf("expected sinceHash lookup to use rebuilt positions, got %d ops", len(tail))
+			}
+		})
+	}
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"GOproject/clip_helper"
+)
+
+const (
+	journalExt          = ".jsonl"
+	journalKindOp       = "op"     // a new operation appended to the room
+	journalKindUpdate   = "update" // an existing operation's item changed in place (e.g. file attached)
+	maxJournalLineBytes = 64 * 1024 * 1024
+)
+
+// journalRecord is a single line in a room's append-only operation log.
+type journalRecord struct {
+	Kind  string                   `json:"kind"`
+	Op    *Operation               `json:"op,omitempty"`
+	Files map[string]*journalFiles `json:"files,omitempty"` // itemID -> host file locations
+}
+
+// journalFiles keeps host-side file paths that are deliberately hidden from API JSON.
+type journalFiles struct {
+	ArchiveFilePath string `json:"archiveFilePath,omitempty"`
+	SingleFilePath  string `json:"singleFilePath,omitempty"`
+}
+
+// historyJournal appends operations to one JSONL file per room under dir.
+type historyJournal struct {
+	dir   string
+	files map[string]*os.File
+	mu    sync.Mutex
+}
+
+func newHistoryJournal(dir string) (*historyJournal, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create history dir: %w", err)
+	}
+	return &historyJournal{dir: dir, files: make(map[string]*os.File)}, nil
+}
+
+func journalFileName(roomID string) string {
+	return url.PathEscape(roomID) + journalExt
+}
+
+func (j *historyJournal) append(roomID string, rec journalRecord) error {
+	rec.Files = collectJournalFiles(rec.Op)
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("marshal journal record: %w", err)
+	}
+	line = append(line, '\n')
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	f, ok := j.files[roomID]
+	if !ok {
+		f, err = os.OpenFile(filepath.Join(j.dir, journalFileName(roomID)), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("open journal for room %s: %w", roomID, err)
+		}
+		j.files[roomID] = f
+	}
+
+	if _, err := f.Write(line); err != nil {
+		return fmt.Errorf("write journal for room %s: %w", roomID, err)
+	}
+	return f.Sync()
+}
+
+// rewrite replaces a room's log with one op record per retained operation,
+// used after compaction so the log does not grow without bound.
+func (j *historyJournal) rewrite(roomID string, ops []*Operation) error {
+	var buf bytes.Buffer
+	for _, op := range ops {
+		line, err := json.Marshal(journalRecord{Kind: journalKindOp, Op: op, Files: collectJournalFiles(op)})
+		if err != nil {
+			return fmt.Errorf("marshal journal record: %w", err)
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if f, ok := j.files[roomID]; ok {
+		f.Close()
+		delete(j.files, roomID)
+	}
+
+	path := filepath.Join(j.dir, journalFileName(roomID))
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("write compacted journal for room %s: %w", roomID, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("replace journal for room %s: %w", roomID, err)
+	}
+	return nil
+}
+
+func (j *historyJournal) close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var errs []error
+	for roomID, f := range j.files {
+		if err := f.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		delete(j.files, roomID)
+	}
+	return errors.Join(errs...)
+}
+
+// replay reads every room log in dir and hands records to apply in file order.
+// A truncated trailing line (e.g. from a crash mid-write) is skipped.
+func (j *historyJournal) replay(apply func(roomID string, rec *journalRecord)) error {
+	entries, err := os.ReadDir(j.dir)
+	if err != nil {
+		return fmt.Errorf("read history dir: %w", err)
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, journalExt) {
+			continue
+		}
+		roomID, err := url.PathUnescape(strings.TrimSuffix(name, journalExt))
+		if err != nil {
+			fmt.Printf("Skipping history file with invalid name %s: %v\n", name, err)
+			continue
+		}
+		if err := replayJournalFile(filepath.Join(j.dir, name), roomID, apply); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func replayJournalFile(path, roomID string, apply func(roomID string, rec *journalRecord)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open history file %s: %w", path, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxJournalLineBytes)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		raw := scanner.Bytes()
+		if len(raw) == 0 {
+			continue
+		}
+		var rec journalRecord
+		if err := json.Unmarshal(raw, &rec); err != nil || rec.Op == nil {
+			fmt.Printf("Skipping unreadable history record %s:%d: %v\n", path, lineNo, err)
+			continue
+		}
+		restoreJournalFiles(rec.Op, rec.Files)
+		apply(roomID, &rec)
+	}
+	return scanner.Err()
+}
+
+func collectJournalFiles(op *Operation) map[string]*journalFiles {
+	if op == nil || op.Item == nil {
+		return nil
+	}
+	files := make(map[string]*journalFiles)
+	addFiles := func(item *Item) {
+		if item == nil {
+			return
+		}
+		clip, ok := item.Data.(*clip_helper.ClipboardItem)
+		if !ok || (clip.ArchiveFilePath == "" && clip.SingleFilePath == "") {
+			return
+		}
+		files[item.ID] = &journalFiles{ArchiveFilePath: clip.ArchiveFilePath, SingleFilePath: clip.SingleFilePath}
+	}
+
+	if snap := snapshotData(op); snap != nil {
+		for _, e := range snap.Clipboard {
+			addFiles(e.Item)
+		}
+	} else {
+		addFiles(op.Item)
+	}
+
+	if len(files) == 0 {
+		return nil
+	}
+	return files
+}
+
+func restoreJournalFiles(op *Operation, files map[string]*journalFiles) {
+	if op.Item == nil || len(files) == 0 {
+		return
+	}
+	restore := func(item *Item) {
+		if item == nil {
+			return
+		}
+		clip, ok := item.Data.(*clip_helper.ClipboardItem)
+		if !ok {
+			return
+		}
+		if paths, ok := files[item.ID]; ok {
+			clip.ArchiveFilePath = paths.ArchiveFilePath
+			clip.SingleFilePath = paths.SingleFilePath
+		}
+	}
+
+	if snap := snapshotData(op); snap != nil {
+		for _, e := range snap.Clipboard {
+			restore(e.Item)
+		}
+		return
+	}
+	restore(op.Item)
+}
+
+// operationSequence extracts N from an "op_N" ID so the counter can resume after replay.
+func operationSequence(opID string) int {
+	n, err := strconv.Atoi(strings.TrimPrefix(opID, "op_"))
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// FileHistoryStore keeps the in-memory HistoryPool view and mirrors every
+// mutation to an append-only JSONL log per room, replayed on startup. The pool
+// is a named field so that every HistoryStore method is spelled out here and a
+// new mutating method cannot bypass the log by being promoted from the pool.
+type FileHistoryStore struct {
+	pool    *HistoryPool
+	journal *historyJournal
+	mu      sync.Mutex // serialises appends so log order matches chain order
+}
+
+// NewFileHistoryStore replays any existing room logs from dir and persists
+// every subsequent operation there.
+func NewFileHistoryStore(dir string) (*FileHistoryStore, error) {
+	return openFileHistoryStore(dir, nil)
+}
+
+// openFileHistoryStore is NewFileHistoryStore with per-room operation limits
+// applied before the replay, so a room allowed more than maxOperationsPerRoom
+// is not compacted while its log loads.
+func openFileHistoryStore(dir string, limits map[string]int) (*FileHistoryStore, error) {
+	journal, err := newHistoryJournal(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := NewHistoryPool()
+	for roomID, limit := range limits {
+		pool.SetOperationLimit(roomID, limit)
+	}
+	replayed := 0
+	err = journal.replay(func(roomID string, rec *journalRecord) {
+		pool.applyJournalRecord(roomID, rec)
+		if rec.Kind == journalKindOp {
+			replayed++
+		}
+	})
+	if err != nil {
+		journal.close()
+		return nil, err
+	}
+
+	fmt.Printf("Replayed %d operations from %s\n", replayed, dir)
+	return &FileHistoryStore{pool: pool, journal: journal}, nil
+}
+
+func (hp *HistoryPool) applyJournalRecord(roomID string, rec *journalRecord) {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+
+	switch rec.Kind {
+	case journalKindOp:
+		hp.operations[roomID] = append(hp.operations[roomID], rec.Op)
+		hp.refs.add(roomID, rec.Op, len(hp.operations[roomID])-1)
+		hp.index.apply(roomID, rec.Op)
+		hp.enforceLimits(roomID)
+		if seq := operationSequence(rec.Op.ID); seq > hp.counter {
+			hp.counter = seq
+		}
+	case journalKindUpdate:
+		if pos := hp.positionLocked(roomID, rec.Op.ID); pos >= 0 {
+			op := hp.operations[roomID][pos]
+			op.Item = rec.Op.Item
+			hp.index.reindex(roomID, op)
+		}
+	}
+}
+
+// AddOperation appends to the in-memory history and the room log, rewriting the
+// log when the append triggered a compaction.
+func (fs *FileHistoryStore) AddOperation(roomID string, opType OperationType, itemID string, item *Item, userID, userName string) *Operation {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	op, compacted := fs.pool.addOperation(roomID, opType, itemID, item, userID, userName)
+	if compacted {
+		// The log is rewritten as snapshot + tail, which already includes op
+		if err := fs.journal.rewrite(roomID, fs.pool.GetOperations(roomID, "", "")); err != nil {
+			fmt.Printf("Failed to persist compacted history for room %s: %v\n", roomID, err)
+		}
+		return op
+	}
+	if err := fs.journal.append(roomID, journalRecord{Kind: journalKindOp, Op: op}); err != nil {
+		fmt.Printf("Failed to persist operation %s for room %s: %v\n", op.ID, roomID, err)
+	}
+	return op
+}
+
+// UpdateOperation persists an in-place change to an operation's item, such as
+// the archive path recorded once a file upload completes.
+func (fs *FileHistoryStore) UpdateOperation(roomID, opID string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err := fs.pool.UpdateOperation(roomID, opID); err != nil {
+		return err
+	}
+	op := fs.pool.findOperation(roomID, opID)
+	return fs.journal.append(roomID, journalRecord{Kind: journalKindUpdate, Op: op})
+}
+
+// RedactItems scrubs the items from memory and rewrites the room log once so
+// the deleted content no longer exists on disk either.
+func (fs *FileHistoryStore) RedactItems(roomID string, itemIDs ...string) int {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	redacted := fs.pool.RedactItems(roomID, itemIDs...)
+	if redacted > 0 {
+		if err := fs.journal.rewrite(roomID, fs.pool.GetOperations(roomID, "", "")); err != nil {
+			fmt.Printf("Failed to persist redaction of %d items in room %s: %v\n", len(itemIDs), roomID, err)
+		}
+	}
+	return redacted
+}
+
+// GetOperations reads from the in-memory view.
+func (fs *FileHistoryStore) GetOperations(roomID, sinceID, sinceHash string) []*Operation {
+	return fs.pool.GetOperations(roomID, sinceID, sinceHash)
+}
+
+// GetOperationRange reads from the in-memory view.
+func (fs *FileHistoryStore) GetOperationRange(roomID, fromRef, toRef string) []*Operation {
+	return fs.pool.GetOperationRange(roomID, fromRef, toRef)
+}
+
+// SetOperationLimit is not logged; the limit is kept with the room's settings.
+func (fs *FileHistoryStore) SetOperationLimit(roomID string, limit int) {
+	fs.pool.SetOperationLimit(roomID, limit)
+}
+
+// Search queries the in-memory index.
+func (fs *FileHistoryStore) Search(roomID string, q SearchQuery) []*SearchResult {
+	return fs.pool.Search(roomID, q)
+}
+
+// VerifyChain checks the in-memory view, which matches the log after replay.
+func (fs *FileHistoryStore) VerifyChain(roomID string) *ChainVerification {
+	return fs.pool.VerifyChain(roomID)
+}
+
+// FindOperation reads from the in-memory view.
+func (fs *FileHistoryStore) FindOperation(opID string) (*Operation, string) {
+	return fs.pool.FindOperation(opID)
+}
+
+// FindItemOperation reads from the in-memory view.
+func (fs *FileHistoryStore) FindItemOperation(roomID, itemID string) *Operation {
+	return fs.pool.FindItemOperation(roomID, itemID)
+}
+
+// GetCurrentItem reads from the in-memory view.
+func (fs *FileHistoryStore) GetCurrentItem(roomID, itemID string) (*Item, *Operation) {
+	return fs.pool.GetCurrentItem(roomID, itemID)
+}
+
+// GetCurrentChatMessages reads from the in-memory view.
+func (fs *FileHistoryStore) GetCurrentChatMessages(roomID string) []*ChatMessage {
+	return fs.pool.GetCurrentChatMessages(roomID)
+}
+
+// GetCurrentClipboardItems reads from the in-memory view.
+func (fs *FileHistoryStore) GetCurrentClipboardItems(roomID string) []*clip_helper.ClipboardItem {
+	return fs.pool.GetCurrentClipboardItems(roomID)
+}
+
+// Close flushes and closes the room logs.
+func (fs *FileHistoryStore) Close() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.journal.close()
+}
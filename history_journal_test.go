@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"GOproject/clip_helper"
+)
+
+// Ensures a journaled pool replays to the same operations, state and hash chain.
+func TestHistoryJournalReplay(t *testing.T) {
+	dir := t.TempDir()
+	room := "room_1"
+
+	hp, err := NewFileHistoryStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileHistoryStore error: %v", err)
+	}
+
+	chat := &ChatMessage{ID: "msg_1", RoomID: room, UserID: "user_1", UserName: "Alice", Message: "hello", Timestamp: 1}
+	hp.AddOperation(room, OpAdd, chat.ID, &Item{ID: chat.ID, Type: ItemChat, Data: chat}, "user_1", "Alice")
+
+	clip := &clip_helper.ClipboardItem{Type: clip_helper.ClipboardFile, Files: []string{"/tmp/a.txt"}, Text: "1 files selected"}
+	fileOp := hp.AddOperation(room, OpAdd, "clip_1", &Item{ID: "clip_1", Type: ItemClipboard, Data: clip}, "user_1", "Alice")
+
+	clip.SingleFilePath = filepath.Join(dir, "clip_1_a.txt")
+	clip.IsSingleFile = true
+	clip.Text = "a.txt (1 B) ready"
+	if err := hp.UpdateOperation(room, fileOp.ID); err != nil {
+		t.Fatalf("UpdateOperation error: %v", err)
+	}
+
+	removed := &ChatMessage{ID: "msg_2", RoomID: room, Message: "oops", Timestamp: 2}
+	hp.AddOperation(room, OpAdd, removed.ID, &Item{ID: removed.ID, Type: ItemChat, Data: removed}, "user_1", "Alice")
+	hp.AddOperation(room, OpRemove, removed.ID, &Item{ID: removed.ID, Type: ItemChat}, "user_1", "Alice")
+
+	before := hp.GetOperations(room, "", "")
+	if err := hp.Close(); err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+
+	restored, err := NewFileHistoryStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileHistoryStore replay error: %v", err)
+	}
+	defer restored.Close()
+
+	after := restored.GetOperations(room, "", "")
+	if len(after) != len(before) {
+		t.Fatalf("expected %d replayed ops, got %d", len(before), len(after))
+	}
+	for i := range before {
+		if before[i].ID != after[i].ID || before[i].Hash != after[i].Hash || before[i].ParentHash != after[i].ParentHash {
+			t.Fatalf("op %d mismatch after replay: %+v vs %+v", i, before[i], after[i])
+		}
+		expected := computeOperationHash(after[i].ParentHash, after[i].OpType, after[i].ItemID, after[i].Item, after[i].UserID, after[i].UserName, after[i].Timestamp)
+		if expected != after[i].Hash {
+			t.Fatalf("hash chain broken at %s after replay", after[i].ID)
+		}
+	}
+
+	msgs := restored.GetCurrentChatMessages(room)
+	if len(msgs) != 1 || msgs[0].Message != "hello" {
+		t.Fatalf("expected single replayed chat message, got %#v", msgs)
+	}
+
+	items := restored.GetCurrentClipboardItems(room)
+	if len(items) != 1 || items[0].SingleFilePath != clip.SingleFilePath || items[0].Text != clip.Text {
+		t.Fatalf("expected clipboard item with restored file path, got %#v", items)
+	}
+
+	next := restored.AddOperation(room, OpAdd, "msg_3", &Item{ID: "msg_3", Type: ItemChat, Data: &ChatMessage{ID: "msg_3", Message: "again"}}, "", "")
+	if next.ID != "op_5" || next.ParentHash != before[len(before)-1].Hash {
+		t.Fatalf("expected counter and chain to resume after replay, got %+v", next)
+	}
+}
+
+// Ensures a compaction rewrites the room log so replay yields the same snapshot + tail.
+func TestFileHistoryStoreCompactionReplay(t *testing.T) {
+	dir := t.TempDir()
+	room := "room-compact"
+
+	store, err := NewFileHistoryStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileHistoryStore error: %v", err)
+	}
+	file := &clip_helper.ClipboardItem{Type: clip_helper.ClipboardFile, Files: []string{"a.txt"}, IsSingleFile: true, SingleFilePath: filepath.Join(dir, "a.txt")}
+	fileOp := store.AddOperation(room, OpAdd, "clip_1", &Item{ID: "clip_1", Type: ItemClipboard, Data: file}, "user_1", "Alice")
+	for i := 0; i <= maxOperationsPerRoom; i++ {
+		id := fmt.Sprintf("msg_%d", i)
+		store.AddOperation(room, OpAdd, id, &Item{ID: id, Type: ItemChat, Data: &ChatMessage{ID: id, Message: id, Timestamp: int64(i)}}, "", "")
+	}
+	before := store.GetOperations(room, "", "")
+	store.Close()
+
+	replayed, err := NewFileHistoryStore(dir)
+	if err != nil {
+		t.Fatalf("replay error: %v", err)
+	}
+	defer replayed.Close()
+
+	after := replayed.GetOperations(room, "", "")
+	if len(after) != len(before) || after[0].OpType != OpSnapshot || after[0].Hash != before[0].Hash {
+		t.Fatalf("expected replayed snapshot + tail of %d ops, got %d", len(before), len(after))
+	}
+	items := replayed.GetCurrentClipboardItems(room)
+	if len(items) != 1 || items[0].SingleFilePath != file.SingleFilePath {
+		t.Fatalf("expected compacted file item to keep its host path, got %#v", items)
+	}
+	if snap := snapshotData(after[0]); snap.entryForOp(fileOp.ID) == nil {
+		t.Fatalf("expected snapshot to remember the originating operation %s", fileOp.ID)
+	}
+	if report := replayed.VerifyChain(room); !report.Valid {
+		t.Fatalf("expected replayed chain to verify, got %+v", report)
+	}
+}
+
+// Ensures rooms and counters survive a host restart when persistence is enabled,
+// and that a restored room is not cleaned up while it has no members.
+func TestAppPersistenceRestoresRooms(t *testing.T) {
+	dir := t.TempDir()
+
+	app := newTestApp()
+	app.dataDir = dir
+	if err := app.enablePersistence(); err != nil {
+		t.Fatalf("enablePersistence error: %v", err)
+	}
+
+	owner := app.CreateUser("Owner")
+	room := app.CreateRoom("Persisted", owner.ID)
+	app.historyStore.AddOperation(room.ID, OpAdd, "msg_1", &Item{ID: "msg_1", Type: ItemChat, Data: &ChatMessage{ID: "msg_1", RoomID: room.ID, Message: "kept"}}, owner.ID, owner.Name)
+	app.historyStore.Close()
+
+	restarted := newTestApp()
+	restarted.dataDir = dir
+	if err := restarted.enablePersistence(); err != nil {
+		t.Fatalf("enablePersistence after restart error: %v", err)
+	}
+	defer restarted.historyStore.Close()
+
+	if len(restarted.GetOperations(room.ID, "", "")) != 1 {
+		t.Fatalf("expected room history to be restored")
+	}
+	if msgs := restarted.GetChatHistory(room.ID); len(msgs) != 1 || msgs[0].Message != "kept" {
+		t.Fatalf("expected restored chat history, got %#v", msgs)
+	}
+
+	next := restarted.CreateUser("Newcomer")
+	if next.ID == owner.ID {
+		t.Fatalf("user IDs must not be reused after restart")
+	}
+	again := restarted.CreateRoom("Another", next.ID)
+	if again.ID == room.ID {
+		t.Fatalf("room IDs must not be reused after restart")
+	}
+
+	restarted.cleanupEmptyRooms()
+	rooms := restarted.GetAllRooms()
+	if len(rooms) != 1 || rooms[0].ID != room.ID {
+		t.Fatalf("expected only the empty room with persisted history to be kept, got %+v", rooms)
+	}
+}
+
+// Ensures redacting a deleted item rewrites the room log so the content is gone from disk.
+func TestFileHistoryStoreRedactRewritesLog(t *testing.T) {
+	dir := t.TempDir()
+	room := "room-redact"
+
+	store, err := NewFileHistoryStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileHistoryStore error: %v", err)
+	}
+	store.AddOperation(room, OpAdd, "m1", &Item{ID: "m1", Type: ItemChat, Data: &ChatMessage{ID: "m1", Message: "top-secret"}}, "u1", "")
+	store.AddOperation(room, OpRemove, "m1", &Item{ID: "m1", Type: ItemChat}, "u1", "")
+	if n := store.RedactItems(room, "m1"); n != 1 {
+		t.Fatalf("expected one redacted op, got %d", n)
+	}
+	store.Close()
+
+	raw, err := os.ReadFile(filepath.Join(dir, journalFileName(room)))
+	if err != nil {
+		t.Fatalf("read log: %v", err)
+	}
+	if strings.Contains(string(raw), "top-secret") {
+		t.Fatalf("redacted content still present in room log")
+	}
+
+	replayed, err := NewFileHistoryStore(dir)
+	if err != nil {
+		t.Fatalf("replay error: %v", err)
+	}
+	defer replayed.Close()
+	if ops := replayed.GetOperations(room, "", ""); len(ops) != 2 || !ops[0].Redacted {
+		t.Fatalf("expected redaction to survive replay, got %+v", ops)
+	}
+	if report := replayed.VerifyChain(room); !report.Valid {
+		t.Fatalf("expected redacted chain to verify, got %+v", report)
+	}
+}
+
+// Ensures a room allowed more operations than the default keeps them all across
+// a restart, instead of being compacted while its log is replayed.
+func TestAppPersistenceKeepsRoomOperationLimit(t *testing.T) {
+	dir := t.TempDir()
+
+	app := newTestApp()
+	app.dataDir = dir
+	if err := app.enablePersistence(); err != nil {
+		t.Fatalf("enablePersistence error: %v", err)
+	}
+	owner := app.CreateUser("Owner")
+	room := app.CreateRoom("Chatty", owner.ID)
+	limit := maxOperationsPerRoom + 500
+	if _, err := app.UpdateRoomSettings(room.ID, owner.ID, RoomRetention{MaxOperations: limit}); err != nil {
+		t.Fatalf("UpdateRoomSettings error: %v", err)
+	}
+	total := maxOperationsPerRoom + 200
+	for i := 0; i < total; i++ {
+		id := fmt.Sprintf("msg_%d", i)
+		app.historyStore.AddOperation(room.ID, OpAdd, id, &Item{ID: id, Type: ItemChat, Data: &ChatMessage{ID: id, RoomID: room.ID, Message: id}}, owner.ID, owner.Name)
+	}
+	if ops := app.GetOperations(room.ID, "", ""); len(ops) != total {
+		t.Fatalf("expected %d operations before the restart, got %d", total, len(ops))
+	}
+	app.historyStore.Close()
+
+	restarted := newTestApp()
+	restarted.dataDir = dir
+	if err := restarted.enablePersistence(); err != nil {
+		t.Fatalf("enablePersistence after restart error: %v", err)
+	}
+	defer restarted.historyStore.Close()
+
+	if ops := restarted.GetOperations(room.ID, "", ""); len(ops) != total {
+		t.Fatalf("expected all %d operations to survive the restart, got %d", total, len(ops))
+	}
+	restarted.historyStore.AddOperation(room.ID, OpAdd, "after", &Item{ID: "after", Type: ItemChat, Data: &ChatMessage{ID: "after", RoomID: room.ID, Message: "after"}}, owner.ID, owner.Name)
+	if ops := restarted.GetOperations(room.ID, "", ""); len(ops) != total+1 {
+		t.Fatalf("expected the restored limit to keep applying, got %d operations", len(ops))
+	}
+}
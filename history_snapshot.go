@@ -0,0 +1,270 @@
+package main
+
+import (
+	"sort"
+
+	"GOproject/clip_helper"
+)
+
+// compactionTail is how many operations survive a compaction alongside the
+// snapshot; compacting in batches avoids rebuilding a snapshot on every add.
+func compactionTail(limit int) int {
+	return limit - limit/10 - 1
+}
+
+// compactLocked folds everything before the newest tail operations into a single
+// snapshot operation. Callers must hold hp.mu.
+func (hp *HistoryPool) compactLocked(roomID string, tail int) int {
+	ops := hp.operations[roomID]
+	cut := len(ops) - tail
+	if tail < 0 || cut <= 1 {
+		return 0
+	}
+
+	last := ops[cut-1]
+	snapshot := &Operation{
+		ID:        last.ID,
+		Hash:      last.Hash,
+		OpType:    OpSnapshot,
+		ItemID:    "snapshot_" + last.ID,
+		Timestamp: last.Timestamp,
+	}
+	snapshot.Item = &Item{ID: snapshot.ItemID, Type: ItemSnapshot, Data: buildHistorySnapshot(ops[:cut])}
+
+	compacted := make([]*Operation, 0, tail+1)
+	compacted = append(compacted, snapshot)
+	compacted = append(compacted, ops[cut:]...)
+	hp.operations[roomID] = compacted
+	hp.refs.rebuild(roomID, compacted)
+	return cut
+}
+
+// buildHistorySnapshot materialises the live chat and clipboard items produced by ops.
+// Chat is capped to the newest maxChatMessagesPerRoom messages, matching GetCurrentChatMessages.
+func buildHistorySnapshot(ops []*Operation) *HistorySnapshot {
+	snap := materializeHistory(ops)
+	if len(snap.Chat) > maxChatMessagesPerRoom {
+		snap.Chat = snap.Chat[len(snap.Chat)-maxChatMessagesPerRoom:]
+	}
+	return snap
+}
+
+// materializeHistory replays ops into the full set of live items, chat ordered by timestamp.
+func materializeHistory(ops []*Operation) *HistorySnapshot {
+	chat := newSnapshotBuilder()
+	clipboard := newSnapshotBuilder()
+
+	for _, op := range ops {
+		if op.OpType == OpSnapshot {
+			if snap := snapshotData(op); snap != nil {
+				chat.seed(snap.Chat)
+				clipboard.seed(snap.Clipboard)
+			}
+			continue
+		}
+		if op.Item == nil {
+			continue
+		}
+
+		target := chat
+		if op.Item.Type == ItemClipboard {
+			target = clipboard
+		} else if op.Item.Type != ItemChat {
+			continue
+		}
+
+		switch op.OpType {
+		case OpAdd:
+			target.put(&SnapshotEntry{
+				OpID:      op.ID,
+				ItemID:    op.ItemID,
+				UserID:    op.UserID,
+				UserName:  op.UserName,
+				Timestamp: op.Timestamp,
+				Item:      op.Item,
+			})
+		case OpModify:
+			target.modify(op.ItemID, op.Item)
+		case OpRemove:
+			target.remove(op.ItemID)
+		}
+	}
+
+	snap := &HistorySnapshot{Chat: chat.entries(), Clipboard: clipboard.entries()}
+	sort.SliceStable(snap.Chat, func(i, j int) bool {
+		return chatEntryTimestamp(snap.Chat[i]) < chatEntryTimestamp(snap.Chat[j])
+	})
+	return snap
+}
+
+// snapshotBuilder tracks live items in first-added order.
+type snapshotBuilder struct {
+	byID  map[string]*SnapshotEntry
+	order []string
+}
+
+func newSnapshotBuilder() *snapshotBuilder {
+	return &snapshotBuilder{byID: make(map[string]*SnapshotEntry)}
+}
+
+func (b *snapshotBuilder) seed(entries []*SnapshotEntry) {
+	for _, e := range entries {
+		b.put(e)
+	}
+}
+
+func (b *snapshotBuilder) put(e *SnapshotEntry) {
+	if _, exists := b.byID[e.ItemID]; !exists {
+		b.order = append(b.order, e.ItemID)
+	}
+	b.byID[e.ItemID] = e
+}
+
+// modify swaps in the edited item but keeps the originating add operation's
+// metadata, so the entry still resolves by its original operation ID.
+func (b *snapshotBuilder) modify(itemID string, item *Item) {
+	if e, ok := b.byID[itemID]; ok {
+		edited := *e
+		edited.Item = item
+		b.byID[itemID] = &edited
+	}
+}
+
+func (b *snapshotBuilder) remove(itemID string) {
+	delete(b.byID, itemID)
+}
+
+func (b *snapshotBuilder) entries() []*SnapshotEntry {
+	result := make([]*SnapshotEntry, 0, len(b.byID))
+	for _, id := range b.order {
+		if e, ok := b.byID[id]; ok {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+func chatEntryTimestamp(e *SnapshotEntry) int64 {
+	if e.Item != nil {
+		if msg, ok := e.Item.Data.(*ChatMessage); ok {
+			return msg.Timestamp
+		}
+	}
+	return e.Timestamp
+}
+
+func snapshotData(op *Operation) *HistorySnapshot {
+	if op == nil || op.Item == nil {
+		return nil
+	}
+	snap, _ := op.Item.Data.(*HistorySnapshot)
+	return snap
+}
+
+// snapshotChatMessages and snapshotClipboardItems seed materialised state from a snapshot operation.
+func snapshotChatMessages(op *Operation, into map[string]*ChatMessage) {
+	if snap := snapshotData(op); snap != nil {
+		for _, e := range snap.Chat {
+			if msg, ok := e.Item.Data.(*ChatMessage); ok {
+				into[e.ItemID] = msg
+			}
+		}
+	}
+}
+
+func snapshotClipboardItems(op *Operation, into map[string]*clip_helper.ClipboardItem) {
+	if snap := snapshotData(op); snap != nil {
+		for _, e := range snap.Clipboard {
+			if item, ok := e.Item.Data.(*clip_helper.ClipboardItem); ok {
+				into[e.ItemID] = item
+			}
+		}
+	}
+}
+
+// entryForOp returns the captured item originally added by opID, if any.
+func (s *HistorySnapshot) entryForOp(opID string) *SnapshotEntry {
+	if s == nil {
+		return nil
+	}
+	for _, entries := range [][]*SnapshotEntry{s.Clipboard, s.Chat} {
+		for _, e := range entries {
+			if e.OpID == opID {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+// entryForItem returns the captured state of itemID, if it was live at the snapshot.
+func (s *HistorySnapshot) entryForItem(itemID string) *SnapshotEntry {
+	if s == nil {
+		return nil
+	}
+	for _, entries := range [][]*SnapshotEntry{s.Clipboard, s.Chat} {
+		for _, e := range entries {
+			if e.ItemID == itemID {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+// dropItem removes itemID from the snapshot and reports whether it was present.
+func (s *HistorySnapshot) dropItem(itemID string) bool {
+	dropped := false
+	filter := func(entries []*SnapshotEntry) []*SnapshotEntry {
+		kept := entries[:0]
+		for _, e := range entries {
+			if e.ItemID == itemID {
+				dropped = true
+				continue
+			}
+			kept = append(kept, e)
+		}
+		return kept
+	}
+	s.Chat = filter(s.Chat)
+	s.Clipboard = filter(s.Clipboard)
+	return dropped
+}
+
+// operation rebuilds the original add operation for an item folded into a snapshot.
+func (e *SnapshotEntry) operation() *Operation {
+	return &Operation{
+		ID:        e.OpID,
+		OpType:    OpAdd,
+		ItemID:    e.ItemID,
+		Item:      e.Item,
+		Timestamp: e.Timestamp,
+		UserID:    e.UserID,
+		UserName:  e.UserName,
+	}
+}
+
+// findOperationByID locates an operation in any room, including add operations
+// that have been folded into a snapshot, and reports the room it belongs to.
+func (a *App) findOperationByID(opID string) (*Operation, string) {
+	op, roomID := a.historyStore.FindOperation(opID)
+	if op == nil {
+		return nil, ""
+	}
+	a.mu.RLock()
+	_, exists := a.rooms[roomID]
+	a.mu.RUnlock()
+	if !exists {
+		return nil, ""
+	}
+	return op, roomID
+}
+
+// behindSnapshot reports whether sinceID names an operation folded into the room's snapshot.
+func behindSnapshot(ops []*Operation, sinceID string) bool {
+	if len(ops) == 0 || ops[0].OpType != OpSnapshot {
+		return false
+	}
+	seq := operationSequence(sinceID)
+	return seq > 0 && seq < operationSequence(ops[0].ID)
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"
+
+	"GOproject/clip_helper"
+)
+
+// HistoryStore is the storage boundary for room operation history. HistoryPool
+// is the in-memory implementation; FileHistoryStore adds an on-disk log.
+type HistoryStore interface {
+	// AddOperation appends a new operation to the room's hash chain.
+	AddOperation(roomID string, opType OperationType, itemID string, item *Item, userID, userName string) *Operation
+	// GetOperations returns operations after sinceID or sinceHash (hash preferred).
+	GetOperations(roomID, sinceID, sinceHash string) []*Operation
+	// GetOperationRange returns the inclusive span between two operation IDs or hashes.
+	// An empty bound means the start or end of the retained history.
+	GetOperationRange(roomID, fromRef, toRef string) []*Operation
+	// UpdateOperation records that an operation's item was changed in place.
+	UpdateOperation(roomID, opID string) error
+	// SetOperationLimit sets the operation count above which the room is compacted;
+	// zero restores the default. It takes effect on the next AddOperation.
+	SetOperationLimit(roomID string, limit int)
+	// RedactItems scrubs the payload of every earlier operation for the deleted
+	// items and reports how many operations were changed.
+	RedactItems(roomID string, itemIDs ...string) int
+	// Search finds live items matching a full-text query.
+	Search(roomID string, q SearchQuery) []*SearchResult
+	// VerifyChain recomputes the room's hash chain and reports the first broken link.
+	VerifyChain(roomID string) *ChainVerification
+	// FindOperation looks an operation up by ID in any room, including add
+	// operations folded into a snapshot, and reports the room it belongs to.
+	FindOperation(opID string) (*Operation, string)
+	// FindItemOperation returns the operation that added a live item.
+	FindItemOperation(roomID, itemID string) *Operation
+	// GetCurrentItem returns an item's latest version and the operation that first added it,
+	// or nils if the item does not exist or has been removed.
+	GetCurrentItem(roomID, itemID string) (*Item, *Operation)
+	// GetCurrentChatMessages materialises the room's chat state.
+	GetCurrentChatMessages(roomID string) []*ChatMessage
+	// GetCurrentClipboardItems materialises the room's shared clipboard state.
+	GetCurrentClipboardItems(roomID string) []*clip_helper.ClipboardItem
+	// Close releases any resources held by the store.
+	Close() error
+}
+
+var (
+	_ HistoryStore = (*HistoryPool)(nil)
+	_ HistoryStore = (*FileHistoryStore)(nil)
+)
+
+// GetOperationRange returns the inclusive slice of operations between fromRef and
+// toRef, each matched against operation IDs or hashes. Unknown refs yield no results.
+func (hp *HistoryPool) GetOperationRange(roomID, fromRef, toRef string) []*Operation {
+	hp.mu.RLock()
+	defer hp.mu.RUnlock()
+
+	ops := hp.operations[roomID]
+	start, end := 0, len(ops)-1
+	if fromRef != "" {
+		start = hp.positionLocked(roomID, fromRef)
+	}
+	if toRef != "" {
+		end = hp.positionLocked(roomID, toRef)
+	}
+	if start < 0 || end < 0 || start > end {
+		return []*Operation{}
+	}
+
+	result := make([]*Operation, end-start+1)
+	copy(result, ops[start:end+1])
+	return result
+}
+
+// GetCurrentItem replays the room's operations for a single item, applying edits in order.
+func (hp *HistoryPool) GetCurrentItem(roomID, itemID string) (*Item, *Operation) {
+	hp.mu.RLock()
+	defer hp.mu.RUnlock()
+
+	var item *Item
+	var origin *Operation
+	for _, op := range hp.operations[roomID] {
+		if op.OpType == OpSnapshot {
+			if e := snapshotData(op).entryForItem(itemID); e != nil {
+				item, origin = e.Item, e.operation()
+			}
+			continue
+		}
+		if op.ItemID != itemID {
+			continue
+		}
+		switch op.OpType {
+		case OpAdd:
+			item, origin = op.Item, op
+		case OpModify:
+			if item != nil {
+				item = op.Item
+			}
+		case OpRemove:
+			item, origin = nil, nil
+		}
+	}
+	return item, origin
+}
+
+// UpdateOperation refreshes the search index for an item that was mutated in place;
+// the item itself is already up to date in memory.
+func (hp *HistoryPool) UpdateOperation(roomID, opID string) error {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+
+	ops := hp.operations[roomID]
+	idx := hp.positionLocked(roomID, opID)
+	if idx < 0 {
+		return fmt.Errorf("operation %s not found in room %s", opID, roomID)
+	}
+	hp.index.reindex(roomID, ops[idx])
+	return nil
+}
+
+// SetOperationLimit overrides maxOperationsPerRoom for one room.
+func (hp *HistoryPool) SetOperationLimit(roomID string, limit int) {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+
+	if limit <= 0 {
+		delete(hp.limits, roomID)
+		return
+	}
+	hp.limits[roomID] = limit
+}
+
+func (hp *HistoryPool) operationLimitLocked(roomID string) int {
+	if limit, ok := hp.limits[roomID]; ok {
+		return limit
+	}
+	return maxOperationsPerRoom
+}
+
+// Search queries the room's full-text index.
+func (hp *HistoryPool) Search(roomID string, q SearchQuery) []*SearchResult {
+	hp.mu.RLock()
+	defer hp.mu.RUnlock()
+	return hp.index.search(roomID, q)
+}
+
+// Trim keeps only the newest keep operations for a room.
+func (hp *HistoryPool) Trim(roomID string, keep int) int {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+	return hp.trimLocked(roomID, keep)
+}
+
+// RedactItems removes the stored content of items from their add and modify
+// operations (and any snapshot holding them) so deleted data is not served again.
+func (hp *HistoryPool) RedactItems(roomID string, itemIDs ...string) int {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+
+	deleted := make(map[string]bool, len(itemIDs))
+	for _, id := range itemIDs {
+		deleted[id] = true
+	}
+	redacted := 0
+	for _, op := range hp.operations[roomID] {
+		if op.OpType == OpSnapshot {
+			if snap := snapshotData(op); snap != nil {
+				for _, id := range itemIDs {
+					if snap.dropItem(id) {
+						redacted++
+					}
+				}
+			}
+			continue
+		}
+		if !deleted[op.ItemID] || op.Item == nil || op.Item.Data == nil || op.Redacted {
+			continue
+		}
+		op.Item = &Item{ID: op.Item.ID, Type: op.Item.Type}
+		op.Redacted = true
+		redacted++
+	}
+	return redacted
+}
+
+// Close is a no-op for in-memory history.
+func (hp *HistoryPool) Close() error {
+	return nil
+}
+
+func (hp *HistoryPool) trimLocked(roomID string, keep int) int {
+	ops := hp.operations[roomID]
+	if keep < 0 || len(ops) <= keep {
+		return 0
+	}
+
+	removed := len(ops) - keep
+	hp.operations[roomID] = ops[removed:]
+	hp.refs.rebuild(roomID, hp.operations[roomID])
+	hp.index.rebuild(roomID, hp.operations[roomID])
+	return removed
+}
+
+func (hp *HistoryPool) findOperation(roomID, opID string) *Operation {
+	hp.mu.RLock()
+	defer hp.mu.RUnlock()
+
+	ops := hp.operations[roomID]
+	if idx := hp.positionLocked(roomID, opID); idx >= 0 {
+		return ops[idx]
+	}
+	return nil
+}
+
+// Reasons reported by VerifyChain when a link fails.
+const (
+	chainBrokenHashMismatch    = "hash_mismatch"    // stored hash does not match the recomputed one
+	chainBrokenParentMismatch  = "parent_mismatch"  // parentHash does not point at the previous operation
+	chainBrokenMissingParent   = "missing_parent"   // a non-initial operation has no parent recorded
+	chainBrokenRedactedPayload = "redacted_payload" // an operation marked redacted still carries its item
+)
+
+// VerifyChain recomputes every retained operation hash and checks each parent link.
+func (hp *HistoryPool) VerifyChain(roomID string) *ChainVerification {
+	hp.mu.RLock()
+	defer hp.mu.RUnlock()
+
+	return verifyOperationChain(roomID, hp.operations[roomID])
+}
+
+// verifyOperationChain walks ops in order and stops at the first broken link.
+// A first operation that references a parent, or a leading snapshot, means older
+// history was trimmed or compacted, which is reported but does not make the chain invalid.
+func verifyOperationChain(roomID string, ops []*Operation) *ChainVerification {
+	report := &ChainVerification{RoomID: roomID, Valid: true}
+
+	for i, op := range ops {
+		if i == 0 {
+			if op.OpType == OpSnapshot {
+				// A snapshot inherits the hash of the last operation it folded in;
+				// its content cannot be recomputed, so it anchors the chain instead.
+				report.TrimmedPrefix = true
+				report.BaseHash = op.Hash
+				report.SnapshotID = op.ID
+				report.HeadHash = op.Hash
+				continue
+			}
+			if op.ParentHash != "" {
+				report.TrimmedPrefix = true
+				report.BaseHash = op.ParentHash
+			}
+		} else {
+			prev := ops[i-1]
+			switch {
+			case op.ParentHash == "":
+				report.markBroken(op, chainBrokenMissingParent, prev.Hash)
+				return report
+			case op.ParentHash != prev.Hash:
+				report.markBroken(op, chainBrokenParentMismatch, prev.Hash)
+				return report
+			}
+		}
+
+		if op.Redacted {
+			// The payload that produced the hash is gone; trust the stored hash
+			// so the link to the next operation can still be checked. A flag on
+			// an op that still carries content would hide an edit, so it breaks.
+			if op.Item != nil && op.Item.Data != nil {
+				report.markBroken(op, chainBrokenRedactedPayload, "")
+				return report
+			}
+			report.Redacted++
+			report.Checked++
+			report.HeadHash = op.Hash
+			continue
+		}
+
+		expected := computeOperationHash(op.ParentHash, op.OpType, op.ItemID, op.Item, op.UserID, op.UserName, op.Timestamp)
+		if expected != op.Hash {
+			report.markBroken(op, chainBrokenHashMismatch, expected)
+			return report
+		}
+
+		report.Checked++
+		report.HeadHash = op.Hash
+	}
+
+	return report
+}
+
+func (cv *ChainVerification) markBroken(op *Operation, reason, expected string) {
+	cv.Valid = false
+	cv.BrokenAt = op.ID
+	cv.Reason = reason
+	cv.ExpectedHash = expected
+}
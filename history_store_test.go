@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+type recordingHistoryStore struct {
+	*HistoryPool
+	added      []string
+	redactions int
+}
+
+func (r *recordingHistoryStore) RedactItems(roomID string, itemIDs ...string) int {
+	r.redactions++
+	return r.HistoryPool.RedactItems(roomID, itemIDs...)
+}
+
+func (r *recordingHistoryStore) AddOperation(roomID string, opType OperationType, itemID string, item *Item, userID, userName string) *Operation {
+	r.added = append(r.added, itemID)
+	return r.HistoryPool.AddOperation(roomID, opType, itemID, item, userID, userName)
+}
+
+func historyStoreImplementations(t *testing.T) map[string]func() HistoryStore {
+	t.Helper()
+	return map[string]func() HistoryStore{
+		"memory": func() HistoryStore { return NewHistoryPool() },
+		"file": func() HistoryStore {
+			store, err := NewFileHistoryStore(t.TempDir())
+			if err != nil {
+				t.Fatalf("NewFileHistoryStore error: %v", err)
+			}
+			t.Cleanup(func() { store.Close() })
+			return store
+		},
+	}
+}
+
+// Exercises range queries against every HistoryStore implementation.
+func TestHistoryStoreRange(t *testing.T) {
+	for name, newStore := range historyStoreImplementations(t) {
+		t.Run(name, func(t *testing.T) {
+			store := newStore()
+			room := "room-store"
+
+			ops := make([]*Operation, 0, 5)
+			for i := 0; i < 5; i++ {
+				id := fmt.Sprintf("msg_%d", i)
+				msg := &ChatMessage{ID: id, RoomID: room, Message: id, Timestamp: int64(i)}
+				ops = append(ops, store.AddOperation(room, OpAdd, id, &Item{ID: id, Type: ItemChat, Data: msg}, "u1", "Alice"))
+			}
+
+			span := store.GetOperationRange(room, ops[1].ID, ops[3].Hash)
+			if len(span) != 3 || span[0].ID != ops[1].ID || span[2].ID != ops[3].ID {
+				t.Fatalf("expected ops 1..3 in range, got %+v", span)
+			}
+			if tail := store.GetOperationRange(room, ops[3].Hash, ""); len(tail) != 2 {
+				t.Fatalf("expected open-ended range to reach the head, got %d ops", len(tail))
+			}
+			if unknown := store.GetOperationRange(room, "missing", ""); len(unknown) != 0 {
+				t.Fatalf("expected unknown ref to yield no ops, got %d", len(unknown))
+			}
+		})
+	}
+}
+
+// Ensures the app routes history writes through whichever HistoryStore it is given.
+func TestAppUsesInjectedHistoryStore(t *testing.T) {
+	app := newTestApp()
+	fake := &recordingHistoryStore{HistoryPool: NewHistoryPool()}
+	app.historyStore = fake
+
+	alice := app.CreateUser("Alice")
+	bob := app.CreateUser("Bob")
+	room := app.CreateRoom("Fake", "host")
+	if _, err := app.JoinRoom(alice.ID, room.ID); err != nil {
+		t.Fatalf("JoinRoom error: %v", err)
+	}
+	if _, err := app.JoinRoom(bob.ID, room.ID); err != nil {
+		t.Fatalf("JoinRoom error: %v", err)
+	}
+
+	app.SendChatMessage(room.ID, alice.ID, "through the fake")
+	if len(fake.added) != 1 {
+		t.Fatalf("expected fake store to record the chat operation, got %v", fake.added)
+	}
+	if msgs := app.GetChatHistory(room.ID); len(msgs) != 1 {
+		t.Fatalf("expected chat history served from fake store, got %d", len(msgs))
+	}
+}
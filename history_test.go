@@ -38,18 +38,79 @@ func TestHistoryPoolEnforceLimits(t *testing.T) {
 	hp := NewHistoryPool()
 	roomID := "room-large"
 
+	pinned := &clip_helper.ClipboardItem{Type: clip_helper.ClipboardText, Text: "pinned"}
+	hp.AddOperation(roomID, OpAdd, "clip_1", &Item{ID: "clip_1", Type: ItemClipboard, Data: pinned}, "user", "User")
+	hp.AddOperation(roomID, OpAdd, "gone", &Item{ID: "gone", Type: ItemChat, Data: &ChatMessage{ID: "gone", Message: "gone"}}, "", "")
+	hp.AddOperation(roomID, OpRemove, "gone", &Item{ID: "gone", Type: ItemChat}, "", "")
+
+	var ops []*Operation
 	for i := 0; i < maxOperationsPerRoom+10; i++ {
 		id := fmt.Sprintf("msg_%d", i)
 		msg := &ChatMessage{ID: id, RoomID: roomID, UserID: "user", UserName: "User", Message: id, Timestamp: int64(i)}
-		hp.AddOperation(roomID, OpAdd, id, &Item{ID: id, Type: ItemChat, Data: msg}, "", "")
+		ops = append(ops, hp.AddOperation(roomID, OpAdd, id, &Item{ID: id, Type: ItemChat, Data: msg}, "", ""))
+	}
+
+	retained := hp.GetOperations(roomID, "", "")
+	if len(retained) > maxOperationsPerRoom {
+		t.Fatalf("expected history compacted below %d ops, got %d", maxOperationsPerRoom, len(retained))
+	}
+
+	snapshot := retained[0]
+	if snapshot.OpType != OpSnapshot {
+		t.Fatalf("expected compacted history to start with a snapshot, got %s", snapshot.OpType)
+	}
+	if retained[1].ParentHash != snapshot.Hash {
+		t.Fatalf("expected tail to link to the snapshot hash")
+	}
+
+	items := hp.GetCurrentClipboardItems(roomID)
+	if len(items) != 1 || items[0].Text != "pinned" {
+		t.Fatalf("expected clipboard item added before the cut to survive compaction, got %#v", items)
+	}
+
+	msgs := hp.GetCurrentChatMessages(roomID)
+	if len(msgs) != maxChatMessagesPerRoom || msgs[len(msgs)-1].ID != ops[len(ops)-1].ItemID {
+		t.Fatalf("expected newest %d chat messages after compaction, got %d", maxChatMessagesPerRoom, len(msgs))
 	}
+	for _, msg := range msgs {
+		if msg.ID == "gone" {
+			t.Fatalf("removed chat message resurfaced after compaction")
+		}
+	}
+
+	// A client behind the horizon gets snapshot + tail, by hash or by ID
+	if stale := hp.GetOperations(roomID, "", ops[0].Hash); len(stale) != len(retained) || stale[0].OpType != OpSnapshot {
+		t.Fatalf("expected snapshot + tail for compacted hash, got %d ops", len(stale))
+	}
+	if stale := hp.GetOperations(roomID, ops[0].ID, ""); len(stale) != len(retained) || stale[0].OpType != OpSnapshot {
+		t.Fatalf("expected snapshot + tail for compacted ID, got %d ops", len(stale))
+	}
+	if atCut := hp.GetOperations(roomID, "", snapshot.Hash); len(atCut) != len(retained)-1 {
+		t.Fatalf("expected only the tail for a client at the cut point, got %d ops", len(atCut))
+	}
+
+	if report := hp.VerifyChain(roomID); !report.Valid || report.SnapshotID != snapshot.ID {
+		t.Fatalf("expected compacted chain to verify from the snapshot, got %+v", report)
+	}
+}
+
+// Ensures edits apply in order and survive being folded into a snapshot.
+func TestHistoryPoolModifyAndSnapshot(t *testing.T) {
+	hp := NewHistoryPool()
+	roomID := "room-modify"
+
+	hp.AddOperation(roomID, OpAdd, "m1", &Item{ID: "m1", Type: ItemChat, Data: &ChatMessage{ID: "m1", Message: "v1"}}, "u1", "")
+	hp.AddOperation(roomID, OpModify, "m1", &Item{ID: "m1", Type: ItemChat, Data: &ChatMessage{ID: "m1", Message: "v2"}}, "u1", "")
+	hp.AddOperation(roomID, OpModify, "m1", &Item{ID: "m1", Type: ItemChat, Data: &ChatMessage{ID: "m1", Message: "v3"}}, "u1", "")
+	hp.AddOperation(roomID, OpModify, "ghost", &Item{ID: "ghost", Type: ItemChat, Data: &ChatMessage{ID: "ghost", Message: "x"}}, "u1", "")
 
-	ops := hp.GetOperations(roomID, "", "")
-	if len(ops) != maxOperationsPerRoom {
-		t.Fatalf("expected history trimmed to %d ops, got %d", maxOperationsPerRoom, len(ops))
+	msgs := hp.GetCurrentChatMessages(roomID)
+	if len(msgs) != 1 || msgs[0].Message != "v3" {
+		t.Fatalf("expected latest edit to win and modifies of unknown items to be ignored, got %#v", msgs)
 	}
 
-	if ops[0].ID == "msg_0" {
-		t.Fatalf("oldest operations should have been trimmed")
+	snap := buildHistorySnapshot(hp.GetOperations(roomID, "", ""))
+	if len(snap.Chat) != 1 || snap.Chat[0].OpID != "op_1" || snap.Chat[0].Item.Data.(*ChatMessage).Message != "v3" {
+		t.Fatalf("expected snapshot to hold the edited item under its original op, got %#v", snap.Chat)
 	}
 }
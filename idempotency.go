@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	idempotencyTTL        = 24 * time.Hour
+	maxIdempotencyEntries = 10000
+	maxIdempotencyKeyLen  = 128
+)
+
+// idempotentResponse is a stored response replayed for a retried request. Until
+// the first request finishes it is pending, and done is closed once it either
+// stores its response or gives the key up.
+type idempotentResponse struct {
+	status  int
+	body    []byte
+	pending bool
+	done    chan struct{}
+	expires time.Time
+}
+
+// idempotencyCache remembers responses by Idempotency-Key so a client retrying
+// after a lost response does not apply the same chat message or upload twice.
+// A key is reserved while its first request runs, so a retry arriving meanwhile
+// waits for that response instead of applying the request again.
+type idempotencyCache struct {
+	entries map[string]*idempotentResponse
+	mu      sync.Mutex
+}
+
+func newIdempotencyCache() *idempotencyCache {
+	return &idempotencyCache{entries: make(map[string]*idempotentResponse)}
+}
+
+// reserve returns the response stored for key, waiting for a request still
+// running with it. When there is none it reserves key for the caller, which must
+// then put a response or release the key, and returns nil.
+func (c *idempotencyCache) reserve(key string) *idempotentResponse {
+	for {
+		c.mu.Lock()
+		entry, ok := c.entries[key]
+		if ok && !entry.pending && time.Now().After(entry.expires) {
+			delete(c.entries, key)
+			ok = false
+		}
+		if !ok {
+			c.makeRoomLocked()
+			c.entries[key] = &idempotentResponse{pending: true, done: make(chan struct{})}
+			c.mu.Unlock()
+			return nil
+		}
+		if !entry.pending {
+			c.mu.Unlock()
+			return entry
+		}
+		c.mu.Unlock()
+
+		// The first request may fail and release the key; try to reserve it again
+		<-entry.done
+	}
+}
+
+// put stores the response for a key reserved by reserve and wakes its waiters.
+func (c *idempotencyCache) put(key string, status int, body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || !entry.pending {
+		return
+	}
+	entry.status = status
+	entry.body = body
+	entry.pending = false
+	entry.expires = time.Now().Add(idempotencyTTL)
+	close(entry.done)
+}
+
+// release gives up a reservation whose request stored no response, so a retry
+// applies the request itself. It does nothing once a response was stored.
+func (c *idempotencyCache) release(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.entries[key]; ok && entry.pending {
+		delete(c.entries, key)
+		close(entry.done)
+	}
+}
+
+func (c *idempotencyCache) makeRoomLocked() {
+	if len(c.entries) < maxIdempotencyEntries {
+		return
+	}
+	now := time.Now()
+	for k, entry := range c.entries {
+		if !entry.pending && now.After(entry.expires) {
+			delete(c.entries, k)
+		}
+	}
+	// Still full of live keys: forget an arbitrary stored one rather than grow unbounded
+	for k, entry := range c.entries {
+		if len(c.entries) < maxIdempotencyEntries {
+			break
+		}
+		if !entry.pending {
+			delete(c.entries, k)
+		}
+	}
+}
+
+// idempotencyKey returns the request's Idempotency-Key scoped to an endpoint and
+// user, or "" when the request did not send one.
+func idempotencyKey(r *http.Request, scope, userID string) string {
+	key := strings.TrimSpace(r.Header.Get(idempotencyKeyHeader))
+	if key == "" || len(key) > maxIdempotencyKeyLen {
+		return ""
+	}
+	return scope + "|" + userID + "|" + key
+}
+
+// replayIdempotent writes the stored response for key, waiting for one still
+// being produced. When it returns false the caller holds the key and must answer
+// through respondIdempotent; a deferred releaseIdempotent frees the key on any
+// other return.
+func (a *App) replayIdempotent(w http.ResponseWriter, key string) bool {
+	if key == "" {
+		return false
+	}
+	stored := a.idempotency.reserve(key)
+	if stored == nil {
+		return false
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(stored.status)
+	w.Write(stored.body)
+	return true
+}
+
+// releaseIdempotent frees key if the request holding it stored no response.
+func (a *App) releaseIdempotent(key string) {
+	if key != "" {
+		a.idempotency.release(key)
+	}
+}
+
+// respondIdempotent encodes v as the JSON response with status and stores both
+// under key.
+func (a *App) respondIdempotent(w http.ResponseWriter, key string, status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	body = append(body, '\n')
+	if key != "" {
+		a.idempotency.put(key, status, body)
+	}
+	w.WriteHeader(status)
+	w.Write(body)
+}
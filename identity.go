@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// A device key is a random secret the client generates once and keeps, sent
+	// base64url-encoded; 22 characters carry 128 bits.
+	minDeviceKeyLen = 22
+	maxDeviceKeyLen = 128
+)
+
+var errInvalidDeviceKey = errors.New("device key must be 22 to 128 characters")
+
+// deviceKeyHash is what the host keeps of a device key, so its user lookup table
+// never holds a usable credential.
+func deviceKeyHash(deviceKey string) string {
+	sum := sha256.Sum256([]byte(deviceKey))
+	return hex.EncodeToString(sum[:])
+}
+
+func validDeviceKey(deviceKey string) bool {
+	return len(deviceKey) >= minDeviceKeyLen && len(deviceKey) <= maxDeviceKeyLen
+}
+
+// BindDeviceKey lets deviceKey restore userID's identity later through RestoreUser.
+func (a *App) BindDeviceKey(userID, deviceKey string) error {
+	if !validDeviceKey(deviceKey) {
+		return errInvalidDeviceKey
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, exists := a.users[userID]; !exists {
+		return fmt.Errorf("user not found")
+	}
+	a.deviceKeys[deviceKeyHash(deviceKey)] = userID
+	a.persistStateLocked()
+	return nil
+}
+
+// RestoreUser returns the user deviceKey was bound to, back online with the same
+// ID, name and room membership, if that user has not expired yet. It returns nil
+// when the key is unknown.
+func (a *App) RestoreUser(deviceKey string) *User {
+	if !validDeviceKey(deviceKey) {
+		return nil
+	}
+
+	a.mu.Lock()
+	userID, bound := a.deviceKeys[deviceKeyHash(deviceKey)]
+	user, exists := a.users[userID]
+	a.mu.Unlock()
+	if !bound || !exists {
+		return nil
+	}
+
+	a.markUserOnline(userID)
+	fmt.Printf("Restored user %s (%s) from its device key\n", user.Name, userID)
+	return user
+}
+
+// markUserOnline brings back a user that went offline, telling everyone.
+func (a *App) markUserOnline(userID string) {
+	a.mu.Lock()
+	user, exists := a.users[userID]
+	if !exists || user.IsOnline {
+		a.mu.Unlock()
+		return
+	}
+	user.IsOnline = true
+	user.OfflineSince = 0
+	snapshot := *user
+	a.persistStateLocked()
+	a.mu.Unlock()
+
+	a.sseManager.BroadcastToAll(EventUserOnline, &snapshot)
+}
+
+// markUserOffline keeps a user whose event stream did not come back, with its
+// rooms and sessions, for userTimeout before removing it.
+func (a *App) markUserOffline(userID string) {
+	a.mu.Lock()
+	user, exists := a.users[userID]
+	if !exists || !user.IsOnline {
+		a.mu.Unlock()
+		return
+	}
+	user.IsOnline = false
+	user.OfflineSince = time.Now().Unix()
+	a.persistStateLocked()
+	a.mu.Unlock()
+
+	fmt.Printf("User %s went offline; keeping it for %v\n", userID, userTimeout)
+	a.sseManager.BroadcastToAll(EventUserOffline, map[string]string{"userId": userID})
+	time.AfterFunc(userTimeout, func() { a.expireOfflineUser(userID) })
+}
+
+// expireOfflineUser removes a user that has stayed offline for userTimeout.
+func (a *App) expireOfflineUser(userID string) {
+	a.mu.RLock()
+	user, exists := a.users[userID]
+	expired := exists && !user.IsOnline && user.OfflineSince != 0 &&
+		time.Since(time.Unix(user.OfflineSince, 0)) >= userTimeout
+	a.mu.RUnlock()
+	if !expired || a.sseManager.IsConnected(userID) {
+		return
+	}
+	a.removeUser(userID)
+}
+
+// removeUser takes a user out of its room and deletes it along with its
+// sessions, device keys and event replay history.
+func (a *App) removeUser(userID string) {
+	a.mu.Lock()
+	user, exists := a.users[userID]
+	a.mu.Unlock()
+
+	if exists {
+		if user.RoomID != nil {
+			a.LeaveRoom(userID)
+		}
+
+		a.mu.Lock()
+		delete(a.users, userID)
+		for hash, boundID := range a.deviceKeys {
+			if boundID == userID {
+				delete(a.deviceKeys, hash)
+			}
+		}
+		a.persistStateLocked()
+		a.mu.Unlock()
+		a.sessions.revokeUser(userID)
+
+		fmt.Printf("Removed user %s\n", userID)
+		a.sseManager.BroadcastToAll(EventUserRemoved, map[string]string{"userId": userID})
+	}
+	a.sseManager.ForgetUser(userID)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testDeviceKey = "dGVzdC1kZXZpY2Uta2V5LWZvci1ib2I"
+
+func postUser(app *App, body string) (*httptest.ResponseRecorder, CreateUserResponse) {
+	req := httptest.NewRequest(http.MethodPost, "/api/users", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	app.handleUsers(rr, req)
+	var resp CreateUserResponse
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	return rr, resp
+}
+
+func admitWithJoinCode(t *testing.T, app *App, owner *User, room *Room, userID string) {
+	t.Helper()
+	code, err := app.CreateJoinCode(room.ID, owner.ID, 0, 0)
+	if err != nil {
+		t.Fatalf("CreateJoinCode error: %v", err)
+	}
+	if rr := redeemJoinCode(app, userID, code.Code); rr.Code != http.StatusOK {
+		t.Fatalf("expected the join code to admit %s, got %d", userID, rr.Code)
+	}
+}
+
+func userExists(app *App, userID string) bool {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+	_, exists := app.users[userID]
+	return exists
+}
+
+// Verifies that a dropped stream leaves the user and its room in place and that
+// its device key brings back the same identity.
+func TestDeviceKeyRestoresUser(t *testing.T) {
+	app, room, members := newTestRoom(t, "Private", "Owner")
+	owner := members[0]
+	rr, created := postUser(app, `{"name":"Bob","deviceKey":"`+testDeviceKey+`"}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected Bob to be created, got %d: %s", rr.Code, rr.Body.String())
+	}
+	bob := created.User
+	admitWithJoinCode(t, app, owner, room, bob.ID)
+	ownerConn := attachClient(app, owner.ID)
+
+	app.cleanupDisconnectedUser(bob.ID)
+	app.mu.RLock()
+	stored, exists := app.users[bob.ID]
+	offline := exists && !stored.IsOnline && stored.OfflineSince != 0
+	app.mu.RUnlock()
+	if !offline || stored.RoomID == nil || *stored.RoomID != room.ID {
+		t.Fatalf("expected Bob to be kept offline in his room, got %+v", stored)
+	}
+	if _, ok := findEvent(ownerConn.Events(), EventUserOffline); !ok {
+		t.Fatalf("expected the owner to see Bob go offline")
+	}
+	ownerConn.Reset()
+
+	if rr, _ := postUser(app, `{"name":"Bob","deviceKey":"short"}`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected a short device key to be refused, got %d", rr.Code)
+	}
+	if rr, _ := postUser(app, `{"name":"Bob"}`); rr.Code != http.StatusConflict {
+		t.Fatalf("expected an offline user to keep its name, got %d", rr.Code)
+	}
+
+	rr, restored := postUser(app, `{"name":"Someone else","deviceKey":"`+testDeviceKey+`"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected Bob to be restored, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if restored.User.ID != bob.ID || restored.User.Name != "Bob" || restored.User.RoomID == nil || *restored.User.RoomID != room.ID {
+		t.Fatalf("expected the same identity and room, got %+v", restored.User)
+	}
+	if !restored.User.IsOnline || restored.User.OfflineSince != 0 || restored.Token == "" {
+		t.Fatalf("expected Bob back online with a new session, got %+v", restored)
+	}
+	evt, ok := findEvent(ownerConn.Events(), EventUserOnline)
+	if !ok || decodeEventPayload[User](t, evt).ID != bob.ID {
+		t.Fatalf("expected the owner to see Bob come back online")
+	}
+}
+
+// Verifies that a user offline for longer than userTimeout is removed with its
+// room membership and device key.
+func TestOfflineUserExpires(t *testing.T) {
+	app, room, members := newTestRoom(t, "Private", "Owner")
+	owner := members[0]
+	_, created := postUser(app, `{"name":"Bob","deviceKey":"`+testDeviceKey+`"}`)
+	bob := created.User
+	admitWithJoinCode(t, app, owner, room, bob.ID)
+	ownerConn := attachClient(app, owner.ID)
+
+	app.cleanupDisconnectedUser(bob.ID)
+	app.expireOfflineUser(bob.ID)
+	if !userExists(app, bob.ID) {
+		t.Fatalf("expected Bob to be kept within the grace period")
+	}
+
+	app.mu.Lock()
+	app.users[bob.ID].OfflineSince = time.Now().Add(-userTimeout - time.Minute).Unix()
+	app.mu.Unlock()
+	app.expireOfflineUser(bob.ID)
+	if userExists(app, bob.ID) {
+		t.Fatalf("expected Bob to be removed once the grace period passed")
+	}
+	evt, ok := findEvent(ownerConn.Events(), EventUserRemoved)
+	if !ok || decodeEventPayload[map[string]string](t, evt)["userId"] != bob.ID {
+		t.Fatalf("expected the owner to see Bob removed")
+	}
+
+	rr, again := postUser(app, `{"name":"Bob","deviceKey":"`+testDeviceKey+`"}`)
+	if rr.Code != http.StatusCreated || again.User.ID == bob.ID || again.User.RoomID != nil {
+		t.Fatalf("expected an expired device key to create a new user, got %d %+v", rr.Code, again.User)
+	}
+}
+
+// Verifies that with persistence on, a user with a device key is restored
+// offline and in its room after a host restart, and users without one are not.
+func TestDeviceKeySurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	app := newTestApp()
+	app.dataDir = dir
+	if err := app.enablePersistence(); err != nil {
+		t.Fatalf("enablePersistence error: %v", err)
+	}
+	owner := app.CreateUser("Owner")
+	room := app.CreateRoom("Kept", owner.ID)
+	if _, err := app.JoinRoom(owner.ID, room.ID); err != nil {
+		t.Fatalf("JoinRoom error: %v", err)
+	}
+	_, created := postUser(app, `{"name":"Bob","deviceKey":"`+testDeviceKey+`"}`)
+	bob := created.User
+	admitWithJoinCode(t, app, owner, room, bob.ID)
+	app.historyStore.Close()
+
+	restarted := newTestApp()
+	restarted.dataDir = dir
+	if err := restarted.enablePersistence(); err != nil {
+		t.Fatalf("enablePersistence after restart error: %v", err)
+	}
+	defer restarted.historyStore.Close()
+
+	if userExists(restarted, owner.ID) {
+		t.Fatalf("expected users without a device key to be forgotten")
+	}
+	restarted.mu.RLock()
+	stored := restarted.users[bob.ID]
+	members := append([]string(nil), restarted.rooms[room.ID].UserIDs...)
+	restarted.mu.RUnlock()
+	if stored == nil || stored.IsOnline || stored.OfflineSince == 0 || len(members) != 1 || members[0] != bob.ID {
+		t.Fatalf("expected Bob restored offline in his room, got %+v in %v", stored, members)
+	}
+
+	rr, restored := postUser(restarted, `{"name":"Bob","deviceKey":"`+testDeviceKey+`"}`)
+	if rr.Code != http.StatusOK || restored.User.ID != bob.ID || restored.User.RoomID == nil || *restored.User.RoomID != room.ID {
+		t.Fatalf("expected the device key to restore Bob after the restart, got %d %+v", rr.Code, restored.User)
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"GOproject/clip_helper"
+)
+
+// maxBundleMetadataSize bounds the manifest, log and transcript read into memory.
+const maxBundleMetadataSize = 64 * 1024 * 1024
+
+// errInvalidBundle marks import failures caused by the uploaded bundle itself.
+var errInvalidBundle = errors.New("invalid export bundle")
+
+// ImportResult reports the room created from a bundle and the verified source chain.
+type ImportResult struct {
+	Room           *Room              `json:"room"`
+	Verification   *ChainVerification `json:"verification"`
+	ChatMessages   int                `json:"chatMessages"`
+	ClipboardItems int                `json:"clipboardItems"`
+	Files          int                `json:"files"`
+	// FailedFiles lists bundle entries that could not be restored; their
+	// clipboard items are imported without a file.
+	FailedFiles []string `json:"failedFiles,omitempty"`
+}
+
+// exportBundle is an export tar read back from disk; files maps bundle entry
+// names to temporary copies waiting to be moved into the file store.
+type exportBundle struct {
+	manifest *ExportManifest
+	ops      []*Operation
+	files    map[string]string
+}
+
+// importRoomBundle recreates a room from an export bundle. The source chain must
+// verify and vouch for every operation in it; the new room opens with an OpImport
+// operation recording its head hash, followed by one add per live chat message
+// and clipboard item. The bundle's authors cannot be checked, so the new
+// operations belong to the importer and the original authors are only recorded
+// in the provenance and shown on imported messages.
+func (a *App) importRoomBundle(r io.Reader, name, ownerID string) (*ImportResult, error) {
+	a.mu.RLock()
+	owner, ok := a.users[ownerID]
+	var ownerName string
+	if ok {
+		ownerName = owner.Name
+	}
+	a.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("user %s not found", ownerID)
+	}
+
+	bundle, err := a.readExportBundle(r)
+	if bundle != nil {
+		defer bundle.cleanup()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	report := verifyOperationChain(bundle.manifest.RoomID, bundle.ops)
+	if !report.Valid {
+		return nil, fmt.Errorf("%w: hash chain broken at %s (%s)", errInvalidBundle, report.BrokenAt, report.Reason)
+	}
+	if bundle.manifest.HeadHash != "" && bundle.manifest.HeadHash != report.HeadHash {
+		return nil, fmt.Errorf("%w: manifest head %s does not match operation log", errInvalidBundle, bundle.manifest.HeadHash)
+	}
+	if op := unverifiableOperation(bundle.ops); op != nil {
+		return nil, fmt.Errorf("%w: operation %s cannot be verified", errInvalidBundle, op.ID)
+	}
+
+	if strings.TrimSpace(name) == "" {
+		name = bundle.manifest.RoomName
+	}
+	room := a.CreateRoom(name, ownerID)
+	if _, err := a.JoinRoom(ownerID, room.ID); err != nil {
+		return nil, err
+	}
+	result := &ImportResult{Room: room, Verification: report}
+
+	state := materializeHistory(bundle.ops)
+	provenance := &ImportProvenance{
+		SourceRoomID:   bundle.manifest.RoomID,
+		SourceRoomName: bundle.manifest.RoomName,
+		ExportedAt:     bundle.manifest.ExportedAt,
+		HeadHash:       report.HeadHash,
+		BaseHash:       report.BaseHash,
+		Operations:     len(bundle.ops),
+	}
+
+	// Fresh item IDs keep a bundle importable more than once on the same host.
+	seq := time.Now().UnixNano()
+	newIDs := make(map[string]string)
+	for _, group := range []struct {
+		prefix  string
+		entries []*SnapshotEntry
+	}{{"msg", state.Chat}, {"clip", state.Clipboard}} {
+		for _, e := range group.entries {
+			seq++
+			newIDs[e.ItemID] = fmt.Sprintf("%s_%d", group.prefix, seq)
+			provenance.Items = append(provenance.Items, &ImportedItem{
+				ItemID:         newIDs[e.ItemID],
+				SourceItemID:   e.ItemID,
+				SourceOpID:     e.OpID,
+				SourceUserID:   e.UserID,
+				SourceUserName: e.UserName,
+			})
+		}
+	}
+
+	importID := "import_" + room.ID
+	a.historyStore.AddOperation(room.ID, OpImport, importID, &Item{ID: importID, Type: ItemImport, Data: provenance}, ownerID, ownerName)
+
+	for _, e := range state.Chat {
+		msg, ok := e.Item.Data.(*ChatMessage)
+		if !ok {
+			continue
+		}
+		copied := *msg
+		copied.ID = newIDs[e.ItemID]
+		copied.RoomID = room.ID
+		copied.UserID = ownerID
+		copied.UserName = ownerName
+		if copied.ImportedFrom == "" {
+			copied.ImportedFrom = e.UserName
+		}
+		a.historyStore.AddOperation(room.ID, OpAdd, copied.ID, &Item{ID: copied.ID, Type: ItemChat, Data: &copied}, ownerID, ownerName)
+		result.ChatMessages++
+	}
+
+	for _, e := range state.Clipboard {
+		clip, ok := e.Item.Data.(*clip_helper.ClipboardItem)
+		if !ok {
+			continue
+		}
+		itemID := newIDs[e.ItemID]
+		copied := *clip
+		copied.ArchiveFilePath = ""
+		copied.SingleFilePath = ""
+		if entry, ok := bundle.manifest.Files[e.ItemID]; ok {
+			restored, err := a.restoreBundleFile(bundle, entry, itemID, &copied)
+			if err != nil {
+				fmt.Printf("Import could not restore %s: %v\n", entry, err)
+				result.FailedFiles = append(result.FailedFiles, entry)
+			} else if restored {
+				result.Files++
+			}
+		}
+		a.historyStore.AddOperation(room.ID, OpAdd, itemID, &Item{ID: itemID, Type: ItemClipboard, Data: &copied}, ownerID, ownerName)
+		result.ClipboardItems++
+	}
+
+	fmt.Printf("Room %s imported from %s (%d ops, head %s)\n", room.ID, bundle.manifest.RoomID, len(bundle.ops), report.HeadHash)
+	return result, nil
+}
+
+// unverifiableOperation returns an operation whose effect the hash chain
+// does not cover: a leading snapshot, whose content is not hashed, or a redacted
+// operation for an item the log never removes. Genuine redactions only follow a
+// removal, so they change nothing that is imported.
+func unverifiableOperation(ops []*Operation) *Operation {
+	if len(ops) > 0 && ops[0].OpType == OpSnapshot {
+		return ops[0]
+	}
+	removed := make(map[string]bool)
+	for i := len(ops) - 1; i >= 0; i-- {
+		op := ops[i]
+		if op.Redacted && !removed[op.ItemID] {
+			return op
+		}
+		if op.OpType == OpRemove && !op.Redacted {
+			removed[op.ItemID] = true
+		}
+	}
+	return nil
+}
+
+// restoreBundleFile moves a shared file out of the bundle into the host file store
+// and points the clipboard item at it.
+func (a *App) restoreBundleFile(bundle *exportBundle, entry, itemID string, clip *clip_helper.ClipboardItem) (bool, error) {
+	src, ok := bundle.files[entry]
+	if !ok {
+		return false, nil
+	}
+
+	var dest string
+	if clip.IsSingleFile {
+		dest = filepath.Join(a.fileStoreDir(), itemID+"_"+path.Base(entry))
+	} else {
+		dest = filepath.Join(a.fileStoreDir(), itemID+".tar")
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return false, err
+	}
+	if err := moveFile(src, dest); err != nil {
+		return false, err
+	}
+	delete(bundle.files, entry)
+
+	if clip.IsSingleFile {
+		clip.SingleFilePath = dest
+	} else {
+		clip.ArchiveFilePath = dest
+	}
+	return true, nil
+}
+
+// readExportBundle reads the manifest and operation log into memory and spools
+// shared files to the temp directory.
+func (a *App) readExportBundle(r io.Reader) (*exportBundle, error) {
+	bundle := &exportBundle{files: make(map[string]string)}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return bundle, fmt.Errorf("%w: %v", errInvalidBundle, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := path.Clean(hdr.Name)
+		switch {
+		case name == bundleManifestName:
+			data, err := readBundleEntry(tr)
+			if err != nil {
+				return bundle, err
+			}
+			var manifest ExportManifest
+			if err := json.Unmarshal(data, &manifest); err != nil {
+				return bundle, fmt.Errorf("%w: manifest: %v", errInvalidBundle, err)
+			}
+			bundle.manifest = &manifest
+		case name == bundleOperationsName:
+			data, err := readBundleEntry(tr)
+			if err != nil {
+				return bundle, err
+			}
+			if bundle.ops, err = parseOperationsJSONL(data); err != nil {
+				return bundle, err
+			}
+		case strings.HasPrefix(name, bundleFilesDir+"/"):
+			tmp, err := os.CreateTemp(a.tempDir, "import_*")
+			if err != nil {
+				return bundle, err
+			}
+			bundle.files[name] = tmp.Name()
+			_, err = io.Copy(tmp, tr)
+			if cerr := tmp.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return bundle, err
+			}
+		}
+	}
+
+	if bundle.manifest == nil || bundle.ops == nil {
+		return bundle, fmt.Errorf("%w: missing %s or %s", errInvalidBundle, bundleManifestName, bundleOperationsName)
+	}
+	return bundle, nil
+}
+
+// cleanup removes spooled files that were not moved into the file store.
+func (b *exportBundle) cleanup() {
+	for _, tmp := range b.files {
+		os.Remove(tmp)
+	}
+}
+
+func readBundleEntry(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxBundleMetadataSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidBundle, err)
+	}
+	if len(data) > maxBundleMetadataSize {
+		return nil, fmt.Errorf("%w: entry exceeds %d bytes", errInvalidBundle, maxBundleMetadataSize)
+	}
+	return data, nil
+}
+
+func parseOperationsJSONL(data []byte) ([]*Operation, error) {
+	ops := []*Operation{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), maxBundleMetadataSize)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var op Operation
+		if err := json.Unmarshal(scanner.Bytes(), &op); err != nil {
+			return nil, fmt.Errorf("%w: %s line %d: %v", errInvalidBundle, bundleOperationsName, line, err)
+		}
+		ops = append(ops, &op)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidBundle, err)
+	}
+	return ops, nil
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"GOproject/clip_helper"
+)
+
+// Ensures an exported bundle recreates its chat, clipboard and files in a new room
+// whose first operation records the verified source head.
+func TestImportRoomBundleRoundTrip(t *testing.T) {
+	app, room, members := newTestRoom(t, "Archive", "", "Alice")
+	alice := members[0]
+	app.SendChatMessage(room.ID, alice.ID, "draft")
+	msgs := app.GetChatHistory(room.ID)
+	app.EditChatMessage(room.ID, alice.ID, msgs[0].ID, "final wording")
+
+	filePath := filepath.Join(t.TempDir(), "plan.txt")
+	if err := os.WriteFile(filePath, []byte("the plan"), 0644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	clip := &clip_helper.ClipboardItem{Type: clip_helper.ClipboardFile, Files: []string{"plan.txt"}, IsSingleFile: true, SingleFileName: "plan.txt", SingleFilePath: filePath}
+	app.historyStore.AddOperation(room.ID, OpAdd, "clip_1", &Item{ID: "clip_1", Type: ItemClipboard, Data: clip}, alice.ID, alice.Name)
+
+	var bundle bytes.Buffer
+	if err := app.exportRoom(room.ID, ExportBundle, &bundle); err != nil {
+		t.Fatalf("exportRoom error: %v", err)
+	}
+	sourceOps := app.GetOperations(room.ID, "", "")
+	sourceHead := sourceOps[len(sourceOps)-1].Hash
+
+	token, _ := app.issueToken(alice.ID)
+	req := httptest.NewRequest(http.MethodPost, "/api/rooms/import?name=Restored", bytes.NewReader(bundle.Bytes()))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	app.handleRoomImport(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("import expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	result := decodeResponseBody[ImportResult](t, rr)
+	if result.Room == nil || result.Room.Name != "Restored" || result.Room.OwnerID != alice.ID {
+		t.Fatalf("expected a new room owned by the importer, got %+v", result.Room)
+	}
+	if result.ChatMessages != 1 || result.ClipboardItems != 1 || result.Files != 1 {
+		t.Fatalf("unexpected import counts: %+v", result)
+	}
+
+	ops := app.GetOperations(result.Room.ID, "", "")
+	prov, ok := ops[0].Item.Data.(*ImportProvenance)
+	if ops[0].OpType != OpImport || !ok || prov.HeadHash != sourceHead || prov.SourceRoomID != room.ID {
+		t.Fatalf("expected leading import operation pointing at %s, got %+v", sourceHead, ops[0])
+	}
+	if report, err := app.VerifyOperations(result.Room.ID); err != nil || !report.Valid {
+		t.Fatalf("expected imported room chain to verify, got %+v (%v)", report, err)
+	}
+
+	chat := app.GetChatHistory(result.Room.ID)
+	if len(chat) != 1 || chat[0].Message != "final wording" || chat[0].RoomID != result.Room.ID || chat[0].ID == msgs[0].ID {
+		t.Fatalf("expected the edited message recreated under a new ID, got %+v", chat)
+	}
+	items := app.historyStore.GetCurrentClipboardItems(result.Room.ID)
+	if len(items) != 1 || items[0].SingleFilePath == "" || items[0].SingleFilePath == filePath {
+		t.Fatalf("expected the shared file restored into host storage, got %+v", items)
+	}
+	if data, err := os.ReadFile(items[0].SingleFilePath); err != nil || string(data) != "the plan" {
+		t.Fatalf("expected restored file content, got %q (%v)", data, err)
+	}
+}
+
+// Ensures a bundle whose operation log was altered is rejected without creating a room.
+func TestImportRoomBundleRejectsTamperedLog(t *testing.T) {
+	app, room, members := newTestRoom(t, "Archive", "", "Alice")
+	alice := members[0]
+	app.SendChatMessage(room.ID, alice.ID, "original")
+
+	var bundle bytes.Buffer
+	if err := app.exportRoom(room.ID, ExportBundle, &bundle); err != nil {
+		t.Fatalf("exportRoom error: %v", err)
+	}
+
+	var tampered bytes.Buffer
+	tr := tar.NewReader(&bundle)
+	tw := tar.NewWriter(&tampered)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("read bundle: %v", err)
+		}
+		data, _ := io.ReadAll(tr)
+		if hdr.Name == bundleOperationsName {
+			data = []byte(strings.Replace(string(data), "original", "forged", 1))
+			hdr.Size = int64(len(data))
+		}
+		tw.WriteHeader(hdr)
+		tw.Write(data)
+	}
+	tw.Close()
+
+	roomsBefore := len(app.GetAllRooms())
+	token, _ := app.issueToken(alice.ID)
+	req := httptest.NewRequest(http.MethodPost, "/api/rooms/import", &tampered)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	app.handleRoomImport(rr, req)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "hash chain broken") {
+		t.Fatalf("tampered import expected 400, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if len(app.GetAllRooms()) != roomsBefore {
+		t.Fatalf("expected no room to be created for a rejected bundle")
+	}
+}
+
+// Ensures bundle files are copied when they cannot be renamed into the file
+// store, and that files which cannot be restored at all are reported.
+func TestImportRoomBundleRestoresFilesAcrossFilesystems(t *testing.T) {
+	app, room, members := newTestRoom(t, "Archive", "", "Alice")
+	alice := members[0]
+	filePath := filepath.Join(t.TempDir(), "plan.txt")
+	if err := os.WriteFile(filePath, []byte("the plan"), 0644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	clip := &clip_helper.ClipboardItem{Type: clip_helper.ClipboardFile, Files: []string{"plan.txt"}, IsSingleFile: true, SingleFileName: "plan.txt", SingleFilePath: filePath}
+	app.historyStore.AddOperation(room.ID, OpAdd, "clip_1", &Item{ID: "clip_1", Type: ItemClipboard, Data: clip}, alice.ID, alice.Name)
+	var bundle bytes.Buffer
+	if err := app.exportRoom(room.ID, ExportBundle, &bundle); err != nil {
+		t.Fatalf("exportRoom error: %v", err)
+	}
+
+	renameFile = func(src, dest string) error {
+		return &os.LinkError{Op: "rename", Old: src, New: dest, Err: syscall.EXDEV}
+	}
+	defer func() { renameFile = os.Rename }()
+
+	result, err := app.importRoomBundle(bytes.NewReader(bundle.Bytes()), "Copied", alice.ID)
+	if err != nil {
+		t.Fatalf("importRoomBundle error: %v", err)
+	}
+	items := app.historyStore.GetCurrentClipboardItems(result.Room.ID)
+	if result.Files != 1 || len(result.FailedFiles) != 0 || len(items) != 1 {
+		t.Fatalf("expected the file to be copied into the store, got %+v", result)
+	}
+	if data, err := os.ReadFile(items[0].SingleFilePath); err != nil || string(data) != "the plan" {
+		t.Fatalf("expected copied file content, got %q (%v)", data, err)
+	}
+
+	// A file store that cannot be created leaves the item without its file
+	blocked := filepath.Join(t.TempDir(), "blocked")
+	os.WriteFile(blocked, nil, 0644)
+	app.dataDir = blocked
+	app.persistenceEnabled = true
+	defer func() { app.persistenceEnabled = false }()
+	result, err = app.importRoomBundle(bytes.NewReader(bundle.Bytes()), "Broken", alice.ID)
+	if err != nil {
+		t.Fatalf("importRoomBundle error: %v", err)
+	}
+	if result.Files != 0 || len(result.FailedFiles) != 1 || result.ClipboardItems != 1 {
+		t.Fatalf("expected the failed file to be reported, got %+v", result)
+	}
+}
+
+// Ensures bundles are refused when the chain does not vouch for what they import:
+// a leading snapshot, or a redacted operation for an item that is never removed.
+func TestImportRoomBundleRejectsUnverifiableOperations(t *testing.T) {
+	app, room, members := newTestRoom(t, "Archive", "", "Alice")
+	alice := members[0]
+	app.SendChatMessage(room.ID, alice.ID, "kept")
+	app.SendChatMessage(room.ID, alice.ID, "deleted")
+	var kept, deleted *ChatMessage
+	for _, msg := range app.GetChatHistory(room.ID) {
+		if msg.Message == "kept" {
+			kept = msg
+		} else {
+			deleted = msg
+		}
+	}
+	if result := app.DeleteChatMessage(room.ID, alice.ID, deleted.ID); strings.HasPrefix(result, "Error") {
+		t.Fatalf("DeleteChatMessage: %s", result)
+	}
+
+	importOps := func(name string, ops []*Operation) (*ImportResult, error) {
+		var bundle bytes.Buffer
+		manifest := &ExportManifest{RoomID: room.ID, RoomName: room.Name, Operations: len(ops), HeadHash: ops[len(ops)-1].Hash}
+		if err := writeExportBundle(&bundle, manifest, ops); err != nil {
+			t.Fatalf("writeExportBundle error: %v", err)
+		}
+		return app.importRoomBundle(&bundle, name, alice.ID)
+	}
+
+	ops := app.GetOperations(room.ID, "", "")
+	result, err := importOps("Deleted", ops)
+	if err != nil || result.ChatMessages != 1 || result.Verification.Redacted != 1 {
+		t.Fatalf("expected a genuine deletion to import, got %+v (%v)", result, err)
+	}
+
+	forged := make([]*Operation, len(ops))
+	for i, op := range ops {
+		copied := *op
+		if copied.ItemID == kept.ID {
+			copied.Item = &Item{ID: kept.ID, Type: ItemChat}
+			copied.Redacted = true
+		}
+		forged[i] = &copied
+	}
+	if _, err := importOps("Forged", forged); err == nil || !strings.Contains(err.Error(), "cannot be verified") {
+		t.Fatalf("expected a redacted op for a live item to be refused, got %v", err)
+	}
+
+	hp := NewHistoryPool()
+	hp.SetOperationLimit(room.ID, 4)
+	for i := 0; i < 10; i++ {
+		id := fmt.Sprintf("msg_%d", i)
+		hp.AddOperation(room.ID, OpAdd, id, &Item{ID: id, Type: ItemChat, Data: &ChatMessage{ID: id, Message: id}}, alice.ID, alice.Name)
+	}
+	compacted := hp.GetOperations(room.ID, "", "")
+	if compacted[0].OpType != OpSnapshot {
+		t.Fatalf("expected the history to start with a snapshot, got %s", compacted[0].OpType)
+	}
+	if _, err := importOps("Compacted", compacted); err == nil || !strings.Contains(err.Error(), "cannot be verified") {
+		t.Fatalf("expected a leading snapshot to be refused, got %v", err)
+	}
+}
+
+// Ensures imported items are authored by the importer, with the authors the
+// bundle claims kept only in the provenance and on the messages for display.
+func TestImportRoomBundleRecordsImporterAsAuthor(t *testing.T) {
+	app, room, members := newTestRoom(t, "Archive", "", "Alice")
+	alice := members[0]
+	app.SendChatMessage(room.ID, alice.ID, "from alice")
+	var bundle bytes.Buffer
+	if err := app.exportRoom(room.ID, ExportBundle, &bundle); err != nil {
+		t.Fatalf("exportRoom error: %v", err)
+	}
+
+	bob := app.CreateUser("Bob")
+	result, err := app.importRoomBundle(&bundle, "Copied", bob.ID)
+	if err != nil {
+		t.Fatalf("importRoomBundle error: %v", err)
+	}
+	if !app.userInRoom(bob.ID, result.Room.ID) {
+		t.Fatalf("expected the importer to join the imported room")
+	}
+	for _, op := range app.GetOperations(result.Room.ID, "", "") {
+		if op.UserID != bob.ID || op.UserName != bob.Name {
+			t.Fatalf("expected every imported op authored by the importer, got %s by %s", op.OpType, op.UserID)
+		}
+	}
+	chat := app.GetChatHistory(result.Room.ID)
+	if len(chat) != 1 || chat[0].UserID != bob.ID || chat[0].ImportedFrom != alice.Name {
+		t.Fatalf("expected the message owned by Bob and shown as imported from Alice, got %+v", chat)
+	}
+	prov := app.GetOperations(result.Room.ID, "", "")[0].Item.Data.(*ImportProvenance)
+	if len(prov.Items) != 1 || prov.Items[0].SourceUserID != alice.ID || prov.Items[0].SourceUserName != alice.Name {
+		t.Fatalf("expected the original author in the provenance, got %+v", prov.Items)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	joinCodeLength         = 8
+	joinCodeAlphabet       = "ABCDEFGHJKMNPQRSTVWXYZ23456789" // no 0/O, 1/I/L or U, which are easy to mistype
+	defaultJoinCodeExpiry  = 24 * time.Hour
+	maxJoinCodeExpiry      = 30 * 24 * time.Hour
+	maxJoinCodesPerRoom    = 20
+	joinCodeGenerateTrials = 5
+)
+
+var (
+	errJoinCodeInvalid = errors.New("join code is invalid or has expired")
+	errNotCodeOwner    = errors.New("only the room owner can manage join codes")
+)
+
+// JoinCode lets anyone who knows it join a room without the owner approving
+// them, until it expires or runs out of uses.
+type JoinCode struct {
+	Code      string    `json:"code"` // formatted as XXXX-XXXX
+	RoomID    string    `json:"roomId"`
+	CreatedBy string    `json:"createdBy"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	MaxUses   int       `json:"maxUses,omitempty"` // 0 allows any number of uses until expiry
+	Uses      int       `json:"uses"`
+}
+
+// CreateJoinCodeRequest is the body of POST /api/rooms/{id}/codes.
+type CreateJoinCodeRequest struct {
+	ExpiresInSeconds int64 `json:"expiresInSeconds,omitempty"` // default 24h, at most 30 days
+	MaxUses          int   `json:"maxUses,omitempty"`
+}
+
+// RedeemJoinCodeRequest is the body of POST /api/join/code.
+type RedeemJoinCodeRequest struct {
+	Code   string `json:"code"`
+	UserID string `json:"userId,omitempty"`
+}
+
+func (c *JoinCode) usable(now time.Time) bool {
+	return now.Before(c.ExpiresAt) && (c.MaxUses == 0 || c.Uses < c.MaxUses)
+}
+
+// normalizeJoinCode uppercases a typed code and drops separators and spaces.
+func normalizeJoinCode(code string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(code) {
+		if r != '-' && r != ' ' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func formatJoinCode(normalized string) string {
+	return normalized[:joinCodeLength/2] + "-" + normalized[joinCodeLength/2:]
+}
+
+func randomJoinCode() (string, error) {
+	buf := make([]byte, joinCodeLength)
+	limit := big.NewInt(int64(len(joinCodeAlphabet)))
+	for i := range buf {
+		n, err := rand.Int(rand.Reader, limit)
+		if err != nil {
+			return "", err
+		}
+		buf[i] = joinCodeAlphabet[n.Int64()]
+	}
+	return string(buf), nil
+}
+
+// pruneJoinCodesLocked drops codes that can no longer be redeemed. Callers must hold a.mu.
+func (a *App) pruneJoinCodesLocked(now time.Time) bool {
+	pruned := false
+	for key, code := range a.joinCodes {
+		if _, roomExists := a.rooms[code.RoomID]; !roomExists || !code.usable(now) {
+			delete(a.joinCodes, key)
+			pruned = true
+		}
+	}
+	return pruned
+}
+
+// CreateJoinCode generates a join code for a room. Only the room owner may create codes.
+func (a *App) CreateJoinCode(roomID, ownerID string, expiresIn time.Duration, maxUses int) (*JoinCode, error) {
+	if expiresIn == 0 {
+		expiresIn = defaultJoinCodeExpiry
+	}
+	if expiresIn < time.Minute || expiresIn > maxJoinCodeExpiry {
+		return nil, fmt.Errorf("join code expiry must be between 1 minute and %v", maxJoinCodeExpiry)
+	}
+	if maxUses < 0 {
+		return nil, errors.New("maxUses must not be negative")
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	room, exists := a.rooms[roomID]
+	if !exists {
+		return nil, fmt.Errorf("room %s not found", roomID)
+	}
+	if room.OwnerID != ownerID {
+		return nil, errNotCodeOwner
+	}
+
+	now := time.Now()
+	a.pruneJoinCodesLocked(now)
+	active := 0
+	for _, code := range a.joinCodes {
+		if code.RoomID == roomID {
+			active++
+		}
+	}
+	if active >= maxJoinCodesPerRoom {
+		return nil, fmt.Errorf("room already has %d active join codes", maxJoinCodesPerRoom)
+	}
+
+	var key string
+	for i := 0; i < joinCodeGenerateTrials && key == ""; i++ {
+		candidate, err := randomJoinCode()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate join code: %w", err)
+		}
+		if _, taken := a.joinCodes[candidate]; !taken {
+			key = candidate
+		}
+	}
+	if key == "" {
+		return nil, errors.New("failed to generate a unique join code")
+	}
+
+	code := &JoinCode{
+		Code:      formatJoinCode(key),
+		RoomID:    roomID,
+		CreatedBy: ownerID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(expiresIn),
+		MaxUses:   maxUses,
+	}
+	a.joinCodes[key] = code
+	a.persistStateLocked()
+	fmt.Printf("Join code created for room %s, expires %s\n", roomID, code.ExpiresAt.Format(time.RFC3339))
+
+	copied := *code
+	return &copied, nil
+}
+
+// ListJoinCodes returns a room's active join codes, newest first. Only the room owner may list them.
+func (a *App) ListJoinCodes(roomID, ownerID string) ([]*JoinCode, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	room, exists := a.rooms[roomID]
+	if !exists {
+		return nil, fmt.Errorf("room %s not found", roomID)
+	}
+	if room.OwnerID != ownerID {
+		return nil, errNotCodeOwner
+	}
+
+	if a.pruneJoinCodesLocked(time.Now()) {
+		a.persistStateLocked()
+	}
+	codes := []*JoinCode{}
+	for _, code := range a.joinCodes {
+		if code.RoomID == roomID {
+			copied := *code
+			codes = append(codes, &copied)
+		}
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i].CreatedAt.After(codes[j].CreatedAt) })
+	return codes, nil
+}
+
+// RevokeJoinCode deletes a room's join code. Only the room owner may revoke codes.
+func (a *App) RevokeJoinCode(roomID, ownerID, code string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	room, exists := a.rooms[roomID]
+	if !exists {
+		return fmt.Errorf("room %s not found", roomID)
+	}
+	if room.OwnerID != ownerID {
+		return errNotCodeOwner
+	}
+
+	key := normalizeJoinCode(code)
+	existing, ok := a.joinCodes[key]
+	if !ok || existing.RoomID != roomID {
+		return errJoinCodeInvalid
+	}
+	delete(a.joinCodes, key)
+	a.persistStateLocked()
+	fmt.Printf("Join code for room %s revoked\n", roomID)
+	return nil
+}
+
+// RedeemJoinCode approves userID for the code's room and joins it.
+func (a *App) RedeemJoinCode(userID, code string) (*Room, error) {
+	key := normalizeJoinCode(code)
+
+	a.mu.Lock()
+	if _, exists := a.users[userID]; !exists {
+		a.mu.Unlock()
+		return nil, fmt.Errorf("user not found")
+	}
+	joinCode, ok := a.joinCodes[key]
+	if !ok || !joinCode.usable(time.Now()) {
+		a.mu.Unlock()
+		return nil, errJoinCodeInvalid
+	}
+	room, exists := a.rooms[joinCode.RoomID]
+	if !exists {
+		delete(a.joinCodes, key)
+		a.mu.Unlock()
+		return nil, errJoinCodeInvalid
+	}
+
+	if contains(room.BannedUserIDs, userID) {
+		a.mu.Unlock()
+		return nil, errBannedFromRoom
+	}
+
+	if !contains(room.UserIDs, userID) {
+		joinCode.Uses++
+	}
+	if !contains(room.ApprovedUserIDs, userID) {
+		room.ApprovedUserIDs = append(room.ApprovedUserIDs, userID)
+	}
+	a.persistStateLocked()
+	roomID := room.ID
+	a.mu.Unlock()
+
+	fmt.Printf("User %s redeemed a join code for room %s\n", userID, roomID)
+	return a.JoinRoom(userID, roomID)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func redeemJoinCode(app *App, userID, code string) *httptest.ResponseRecorder {
+	token, _ := app.issueToken(userID)
+	req := httptest.NewRequest(http.MethodPost, "/api/join/code", strings.NewReader(`{"code":"`+code+`"}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	app.handleJoinCode(rr, req)
+	return rr
+}
+
+// Ensures a join code admits users without the owner online, honours its use
+// count and stops working once revoked.
+func TestJoinCodeLifecycle(t *testing.T) {
+	app, room, members := newTestRoom(t, "Private", "Owner")
+	owner := members[0]
+	carol := app.CreateUser("Carol")
+	dave := app.CreateUser("Dave")
+
+	if _, err := app.RequestJoinRoom(carol.ID, room.ID); err == nil {
+		t.Fatalf("expected a join request to fail while the owner is offline")
+	}
+
+	ownerToken, _ := app.issueToken(owner.ID)
+	req := httptest.NewRequest(http.MethodPost, "/api/rooms/"+room.ID+"/codes", strings.NewReader(`{"expiresInSeconds":3600,"maxUses":1}`))
+	req.Header.Set("Authorization", "Bearer "+ownerToken)
+	rr := httptest.NewRecorder()
+	app.handleRoomRoutes(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201 creating a code, got %d: %s", rr.Code, rr.Body.String())
+	}
+	code := decodeResponseBody[JoinCode](t, rr)
+	if len(code.Code) != joinCodeLength+1 || code.Code[4] != '-' || code.MaxUses != 1 {
+		t.Fatalf("unexpected join code %+v", code)
+	}
+
+	typed := strings.ToLower(strings.ReplaceAll(code.Code, "-", " "))
+	if rr := redeemJoinCode(app, carol.ID, typed); rr.Code != http.StatusOK {
+		t.Fatalf("expected redeem to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if !app.userInRoom(carol.ID, room.ID) || !contains(app.rooms[room.ID].ApprovedUserIDs, carol.ID) {
+		t.Fatalf("expected carol to be approved and joined")
+	}
+	if rr := redeemJoinCode(app, dave.ID, code.Code); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected a used-up code to be rejected, got %d", rr.Code)
+	}
+
+	unlimited, err := app.CreateJoinCode(room.ID, owner.ID, 0, 0)
+	if err != nil {
+		t.Fatalf("CreateJoinCode error: %v", err)
+	}
+	if _, err := app.CreateJoinCode(room.ID, carol.ID, 0, 0); err != errNotCodeOwner {
+		t.Fatalf("expected non-owner create to fail, got %v", err)
+	}
+	codes, err := app.ListJoinCodes(room.ID, owner.ID)
+	if err != nil || len(codes) != 1 || codes[0].Code != unlimited.Code {
+		t.Fatalf("expected only the unlimited code to be listed, got %+v err %v", codes, err)
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/api/rooms/"+room.ID+"/codes/"+unlimited.Code, nil)
+	req.Header.Set("Authorization", "Bearer "+ownerToken)
+	rr = httptest.NewRecorder()
+	app.handleRoomRoutes(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected revoke to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := redeemJoinCode(app, dave.ID, unlimited.Code); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected a revoked code to be rejected, got %d", rr.Code)
+	}
+}
+
+// Verifies expired codes are refused and pruned, and that active codes survive a restart.
+func TestJoinCodeExpiryAndPersistence(t *testing.T) {
+	dir := t.TempDir()
+	app := newTestApp()
+	app.dataDir = dir
+	if err := app.enablePersistence(); err != nil {
+		t.Fatalf("enablePersistence error: %v", err)
+	}
+	owner := app.CreateUser("Owner")
+	room := app.CreateRoom("Private", owner.ID)
+
+	if _, err := app.CreateJoinCode(room.ID, owner.ID, time.Second, 0); err == nil {
+		t.Fatalf("expected an expiry under a minute to be rejected")
+	}
+	expired, _ := app.CreateJoinCode(room.ID, owner.ID, time.Minute, 0)
+	kept, _ := app.CreateJoinCode(room.ID, owner.ID, time.Hour, 3)
+	app.mu.Lock()
+	app.joinCodes[normalizeJoinCode(expired.Code)].ExpiresAt = time.Now().Add(-time.Second)
+	app.mu.Unlock()
+
+	if _, err := app.RedeemJoinCode(app.CreateUser("Late").ID, expired.Code); err != errJoinCodeInvalid {
+		t.Fatalf("expected expired code to be invalid, got %v", err)
+	}
+
+	restarted := newTestApp()
+	restarted.dataDir = dir
+	if err := restarted.enablePersistence(); err != nil {
+		t.Fatalf("enablePersistence after restart error: %v", err)
+	}
+	codes, err := restarted.ListJoinCodes(room.ID, owner.ID)
+	if err != nil || len(codes) != 1 || codes[0].Code != kept.Code || codes[0].MaxUses != 3 {
+		t.Fatalf("expected the active code to survive a restart, got %+v err %v", codes, err)
+	}
+}
@@ -17,10 +17,29 @@ var assets embed.FS
 func main() {
 	// Parse command line flags
 	mode := flag.String("mode", "pending", "Mode: 'host' for central-server host, 'client' for client, omit to choose in UI")
+	dataDir := flag.String("data-dir", getEnvDefault("GOTEAMWORK_DATA_DIR", defaultDataDir()), "Directory for persisted host history and the client outbox; empty keeps both in memory only")
+	queueSize := flag.Int("event-queue-size", defaultClientQueueSize, "Events buffered per connected client before the overflow policy applies")
+	overflow := flag.String("event-overflow", getEnvDefault("GOTEAMWORK_EVENT_OVERFLOW", string(defaultOverflowPolicy)), "Full event queue policy: drop_oldest, disconnect or coalesce_heartbeats")
+	useTLS := flag.Bool("tls", getEnvDefault("GOTEAMWORK_TLS", "") == "1", "Serve the host API over HTTPS, with a self-signed certificate kept in the data dir unless -tls-cert is set")
+	tlsCert := flag.String("tls-cert", getEnvDefault("GOTEAMWORK_TLS_CERT", ""), "PEM certificate file for the host API; implies -tls")
+	tlsKey := flag.String("tls-key", getEnvDefault("GOTEAMWORK_TLS_KEY", ""), "PEM private key file for -tls-cert")
+	rateLimits := flag.String("rate-limits", getEnvDefault("GOTEAMWORK_RATE_LIMITS", ""), "Host API rate limit overrides per route class, e.g. chat=1/s:5,signup=3/m,invite=off; 'off' disables all")
 	flag.Parse()
 
 	// Create an instance of the app structure
 	app := NewApp(*mode)
+	app.dataDir = *dataDir
+	app.tlsOptions = TLSOptions{Enabled: *useTLS || *tlsCert != "", CertFile: *tlsCert, KeyFile: *tlsKey}
+	if policy, err := parseOverflowPolicy(*overflow); err != nil {
+		println("Error:", err.Error())
+	} else if err := app.sseManager.SetQueuePolicy(*queueSize, policy); err != nil {
+		println("Error:", err.Error())
+	}
+	if limits, err := parseRateLimits(*rateLimits); err != nil {
+		println("Error:", err.Error())
+	} else {
+		app.rateLimiter.SetLimits(limits)
+	}
 
 	// Create application with options
 	err := wails.Run(&options.App{
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// RoomRole is a member's permission level within a room.
+type RoomRole string
+
+const (
+	RoleOwner     RoomRole = "owner"
+	RoleModerator RoomRole = "moderator"
+	RoleMember    RoomRole = "member"
+	RoleViewer    RoomRole = "viewer" // read-only: may not chat or share clipboard items
+)
+
+// ModerationAction is what a moderation request does to its target.
+type ModerationAction string
+
+const (
+	ModerationSetRole ModerationAction = "role"
+	ModerationKick    ModerationAction = "kick"
+	ModerationBan     ModerationAction = "ban"
+	ModerationUnban   ModerationAction = "unban"
+	ModerationMute    ModerationAction = "mute"
+	ModerationUnmute  ModerationAction = "unmute"
+)
+
+var (
+	errNotModerator   = errors.New("only the room owner or a moderator can do that")
+	errNotRoleManager = errors.New("only the room owner can change member roles")
+	errOutranked      = errors.New("cannot moderate a member with an equal or higher role")
+	errBannedFromRoom = errors.New("user is banned from this room")
+	errMemberMuted    = errors.New("user is muted in this room")
+	errViewerReadOnly = errors.New("viewers cannot post in this room")
+)
+
+// ModerationRequest is the body of POST /api/rooms/{id}/members/{userId}/{action}.
+// Role is only read by the role action.
+type ModerationRequest struct {
+	Action ModerationAction `json:"action,omitempty"`
+	Role   RoomRole         `json:"role,omitempty"`
+}
+
+// ModerationEvent announces a moderation action to the room and to its target.
+type ModerationEvent struct {
+	RoomID   string           `json:"roomId"`
+	Action   ModerationAction `json:"action"`
+	ActorID  string           `json:"actorId"`
+	TargetID string           `json:"targetId"`
+	Role     RoomRole         `json:"role,omitempty"` // the target's role after a role change
+}
+
+func (r RoomRole) rank() int {
+	switch r {
+	case RoleOwner:
+		return 3
+	case RoleModerator:
+		return 2
+	case RoleMember:
+		return 1
+	}
+	return 0
+}
+
+// roleOf returns userID's role in room. Members without an explicit role are RoleMember.
+func roleOf(room *Room, userID string) RoomRole {
+	switch {
+	case room.OwnerID == userID:
+		return RoleOwner
+	case contains(room.ModeratorIDs, userID):
+		return RoleModerator
+	case contains(room.ViewerIDs, userID):
+		return RoleViewer
+	}
+	return RoleMember
+}
+
+// postingError reports why userID may not add chat messages or clipboard items to room, if anything.
+func postingError(room *Room, userID string) error {
+	if roleOf(room, userID) == RoleViewer {
+		return errViewerReadOnly
+	}
+	if contains(room.MutedUserIDs, userID) {
+		return errMemberMuted
+	}
+	return nil
+}
+
+// canPost checks that userID is allowed to post in roomID under its roles and mutes.
+func (a *App) canPost(userID, roomID string) error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	room, exists := a.rooms[roomID]
+	if !exists {
+		return fmt.Errorf("room %s not found", roomID)
+	}
+	return postingError(room, userID)
+}
+
+// without returns a copy of ids with every occurrence of id removed.
+func without(ids []string, id string) []string {
+	kept := make([]string, 0, len(ids))
+	for _, existing := range ids {
+		if existing != id {
+			kept = append(kept, existing)
+		}
+	}
+	return kept
+}
+
+// ModerateMember applies a moderation action by actorID to targetID in roomID and
+// announces it to the room. Only the owner may change roles; kicking, banning and
+// muting need a moderator or the owner acting on someone of lower rank.
+func (a *App) ModerateMember(roomID, actorID, targetID string, req ModerationRequest) (*ModerationEvent, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	room, exists := a.rooms[roomID]
+	if !exists {
+		return nil, fmt.Errorf("room %s not found", roomID)
+	}
+	if actorID == targetID {
+		return nil, errors.New("cannot moderate yourself")
+	}
+
+	actorRole := roleOf(room, actorID)
+	targetRole := roleOf(room, targetID)
+	if req.Action == ModerationSetRole {
+		if actorRole != RoleOwner {
+			return nil, errNotRoleManager
+		}
+		if req.Role != RoleModerator && req.Role != RoleMember && req.Role != RoleViewer {
+			return nil, errors.New("role must be moderator, member or viewer")
+		}
+	} else {
+		if actorRole.rank() < RoleModerator.rank() {
+			return nil, errNotModerator
+		}
+		if targetRole.rank() >= actorRole.rank() {
+			return nil, errOutranked
+		}
+	}
+
+	inRoom := contains(room.UserIDs, targetID)
+	switch req.Action {
+	case ModerationSetRole, ModerationKick, ModerationMute, ModerationUnmute:
+		if !inRoom {
+			return nil, fmt.Errorf("user %s is not in room %s", targetID, roomID)
+		}
+	case ModerationBan:
+		if contains(room.BannedUserIDs, targetID) {
+			return nil, fmt.Errorf("user %s is already banned", targetID)
+		}
+	case ModerationUnban:
+		if !contains(room.BannedUserIDs, targetID) {
+			return nil, fmt.Errorf("user %s is not banned", targetID)
+		}
+	default:
+		return nil, fmt.Errorf("unknown moderation action %q", req.Action)
+	}
+
+	event := &ModerationEvent{
+		RoomID:   roomID,
+		Action:   req.Action,
+		ActorID:  actorID,
+		TargetID: targetID,
+	}
+	switch req.Action {
+	case ModerationSetRole:
+		room.ModeratorIDs = without(room.ModeratorIDs, targetID)
+		room.ViewerIDs = without(room.ViewerIDs, targetID)
+		switch req.Role {
+		case RoleModerator:
+			room.ModeratorIDs = append(room.ModeratorIDs, targetID)
+		case RoleViewer:
+			room.ViewerIDs = append(room.ViewerIDs, targetID)
+		}
+		event.Role = req.Role
+	case ModerationMute:
+		if !contains(room.MutedUserIDs, targetID) {
+			room.MutedUserIDs = append(room.MutedUserIDs, targetID)
+		}
+	case ModerationUnmute:
+		room.MutedUserIDs = without(room.MutedUserIDs, targetID)
+	case ModerationBan:
+		room.ApprovedUserIDs = without(room.ApprovedUserIDs, targetID)
+		room.ModeratorIDs = without(room.ModeratorIDs, targetID)
+		room.ViewerIDs = without(room.ViewerIDs, targetID)
+		room.MutedUserIDs = without(room.MutedUserIDs, targetID)
+		room.BannedUserIDs = append(room.BannedUserIDs, targetID)
+	case ModerationUnban:
+		room.BannedUserIDs = without(room.BannedUserIDs, targetID)
+	}
+	a.persistStateLocked()
+
+	// The target hears about it too, even once it is no longer a member
+	recipients := append([]string(nil), room.UserIDs...)
+	if !inRoom {
+		recipients = append(recipients, targetID)
+	}
+	a.sseManager.BroadcastToUsers(recipients, EventRoomModeration, event, "")
+	fmt.Printf("Moderation in room %s: %s applied %s to %s\n", roomID, actorID, req.Action, targetID)
+
+	if inRoom && (req.Action == ModerationKick || req.Action == ModerationBan) {
+		if target, ok := a.users[targetID]; ok {
+			a.leaveRoomLocked(target, room)
+		}
+	}
+	return event, nil
+}
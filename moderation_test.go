@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func moderate(app *App, actorID, roomID, targetID, action, body string) *httptest.ResponseRecorder {
+	token, _ := app.issueToken(actorID)
+	req := httptest.NewRequest(http.MethodPost, "/api/rooms/"+roomID+"/members/"+targetID+"/"+action, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	app.handleRoomRoutes(rr, req)
+	return rr
+}
+
+// Verifies that only the owner assigns roles, that viewers and muted members
+// cannot chat or share, and that nobody can act on an equal or higher role.
+func TestModerationRolesGatePosting(t *testing.T) {
+	app, room, users := newTestRoom(t, "Private", "Owner", "Bob", "Carol", "Dave")
+	owner, bob, carol, dave := users[0], users[1], users[2], users[3]
+	carolConn := attachClient(app, carol.ID)
+
+	if rr := moderate(app, owner.ID, room.ID, bob.ID, "role", `{"role":"moderator"}`); rr.Code != http.StatusOK {
+		t.Fatalf("expected owner to promote bob, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := moderate(app, bob.ID, room.ID, carol.ID, "role", `{"role":"viewer"}`); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected moderator role change to be forbidden, got %d", rr.Code)
+	}
+	if rr := moderate(app, owner.ID, room.ID, carol.ID, "role", `{"role":"owner"}`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected owner role to be unassignable, got %d", rr.Code)
+	}
+	if rr := moderate(app, owner.ID, room.ID, carol.ID, "role", `{"role":"viewer"}`); rr.Code != http.StatusOK {
+		t.Fatalf("expected owner to make carol a viewer, got %d", rr.Code)
+	}
+
+	evt, ok := findEvent(carolConn.Events(), EventRoomModeration)
+	if !ok {
+		t.Fatalf("expected carol to receive a room_moderation event")
+	}
+	if payload := decodeEventPayload[ModerationEvent](t, evt); payload.Action != ModerationSetRole || payload.TargetID != bob.ID || payload.Role != RoleModerator {
+		t.Fatalf("unexpected first moderation event %+v", payload)
+	}
+
+	if result := app.SendChatMessage(room.ID, carol.ID, "hello"); !strings.HasPrefix(result, "Error") {
+		t.Fatalf("expected viewer chat to be refused, got %q", result)
+	}
+	carolToken, _ := app.issueToken(carol.ID)
+	req := httptest.NewRequest(http.MethodPost, "/api/clipboard", strings.NewReader(`{"item":{"type":"text","text":"spam"},"userId":"`+carol.ID+`"}`))
+	req.Header.Set("Authorization", "Bearer "+carolToken)
+	rr := httptest.NewRecorder()
+	app.handleClipboardUpload(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected viewer clipboard upload to be forbidden, got %d", rr.Code)
+	}
+
+	if rr := moderate(app, bob.ID, room.ID, dave.ID, "mute", ""); rr.Code != http.StatusOK {
+		t.Fatalf("expected moderator to mute dave, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if result := app.SendChatMessage(room.ID, dave.ID, "flood"); chatResultStatus(result) != http.StatusForbidden {
+		t.Fatalf("expected muted chat to be forbidden, got %q", result)
+	}
+	if rr := moderate(app, bob.ID, room.ID, dave.ID, "unmute", ""); rr.Code != http.StatusOK {
+		t.Fatalf("expected moderator to unmute dave, got %d", rr.Code)
+	}
+	if result := app.SendChatMessage(room.ID, dave.ID, "sorry"); strings.HasPrefix(result, "Error") {
+		t.Fatalf("expected unmuted chat to be sent, got %q", result)
+	}
+
+	if rr := moderate(app, dave.ID, room.ID, carol.ID, "kick", ""); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected member kick to be forbidden, got %d", rr.Code)
+	}
+	if rr := moderate(app, bob.ID, room.ID, owner.ID, "mute", ""); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected moderator to be unable to mute the owner, got %d", rr.Code)
+	}
+}
+
+// Exercises kick, ban and unban: a kicked member may come back, a banned one
+// loses approval and cannot rejoin by any route until unbanned.
+func TestModerationKickAndBan(t *testing.T) {
+	app, room, users := newTestRoom(t, "Private", "Owner", "Bob", "Mallory")
+	owner, bob, mallory := users[0], users[1], users[2]
+	bobConn := attachClient(app, bob.ID)
+	malloryConn := attachClient(app, mallory.ID)
+
+	if rr := moderate(app, owner.ID, room.ID, mallory.ID, "kick", ""); rr.Code != http.StatusOK {
+		t.Fatalf("expected kick to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if app.userInRoom(mallory.ID, room.ID) {
+		t.Fatalf("expected mallory to be removed from the room")
+	}
+	if _, ok := findEvent(bobConn.Events(), EventUserLeft); !ok {
+		t.Fatalf("expected remaining members to see mallory leave")
+	}
+	evt, ok := findEvent(malloryConn.Events(), EventRoomModeration)
+	if !ok || decodeEventPayload[ModerationEvent](t, evt).Action != ModerationKick {
+		t.Fatalf("expected mallory to be told about the kick")
+	}
+	if _, err := app.JoinRoom(mallory.ID, room.ID); err != nil {
+		t.Fatalf("expected a kicked member to be able to rejoin, got %v", err)
+	}
+
+	malloryConn.Reset()
+	if rr := moderate(app, owner.ID, room.ID, mallory.ID, "ban", ""); rr.Code != http.StatusOK {
+		t.Fatalf("expected ban to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+	stored := app.rooms[room.ID]
+	if app.userInRoom(mallory.ID, room.ID) || contains(stored.ApprovedUserIDs, mallory.ID) || !contains(stored.BannedUserIDs, mallory.ID) {
+		t.Fatalf("expected mallory to be removed, unapproved and banned, got %+v", stored)
+	}
+	evt, ok = findEvent(malloryConn.Events(), EventRoomModeration)
+	if !ok || decodeEventPayload[ModerationEvent](t, evt).Action != ModerationBan {
+		t.Fatalf("expected mallory to be told about the ban")
+	}
+
+	if _, err := app.JoinRoom(mallory.ID, room.ID); err != errBannedFromRoom {
+		t.Fatalf("expected JoinRoom to refuse a banned user, got %v", err)
+	}
+	code, _ := app.CreateJoinCode(room.ID, owner.ID, 0, 0)
+	if rr := redeemJoinCode(app, mallory.ID, code.Code); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected a banned user's join code to be refused, got %d", rr.Code)
+	}
+	if err := app.ApproveJoinRequest(owner.ID, mallory.ID, room.ID); err != errBannedFromRoom {
+		t.Fatalf("expected approval of a banned user to fail, got %v", err)
+	}
+
+	if rr := moderate(app, owner.ID, room.ID, mallory.ID, "unban", ""); rr.Code != http.StatusOK {
+		t.Fatalf("expected unban to succeed, got %d", rr.Code)
+	}
+	if rr := redeemJoinCode(app, mallory.ID, code.Code); rr.Code != http.StatusOK {
+		t.Fatalf("expected an unbanned user to rejoin with a code, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
@@ -4,11 +4,14 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -17,23 +20,71 @@ import (
 
 const defaultHTTPTimeout = 15 * time.Second
 
+// tokenRefreshMargin is how long before expiry the access token is renewed.
+const tokenRefreshMargin = time.Minute
+
+// idempotencyKeyHeader lets the host recognise a retried request it already applied.
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// HostStatusError is returned when the host answered with an unexpected status,
+// or refused a request with an "Error: ..." message.
+type HostStatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *HostStatusError) Error() string {
+	if e.Message != "" {
+		return e.Message
+	}
+	return fmt.Sprintf("server returned status: %d", e.StatusCode)
+}
+
+// Rejected reports whether retrying the same request cannot succeed.
+func (e *HostStatusError) Rejected() bool {
+	if e.Message != "" {
+		return true
+	}
+	return e.StatusCode >= 400 && e.StatusCode < 500 &&
+		e.StatusCode != http.StatusRequestTimeout && e.StatusCode != http.StatusTooManyRequests
+}
+
 // NetworkClient handles communication with the central server
 type NetworkClient struct {
 	serverURL  string
 	httpClient *http.Client
 	connected  bool
-	mu         sync.RWMutex
+	authToken  string
+
+	// Session renewal; refreshMu keeps concurrent requests from spending the
+	// single-use refresh token twice
+	refreshToken string
+	tokenExpiry  time.Time
+	refreshMu    sync.Mutex
+
+	// Room whose operations Subscribe catches up on after a reconnect
+	followRoomID string
+	followHead   string
+
+	// Certificate pin for HTTPS hosts; see verifyHostCertificate
+	pinnedFingerprint string
+	onFirstUse        func(fingerprint string)
+	pinMu             sync.Mutex
+
+	mu sync.RWMutex
 }
 
 // NewNetworkClient creates a new network client
 func NewNetworkClient(serverURL string) *NetworkClient {
-	return &NetworkClient{
+	n := &NetworkClient{
 		serverURL: serverURL,
-		httpClient: &http.Client{
-			Timeout: 0, // rely on per-request contexts for timeouts
-		},
 		connected: false,
 	}
+	n.httpClient = &http.Client{
+		Timeout:   0, // rely on per-request contexts for timeouts
+		Transport: n.newPinningTransport(),
+	}
+	return n
 }
 
 // ConnectToServer establishes connection to the central server with retry logic
@@ -87,6 +138,123 @@ func (n *NetworkClient) ConnectToServer() error {
 	return lastErr
 }
 
+// SetAuthToken sets the bearer token sent with authenticated requests
+func (n *NetworkClient) SetAuthToken(token string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.authToken = token
+}
+
+// SetSession stores the tokens returned when a user is created or a session is
+// refreshed. The access token is then renewed automatically shortly before it expires.
+func (n *NetworkClient) SetSession(tokens *SessionTokens) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.authToken = tokens.Token
+	n.refreshToken = tokens.RefreshToken
+	n.tokenExpiry = time.Unix(tokens.ExpiresAt, 0)
+}
+
+// RefreshSession exchanges the refresh token for new session tokens.
+func (n *NetworkClient) RefreshSession() error {
+	n.mu.RLock()
+	refresh := n.refreshToken
+	n.mu.RUnlock()
+	if refresh == "" {
+		return errors.New("no refresh token")
+	}
+
+	jsonData, err := json.Marshal(RefreshRequest{RefreshToken: refresh})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultHTTPTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", n.serverURL+"/api/auth/refresh", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to refresh session: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &HostStatusError{StatusCode: resp.StatusCode}
+	}
+	var tokens SessionTokens
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	n.SetSession(&tokens)
+	return nil
+}
+
+// Logout revokes the current session on the host and forgets its tokens.
+func (n *NetworkClient) Logout() error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultHTTPTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", n.serverURL+"/api/auth/logout", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	n.setAuthHeader(req)
+
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to log out: %w", err)
+	}
+	resp.Body.Close()
+
+	n.mu.Lock()
+	n.authToken = ""
+	n.refreshToken = ""
+	n.tokenExpiry = time.Time{}
+	n.mu.Unlock()
+
+	if resp.StatusCode != http.StatusOK {
+		return &HostStatusError{StatusCode: resp.StatusCode}
+	}
+	return nil
+}
+
+// accessToken returns the bearer token, refreshing it first when it is about to expire.
+func (n *NetworkClient) accessToken() string {
+	n.mu.RLock()
+	token, refresh, expiry := n.authToken, n.refreshToken, n.tokenExpiry
+	n.mu.RUnlock()
+	if refresh == "" || time.Until(expiry) > tokenRefreshMargin {
+		return token
+	}
+
+	n.refreshMu.Lock()
+	defer n.refreshMu.Unlock()
+	// Another request may have refreshed while we waited
+	n.mu.RLock()
+	token, expiry = n.authToken, n.tokenExpiry
+	n.mu.RUnlock()
+	if time.Until(expiry) > tokenRefreshMargin {
+		return token
+	}
+	if err := n.RefreshSession(); err != nil {
+		fmt.Printf("Failed to refresh session: %v\n", err)
+		return token
+	}
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.authToken
+}
+
+// setAuthHeader attaches the bearer token, if one has been set
+func (n *NetworkClient) setAuthHeader(req *http.Request) {
+	if token := n.accessToken(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
 // IsConnected returns the current connection status
 func (n *NetworkClient) IsConnected() bool {
 	n.mu.RLock()
@@ -181,8 +349,13 @@ func (n *NetworkClient) SendInvite(inviteeID, inviterID, message string) (string
 }
 
 // UploadClipboardItem uploads a clipboard item to the server
-
 func (n *NetworkClient) UploadClipboardItem(item *clip_helper.ClipboardItem, userID, userName string) (*Operation, error) {
+	return n.uploadClipboardItem(item, userID, userName, "")
+}
+
+// uploadClipboardItem uploads a clipboard item; a non-empty idempotencyKey makes
+// retries return the operation created by the first attempt.
+func (n *NetworkClient) uploadClipboardItem(item *clip_helper.ClipboardItem, userID, userName, idempotencyKey string) (*Operation, error) {
 	payload := ClipboardUploadRequest{
 		Item:     *item,
 		UserID:   userID,
@@ -198,6 +371,10 @@ func (n *NetworkClient) UploadClipboardItem(item *clip_helper.ClipboardItem, use
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 	httpReq.Header.Set("Content-Type", "application/json")
+	if idempotencyKey != "" {
+		httpReq.Header.Set(idempotencyKeyHeader, idempotencyKey)
+	}
+	n.setAuthHeader(httpReq)
 	ctx, cancel := context.WithTimeout(context.Background(), defaultHTTPTimeout)
 	defer cancel()
 	httpReq = httpReq.WithContext(ctx)
@@ -210,7 +387,7 @@ func (n *NetworkClient) UploadClipboardItem(item *clip_helper.ClipboardItem, use
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("server returned status: %d", resp.StatusCode)
+		return nil, &HostStatusError{StatusCode: resp.StatusCode}
 	}
 
 	var op Operation
@@ -296,6 +473,7 @@ func (n *NetworkClient) uploadFileData(opID string, data io.Reader, single bool,
 			req.Header.Set(k, v)
 		}
 	}
+	n.setAuthHeader(req)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
 	defer cancel()
@@ -309,12 +487,273 @@ func (n *NetworkClient) uploadFileData(opID string, data io.Reader, single bool,
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("server returned status: %d", resp.StatusCode)
+		return &HostStatusError{StatusCode: resp.StatusCode}
+	}
+
+	return nil
+}
+
+// SendChatMessage posts a chat message to a room. A non-empty idempotencyKey
+// makes retries of the same message deliver it only once.
+func (n *NetworkClient) SendChatMessage(roomID, userID, message, idempotencyKey string) (string, error) {
+	jsonData, err := json.Marshal(ChatMessageRequest{RoomID: roomID, UserID: userID, Message: message})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", n.serverURL+"/api/chat", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if idempotencyKey != "" {
+		req.Header.Set(idempotencyKeyHeader, idempotencyKey)
+	}
+	n.setAuthHeader(req)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultHTTPTimeout)
+	defer cancel()
+	req = req.WithContext(ctx)
+
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		n.setDisconnected()
+		return "", fmt.Errorf("failed to send chat message: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", &HostStatusError{StatusCode: resp.StatusCode}
+	}
+
+	var result APIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode response: %w", err)
+	}
+	if strings.HasPrefix(result.Message, "Error") {
+		return "", &HostStatusError{StatusCode: resp.StatusCode, Message: result.Message}
+	}
+	return result.Message, nil
+}
+
+// VerifyOperations asks the server to recompute a room's hash chain, e.g. after a
+// reconnect, and returns an error if the chain is broken
+func (n *NetworkClient) VerifyOperations(roomID string) (*ChainVerification, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/operations/%s/verify", n.serverURL, url.PathEscape(roomID)), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	n.setAuthHeader(req)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultHTTPTimeout)
+	defer cancel()
+	req = req.WithContext(ctx)
+
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		n.setDisconnected()
+		return nil, fmt.Errorf("failed to verify operations: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server returned status: %d", resp.StatusCode)
+	}
+
+	var report ChainVerification
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	if !report.Valid {
+		return &report, fmt.Errorf("operation chain for room %s broken at %s: %s", roomID, report.BrokenAt, report.Reason)
+	}
+	return &report, nil
+}
+
+// FetchOperationPage fetches one page of a room's operations
+func (n *NetworkClient) FetchOperationPage(roomID string, q OperationPageQuery) (*OperationPage, error) {
+	params := url.Values{}
+	if q.Limit > 0 {
+		params.Set("limit", strconv.Itoa(q.Limit))
+	} else {
+		params.Set("limit", strconv.Itoa(defaultOperationPageSize))
+	}
+	if q.After != "" {
+		params.Set("after", q.After)
+	}
+	if q.Before != "" {
+		params.Set("before", q.Before)
+	}
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/operations/%s?%s", n.serverURL, url.PathEscape(roomID), params.Encode()), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	n.setAuthHeader(req)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultHTTPTimeout)
+	defer cancel()
+	req = req.WithContext(ctx)
+
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		n.setDisconnected()
+		return nil, fmt.Errorf("failed to fetch operations: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server returned status: %d", resp.StatusCode)
+	}
+
+	var page OperationPage
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &page, nil
+}
+
+// OperationIterator walks a room's operations page by page, fetching the next
+// page only when the current one is used up.
+//
+//	it := client.IterateOperations(roomID, "", 0)
+//	for it.Next() {
+//		op := it.Operation()
+//	}
+//	if err := it.Err(); err != nil { ... }
+type OperationIterator struct {
+	client   *NetworkClient
+	roomID   string
+	query    OperationPageQuery
+	page     []*Operation
+	pos      int
+	current  *Operation
+	lastPage bool
+	err      error
+}
+
+// IterateOperations returns an iterator over the room's operations after the
+// given cursor (empty for the start), fetching pageSize operations at a time.
+func (n *NetworkClient) IterateOperations(roomID, after string, pageSize int) *OperationIterator {
+	return &OperationIterator{
+		client: n,
+		roomID: roomID,
+		query:  OperationPageQuery{After: after, Limit: pageSize},
+	}
+}
+
+// Next advances to the next operation, fetching another page if needed.
+func (it *OperationIterator) Next() bool {
+	for it.pos >= len(it.page) {
+		if it.err != nil || it.lastPage {
+			it.current = nil
+			return false
+		}
+		page, err := it.client.FetchOperationPage(it.roomID, it.query)
+		if err != nil {
+			it.err = err
+			continue
+		}
+		it.page, it.pos = page.Operations, 0
+		it.lastPage = !page.HasMore || page.Next == ""
+		it.query.After = page.Next
+	}
+	it.current = it.page[it.pos]
+	it.pos++
+	return true
+}
+
+// Operation returns the operation Next advanced to.
+func (it *OperationIterator) Operation() *Operation {
+	return it.current
+}
+
+// Err returns the error that stopped iteration, if any.
+func (it *OperationIterator) Err() error {
+	return it.err
+}
+
+// PublishPublicKey registers this client's public key for encrypted rooms
+func (n *NetworkClient) PublishPublicKey(publicKey string) error {
+	return n.keyRequest("PUT", "/api/keys", PublicKeyRequest{PublicKey: publicKey}, nil)
+}
+
+// FetchRoomKeys fetches the caller's wrapped keys and the members' public keys for a room
+func (n *NetworkClient) FetchRoomKeys(roomID string) (*RoomKeysResponse, error) {
+	var resp RoomKeysResponse
+	if err := n.keyRequest("GET", "/api/rooms/"+url.PathEscape(roomID)+"/keys", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// UploadRoomKeys stores room keys wrapped for other members of a room
+func (n *NetworkClient) UploadRoomKeys(roomID string, upload RoomKeyUpload) error {
+	return n.keyRequest("POST", "/api/rooms/"+url.PathEscape(roomID)+"/keys", upload, nil)
+}
+
+func (n *NetworkClient) keyRequest(method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		jsonData, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+		reader = bytes.NewReader(jsonData)
+	}
+	req, err := http.NewRequest(method, n.serverURL+path, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
 	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	n.setAuthHeader(req)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultHTTPTimeout)
+	defer cancel()
+	req = req.WithContext(ctx)
+
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		n.setDisconnected()
+		return fmt.Errorf("failed to exchange room keys: %w", err)
+	}
+	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		statusErr := &HostStatusError{StatusCode: resp.StatusCode}
+		if resp.StatusCode < http.StatusInternalServerError {
+			message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+			statusErr.Message = strings.TrimSpace(string(message))
+		}
+		return statusErr
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
 	return nil
 }
 
+// DownloadOperationFile streams the files attached to a clipboard operation; the caller closes the body
+func (n *NetworkClient) DownloadOperationFile(opID string) (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", n.serverURL+"/api/download/"+url.PathEscape(opID), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	n.setAuthHeader(req)
+
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		n.setDisconnected()
+		return nil, fmt.Errorf("failed to download file: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, &HostStatusError{StatusCode: resp.StatusCode}
+	}
+	return resp.Body, nil
+}
+
 // setDisconnected marks the client as disconnected
 func (n *NetworkClient) setDisconnected() {
 	n.mu.Lock()
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -8,6 +9,7 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestNetworkClientConnectAndCreateUser(t *testing.T) {
@@ -79,6 +81,10 @@ func TestNetworkClientUploadsAndPing(t *testing.T) {
 			w.WriteHeader(http.StatusOK)
 			return
 		case strings.HasPrefix(r.URL.Path, "/api/clipboard/op123/zip"):
+			if r.Header.Get("Authorization") != "Bearer upload-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
 			if r.URL.Query().Get("single") == "1" {
 				singleHit++
 			} else {
@@ -93,6 +99,7 @@ func TestNetworkClientUploadsAndPing(t *testing.T) {
 	defer ts.Close()
 
 	nc := NewNetworkClient(ts.URL)
+	nc.SetAuthToken("upload-token")
 
 	if err := nc.UploadZipData("op123", []byte("zipdata")); err != nil {
 		t.Fatalf("UploadZipData error: %v", err)
@@ -127,3 +134,161 @@ func TestNetworkClientUploadsAndPing(t *testing.T) {
 		t.Fatalf("expected both zip and single upload endpoints to be hit; zip %d single %d", zipHit, singleHit)
 	}
 }
+
+// Ensures VerifyOperations sends the token and surfaces a broken chain from the host.
+func TestNetworkClientVerifyOperations(t *testing.T) {
+	app := newTestApp()
+	user := app.CreateUser("Verifier")
+	room := app.CreateRoom("Verified", user.ID)
+	if _, err := app.JoinRoom(user.ID, room.ID); err != nil {
+		t.Fatalf("JoinRoom error: %v", err)
+	}
+	app.SendChatMessage(room.ID, user.ID, "first")
+	app.SendChatMessage(room.ID, user.ID, "second")
+
+	ts := httptest.NewServer(http.HandlerFunc(app.handleOperations))
+	defer ts.Close()
+
+	nc := NewNetworkClient(ts.URL)
+	if _, err := nc.VerifyOperations(room.ID); err == nil {
+		t.Fatalf("expected unauthenticated verify to fail")
+	}
+
+	token, err := app.issueToken(user.ID)
+	if err != nil {
+		t.Fatalf("issueToken error: %v", err)
+	}
+	nc.SetAuthToken(token)
+
+	report, err := nc.VerifyOperations(room.ID)
+	if err != nil || !report.Valid || report.Checked != 2 {
+		t.Fatalf("expected valid chain, got %+v err %v", report, err)
+	}
+
+	ops := app.GetOperations(room.ID, "", "")
+	ops[0].Hash = "corrupted"
+	report, err = nc.VerifyOperations(room.ID)
+	if err == nil || report == nil || report.BrokenAt != ops[0].ID {
+		t.Fatalf("expected broken chain at %s, got %+v err %v", ops[0].ID, report, err)
+	}
+}
+
+// Ensures the operation iterator walks every page and stops after the last one.
+func TestNetworkClientIterateOperations(t *testing.T) {
+	app, room, members := newTestRoom(t, "Archive", "", "Alice")
+	alice := members[0]
+	for i := 0; i < 5; i++ {
+		app.SendChatMessage(room.ID, alice.ID, "paged")
+	}
+
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		app.handleOperations(w, r)
+	}))
+	defer ts.Close()
+
+	token, _ := app.issueToken(alice.ID)
+	nc := NewNetworkClient(ts.URL)
+	nc.SetAuthToken(token)
+
+	var ids []string
+	it := nc.IterateOperations(room.ID, "", 2)
+	for it.Next() {
+		ids = append(ids, it.Operation().ID)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("iterator error: %v", err)
+	}
+	all := app.GetOperations(room.ID, "", "")
+	if len(ids) != len(all) || ids[0] != all[0].ID || ids[len(ids)-1] != all[len(all)-1].ID {
+		t.Fatalf("expected iterator to yield every op in order, got %v", ids)
+	}
+	if requests != 3 {
+		t.Fatalf("expected 3 page requests for 5 ops, got %d", requests)
+	}
+
+	nc.SetAuthToken("")
+	it = nc.IterateOperations(room.ID, "", 2)
+	if it.Next() || it.Err() == nil {
+		t.Fatalf("expected iterator to surface the unauthorized error")
+	}
+}
+
+// Ensures Subscribe delivers typed events and, after the stream drops, reconnects
+// and catches up on operations it never saw live.
+func TestNetworkClientSubscribeCatchUp(t *testing.T) {
+	app, room, members := newTestRoom(t, "Archive", "", "Alice")
+	alice := members[0]
+	bob := app.CreateUser("Bob")
+	if _, err := app.JoinRoom(bob.ID, room.ID); err != nil {
+		t.Fatalf("JoinRoom error: %v", err)
+	}
+	app.SendChatMessage(room.ID, alice.ID, "before subscribing")
+	ops := app.GetOperations(room.ID, "", "")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/sse", app.handleSSE)
+	mux.HandleFunc("/api/operations/", app.handleOperations)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	defer func(min time.Duration) { subscribeRetryMin = min }(subscribeRetryMin)
+	subscribeRetryMin = 10 * time.Millisecond
+
+	token, _ := app.issueToken(alice.ID)
+	nc := NewNetworkClient(ts.URL)
+	if _, err := nc.Subscribe(context.Background()); err == nil {
+		t.Fatalf("expected Subscribe to require a token")
+	}
+	nc.SetAuthToken(token)
+	nc.FollowRoom(room.ID, ops[len(ops)-1].Hash)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := nc.Subscribe(ctx)
+	if err != nil {
+		t.Fatalf("Subscribe error: %v", err)
+	}
+	next := func(eventType SSEEventType) *SSEEvent {
+		t.Helper()
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case evt := <-events:
+				if evt.Type == eventType {
+					return evt
+				}
+			case <-timeout:
+				t.Fatalf("timed out waiting for %s", eventType)
+			}
+		}
+	}
+
+	next(EventConnected)
+	app.SendChatMessage(room.ID, bob.ID, "live")
+	if msg, ok := next(EventChatMessage).Data.(*ChatMessage); !ok || msg.Message != "live" {
+		t.Fatalf("expected typed chat message payload, got %#v", msg)
+	}
+
+	// An operation the stream never announces, then a dropped connection
+	missed := &ChatMessage{ID: "msg_missed", RoomID: room.ID, Message: "while away"}
+	app.historyStore.AddOperation(room.ID, OpAdd, missed.ID, &Item{ID: missed.ID, Type: ItemChat, Data: missed}, bob.ID, bob.Name)
+	ts.CloseClientConnections()
+
+	catchUp, ok := next(EventOperationsCatchUp).Data.(*OperationsCatchUp)
+	if !ok || catchUp.RoomID != room.ID {
+		t.Fatalf("expected catch-up for room %s, got %#v", room.ID, catchUp)
+	}
+	last := catchUp.Operations[len(catchUp.Operations)-1]
+	if last.ItemID != missed.ID {
+		t.Fatalf("expected catch-up to end with the missed operation, got %s", last.ItemID)
+	}
+	if !nc.IsConnected() {
+		t.Fatalf("expected client marked connected after reconnecting")
+	}
+
+	cancel()
+	for range events {
+	}
+}
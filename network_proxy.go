@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+)
+
+// HostAPIURL returns the base URL the UI should send its API requests, event
+// streams and WebSockets to. A plain HTTP server is used directly. An HTTPS
+// server is reached through a loopback proxy that uses the network client's
+// pinned transport, since the webview would otherwise check the host's
+// certificate against the OS trust store instead of the pin.
+func (a *App) HostAPIURL() (string, error) {
+	if a.Mode != "client" || a.networkClient == nil {
+		return "", errors.New("not in client mode")
+	}
+	serverURL := a.networkClient.serverURL
+	if hostKey(serverURL) == "" {
+		return serverURL, nil
+	}
+	return a.startHostProxy()
+}
+
+// startHostProxy starts the loopback proxy to the current server once and
+// returns its URL. The proxy follows later SetServerURL and pin changes.
+func (a *App) startHostProxy() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.hostProxyURL != "" {
+		return a.hostProxyURL, nil
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", fmt.Errorf("failed to start host proxy: %w", err)
+	}
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			target, err := url.Parse(a.networkClient.serverURL)
+			if err != nil {
+				return
+			}
+			pr.SetURL(target)
+		},
+		Transport:     a.networkClient.httpClient.Transport,
+		FlushInterval: -1, // event streams must not be buffered
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			fmt.Printf("Host proxy request %s failed: %v\n", r.URL.Path, err)
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			http.Error(w, "Host unreachable", http.StatusBadGateway)
+		},
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			http.NotFound(w, r)
+			return
+		}
+		proxy.ServeHTTP(w, r)
+	})}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			fmt.Printf("Host proxy stopped: %v\n", err)
+		}
+	}()
+
+	a.hostProxyURL = "http://" + listener.Addr().String()
+	fmt.Printf("Proxying UI traffic to the host through %s\n", a.hostProxyURL)
+	return a.hostProxyURL, nil
+}
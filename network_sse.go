@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EventOperationsCatchUp is synthesized by NetworkClient.Subscribe after each
+// reconnect; it is never sent by the host.
+const EventOperationsCatchUp SSEEventType = "operations_catch_up"
+
+const subscribeBufferSize = 64
+
+// Reconnect backoff bounds for Subscribe; variables so tests can shorten them.
+var (
+	subscribeRetryMin = time.Second
+	subscribeRetryMax = 30 * time.Second
+)
+
+// OperationsCatchUp carries the operations a followed room gained while the
+// event stream was down. It may repeat operations already seen live, so
+// consumers should dedupe by operation ID.
+type OperationsCatchUp struct {
+	RoomID     string       `json:"roomId"`
+	SinceHash  string       `json:"sinceHash"`
+	Operations []*Operation `json:"operations"`
+}
+
+// eventPayloads maps event types to the type their data decodes into. Events not
+// listed keep their data as a generic JSON value.
+var eventPayloads = map[SSEEventType]func() interface{}{
+	EventUserCreated:         func() interface{} { return &User{} },
+	EventUserOnline:          func() interface{} { return &User{} },
+	EventChatMessage:         func() interface{} { return &ChatMessage{} },
+	EventChatMessageEdited:   func() interface{} { return &ChatMessage{} },
+	EventClipboardCopied:     func() interface{} { return &Operation{} },
+	EventClipboardUpdated:    func() interface{} { return &Operation{} },
+	EventClipboardModified:   func() interface{} { return &Operation{} },
+	EventChatMessageDeleted:  func() interface{} { return &ItemRemovedEvent{} },
+	EventClipboardRemoved:    func() interface{} { return &ItemRemovedEvent{} },
+	EventRoomSettingsUpdated: func() interface{} { return &RoomSettings{} },
+	EventRetentionExpired:    func() interface{} { return &RetentionNotice{} },
+	EventResyncRequired:      func() interface{} { return &ResyncRequiredEvent{} },
+	EventTyping:              func() interface{} { return &TypingEvent{} },
+	EventPresence:            func() interface{} { return &PresenceEvent{} },
+}
+
+// FollowRoom makes Subscribe fetch the room's operations after headHash whenever
+// the stream reconnects. An empty roomID stops following.
+func (n *NetworkClient) FollowRoom(roomID, headHash string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.followRoomID = roomID
+	n.followHead = headHash
+}
+
+// Subscribe streams the host's events as typed SSEEvent values until ctx is
+// cancelled, when the channel is closed. Dropped connections are retried with
+// exponential backoff and resume from the last event ID. Every connection starts
+// with an EventOperationsCatchUp for the room set with FollowRoom, if it gained
+// operations since its head.
+func (n *NetworkClient) Subscribe(ctx context.Context) (<-chan *SSEEvent, error) {
+	n.mu.RLock()
+	token := n.authToken
+	n.mu.RUnlock()
+	if token == "" {
+		return nil, errors.New("subscribe requires an auth token")
+	}
+
+	events := make(chan *SSEEvent, subscribeBufferSize)
+	go n.runSubscription(ctx, events)
+	return events, nil
+}
+
+func (n *NetworkClient) runSubscription(ctx context.Context, events chan<- *SSEEvent) {
+	defer close(events)
+
+	var lastEventID uint64
+	delay := subscribeRetryMin
+	for {
+		connected := false
+		err := n.streamEvents(ctx, &lastEventID, func() {
+			connected = true
+			delay = subscribeRetryMin
+			n.catchUp(ctx, events)
+		}, events)
+		if ctx.Err() != nil {
+			return
+		}
+		if !connected {
+			n.setDisconnected()
+		}
+		fmt.Printf("Event stream interrupted, reconnecting in %v: %v\n", delay, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, subscribeRetryMax)
+	}
+}
+
+// streamEvents reads one SSE connection until it fails. onConnect runs once the
+// host has accepted the stream.
+func (n *NetworkClient) streamEvents(ctx context.Context, lastEventID *uint64, onConnect func(), events chan<- *SSEEvent) error {
+	token := n.accessToken()
+	req, err := http.NewRequestWithContext(ctx, "GET", n.serverURL+"/api/sse?token="+url.QueryEscape(token), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if *lastEventID > 0 {
+		req.Header.Set("Last-Event-ID", strconv.FormatUint(*lastEventID, 10))
+	}
+
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to open event stream: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned status: %d", resp.StatusCode)
+	}
+
+	n.mu.Lock()
+	n.connected = true
+	n.mu.Unlock()
+	onConnect()
+
+	reader := bufio.NewReader(resp.Body)
+	var eventType, data strings.Builder
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				return errors.New("event stream closed by host")
+			}
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		switch {
+		case line == "":
+			if data.Len() > 0 {
+				evt, err := decodeStreamEvent(SSEEventType(eventType.String()), data.String())
+				if err != nil {
+					fmt.Printf("Skipping malformed %s event: %v\n", eventType.String(), err)
+				} else {
+					if evt.ID > 0 {
+						*lastEventID = evt.ID
+					}
+					n.advanceFollowHead(evt)
+					select {
+					case events <- evt:
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				}
+			}
+			eventType.Reset()
+			data.Reset()
+		case strings.HasPrefix(line, "event:"):
+			eventType.WriteString(strings.TrimSpace(strings.TrimPrefix(line, "event:")))
+		case strings.HasPrefix(line, "data:"):
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+		// id: lines repeat the envelope's ID and comments need no handling
+	}
+}
+
+// decodeStreamEvent parses an event envelope and types its data.
+func decodeStreamEvent(eventType SSEEventType, data string) (*SSEEvent, error) {
+	var envelope struct {
+		ID        uint64          `json:"id"`
+		Type      SSEEventType    `json:"type"`
+		Data      json.RawMessage `json:"data"`
+		Timestamp int64           `json:"timestamp"`
+	}
+	if err := json.Unmarshal([]byte(data), &envelope); err != nil {
+		return nil, err
+	}
+	if envelope.Type == "" {
+		envelope.Type = eventType
+	}
+
+	evt := &SSEEvent{ID: envelope.ID, Type: envelope.Type, Timestamp: envelope.Timestamp}
+	if newPayload, ok := eventPayloads[envelope.Type]; ok {
+		payload := newPayload()
+		if err := json.Unmarshal(envelope.Data, payload); err != nil {
+			return nil, err
+		}
+		evt.Data = payload
+	} else if len(envelope.Data) > 0 {
+		var payload interface{}
+		if err := json.Unmarshal(envelope.Data, &payload); err != nil {
+			return nil, err
+		}
+		evt.Data = payload
+	}
+	return evt, nil
+}
+
+// advanceFollowHead moves the followed room's head along when a live operation
+// extends it, so the next catch-up does not fetch it again.
+func (n *NetworkClient) advanceFollowHead(evt *SSEEvent) {
+	op, ok := evt.Data.(*Operation)
+	if !ok || op.Hash == "" {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.followRoomID != "" && op.ParentHash == n.followHead {
+		n.followHead = op.Hash
+	}
+}
+
+// catchUp fetches what the followed room gained since its head and emits it.
+func (n *NetworkClient) catchUp(ctx context.Context, events chan<- *SSEEvent) {
+	n.mu.RLock()
+	roomID, head := n.followRoomID, n.followHead
+	n.mu.RUnlock()
+	if roomID == "" || head == "" {
+		return
+	}
+
+	ops, err := n.FetchOperationsSince(roomID, head)
+	if err != nil {
+		fmt.Printf("Catch-up for room %s failed: %v\n", roomID, err)
+		return
+	}
+	if len(ops) == 0 {
+		return
+	}
+
+	n.mu.Lock()
+	if n.followRoomID == roomID && n.followHead == head {
+		n.followHead = ops[len(ops)-1].Hash
+	}
+	n.mu.Unlock()
+
+	evt := &SSEEvent{
+		Type:      EventOperationsCatchUp,
+		Data:      &OperationsCatchUp{RoomID: roomID, SinceHash: head, Operations: ops},
+		Timestamp: time.Now().Unix(),
+	}
+	select {
+	case events <- evt:
+	case <-ctx.Done():
+	}
+}
+
+// FetchOperationsSince fetches a room's operations after the one with sinceHash.
+// If that operation was compacted away the host starts from its snapshot.
+func (n *NetworkClient) FetchOperationsSince(roomID, sinceHash string) ([]*Operation, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/operations/%s?sinceHash=%s", n.serverURL, url.PathEscape(roomID), url.QueryEscape(sinceHash)), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	n.setAuthHeader(req)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultHTTPTimeout)
+	defer cancel()
+	req = req.WithContext(ctx)
+
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		n.setDisconnected()
+		return nil, fmt.Errorf("failed to fetch operations: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server returned status: %d", resp.StatusCode)
+	}
+
+	var ops []*Operation
+	if err := json.NewDecoder(resp.Body).Decode(&ops); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return ops, nil
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const knownHostsFileName = "known_hosts.json"
+
+var errFingerprintMismatch = errors.New("host certificate does not match the pinned fingerprint")
+
+// newPinningTransport returns a transport that leaves certificate checks to
+// verifyHostCertificate, so self-signed hosts can be trusted by fingerprint.
+func (n *NetworkClient) newPinningTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: true, // verification happens in VerifyConnection
+		VerifyConnection:   n.verifyHostCertificate,
+	}
+	return transport
+}
+
+// PinFingerprint makes the client accept only the host certificate with the
+// given fingerprint. With no fingerprint, a certificate a trusted CA vouches for
+// is accepted as usual and any other one is pinned on first use, after which
+// onFirstUse is told about it.
+func (n *NetworkClient) PinFingerprint(fingerprint string, onFirstUse func(string)) {
+	n.pinMu.Lock()
+	defer n.pinMu.Unlock()
+	n.pinnedFingerprint = fingerprint
+	n.onFirstUse = onFirstUse
+	if transport, ok := n.httpClient.Transport.(*http.Transport); ok {
+		// Connections made under the previous pin must not be reused
+		transport.CloseIdleConnections()
+	}
+}
+
+// PinnedFingerprint returns the host certificate fingerprint the client trusts.
+func (n *NetworkClient) PinnedFingerprint() string {
+	n.pinMu.Lock()
+	defer n.pinMu.Unlock()
+	return n.pinnedFingerprint
+}
+
+func (n *NetworkClient) verifyHostCertificate(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("host presented no certificate")
+	}
+	leaf := cs.PeerCertificates[0]
+	seen := certFingerprint(leaf.Raw)
+
+	n.pinMu.Lock()
+	pinned := n.pinnedFingerprint
+	n.pinMu.Unlock()
+	if pinned != "" {
+		if seen != pinned {
+			return fmt.Errorf("%w: got %s, expected %s", errFingerprintMismatch, seen, pinned)
+		}
+		return nil
+	}
+
+	// A certificate a trusted CA vouches for, such as a tunnel's, needs no pin
+	opts := x509.VerifyOptions{DNSName: cs.ServerName, Intermediates: x509.NewCertPool()}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	if _, err := leaf.Verify(opts); err == nil {
+		return nil
+	}
+
+	n.pinMu.Lock()
+	if n.pinnedFingerprint != "" && n.pinnedFingerprint != seen {
+		// Another connection pinned a different certificate first
+		pinned = n.pinnedFingerprint
+		n.pinMu.Unlock()
+		return fmt.Errorf("%w: got %s, expected %s", errFingerprintMismatch, seen, pinned)
+	}
+	n.pinnedFingerprint = seen
+	onFirstUse := n.onFirstUse
+	n.pinMu.Unlock()
+
+	fmt.Printf("Trusting host certificate %s on first use\n", seen)
+	if onFirstUse != nil {
+		onFirstUse(seen)
+	}
+	return nil
+}
+
+// knownHosts remembers the certificate fingerprint trusted for each host, keyed
+// by host:port, in the client's data directory.
+type knownHosts struct {
+	path string
+
+	mu   sync.Mutex
+	pins map[string]string
+}
+
+// loadKnownHosts reads the pins kept at path. Without a path pins last until the
+// app exits.
+func loadKnownHosts(path string) *knownHosts {
+	k := &knownHosts{path: path, pins: make(map[string]string)}
+	if path == "" {
+		return k
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Printf("Failed to read known hosts: %v\n", err)
+		}
+		return k
+	}
+	if err := json.Unmarshal(data, &k.pins); err != nil {
+		fmt.Printf("Failed to parse known hosts: %v\n", err)
+		k.pins = make(map[string]string)
+	}
+	return k
+}
+
+func (k *knownHosts) get(host string) string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.pins[host]
+}
+
+// remember pins fingerprint for host unless the host is already pinned. It
+// returns the fingerprint the host is pinned to afterwards.
+func (k *knownHosts) remember(host, fingerprint string) string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if existing, ok := k.pins[host]; ok {
+		return existing
+	}
+	k.pins[host] = fingerprint
+	k.saveLocked()
+	return fingerprint
+}
+
+func (k *knownHosts) forget(host string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.pins[host]; !ok {
+		return
+	}
+	delete(k.pins, host)
+	k.saveLocked()
+}
+
+func (k *knownHosts) saveLocked() {
+	if k.path == "" {
+		return
+	}
+	data, err := json.MarshalIndent(k.pins, "", "  ")
+	if err != nil {
+		fmt.Printf("Failed to encode known hosts: %v\n", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(k.path), 0o700); err != nil {
+		fmt.Printf("Failed to create known hosts dir: %v\n", err)
+		return
+	}
+	tmp := k.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		fmt.Printf("Failed to write known hosts: %v\n", err)
+		return
+	}
+	if err := os.Rename(tmp, k.path); err != nil {
+		fmt.Printf("Failed to replace known hosts: %v\n", err)
+	}
+}
+
+// hostKey returns the host:port a server URL's certificate pin is kept under, or
+// "" when the URL is not served over HTTPS.
+func hostKey(serverURL string) string {
+	u, err := url.Parse(serverURL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return ""
+	}
+	host := strings.ToLower(u.Host)
+	if u.Port() == "" {
+		host += ":443"
+	}
+	return host
+}
+
+// hostPins returns the client's certificate pins, loading them on first use.
+func (a *App) hostPins() *knownHosts {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.knownHosts == nil {
+		path := ""
+		if a.dataDir != "" {
+			path = filepath.Join(a.dataDir, knownHostsFileName)
+		}
+		a.knownHosts = loadKnownHosts(path)
+	}
+	return a.knownHosts
+}
+
+// pinServerCertificate points the network client at the pin kept for serverURL
+// and records the certificate it trusts on first use.
+func (a *App) pinServerCertificate(serverURL string) {
+	host := hostKey(serverURL)
+	if host == "" {
+		a.networkClient.PinFingerprint("", nil)
+		return
+	}
+	pins := a.hostPins()
+	a.networkClient.PinFingerprint(pins.get(host), func(fingerprint string) {
+		pins.remember(host, fingerprint)
+	})
+}
+
+// ForgetServerFingerprint drops the certificate pinned for the current server,
+// e.g. after its host generated a new certificate. The next connection pins
+// whatever certificate the server presents.
+func (a *App) ForgetServerFingerprint() error {
+	if a.Mode != "client" || a.networkClient == nil {
+		return errors.New("not in client mode")
+	}
+	serverURL := a.networkClient.serverURL
+	host := hostKey(serverURL)
+	if host == "" {
+		return errors.New("the current server does not use HTTPS")
+	}
+	a.hostPins().forget(host)
+	a.pinServerCertificate(serverURL)
+	fmt.Printf("Forgot certificate pinned for %s\n", host)
+	return nil
+}
@@ -0,0 +1,531 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"GOproject/clip_helper"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// OutboxKind is what an outbox entry delivers.
+type OutboxKind string
+
+const (
+	OutboxChat       OutboxKind = "chat"
+	OutboxClipboard  OutboxKind = "clipboard"
+	OutboxFileUpload OutboxKind = "file_upload"
+)
+
+// OutboxState is an entry's delivery state.
+type OutboxState string
+
+const (
+	OutboxQueued    OutboxState = "queued"
+	OutboxSending   OutboxState = "sending"
+	OutboxDelivered OutboxState = "delivered"
+	OutboxFailed    OutboxState = "failed" // the host refused it; kept until retried or discarded
+)
+
+const (
+	outboxDirName             = "outbox"
+	outboxFileName            = "outbox.json"
+	outboxDrainInterval       = 5 * time.Second
+	maxDeliveredOutboxEntries = 50
+
+	wailsEventOutboxChanged = "outbox:changed"
+)
+
+// OutboxEntry is a client-mode request waiting for, or delivered to, the host.
+// Its ID doubles as the Idempotency-Key, so a retry after a lost response is
+// applied only once.
+type OutboxEntry struct {
+	ID          string                     `json:"id"`
+	Kind        OutboxKind                 `json:"kind"`
+	State       OutboxState                `json:"state"`
+	RoomID      string                     `json:"roomId,omitempty"`
+	UserID      string                     `json:"userId"`
+	UserName    string                     `json:"userName,omitempty"`
+	Message     string                     `json:"message,omitempty"`
+	Clipboard   *clip_helper.ClipboardItem `json:"clipboard,omitempty"`
+	Upload      *OutboxUpload              `json:"upload,omitempty"`
+	OperationID string                     `json:"operationId,omitempty"` // host operation created (clipboard) or targeted (file upload)
+	Attempts    int                        `json:"attempts"`
+	LastError   string                     `json:"lastError,omitempty"`
+	CreatedAt   int64                      `json:"createdAt"`
+	DeliveredAt int64                      `json:"deliveredAt,omitempty"`
+}
+
+// OutboxUpload describes a file waiting to be attached to a clipboard operation.
+type OutboxUpload struct {
+	DependsOn string `json:"dependsOn,omitempty"` // clipboard entry whose operation receives the file
+	FilePath  string `json:"filePath"`
+	Single    bool   `json:"single"`
+	Name      string `json:"name,omitempty"`
+	Mime      string `json:"mime,omitempty"`
+	Size      int64  `json:"size,omitempty"`
+	Thumb     string `json:"thumb,omitempty"`
+}
+
+// Outbox is an ordered, optionally persistent queue of requests for the host.
+type Outbox struct {
+	dir      string // empty keeps the outbox in memory only
+	entries  []*OutboxEntry
+	onChange func([]OutboxEntry)
+	mu       sync.Mutex
+	drainMu  sync.Mutex
+}
+
+// newOutbox loads the outbox persisted in dir, if any.
+func newOutbox(dir string) *Outbox {
+	ob := &Outbox{dir: dir}
+	if dir == "" {
+		return ob
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		fmt.Printf("Failed to create outbox dir %s: %v\n", dir, err)
+		ob.dir = ""
+		return ob
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, outboxFileName))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Printf("Failed to read outbox: %v\n", err)
+		}
+		return ob
+	}
+	if err := json.Unmarshal(data, &ob.entries); err != nil {
+		fmt.Printf("Failed to decode outbox: %v\n", err)
+		return ob
+	}
+	for _, e := range ob.entries {
+		// A delivery interrupted by shutdown is retried; the idempotency key dedupes it
+		if e.State == OutboxSending {
+			e.State = OutboxQueued
+		}
+	}
+	return ob
+}
+
+func newOutboxID() string {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("ob_%d", time.Now().UnixNano())
+	}
+	return "ob_" + hex.EncodeToString(buf)
+}
+
+// enqueue appends e as a queued entry and returns it.
+func (ob *Outbox) enqueue(e *OutboxEntry) *OutboxEntry {
+	ob.mu.Lock()
+	if e.ID == "" {
+		e.ID = newOutboxID()
+	}
+	e.State = OutboxQueued
+	e.CreatedAt = time.Now().Unix()
+	ob.entries = append(ob.entries, e)
+	ob.changedLocked()
+	ob.mu.Unlock()
+
+	fmt.Printf("Queued %s %s for delivery when the host is reachable\n", e.Kind, e.ID)
+	return e
+}
+
+// Pending counts entries still waiting to be delivered.
+func (ob *Outbox) Pending() int {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	count := 0
+	for _, e := range ob.entries {
+		if e.State == OutboxQueued || e.State == OutboxSending {
+			count++
+		}
+	}
+	return count
+}
+
+// has reports whether an entry with id is still waiting to be delivered.
+func (ob *Outbox) has(id string) bool {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	e := ob.findLocked(id)
+	return e != nil && e.State != OutboxDelivered
+}
+
+// Entries returns a copy of every entry in delivery order.
+func (ob *Outbox) Entries() []OutboxEntry {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	return ob.snapshotLocked()
+}
+
+func (ob *Outbox) snapshotLocked() []OutboxEntry {
+	out := make([]OutboxEntry, len(ob.entries))
+	for i, e := range ob.entries {
+		out[i] = *e
+	}
+	return out
+}
+
+// Retry requeues a failed entry in place.
+func (ob *Outbox) Retry(id string) error {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	e := ob.findLocked(id)
+	if e == nil {
+		return fmt.Errorf("outbox entry %s not found", id)
+	}
+	if e.State != OutboxFailed {
+		return fmt.Errorf("outbox entry %s is %s, not failed", id, e.State)
+	}
+	e.State = OutboxQueued
+	e.LastError = ""
+	ob.changedLocked()
+	return nil
+}
+
+// Discard removes an entry that is not being delivered right now, together with
+// any file uploads that depend on it.
+func (ob *Outbox) Discard(id string) error {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	e := ob.findLocked(id)
+	if e == nil {
+		return fmt.Errorf("outbox entry %s not found", id)
+	}
+	if e.State == OutboxSending {
+		return fmt.Errorf("outbox entry %s is being delivered", id)
+	}
+	kept := ob.entries[:0]
+	for _, other := range ob.entries {
+		if other == e || (other.Upload != nil && other.Upload.DependsOn == id && other.State != OutboxDelivered) {
+			ob.removeSpoolLocked(other)
+			continue
+		}
+		kept = append(kept, other)
+	}
+	ob.entries = kept
+	ob.changedLocked()
+	return nil
+}
+
+func (ob *Outbox) findLocked(id string) *OutboxEntry {
+	for _, e := range ob.entries {
+		if e.ID == id {
+			return e
+		}
+	}
+	return nil
+}
+
+// drain delivers queued entries in order. It stops at the first entry that could
+// not reach the host, so later entries never overtake it; entries the host
+// refused are marked failed and skipped. Concurrent drains run one after another.
+func (ob *Outbox) drain(deliver func(*OutboxEntry) error) {
+	ob.drainMu.Lock()
+	defer ob.drainMu.Unlock()
+
+	for {
+		e, blocked := ob.nextDeliverable()
+		if e == nil || blocked {
+			return
+		}
+
+		err := deliver(e)
+
+		ob.mu.Lock()
+		e.Attempts++
+		switch {
+		case err == nil:
+			e.State = OutboxDelivered
+			e.DeliveredAt = time.Now().Unix()
+			e.LastError = ""
+			ob.removeSpoolLocked(e)
+			ob.pruneLocked()
+		case isRejectedByHost(err):
+			e.State = OutboxFailed
+			e.LastError = err.Error()
+			ob.failDependentsLocked(e)
+		default:
+			e.State = OutboxQueued
+			e.LastError = err.Error()
+		}
+		ob.changedLocked()
+		ob.mu.Unlock()
+
+		if err != nil && !isRejectedByHost(err) {
+			fmt.Printf("Outbox delivery of %s paused: %v\n", e.ID, err)
+			return
+		}
+	}
+}
+
+// nextDeliverable marks the first queued entry as sending and resolves a file
+// upload's target operation. blocked is true when that upload still waits for
+// its clipboard entry.
+func (ob *Outbox) nextDeliverable() (*OutboxEntry, bool) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	for _, e := range ob.entries {
+		if e.State != OutboxQueued {
+			continue
+		}
+		if e.Upload != nil && e.Upload.DependsOn != "" && e.OperationID == "" {
+			dep := ob.findLocked(e.Upload.DependsOn)
+			if dep == nil || dep.State != OutboxDelivered {
+				return nil, true
+			}
+			e.OperationID = dep.OperationID
+		}
+		e.State = OutboxSending
+		ob.changedLocked()
+		return e, false
+	}
+	return nil, false
+}
+
+func (ob *Outbox) failDependentsLocked(failed *OutboxEntry) {
+	for _, e := range ob.entries {
+		if e.Upload != nil && e.Upload.DependsOn == failed.ID && e.State == OutboxQueued {
+			e.State = OutboxFailed
+			e.LastError = "clipboard item was refused by the host"
+		}
+	}
+}
+
+// pruneLocked forgets the oldest delivered entries beyond maxDeliveredOutboxEntries.
+func (ob *Outbox) pruneLocked() {
+	delivered := 0
+	for _, e := range ob.entries {
+		if e.State == OutboxDelivered {
+			delivered++
+		}
+	}
+	kept := ob.entries[:0]
+	for _, e := range ob.entries {
+		if e.State == OutboxDelivered && delivered > maxDeliveredOutboxEntries && !ob.hasDependentLocked(e.ID) {
+			delivered--
+			continue
+		}
+		kept = append(kept, e)
+	}
+	ob.entries = kept
+}
+
+func (ob *Outbox) hasDependentLocked(id string) bool {
+	for _, e := range ob.entries {
+		if e.Upload != nil && e.Upload.DependsOn == id && e.State != OutboxDelivered {
+			return true
+		}
+	}
+	return false
+}
+
+// spoolUpload copies a pending upload's file into the outbox directory so it
+// survives the temp directory being cleared on restart.
+func (ob *Outbox) spoolUpload(id string, upload *OutboxUpload) error {
+	if ob.dir == "" {
+		return nil
+	}
+	dest := filepath.Join(ob.dir, id+"_"+filepath.Base(upload.FilePath))
+	if err := os.Link(upload.FilePath, dest); err != nil {
+		if err := copyFile(upload.FilePath, dest); err != nil {
+			return err
+		}
+	}
+	upload.FilePath = dest
+	return nil
+}
+
+func (ob *Outbox) removeSpoolLocked(e *OutboxEntry) {
+	if ob.dir == "" || e.Upload == nil || filepath.Dir(e.Upload.FilePath) != ob.dir {
+		return
+	}
+	if err := os.Remove(e.Upload.FilePath); err != nil && !os.IsNotExist(err) {
+		fmt.Printf("Failed to remove spooled upload %s: %v\n", e.Upload.FilePath, err)
+	}
+}
+
+// changedLocked persists the outbox and notifies the UI.
+func (ob *Outbox) changedLocked() {
+	if ob.dir != "" {
+		if data, err := json.MarshalIndent(ob.entries, "", "  "); err != nil {
+			fmt.Printf("Failed to encode outbox: %v\n", err)
+		} else {
+			path := filepath.Join(ob.dir, outboxFileName)
+			tmp := path + ".tmp"
+			if err := os.WriteFile(tmp, data, 0o644); err != nil {
+				fmt.Printf("Failed to write outbox: %v\n", err)
+			} else if err := os.Rename(tmp, path); err != nil {
+				fmt.Printf("Failed to replace outbox: %v\n", err)
+			}
+		}
+	}
+	if ob.onChange != nil {
+		ob.onChange(ob.snapshotLocked())
+	}
+}
+
+func isRejectedByHost(err error) bool {
+	var statusErr *HostStatusError
+	return errors.As(err, &statusErr) && statusErr.Rejected()
+}
+
+// initOutbox sets up the client-mode outbox and starts draining it whenever the host answers a ping.
+func (a *App) initOutbox(ctx context.Context) {
+	dir := ""
+	if a.dataDir != "" {
+		dir = filepath.Join(a.dataDir, outboxDirName)
+	}
+	a.outbox = newOutbox(dir)
+	a.outbox.onChange = func(entries []OutboxEntry) {
+		if a.ctx != nil {
+			runtime.EventsEmit(a.ctx, wailsEventOutboxChanged, entries)
+		}
+	}
+	if pending := a.outbox.Pending(); pending > 0 {
+		fmt.Printf("Outbox restored with %d undelivered items\n", pending)
+	}
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	go func() {
+		ticker := time.NewTicker(outboxDrainInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if a.outbox.Pending() > 0 && a.networkClient.Ping() == nil {
+					a.drainOutbox()
+				}
+			}
+		}
+	}()
+}
+
+func (a *App) drainOutbox() {
+	a.outbox.drain(a.deliverOutboxEntry)
+}
+
+// deliverOutboxEntry sends one entry to the host.
+func (a *App) deliverOutboxEntry(e *OutboxEntry) error {
+	switch e.Kind {
+	case OutboxChat:
+		_, err := a.networkClient.SendChatMessage(e.RoomID, e.UserID, e.Message, e.ID)
+		return err
+	case OutboxClipboard:
+		op, err := a.uploadClipboard(e.Clipboard, e.UserID, e.UserName, e.ID)
+		if err != nil {
+			return err
+		}
+		a.outbox.mu.Lock()
+		e.OperationID = op.ID
+		a.outbox.mu.Unlock()
+		return nil
+	case OutboxFileUpload:
+		return a.uploadSharedFile(e.OperationID, *e.Upload)
+	default:
+		return &HostStatusError{Message: fmt.Sprintf("Error: unknown outbox entry kind %q", e.Kind)}
+	}
+}
+
+// queueClipboardUpload queues a clipboard share that could not reach the host.
+// The item is copied because archiving keeps updating the original.
+func (a *App) queueClipboardUpload(item *clip_helper.ClipboardItem) *OutboxEntry {
+	a.mu.RLock()
+	copied := *item
+	a.mu.RUnlock()
+	return a.outbox.enqueue(&OutboxEntry{
+		Kind:      OutboxClipboard,
+		UserID:    a.currentUser.ID,
+		UserName:  a.currentUser.Name,
+		Clipboard: &copied,
+	})
+}
+
+// queueFileUpload queues a file for a clipboard operation: operationID when the
+// host already created it, otherwise the queued clipboard entry dependsOn.
+func (a *App) queueFileUpload(operationID, dependsOn string, upload OutboxUpload) {
+	if a.outbox == nil {
+		return
+	}
+	upload.DependsOn = dependsOn
+	entry := &OutboxEntry{
+		ID:          newOutboxID(),
+		Kind:        OutboxFileUpload,
+		UserID:      a.currentUser.ID,
+		UserName:    a.currentUser.Name,
+		Upload:      &upload,
+		OperationID: operationID,
+	}
+	if err := a.outbox.spoolUpload(entry.ID, entry.Upload); err != nil {
+		fmt.Printf("Failed to spool %s for later upload: %v\n", upload.FilePath, err)
+	}
+	a.outbox.enqueue(entry)
+}
+
+// PostChatMessage queues a chat message for the host in client mode and starts
+// delivering it. Progress is reported through GetOutbox and the outbox:changed event.
+func (a *App) PostChatMessage(roomID, message string) (*OutboxEntry, error) {
+	if a.Mode != "client" || a.outbox == nil || a.currentUser == nil {
+		return nil, errors.New("chat posting through the outbox is only available in client mode")
+	}
+	entry := a.outbox.enqueue(&OutboxEntry{
+		Kind:     OutboxChat,
+		RoomID:   roomID,
+		UserID:   a.currentUser.ID,
+		UserName: a.currentUser.Name,
+		Message:  message,
+	})
+	go a.drainOutbox()
+
+	a.outbox.mu.Lock()
+	defer a.outbox.mu.Unlock()
+	copied := *entry
+	return &copied, nil
+}
+
+// GetOutbox lists queued, failed and recently delivered outbox entries in order.
+func (a *App) GetOutbox() []OutboxEntry {
+	if a.outbox == nil {
+		return []OutboxEntry{}
+	}
+	return a.outbox.Entries()
+}
+
+// RetryOutboxEntry requeues an entry the host refused and tries to deliver it.
+func (a *App) RetryOutboxEntry(id string) error {
+	if a.outbox == nil {
+		return errors.New("outbox is only available in client mode")
+	}
+	if err := a.outbox.Retry(id); err != nil {
+		return err
+	}
+	go a.drainOutbox()
+	return nil
+}
+
+// DiscardOutboxEntry drops an undelivered entry.
+func (a *App) DiscardOutboxEntry(id string) error {
+	if a.outbox == nil {
+		return errors.New("outbox is only available in client mode")
+	}
+	return a.outbox.Discard(id)
+}
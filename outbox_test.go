@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"GOproject/clip_helper"
+)
+
+// Ensures a chat POST retried with the same Idempotency-Key is applied once and
+// answered with the original response, even while the first is still running.
+func TestChatIdempotencyKey(t *testing.T) {
+	app, room, members := newTestRoom(t, "Archive", "", "Alice")
+	alice := members[0]
+	token, _ := app.issueToken(alice.ID)
+
+	post := func(key string) *httptest.ResponseRecorder {
+		body := []byte(`{"roomId":"` + room.ID + `","userId":"` + alice.ID + `","message":"once"}`)
+		req := httptest.NewRequest(http.MethodPost, "/api/chat", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set(idempotencyKeyHeader, key)
+		rr := httptest.NewRecorder()
+		app.handleChat(rr, req)
+		return rr
+	}
+
+	first := post("ob_same")
+	second := post("ob_same")
+	if first.Code != http.StatusOK || first.Body.String() != second.Body.String() {
+		t.Fatalf("expected identical replayed response, got %q and %q", first.Body.String(), second.Body.String())
+	}
+	if got := len(app.GetChatHistory(room.ID)); got != 1 {
+		t.Fatalf("expected one message after a retried POST, got %d", got)
+	}
+
+	post("ob_other")
+	if got := len(app.GetChatHistory(room.ID)); got != 2 {
+		t.Fatalf("expected a new key to post again, got %d messages", got)
+	}
+
+	// Retries arriving while the key is held wait for the first response, status
+	// included, or apply the request themselves if the key is given up.
+	held := "chat|" + alice.ID + "|ob_held"
+	if app.idempotency.reserve(held) != nil {
+		t.Fatalf("expected an unused key to be reserved")
+	}
+	waiting := make(chan *httptest.ResponseRecorder)
+	go func() { waiting <- post("ob_held") }()
+	app.idempotency.put(held, http.StatusCreated, []byte(`{"message":"stored"}`))
+	if rr := <-waiting; rr.Code != http.StatusCreated || rr.Body.String() != `{"message":"stored"}` {
+		t.Fatalf("expected the stored response for a held key, got %d %q", rr.Code, rr.Body.String())
+	}
+
+	released := "chat|" + alice.ID + "|ob_released"
+	app.idempotency.reserve(released)
+	go func() { waiting <- post("ob_released") }()
+	app.idempotency.release(released)
+	if rr := <-waiting; rr.Code != http.StatusOK {
+		t.Fatalf("expected a released key to be applied, got %d", rr.Code)
+	}
+	if got := len(app.GetChatHistory(room.ID)); got != 3 {
+		t.Fatalf("expected only the released key to post, got %d messages", got)
+	}
+}
+
+// Verifies queued entries survive a restart and that a sending entry is requeued.
+func TestOutboxPersistence(t *testing.T) {
+	dir := t.TempDir()
+	ob := newOutbox(dir)
+	chat := ob.enqueue(&OutboxEntry{Kind: OutboxChat, RoomID: "room", UserID: "u", Message: "hello"})
+	ob.enqueue(&OutboxEntry{Kind: OutboxClipboard, UserID: "u", Clipboard: &clip_helper.ClipboardItem{Type: clip_helper.ClipboardText, Text: "copied"}})
+	ob.nextDeliverable()
+
+	reloaded := newOutbox(dir)
+	entries := reloaded.Entries()
+	if len(entries) != 2 || entries[0].ID != chat.ID || entries[1].Clipboard == nil || entries[1].Clipboard.Text != "copied" {
+		t.Fatalf("unexpected reloaded outbox: %+v", entries)
+	}
+	if entries[0].State != OutboxQueued || reloaded.Pending() != 2 {
+		t.Fatalf("expected the interrupted delivery to be queued again, got %s", entries[0].State)
+	}
+}
+
+// Exercises ordered draining: an unreachable host pauses the queue, a refusal
+// fails only that entry, and a file upload waits for its clipboard operation.
+func TestOutboxDrainOrder(t *testing.T) {
+	ob := newOutbox("")
+	first := ob.enqueue(&OutboxEntry{Kind: OutboxChat, Message: "first"})
+	refused := ob.enqueue(&OutboxEntry{Kind: OutboxChat, Message: "refused"})
+	clip := ob.enqueue(&OutboxEntry{Kind: OutboxClipboard, Clipboard: &clip_helper.ClipboardItem{Type: clip_helper.ClipboardFile}})
+	upload := ob.enqueue(&OutboxEntry{Kind: OutboxFileUpload, Upload: &OutboxUpload{DependsOn: clip.ID, FilePath: "archive.tar"}})
+
+	var delivered []string
+	offline := true
+	deliver := func(e *OutboxEntry) error {
+		if offline {
+			return errors.New("connection refused")
+		}
+		switch e.ID {
+		case refused.ID:
+			return &HostStatusError{StatusCode: http.StatusForbidden}
+		case clip.ID:
+			e.OperationID = "op_42"
+		case upload.ID:
+			if e.OperationID != "op_42" {
+				t.Fatalf("expected upload to target the clipboard operation, got %q", e.OperationID)
+			}
+		}
+		delivered = append(delivered, e.ID)
+		return nil
+	}
+
+	ob.drain(deliver)
+	if len(delivered) != 0 || ob.Pending() != 4 || ob.Entries()[0].Attempts != 1 {
+		t.Fatalf("expected offline drain to stop at the first entry, delivered %v", delivered)
+	}
+
+	offline = false
+	ob.drain(deliver)
+	want := []string{first.ID, clip.ID, upload.ID}
+	if len(delivered) != len(want) {
+		t.Fatalf("expected delivery order %v, got %v", want, delivered)
+	}
+	for i := range want {
+		if delivered[i] != want[i] {
+			t.Fatalf("expected delivery order %v, got %v", want, delivered)
+		}
+	}
+	if got := ob.Entries()[1]; got.State != OutboxFailed || got.LastError == "" {
+		t.Fatalf("expected refused entry to be failed, got %+v", got)
+	}
+
+	if err := ob.Retry(refused.ID); err != nil {
+		t.Fatalf("Retry error: %v", err)
+	}
+	if err := ob.Discard(refused.ID); err != nil || ob.Pending() != 0 {
+		t.Fatalf("expected discard to empty the queue, err %v pending %d", err, ob.Pending())
+	}
+}
+
+// Ensures a client-mode chat message posted while the host is down is delivered
+// exactly once after the host answers again.
+func TestOutboxDeliversChatAfterOutage(t *testing.T) {
+	host, room, members := newTestRoom(t, "Archive", "", "Alice")
+	alice := members[0]
+	token, _ := host.issueToken(alice.ID)
+
+	var down atomic.Bool
+	down.Store(true)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		host.handleChat(w, r)
+	}))
+	defer ts.Close()
+
+	client := newTestApp()
+	client.Mode = "client"
+	client.currentUser = alice
+	client.networkClient = NewNetworkClient(ts.URL)
+	client.networkClient.SetAuthToken(token)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client.initOutbox(ctx)
+
+	entry, err := client.PostChatMessage(room.ID, "sent offline")
+	if err != nil || entry.State != OutboxQueued {
+		t.Fatalf("expected queued entry, got %+v err %v", entry, err)
+	}
+	client.drainOutbox()
+	if len(host.GetChatHistory(room.ID)) != 0 || client.outbox.Pending() != 1 {
+		t.Fatalf("expected the message to stay queued while the host is down")
+	}
+
+	down.Store(false)
+	client.drainOutbox()
+	client.drainOutbox()
+	history := host.GetChatHistory(room.ID)
+	if len(history) != 1 || history[0].Message != "sent offline" {
+		t.Fatalf("expected one delivered message, got %+v", history)
+	}
+	if got := client.GetOutbox(); len(got) != 1 || got[0].State != OutboxDelivered {
+		t.Fatalf("expected the entry to be delivered, got %+v", got)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	defaultOperationPageSize = 100
+	maxOperationPageSize     = 500
+)
+
+// errUnknownCursor is returned when a page cursor names no retained operation.
+var errUnknownCursor = errors.New("unknown cursor")
+
+// OperationPageQuery selects one page of a room's operations. After pages forward
+// from a cursor (or from the start when empty); Before pages backwards from one.
+// Cursors are operation IDs or hashes.
+type OperationPageQuery struct {
+	After  string
+	Before string
+	Limit  int
+}
+
+// OperationPage is one page of operations in chain order. Next is the cursor for
+// the following page in the same direction and is only set when HasMore is true.
+type OperationPage struct {
+	Operations []*Operation `json:"operations"`
+	HasMore    bool         `json:"hasMore"`
+	Next       string       `json:"next,omitempty"`
+}
+
+// GetOperationPage returns a page of the room's retained operations.
+func (a *App) GetOperationPage(roomID string, q OperationPageQuery) (*OperationPage, error) {
+	if q.After != "" && q.Before != "" {
+		return nil, errors.New("after and before cannot be combined")
+	}
+	if q.Limit < 0 {
+		return nil, errors.New("limit must be positive")
+	}
+	limit := q.Limit
+	if limit == 0 {
+		limit = defaultOperationPageSize
+	}
+	limit = min(limit, maxOperationPageSize)
+
+	page := &OperationPage{Operations: []*Operation{}}
+
+	if q.Before != "" {
+		older := a.historyStore.GetOperationRange(roomID, "", q.Before)
+		if len(older) == 0 {
+			if behindSnapshot(a.GetOperations(roomID, "", ""), q.Before) {
+				// Everything older was folded into the snapshot, which sorts after it
+				return page, nil
+			}
+			return nil, fmt.Errorf("%w: %s", errUnknownCursor, q.Before)
+		}
+		older = older[:len(older)-1]
+		start := max(0, len(older)-limit)
+		page.Operations = append(page.Operations, older[start:]...)
+		if start > 0 {
+			page.HasMore = true
+			page.Next = older[start].ID
+		}
+		return page, nil
+	}
+
+	newer := a.GetOperations(roomID, "", "")
+	if q.After != "" {
+		if tail := a.historyStore.GetOperationRange(roomID, q.After, ""); len(tail) > 0 {
+			newer = tail[1:]
+		} else if !behindSnapshot(newer, q.After) {
+			return nil, fmt.Errorf("%w: %s", errUnknownCursor, q.After)
+		}
+		// A cursor compacted away restarts from the snapshot
+	}
+	end := min(len(newer), limit)
+	page.Operations = append(page.Operations, newer[:end]...)
+	if end < len(newer) {
+		page.HasMore = true
+		page.Next = newer[end-1].ID
+	}
+	return page, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Exercises forward and backward paging over a room's operations.
+func TestGetOperationPage(t *testing.T) {
+	app, room, members := newTestRoom(t, "Archive", "", "Alice")
+	alice := members[0]
+	for i := 0; i < 5; i++ {
+		app.SendChatMessage(room.ID, alice.ID, fmt.Sprintf("message %d", i))
+	}
+	all := app.GetOperations(room.ID, "", "")
+
+	first, err := app.GetOperationPage(room.ID, OperationPageQuery{Limit: 2})
+	if err != nil || len(first.Operations) != 2 || !first.HasMore || first.Next != all[1].ID {
+		t.Fatalf("unexpected first page %+v err %v", first, err)
+	}
+	second, _ := app.GetOperationPage(room.ID, OperationPageQuery{After: first.Next, Limit: 2})
+	last, _ := app.GetOperationPage(room.ID, OperationPageQuery{After: second.Next, Limit: 2})
+	if second.Operations[0].ID != all[2].ID || len(last.Operations) != 1 || last.HasMore || last.Next != "" {
+		t.Fatalf("expected forward pages to cover the log, got %+v then %+v", second, last)
+	}
+
+	back, _ := app.GetOperationPage(room.ID, OperationPageQuery{Before: all[4].Hash, Limit: 3})
+	if len(back.Operations) != 3 || back.Operations[0].ID != all[1].ID || !back.HasMore || back.Next != all[1].ID {
+		t.Fatalf("unexpected backward page %+v", back)
+	}
+	oldest, _ := app.GetOperationPage(room.ID, OperationPageQuery{Before: back.Next, Limit: 3})
+	if len(oldest.Operations) != 1 || oldest.HasMore {
+		t.Fatalf("expected final backward page to hold the first op, got %+v", oldest)
+	}
+
+	if _, err := app.GetOperationPage(room.ID, OperationPageQuery{After: "op_missing"}); err == nil {
+		t.Fatalf("expected unknown cursor to fail")
+	}
+}
+
+// Ensures the handler only pages when asked, keeping the plain array for old clients.
+func TestHandleOperationsPaging(t *testing.T) {
+	app, room, members := newTestRoom(t, "Archive", "", "Alice")
+	alice := members[0]
+	app.SendChatMessage(room.ID, alice.ID, "one")
+	app.SendChatMessage(room.ID, alice.ID, "two")
+	token, _ := app.issueToken(alice.ID)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/operations/"+room.ID+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		app.handleOperations(rr, req)
+		return rr
+	}
+
+	if ops := decodeResponseBody[[]*Operation](t, get("")); len(ops) != 2 {
+		t.Fatalf("expected legacy array of 2 ops, got %d", len(ops))
+	}
+	page := decodeResponseBody[OperationPage](t, get("?limit=1"))
+	if len(page.Operations) != 1 || !page.HasMore || page.Next == "" {
+		t.Fatalf("unexpected page %+v", page)
+	}
+	if rr := get("?limit=1&after=bogus"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("unknown cursor expected 400, got %d", rr.Code)
+	}
+	if rr := get("?limit=0"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("zero limit expected 400, got %d", rr.Code)
+	}
+}
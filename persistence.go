@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	stateFileName  = "state.json"
+	historyDirName = "history"
+	filesDirName   = "files"
+)
+
+// persistedState is the host metadata written alongside the room operation logs.
+type persistedState struct {
+	UserCounter int         `json:"userCounter"`
+	RoomCounter int         `json:"roomCounter"`
+	Rooms       []*Room     `json:"rooms"`
+	JoinCodes   []*JoinCode `json:"joinCodes,omitempty"`
+
+	RoomKeys map[string]map[string]map[int]string `json:"roomKeys,omitempty"` // wrapped keys of encrypted rooms
+
+	// Users with a device key, restored offline so the key can bring them back
+	Users      []*User           `json:"users,omitempty"`
+	DeviceKeys map[string]string `json:"deviceKeys,omitempty"` // device key hash -> user ID
+}
+
+// defaultDataDir returns the per-user location used for host persistence.
+func defaultDataDir() string {
+	base, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(base, "GoTeamWork")
+}
+
+// enablePersistence restores rooms, counters and operation history from the data
+// directory and keeps them there from now on. Only users with a device key are
+// restored, offline and in their rooms, until they sign in with the key again or
+// userTimeout passes; everyone else reconnects and rejoins after a restart.
+func (a *App) enablePersistence() error {
+	if a.dataDir == "" {
+		return errors.New("data directory not configured")
+	}
+	for _, dir := range []string{a.dataDir, filepath.Join(a.dataDir, historyDirName), filepath.Join(a.dataDir, filesDirName)} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("create %s: %w", dir, err)
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := a.loadStateLocked(); err != nil {
+		return err
+	}
+	limits := make(map[string]int)
+	for _, room := range a.rooms {
+		if room.Retention != nil {
+			limits[room.ID] = room.Retention.MaxOperations
+		}
+	}
+	store, err := openFileHistoryStore(filepath.Join(a.dataDir, historyDirName), limits)
+	if err != nil {
+		return err
+	}
+	if err := a.historyStore.Close(); err != nil {
+		fmt.Printf("Failed to close previous history store: %v\n", err)
+	}
+	a.historyStore = store
+
+	a.persistenceEnabled = true
+	fmt.Printf("Persistence enabled in %s (%d rooms restored)\n", a.dataDir, len(a.rooms))
+	return nil
+}
+
+func (a *App) loadStateLocked() error {
+	raw, err := os.ReadFile(filepath.Join(a.dataDir, stateFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read state: %w", err)
+	}
+
+	var state persistedState
+	if err := json.Unmarshal(raw, &state); err != nil {
+		return fmt.Errorf("parse state: %w", err)
+	}
+
+	a.userCounter = max(a.userCounter, state.UserCounter)
+	a.roomCounter = max(a.roomCounter, state.RoomCounter)
+
+	now := time.Now()
+	for _, user := range state.Users {
+		if user.OfflineSince == 0 {
+			user.OfflineSince = now.Unix() // online when the host stopped
+		}
+		remaining := userTimeout - now.Sub(time.Unix(user.OfflineSince, 0))
+		if remaining <= 0 {
+			continue
+		}
+		user.IsOnline = false
+		user.RoomID = nil
+		a.users[user.ID] = user
+		userID := user.ID
+		time.AfterFunc(remaining, func() { a.expireOfflineUser(userID) })
+	}
+	for hash, userID := range state.DeviceKeys {
+		if _, exists := a.users[userID]; exists {
+			a.deviceKeys[hash] = userID
+		}
+	}
+
+	for _, room := range state.Rooms {
+		members := []string{}
+		for _, userID := range room.UserIDs {
+			if user, exists := a.users[userID]; exists && user.RoomID == nil {
+				user.RoomID = &room.ID
+				members = append(members, userID)
+			}
+		}
+		room.UserIDs = members
+		a.rooms[room.ID] = room
+	}
+	for _, code := range state.JoinCodes {
+		a.joinCodes[normalizeJoinCode(code.Code)] = code
+	}
+	a.pruneJoinCodesLocked(time.Now())
+	for roomID, keys := range state.RoomKeys {
+		if _, exists := a.rooms[roomID]; exists {
+			a.roomKeys[roomID] = keys
+		}
+	}
+	return nil
+}
+
+// persistStateLocked writes counters, room metadata and users with a device key.
+// Callers must hold a.mu.
+func (a *App) persistStateLocked() {
+	if !a.persistenceEnabled {
+		return
+	}
+
+	state := persistedState{
+		UserCounter: a.userCounter,
+		RoomCounter: a.roomCounter,
+		Rooms:       make([]*Room, 0, len(a.rooms)),
+	}
+	for _, room := range a.rooms {
+		state.Rooms = append(state.Rooms, room)
+	}
+	for _, code := range a.joinCodes {
+		state.JoinCodes = append(state.JoinCodes, code)
+	}
+	if len(a.roomKeys) > 0 {
+		state.RoomKeys = a.roomKeys
+	}
+	if len(a.deviceKeys) > 0 {
+		state.DeviceKeys = a.deviceKeys
+		bound := make(map[string]bool, len(a.deviceKeys))
+		for _, userID := range a.deviceKeys {
+			if user, exists := a.users[userID]; exists && !bound[userID] {
+				bound[userID] = true
+				state.Users = append(state.Users, user)
+			}
+		}
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		fmt.Printf("Failed to encode state: %v\n", err)
+		return
+	}
+
+	path := filepath.Join(a.dataDir, stateFileName)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		fmt.Printf("Failed to write state: %v\n", err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		fmt.Printf("Failed to replace state: %v\n", err)
+	}
+}
+
+// fileStoreDir is where shared file blobs live: the data directory when history
+// is persisted (so downloads survive restarts), otherwise the temp directory.
+func (a *App) fileStoreDir() string {
+	if a.persistenceEnabled {
+		return filepath.Join(a.dataDir, filesDirName)
+	}
+	return a.tempDir
+}
+
+// renameFile is os.Rename; tests replace it to simulate a file store on another
+// filesystem than the temp directory.
+var renameFile = os.Rename
+
+// moveFile renames src to dest, copying it instead when the two are on different
+// filesystems, as the temp directory and the data directory often are.
+func moveFile(src, dest string) error {
+	if err := renameFile(src, dest); err == nil {
+		return nil
+	}
+	if err := copyFile(src, dest); err != nil {
+		return err
+	}
+	os.Remove(src)
+	return nil
+}
+
+// copyFile copies src to dest, removing a partial dest on failure.
+func copyFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dest)
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateClass groups routes that share a rate limit.
+type RateClass string
+
+const (
+	RateClassRead      RateClass = "read"      // GET and HEAD requests on any route
+	RateClassSignup    RateClass = "signup"    // creating users
+	RateClassAuth      RateClass = "auth"      // refreshing, opening and closing sessions
+	RateClassChat      RateClass = "chat"      // sending, editing and reacting to messages
+	RateClassInvite    RateClass = "invite"    // invites, join requests, join codes and approvals
+	RateClassClipboard RateClass = "clipboard" // clipboard items and file uploads
+	RateClassDefault   RateClass = "default"   // every other write
+)
+
+// rateLimitSweepInterval is how often buckets that refilled completely are dropped.
+const rateLimitSweepInterval = 10 * time.Minute
+
+// RateLimit lets Burst requests through at once and refills at Rate per second.
+// A zero Rate leaves the class unlimited.
+type RateLimit struct {
+	Rate  float64 `json:"ratePerSecond"`
+	Burst int     `json:"burst"`
+}
+
+var defaultRateLimits = map[RateClass]RateLimit{
+	RateClassRead:      {Rate: 20, Burst: 60},
+	RateClassSignup:    {Rate: 5.0 / 60, Burst: 5},
+	RateClassAuth:      {Rate: 0.5, Burst: 10},
+	RateClassChat:      {Rate: 2, Burst: 10},
+	RateClassInvite:    {Rate: 10.0 / 60, Burst: 5},
+	RateClassClipboard: {Rate: 2, Burst: 20},
+	RateClassDefault:   {Rate: 5, Burst: 20},
+}
+
+// RateLimitStats reports one route class: its limit, how many requests it let
+// through or refused, and the users and addresses it is tracking.
+type RateLimitStats struct {
+	Class   RateClass          `json:"class"`
+	Limit   RateLimit          `json:"limit"`
+	Allowed uint64             `json:"allowed"`
+	Limited uint64             `json:"limited"`
+	Keys    []RateLimitKeyStat `json:"keys"`
+}
+
+// RateLimitKeyStat describes the bucket of one user ("user:<id>") or remote
+// address ("ip:<addr>") within a class.
+type RateLimitKeyStat struct {
+	Key         string  `json:"key"`
+	Tokens      float64 `json:"tokens"`
+	Allowed     uint64  `json:"allowed"`
+	Limited     uint64  `json:"limited"`
+	LastLimited int64   `json:"lastLimited,omitempty"` // unix seconds
+}
+
+type tokenBucket struct {
+	tokens      float64
+	updated     time.Time
+	allowed     uint64
+	limited     uint64
+	lastLimited time.Time
+}
+
+type rateClassState struct {
+	allowed uint64
+	limited uint64
+	buckets map[string]*tokenBucket
+}
+
+// rateLimiter keeps a token bucket per route class and key.
+type rateLimiter struct {
+	mu        sync.Mutex
+	limits    map[RateClass]RateLimit
+	classes   map[RateClass]*rateClassState
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	rl := &rateLimiter{
+		limits:  make(map[RateClass]RateLimit, len(defaultRateLimits)),
+		classes: make(map[RateClass]*rateClassState),
+		now:     time.Now,
+	}
+	for class, limit := range defaultRateLimits {
+		rl.limits[class] = limit
+	}
+	rl.lastSweep = rl.now()
+	return rl
+}
+
+// parseRateLimits reads overrides such as "chat=1/s:5,signup=3/m,invite=off".
+// A rate is a count per s, m or h, optionally followed by a burst, which
+// otherwise equals the count. "off" alone disables every class.
+func parseRateLimits(raw string) (map[RateClass]RateLimit, error) {
+	limits := make(map[RateClass]RateLimit)
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return limits, nil
+	}
+	if strings.EqualFold(raw, "off") {
+		for class := range defaultRateLimits {
+			limits[class] = RateLimit{}
+		}
+		return limits, nil
+	}
+
+	for _, entry := range strings.Split(raw, ",") {
+		name, spec, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		class := RateClass(strings.ToLower(strings.TrimSpace(name)))
+		if _, known := defaultRateLimits[class]; !ok || !known {
+			return nil, fmt.Errorf("unknown rate limit entry %q", entry)
+		}
+		spec = strings.ToLower(strings.TrimSpace(spec))
+		if spec == "off" {
+			limits[class] = RateLimit{}
+			continue
+		}
+
+		rate, burstSpec, hasBurst := strings.Cut(spec, ":")
+		countSpec, unit, ok := strings.Cut(rate, "/")
+		count, err := strconv.Atoi(countSpec)
+		if !ok || err != nil || count <= 0 {
+			return nil, fmt.Errorf("invalid rate in %q", entry)
+		}
+		var per time.Duration
+		switch unit {
+		case "s":
+			per = time.Second
+		case "m":
+			per = time.Minute
+		case "h":
+			per = time.Hour
+		default:
+			return nil, fmt.Errorf("invalid rate unit in %q", entry)
+		}
+		burst := count
+		if hasBurst {
+			if burst, err = strconv.Atoi(burstSpec); err != nil || burst <= 0 {
+				return nil, fmt.Errorf("invalid burst in %q", entry)
+			}
+		}
+		limits[class] = RateLimit{Rate: float64(count) / per.Seconds(), Burst: burst}
+	}
+	return limits, nil
+}
+
+// SetLimits replaces the limits of the given classes. Buckets of a changed class
+// start over.
+func (rl *rateLimiter) SetLimits(limits map[RateClass]RateLimit) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	for class, limit := range limits {
+		rl.limits[class] = limit
+		delete(rl.classes, class)
+	}
+}
+
+// allow takes a token from the bucket of every key for class, or from none of
+// them when any is empty, in which case it returns how long until one refills.
+func (rl *rateLimiter) allow(class RateClass, keys []string) (bool, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	limit := rl.limits[class]
+	if limit.Rate <= 0 || limit.Burst <= 0 {
+		return true, 0
+	}
+	now := rl.now()
+	if now.Sub(rl.lastSweep) >= rateLimitSweepInterval {
+		rl.sweepLocked(now)
+	}
+	state := rl.classes[class]
+	if state == nil {
+		state = &rateClassState{buckets: make(map[string]*tokenBucket)}
+		rl.classes[class] = state
+	}
+
+	buckets := make([]*tokenBucket, 0, len(keys))
+	var wait time.Duration
+	for _, key := range keys {
+		bucket := state.buckets[key]
+		if bucket == nil {
+			bucket = &tokenBucket{tokens: float64(limit.Burst), updated: now}
+			state.buckets[key] = bucket
+		}
+		bucket.tokens = math.Min(float64(limit.Burst), bucket.tokens+now.Sub(bucket.updated).Seconds()*limit.Rate)
+		bucket.updated = now
+		if bucket.tokens < 1 {
+			if w := time.Duration((1 - bucket.tokens) / limit.Rate * float64(time.Second)); w > wait {
+				wait = w
+			}
+			if bucket.limited == 0 || now.Sub(bucket.lastLimited) > time.Minute {
+				fmt.Printf("Rate limiting %s on %s requests\n", key, class)
+			}
+			bucket.limited++
+			bucket.lastLimited = now
+		}
+		buckets = append(buckets, bucket)
+	}
+	if wait > 0 {
+		state.limited++
+		return false, wait
+	}
+
+	for _, bucket := range buckets {
+		bucket.tokens--
+		bucket.allowed++
+	}
+	state.allowed++
+	return true, 0
+}
+
+// sweepLocked drops buckets that have refilled completely; they hold nothing a
+// new bucket would not. Callers must hold rl.mu.
+func (rl *rateLimiter) sweepLocked(now time.Time) {
+	rl.lastSweep = now
+	for class, state := range rl.classes {
+		limit := rl.limits[class]
+		for key, bucket := range state.buckets {
+			if bucket.tokens+now.Sub(bucket.updated).Seconds()*limit.Rate >= float64(limit.Burst) {
+				delete(state.buckets, key)
+			}
+		}
+	}
+}
+
+// Stats reports every class, ordered by name, with its tracked keys ordered by key.
+func (rl *rateLimiter) Stats() []RateLimitStats {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+	stats := make([]RateLimitStats, 0, len(rl.limits))
+	for class, limit := range rl.limits {
+		entry := RateLimitStats{Class: class, Limit: limit, Keys: []RateLimitKeyStat{}}
+		if state := rl.classes[class]; state != nil {
+			entry.Allowed = state.allowed
+			entry.Limited = state.limited
+			for key, bucket := range state.buckets {
+				stat := RateLimitKeyStat{
+					Key:     key,
+					Tokens:  math.Min(float64(limit.Burst), bucket.tokens+now.Sub(bucket.updated).Seconds()*limit.Rate),
+					Allowed: bucket.allowed,
+					Limited: bucket.limited,
+				}
+				if !bucket.lastLimited.IsZero() {
+					stat.LastLimited = bucket.lastLimited.Unix()
+				}
+				entry.Keys = append(entry.Keys, stat)
+			}
+			sort.Slice(entry.Keys, func(i, j int) bool { return entry.Keys[i].Key < entry.Keys[j].Key })
+		}
+		stats = append(stats, entry)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Class < stats[j].Class })
+	return stats
+}
+
+// GetRateLimitStats reports the host's rate limits and how often each route
+// class, user and remote address hit them.
+func (a *App) GetRateLimitStats() []RateLimitStats {
+	return a.rateLimiter.Stats()
+}
+
+// rateLimited applies class's limit to writes and RateClassRead to reads, per
+// remote address and, when the request carries a valid token, per user.
+// Refused requests get 429 with Retry-After in seconds.
+func (a *App) rateLimited(class RateClass, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestClass := class
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			requestClass = RateClassRead
+		}
+
+		keys := []string{"ip:" + clientIP(r)}
+		token := bearerToken(r)
+		if token == "" {
+			token = r.URL.Query().Get("token")
+		}
+		if token != "" {
+			if user, err := a.authenticateToken(token); err == nil {
+				keys = append(keys, "user:"+user.ID)
+			}
+		}
+
+		if ok, wait := a.rateLimiter.allow(requestClass, keys); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// clientIP returns the remote address of r. Behind a proxy on the same machine,
+// such as a Cloudflare tunnel, it uses the address the proxy reports instead.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		if forwarded := strings.TrimSpace(r.Header.Get("CF-Connecting-IP")); forwarded != "" {
+			return forwarded
+		}
+		if forwarded, _, _ := strings.Cut(r.Header.Get("X-Forwarded-For"), ","); strings.TrimSpace(forwarded) != "" {
+			return strings.TrimSpace(forwarded)
+		}
+	}
+	return host
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimits(t *testing.T) {
+	limits, err := parseRateLimits("chat=1/s:5, signup=3/m ,invite=off")
+	if err != nil {
+		t.Fatalf("parseRateLimits error: %v", err)
+	}
+	if limits[RateClassChat] != (RateLimit{Rate: 1, Burst: 5}) {
+		t.Fatalf("unexpected chat limit %+v", limits[RateClassChat])
+	}
+	if limits[RateClassSignup] != (RateLimit{Rate: 3.0 / 60, Burst: 3}) {
+		t.Fatalf("expected the burst to default to the count, got %+v", limits[RateClassSignup])
+	}
+	if limits[RateClassInvite] != (RateLimit{}) || len(limits) != 3 {
+		t.Fatalf("expected invite to be disabled and nothing else set, got %+v", limits)
+	}
+
+	off, _ := parseRateLimits("off")
+	if len(off) != len(defaultRateLimits) {
+		t.Fatalf("expected off to disable every class, got %+v", off)
+	}
+	for _, bad := range []string{"bogus=1/s", "chat=1/d", "chat=0/s", "chat=1/s:0", "chat"} {
+		if _, err := parseRateLimits(bad); err == nil {
+			t.Fatalf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+// Verifies that a key refills over time and that a request is only let through
+// when every one of its keys has a token left.
+func TestRateLimiterBuckets(t *testing.T) {
+	now := time.Unix(1_000_000, 0)
+	rl := newRateLimiter()
+	rl.now = func() time.Time { return now }
+	rl.lastSweep = now
+	rl.SetLimits(map[RateClass]RateLimit{RateClassChat: {Rate: 1, Burst: 2}})
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := rl.allow(RateClassChat, []string{"ip:a", "user:1"}); !ok {
+			t.Fatalf("expected request %d within the burst to pass", i)
+		}
+	}
+	ok, wait := rl.allow(RateClassChat, []string{"ip:a", "user:1"})
+	if ok || wait != time.Second {
+		t.Fatalf("expected the third request to wait a second, got %v %v", ok, wait)
+	}
+	if ok, _ := rl.allow(RateClassChat, []string{"ip:b", "user:1"}); ok {
+		t.Fatalf("expected the user's bucket to apply from another address")
+	}
+	if ok, _ := rl.allow(RateClassChat, []string{"ip:c", "user:2"}); !ok {
+		t.Fatalf("expected another user on another address to pass")
+	}
+	if ok, _ := rl.allow(RateClassInvite, []string{"ip:a", "user:1"}); !ok {
+		t.Fatalf("expected other classes to keep their own buckets")
+	}
+
+	now = now.Add(1500 * time.Millisecond)
+	if ok, _ := rl.allow(RateClassChat, []string{"ip:a", "user:1"}); !ok {
+		t.Fatalf("expected a refilled token to let the request through")
+	}
+
+	var chat RateLimitStats
+	for _, stats := range rl.Stats() {
+		if stats.Class == RateClassChat {
+			chat = stats
+		}
+	}
+	if chat.Allowed != 4 || chat.Limited != 2 || len(chat.Keys) != 5 {
+		t.Fatalf("unexpected chat stats %+v", chat)
+	}
+	if key := chat.Keys[3]; key.Key != "user:1" || key.Allowed != 3 || key.Limited != 2 || key.LastLimited == 0 {
+		t.Fatalf("unexpected stats for user:1 %+v", key)
+	}
+
+	now = now.Add(rateLimitSweepInterval)
+	rl.allow(RateClassChat, []string{"ip:z"})
+	for _, stats := range rl.Stats() {
+		if stats.Class == RateClassChat && len(stats.Keys) != 1 {
+			t.Fatalf("expected refilled buckets to be swept, got %+v", stats.Keys)
+		}
+	}
+}
+
+// Exercises the middleware: writes beyond the burst get 429 with Retry-After,
+// reads are counted separately, and proxied requests are keyed by the client.
+func TestRateLimitedHandler(t *testing.T) {
+	app := newTestApp()
+	app.rateLimiter.SetLimits(map[RateClass]RateLimit{RateClassChat: {Rate: 0.5, Burst: 2}})
+	user := app.CreateUser("Spammer")
+	token, _ := app.issueToken(user.ID)
+	hits := 0
+	handler := corsMiddleware(app.rateLimited(RateClassChat, func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.WriteHeader(http.StatusOK)
+	}))
+	send := func(method, remote string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/chat", strings.NewReader(`{}`))
+		req.RemoteAddr = remote
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+	auth := map[string]string{"Authorization": "Bearer " + token}
+
+	for i := 0; i < 2; i++ {
+		if rr := send(http.MethodPost, "10.0.0.1:5000", auth); rr.Code != http.StatusOK {
+			t.Fatalf("expected request %d to pass, got %d", i, rr.Code)
+		}
+	}
+	rr := send(http.MethodPost, "10.0.0.2:5000", auth)
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "2" {
+		t.Fatalf("expected 429 with Retry-After 2 for the same user elsewhere, got %d %q", rr.Code, rr.Header().Get("Retry-After"))
+	}
+	if rr.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Fatalf("expected a 429 to keep its CORS headers")
+	}
+	if rr := send(http.MethodGet, "10.0.0.1:5000", auth); rr.Code != http.StatusOK {
+		t.Fatalf("expected reads not to count against chat writes, got %d", rr.Code)
+	}
+	if rr := send(http.MethodOptions, "10.0.0.1:5000", auth); rr.Code != http.StatusOK {
+		t.Fatalf("expected preflight requests to pass, got %d", rr.Code)
+	}
+
+	for i := 0; i < 2; i++ {
+		send(http.MethodPost, "127.0.0.1:5000", map[string]string{"CF-Connecting-IP": "203.0.113.7"})
+	}
+	if rr := send(http.MethodPost, "127.0.0.1:5000", map[string]string{"CF-Connecting-IP": "203.0.113.8"}); rr.Code != http.StatusOK {
+		t.Fatalf("expected tunnelled clients to be keyed by their own address, got %d", rr.Code)
+	}
+	if rr := send(http.MethodPost, "127.0.0.1:5000", map[string]string{"CF-Connecting-IP": "203.0.113.7"}); rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected the tunnelled address to be limited, got %d", rr.Code)
+	}
+	if hits != 6 {
+		t.Fatalf("expected 6 requests to reach the handler, got %d", hits)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	retentionEnforceInterval = time.Minute
+	minRetentionOperations   = 50
+	maxRetentionOperations   = 10 * maxOperationsPerRoom
+
+	retentionReasonMaxAge    = "max_age"
+	retentionReasonFileBytes = "max_file_bytes"
+
+	// retentionActor is recorded as the author of removals made by the enforcer.
+	retentionActor = "retention"
+)
+
+// errNotRoomOwner is returned when a non-owner tries to change room settings.
+var errNotRoomOwner = errors.New("only the room owner can change room settings")
+
+func (r RoomRetention) validate() error {
+	if r.MaxOperations < 0 || r.MaxAgeSeconds < 0 || r.MaxFileBytes < 0 {
+		return errors.New("retention limits must not be negative")
+	}
+	if r.MaxOperations != 0 && (r.MaxOperations < minRetentionOperations || r.MaxOperations > maxRetentionOperations) {
+		return fmt.Errorf("maxOperations must be between %d and %d", minRetentionOperations, maxRetentionOperations)
+	}
+	return nil
+}
+
+// GetRoomSettings returns the room's retention settings; zero fields use host defaults.
+func (a *App) GetRoomSettings(roomID string) (*RoomSettings, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	room, exists := a.rooms[roomID]
+	if !exists {
+		return nil, fmt.Errorf("room %s not found", roomID)
+	}
+	settings := &RoomSettings{RoomID: roomID}
+	if room.Retention != nil {
+		settings.Retention = *room.Retention
+	}
+	return settings, nil
+}
+
+// UpdateRoomSettings replaces the room's retention settings and applies them right away.
+// Only the room owner may change them.
+func (a *App) UpdateRoomSettings(roomID, userID string, retention RoomRetention) (*RoomSettings, error) {
+	if err := retention.validate(); err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	room, exists := a.rooms[roomID]
+	if !exists {
+		a.mu.Unlock()
+		return nil, fmt.Errorf("room %s not found", roomID)
+	}
+	if room.OwnerID != userID {
+		a.mu.Unlock()
+		return nil, errNotRoomOwner
+	}
+	if retention == (RoomRetention{}) {
+		room.Retention = nil
+	} else {
+		room.Retention = &retention
+	}
+	members := append([]string(nil), room.UserIDs...)
+	a.persistStateLocked()
+	a.mu.Unlock()
+
+	a.historyStore.SetOperationLimit(roomID, retention.MaxOperations)
+	settings := &RoomSettings{RoomID: roomID, Retention: retention}
+	a.sseManager.BroadcastToUsers(members, EventRoomSettingsUpdated, settings, "")
+	fmt.Printf("Retention for room %s set to %+v\n", roomID, retention)
+
+	a.enforceRoomRetention(roomID, time.Now())
+	return settings, nil
+}
+
+// enforceRetention applies every room's retention policy.
+func (a *App) enforceRetention() {
+	a.mu.RLock()
+	roomIDs := make([]string, 0, len(a.rooms))
+	for id, room := range a.rooms {
+		if room.Retention != nil {
+			roomIDs = append(roomIDs, id)
+		}
+	}
+	a.mu.RUnlock()
+
+	now := time.Now()
+	for _, roomID := range roomIDs {
+		a.enforceRoomRetention(roomID, now)
+	}
+}
+
+// enforceRoomRetention removes items older than the room's max age, then the
+// oldest shared files until the room fits its file quota. Members are sent a
+// RetentionNotice for each reason that removed something.
+func (a *App) enforceRoomRetention(roomID string, now time.Time) []*RetentionNotice {
+	a.mu.RLock()
+	room, exists := a.rooms[roomID]
+	var retention RoomRetention
+	var members []string
+	if exists && room.Retention != nil {
+		retention = *room.Retention
+		members = append(members, room.UserIDs...)
+	}
+	a.mu.RUnlock()
+
+	if retention.MaxAgeSeconds == 0 && retention.MaxFileBytes == 0 {
+		return nil
+	}
+
+	state := materializeHistory(a.historyStore.GetOperations(roomID, "", ""))
+	var notices []*RetentionNotice
+	// Retired together at the end so the room's history is scrubbed in one pass
+	var retired []*Item
+
+	if retention.MaxAgeSeconds > 0 {
+		cutoff := now.Unix() - retention.MaxAgeSeconds
+		notice := &RetentionNotice{RoomID: roomID, Reason: retentionReasonMaxAge}
+		expire := func(entries []*SnapshotEntry) []*SnapshotEntry {
+			kept := entries[:0]
+			for _, e := range entries {
+				if e.Timestamp >= cutoff {
+					kept = append(kept, e)
+					continue
+				}
+				notice.FreedBytes += sharedFileBytes(e.Item)
+				retired = append(retired, e.Item)
+				notice.ItemIDs = append(notice.ItemIDs, e.ItemID)
+			}
+			return kept
+		}
+		state.Chat = expire(state.Chat)
+		state.Clipboard = expire(state.Clipboard)
+		if len(notice.ItemIDs) > 0 {
+			notices = append(notices, notice)
+		}
+	}
+
+	if retention.MaxFileBytes > 0 {
+		var total int64
+		sizes := make([]int64, len(state.Clipboard))
+		for i, e := range state.Clipboard {
+			sizes[i] = sharedFileBytes(e.Item)
+			total += sizes[i]
+		}
+		notice := &RetentionNotice{RoomID: roomID, Reason: retentionReasonFileBytes}
+		// Clipboard entries are in the order they were shared, so the oldest go first
+		for i, e := range state.Clipboard {
+			if total <= retention.MaxFileBytes {
+				break
+			}
+			if sizes[i] == 0 {
+				continue
+			}
+			retired = append(retired, e.Item)
+			total -= sizes[i]
+			notice.FreedBytes += sizes[i]
+			notice.ItemIDs = append(notice.ItemIDs, e.ItemID)
+		}
+		if len(notice.ItemIDs) > 0 {
+			notices = append(notices, notice)
+		}
+	}
+
+	a.retireItems(roomID, retired, retentionActor, "", members)
+	for _, notice := range notices {
+		a.sseManager.BroadcastToUsers(members, EventRetentionExpired, notice, "")
+		fmt.Printf("Retention (%s) removed %d items from room %s, freeing %d bytes\n",
+			notice.Reason, len(notice.ItemIDs), roomID, notice.FreedBytes)
+	}
+	return notices
+}
+
+// sharedFileBytes reports how much disk space an item's shared files use.
+func sharedFileBytes(item *Item) int64 {
+	var total int64
+	for _, p := range sharedFilePaths(item) {
+		if info, err := os.Stat(p); err == nil {
+			total += info.Size()
+		}
+	}
+	return total
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"GOproject/clip_helper"
+)
+
+func addTestFileItem(t *testing.T, app *App, roomID, userID, name string, size int) (*Operation, string) {
+	t.Helper()
+	path := filepath.Join(app.tempDir, name)
+	if err := os.WriteFile(path, bytes.Repeat([]byte("x"), size), 0644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	clip := &clip_helper.ClipboardItem{Type: clip_helper.ClipboardFile, Files: []string{name}, IsSingleFile: true, SingleFileName: name, SingleFilePath: path}
+	itemID := "clip_" + name
+	op := app.historyStore.AddOperation(roomID, OpAdd, itemID, &Item{ID: itemID, Type: ItemClipboard, Data: clip}, userID, "")
+	return op, path
+}
+
+// Ensures a room's operation limit overrides the default compaction threshold.
+func TestHistoryPoolOperationLimit(t *testing.T) {
+	hp := NewHistoryPool()
+	room := "room-limit"
+	hp.SetOperationLimit(room, minRetentionOperations)
+
+	for i := 0; i < minRetentionOperations+5; i++ {
+		id := fmt.Sprintf("msg_%d", i)
+		hp.AddOperation(room, OpAdd, id, &Item{ID: id, Type: ItemChat, Data: &ChatMessage{ID: id, Message: id, Timestamp: int64(i)}}, "", "")
+	}
+
+	ops := hp.GetOperations(room, "", "")
+	if len(ops) > minRetentionOperations || ops[0].OpType != OpSnapshot {
+		t.Fatalf("expected room compacted to its own limit, got %d ops", len(ops))
+	}
+	if msgs := hp.GetCurrentChatMessages(room); len(msgs) != minRetentionOperations+5 {
+		t.Fatalf("expected compaction to keep every message, got %d", len(msgs))
+	}
+}
+
+// Verifies the enforcer removes aged-out items and their files in one redaction
+// pass and notifies members.
+func TestEnforceRoomRetentionMaxAge(t *testing.T) {
+	app, room, members := newTestRoom(t, "Archive", "", "Alice")
+	alice := members[0]
+	store := &recordingHistoryStore{HistoryPool: NewHistoryPool()}
+	app.historyStore = store
+	conn := attachClient(app, alice.ID)
+	app.SendChatMessage(room.ID, alice.ID, "old news")
+	_, path := addTestFileItem(t, app, room.ID, alice.ID, "old.txt", 10)
+
+	if _, err := app.UpdateRoomSettings(room.ID, "host", RoomRetention{MaxAgeSeconds: 3600}); err != nil {
+		t.Fatalf("UpdateRoomSettings error: %v", err)
+	}
+	if len(app.GetChatHistory(room.ID)) != 1 {
+		t.Fatalf("expected fresh items to survive the initial enforcement")
+	}
+
+	conn.Reset()
+	notices := app.enforceRoomRetention(room.ID, time.Now().Add(2*time.Hour))
+	if len(notices) != 1 || notices[0].Reason != retentionReasonMaxAge || len(notices[0].ItemIDs) != 2 || notices[0].FreedBytes != 10 {
+		t.Fatalf("expected one max_age notice for both items, got %+v", notices)
+	}
+	if len(app.GetChatHistory(room.ID)) != 0 || len(app.historyStore.GetCurrentClipboardItems(room.ID)) != 0 {
+		t.Fatalf("expected aged-out items removed from the room")
+	}
+	if store.redactions != 1 {
+		t.Fatalf("expected the sweep to redact the room once, got %d passes", store.redactions)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected expired file blob removed from disk, stat err=%v", err)
+	}
+
+	evt, ok := findEvent(conn.Events(), EventRetentionExpired)
+	if !ok {
+		t.Fatalf("expected retention_expired event")
+	}
+	if payload := decodeEventPayload[RetentionNotice](t, evt); payload.RoomID != room.ID || len(payload.ItemIDs) != 2 {
+		t.Fatalf("unexpected retention notice %+v", payload)
+	}
+	if _, ok := findEvent(conn.Events(), EventClipboardRemoved); !ok {
+		t.Fatalf("expected clipboard_removed event for the expired file")
+	}
+}
+
+// Ensures the oldest shared files are removed first once a room exceeds its file quota.
+func TestEnforceRoomRetentionMaxFileBytes(t *testing.T) {
+	app, room, members := newTestRoom(t, "Archive", "", "Alice")
+	alice := members[0]
+	_, oldest := addTestFileItem(t, app, room.ID, alice.ID, "first.bin", 10)
+	_, newest := addTestFileItem(t, app, room.ID, alice.ID, "second.bin", 10)
+
+	if _, err := app.UpdateRoomSettings(room.ID, "host", RoomRetention{MaxFileBytes: 15}); err != nil {
+		t.Fatalf("UpdateRoomSettings error: %v", err)
+	}
+
+	items := app.historyStore.GetCurrentClipboardItems(room.ID)
+	if len(items) != 1 || items[0].SingleFileName != "second.bin" {
+		t.Fatalf("expected only the newest file to remain, got %+v", items)
+	}
+	if _, err := os.Stat(oldest); !os.IsNotExist(err) {
+		t.Fatalf("expected oldest blob removed, stat err=%v", err)
+	}
+	if _, err := os.Stat(newest); err != nil {
+		t.Fatalf("expected newest blob kept: %v", err)
+	}
+}
+
+// Exercises the settings endpoint: members may read, only the owner may change.
+func TestHandleRoomSettings(t *testing.T) {
+	app := newTestApp()
+	owner := app.CreateUser("Owner")
+	member := app.CreateUser("Member")
+	room := app.CreateRoom("Retained", owner.ID)
+	if _, err := app.JoinRoom(owner.ID, room.ID); err != nil {
+		t.Fatalf("JoinRoom owner error: %v", err)
+	}
+	app.ApproveJoinRequest(owner.ID, member.ID, room.ID)
+	if _, err := app.JoinRoom(member.ID, room.ID); err != nil {
+		t.Fatalf("JoinRoom member error: %v", err)
+	}
+
+	put := func(userID, body string) *httptest.ResponseRecorder {
+		token, _ := app.issueToken(userID)
+		req := httptest.NewRequest(http.MethodPut, "/api/rooms/"+room.ID+"/settings", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		app.handleRoomRoutes(rr, req)
+		return rr
+	}
+
+	if rr := put(member.ID, `{"retention":{"maxAgeSeconds":60}}`); rr.Code != http.StatusForbidden {
+		t.Fatalf("non-owner update expected 403, got %d", rr.Code)
+	}
+	if rr := put(owner.ID, `{"retention":{"maxOperations":5}}`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("out-of-range maxOperations expected 400, got %d", rr.Code)
+	}
+	if rr := put(owner.ID, `{"retention":{"maxOperations":200,"maxAgeSeconds":86400}}`); rr.Code != http.StatusOK {
+		t.Fatalf("owner update expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	token, _ := app.issueToken(member.ID)
+	req := httptest.NewRequest(http.MethodGet, "/api/rooms/"+room.ID+"/settings", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	app.handleRoomRoutes(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("member read expected 200, got %d", rr.Code)
+	}
+	settings := decodeResponseBody[RoomSettings](t, rr)
+	if settings.Retention.MaxOperations != 200 || settings.Retention.MaxAgeSeconds != 86400 {
+		t.Fatalf("expected stored retention, got %+v", settings)
+	}
+}
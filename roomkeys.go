@@ -0,0 +1,244 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"GOproject/clip_helper"
+)
+
+var errRoomNotEncrypted = errors.New("room is not encrypted")
+
+// CreateEncryptedRoom creates a room whose content members seal client-side. The
+// owner's client sets the first room key once it enters the room.
+func (a *App) CreateEncryptedRoom(name, ownerID string) *Room {
+	return a.createRoom(name, ownerID, true)
+}
+
+// SetPublicKey records the X25519 public key the user's client seals room keys
+// for. Members holding the current key of the user's encrypted room are asked
+// to share it with the new key.
+func (a *App) SetPublicKey(userID, publicKey string) error {
+	if _, err := decodePublicKey(publicKey); err != nil {
+		return fmt.Errorf("invalid public key: %w", err)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	user, exists := a.users[userID]
+	if !exists {
+		return fmt.Errorf("user not found")
+	}
+	if user.PublicKey == publicKey {
+		return nil
+	}
+	user.PublicKey = publicKey
+	if user.RoomID != nil {
+		if room, ok := a.rooms[*user.RoomID]; ok && room.Encrypted {
+			a.requestRoomKeysLocked(room)
+		}
+	}
+	return nil
+}
+
+// GetRoomKeys returns the caller's wrapped keys for an encrypted room together
+// with every member's public key and the epochs wrapped for it.
+func (a *App) GetRoomKeys(roomID, userID string) (*RoomKeysResponse, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	room, exists := a.rooms[roomID]
+	if !exists {
+		return nil, fmt.Errorf("room %s not found", roomID)
+	}
+	user, userExists := a.users[userID]
+	if !userExists || !contains(room.UserIDs, userID) {
+		return nil, fmt.Errorf("user %s is not in room %s", userID, roomID)
+	}
+
+	resp := &RoomKeysResponse{
+		RoomID:    room.ID,
+		OwnerID:   room.OwnerID,
+		Encrypted: room.Encrypted,
+		Epoch:     room.KeyEpoch,
+		Members:   []MemberKey{},
+	}
+	if !room.Encrypted {
+		return resp, nil
+	}
+	wrapped := a.roomKeys[roomID]
+	if user.PublicKey != "" && len(wrapped[user.PublicKey]) > 0 {
+		resp.Keys = make(map[int]string, len(wrapped[user.PublicKey]))
+		for epoch, key := range wrapped[user.PublicKey] {
+			resp.Keys[epoch] = key
+		}
+	}
+	for _, memberID := range room.UserIDs {
+		member := MemberKey{UserID: memberID}
+		if u, ok := a.users[memberID]; ok && u.PublicKey != "" {
+			member.PublicKey = u.PublicKey
+			for epoch := range wrapped[u.PublicKey] {
+				member.Epochs = append(member.Epochs, epoch)
+			}
+			sort.Ints(member.Epochs)
+		}
+		resp.Members = append(resp.Members, member)
+	}
+	return resp, nil
+}
+
+// StoreRoomKeys saves room keys a member wrapped for other members. Uploading
+// epoch KeyEpoch+1 rotates the room key, which the owner does for the first key
+// and the owner or a moderator does afterwards; the uploader must include its
+// own copy. Lower epochs may only be handed on by a member who holds them, and
+// never replace a wrap the recipient already has.
+func (a *App) StoreRoomKeys(roomID, userID string, upload RoomKeyUpload) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	room, exists := a.rooms[roomID]
+	if !exists {
+		return fmt.Errorf("room %s not found", roomID)
+	}
+	if !room.Encrypted {
+		return errRoomNotEncrypted
+	}
+	uploader, ok := a.users[userID]
+	if !ok || !contains(room.UserIDs, userID) {
+		return fmt.Errorf("user %s is not in room %s", userID, roomID)
+	}
+	if uploader.PublicKey == "" {
+		return errors.New("publish a public key before uploading room keys")
+	}
+	if len(upload.Keys) == 0 {
+		return errors.New("no keys provided")
+	}
+
+	wrapped := a.roomKeys[roomID]
+	if wrapped == nil {
+		wrapped = make(map[string]map[int]string)
+	}
+	rotate := upload.Epoch == room.KeyEpoch+1
+	switch {
+	case rotate:
+		role := roleOf(room, userID)
+		if (room.KeyEpoch == 0 && role != RoleOwner) || role.rank() < RoleModerator.rank() {
+			return errNotModerator
+		}
+		if _, ok := upload.Keys[userID]; !ok {
+			return errors.New("a new room key must include the uploader's own copy")
+		}
+	case upload.Epoch >= 1 && upload.Epoch <= room.KeyEpoch:
+		if _, held := wrapped[uploader.PublicKey][upload.Epoch]; !held {
+			return fmt.Errorf("cannot share key epoch %d without holding it", upload.Epoch)
+		}
+	default:
+		return fmt.Errorf("key epoch must be between 1 and %d", room.KeyEpoch+1)
+	}
+
+	recipients := make(map[string]string, len(upload.Keys)) // public key -> wrapped key
+	for memberID, key := range upload.Keys {
+		member, ok := a.users[memberID]
+		if !ok || !contains(room.UserIDs, memberID) || member.PublicKey == "" {
+			return fmt.Errorf("user %s is not a member with a public key", memberID)
+		}
+		if !validWrappedKey(key) {
+			return fmt.Errorf("wrapped key for %s is malformed", memberID)
+		}
+		if _, exists := wrapped[member.PublicKey][upload.Epoch]; exists && !rotate {
+			continue
+		}
+		recipients[member.PublicKey] = key
+	}
+
+	for publicKey, key := range recipients {
+		if wrapped[publicKey] == nil {
+			wrapped[publicKey] = make(map[int]string)
+		}
+		wrapped[publicKey][upload.Epoch] = key
+	}
+	a.roomKeys[roomID] = wrapped
+	if rotate {
+		room.KeyEpoch = upload.Epoch
+		fmt.Printf("Room %s key rotated to epoch %d by %s\n", roomID, room.KeyEpoch, userID)
+	}
+	a.persistStateLocked()
+
+	var notify []string
+	for memberID := range upload.Keys {
+		if _, ok := recipients[a.users[memberID].PublicKey]; ok {
+			notify = append(notify, memberID)
+		}
+	}
+	if rotate {
+		notify = append([]string(nil), room.UserIDs...)
+	}
+	a.sseManager.BroadcastToUsers(notify, EventRoomKeyUpdated, RoomKeyEvent{RoomID: roomID, Epoch: upload.Epoch}, "")
+	a.requestRoomKeysLocked(room)
+	return nil
+}
+
+// requestRoomKeysLocked asks the members holding the current room key to wrap it
+// for each member that has a public key but not the key yet. Callers must hold a.mu.
+func (a *App) requestRoomKeysLocked(room *Room) {
+	if !room.Encrypted || room.KeyEpoch == 0 {
+		return
+	}
+	wrapped := a.roomKeys[room.ID]
+	var holders []string
+	var missing []*User
+	for _, memberID := range room.UserIDs {
+		member, ok := a.users[memberID]
+		if !ok || member.PublicKey == "" {
+			continue
+		}
+		if _, held := wrapped[member.PublicKey][room.KeyEpoch]; held {
+			holders = append(holders, memberID)
+		} else {
+			missing = append(missing, member)
+		}
+	}
+	if len(holders) == 0 {
+		return
+	}
+	for _, member := range missing {
+		a.sseManager.BroadcastToUsers(holders, EventRoomKeyNeeded, RoomKeyEvent{
+			RoomID:    room.ID,
+			Epoch:     room.KeyEpoch,
+			UserID:    member.ID,
+			PublicKey: member.PublicKey,
+		}, "")
+	}
+}
+
+// sealedClipboardError reports why a clipboard item may not be stored in an
+// encrypted room: all content belongs in the sealed Text.
+func sealedClipboardError(item *clip_helper.ClipboardItem) error {
+	if !isSealedPayload(item.Text) || len(item.Image) > 0 || len(item.Files) > 0 ||
+		item.SingleFileName != "" || item.SingleFileMime != "" || item.SingleFileThumb != "" {
+		return errNotSealed
+	}
+	return nil
+}
+
+// chatMessageContent sanitizes plain chat text. Messages for encrypted rooms are
+// stored as sent, but must be sealed and within maxSealedChatLen.
+func chatMessageContent(message string, encrypted bool) (string, error) {
+	if !encrypted {
+		return sanitizeChatMessage(message), nil
+	}
+	if !isSealedPayload(message) || len(message) > maxSealedChatLen {
+		return "", errNotSealed
+	}
+	return message, nil
+}
+
+// roomEncrypted reports whether roomID is an encrypted room.
+func (a *App) roomEncrypted(roomID string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	room, exists := a.rooms[roomID]
+	return exists && room.Encrypted
+}
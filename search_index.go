@@ -0,0 +1,286 @@
+package main
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"GOproject/clip_helper"
+)
+
+const (
+	defaultSearchLimit = 50
+	maxSearchLimit     = 200
+	searchSnippetRunes = 160
+)
+
+// SearchQuery describes a room search. Terms must all match; the last term also
+// matches as a prefix so partially typed words still find results.
+type SearchQuery struct {
+	Text   string
+	UserID string   // only items authored by this user
+	Type   ItemType // ItemChat or ItemClipboard; empty for both
+	Since  int64    // unix seconds, inclusive; 0 for no lower bound
+	Until  int64    // unix seconds, inclusive; 0 for no upper bound
+	Limit  int
+}
+
+// SearchResult is a live item matching a search, pointing back at its operations.
+type SearchResult struct {
+	OperationID       string   `json:"operationId"`                 // operation that added the item
+	LatestOperationID string   `json:"latestOperationId,omitempty"` // newest edit, if the item was modified
+	ItemID            string   `json:"itemId"`
+	Type              ItemType `json:"type"`
+	UserID            string   `json:"userId,omitempty"`
+	UserName          string   `json:"userName,omitempty"`
+	Timestamp         int64    `json:"timestamp"`
+	Snippet           string   `json:"snippet"`
+}
+
+// searchIndex is an incremental inverted index over the live items of each room.
+// It is owned by a HistoryPool and guarded by the pool's mutex.
+type searchIndex struct {
+	rooms map[string]*roomSearchIndex
+}
+
+type roomSearchIndex struct {
+	postings map[string]map[string]struct{} // term -> itemIDs
+	docs     map[string]*searchDoc          // itemID -> indexed item
+}
+
+type searchDoc struct {
+	result SearchResult
+	terms  []string
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{rooms: make(map[string]*roomSearchIndex)}
+}
+
+func (ix *searchIndex) room(roomID string) *roomSearchIndex {
+	r, ok := ix.rooms[roomID]
+	if !ok {
+		r = &roomSearchIndex{
+			postings: make(map[string]map[string]struct{}),
+			docs:     make(map[string]*searchDoc),
+		}
+		ix.rooms[roomID] = r
+	}
+	return r
+}
+
+// apply folds one operation into the room index.
+func (ix *searchIndex) apply(roomID string, op *Operation) {
+	r := ix.room(roomID)
+	switch op.OpType {
+	case OpSnapshot:
+		if snap := snapshotData(op); snap != nil {
+			for _, entries := range [][]*SnapshotEntry{snap.Chat, snap.Clipboard} {
+				for _, e := range entries {
+					r.index(SearchResult{OperationID: e.OpID, ItemID: e.ItemID, UserID: e.UserID, UserName: e.UserName, Timestamp: e.Timestamp}, e.Item)
+				}
+			}
+		}
+	case OpAdd:
+		r.index(SearchResult{OperationID: op.ID, ItemID: op.ItemID, UserID: op.UserID, UserName: op.UserName, Timestamp: op.Timestamp}, op.Item)
+	case OpModify:
+		if doc, ok := r.docs[op.ItemID]; ok {
+			base := doc.result
+			base.LatestOperationID = op.ID
+			r.index(base, op.Item)
+		}
+	case OpRemove:
+		r.remove(op.ItemID)
+	}
+}
+
+// reindex refreshes an item whose content changed in place (e.g. a file upload
+// completing) without a new operation.
+func (ix *searchIndex) reindex(roomID string, op *Operation) {
+	r := ix.room(roomID)
+	if doc, ok := r.docs[op.ItemID]; ok && doc.result.OperationID == op.ID && doc.result.LatestOperationID == "" {
+		r.index(doc.result, op.Item)
+	}
+}
+
+// rebuild discards the room index and replays ops, used after history is trimmed.
+func (ix *searchIndex) rebuild(roomID string, ops []*Operation) {
+	delete(ix.rooms, roomID)
+	for _, op := range ops {
+		ix.apply(roomID, op)
+	}
+}
+
+// index (re)places the document for base.ItemID using item's current content.
+func (r *roomSearchIndex) index(base SearchResult, item *Item) {
+	if item == nil || (item.Type != ItemChat && item.Type != ItemClipboard) {
+		return
+	}
+	r.remove(base.ItemID)
+
+	text := searchableText(item)
+	base.Type = item.Type
+	base.Snippet = truncateRunes(text, searchSnippetRunes)
+	doc := &searchDoc{result: base, terms: tokenizeSearchText(text)}
+	r.docs[base.ItemID] = doc
+	for _, term := range doc.terms {
+		ids, ok := r.postings[term]
+		if !ok {
+			ids = make(map[string]struct{})
+			r.postings[term] = ids
+		}
+		ids[base.ItemID] = struct{}{}
+	}
+}
+
+func (r *roomSearchIndex) remove(itemID string) {
+	doc, ok := r.docs[itemID]
+	if !ok {
+		return
+	}
+	for _, term := range doc.terms {
+		if ids, ok := r.postings[term]; ok {
+			delete(ids, itemID)
+			if len(ids) == 0 {
+				delete(r.postings, term)
+			}
+		}
+	}
+	delete(r.docs, itemID)
+}
+
+// search returns matching items, newest first.
+func (ix *searchIndex) search(roomID string, q SearchQuery) []*SearchResult {
+	results := []*SearchResult{}
+	r, ok := ix.rooms[roomID]
+	terms := tokenizeSearchText(q.Text)
+	if !ok || len(terms) == 0 {
+		return results
+	}
+
+	var candidates map[string]struct{}
+	for i, term := range terms {
+		matched := r.postings[term]
+		if i == len(terms)-1 {
+			matched = r.prefixMatches(term)
+		}
+		candidates = intersectItemIDs(candidates, matched, i == 0)
+		if len(candidates) == 0 {
+			return results
+		}
+	}
+
+	for itemID := range candidates {
+		doc := r.docs[itemID]
+		if !q.matches(&doc.result) {
+			continue
+		}
+		res := doc.result
+		results = append(results, &res)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Timestamp != results[j].Timestamp {
+			return results[i].Timestamp > results[j].Timestamp
+		}
+		return operationSequence(results[i].OperationID) > operationSequence(results[j].OperationID)
+	})
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	limit = min(limit, maxSearchLimit)
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+func (r *roomSearchIndex) prefixMatches(prefix string) map[string]struct{} {
+	matched := make(map[string]struct{})
+	for term, ids := range r.postings {
+		if strings.HasPrefix(term, prefix) {
+			for id := range ids {
+				matched[id] = struct{}{}
+			}
+		}
+	}
+	return matched
+}
+
+func intersectItemIDs(acc, next map[string]struct{}, first bool) map[string]struct{} {
+	if first {
+		out := make(map[string]struct{}, len(next))
+		for id := range next {
+			out[id] = struct{}{}
+		}
+		return out
+	}
+	for id := range acc {
+		if _, ok := next[id]; !ok {
+			delete(acc, id)
+		}
+	}
+	return acc
+}
+
+func (q SearchQuery) matches(res *SearchResult) bool {
+	if q.UserID != "" && res.UserID != q.UserID {
+		return false
+	}
+	if q.Type != "" && res.Type != q.Type {
+		return false
+	}
+	if q.Since > 0 && res.Timestamp < q.Since {
+		return false
+	}
+	if q.Until > 0 && res.Timestamp > q.Until {
+		return false
+	}
+	return true
+}
+
+// searchableText collects the text a user would search for: chat messages,
+// clipboard text and the names of shared files.
+func searchableText(item *Item) string {
+	switch v := item.Data.(type) {
+	case *ChatMessage:
+		return v.Message
+	case *clip_helper.ClipboardItem:
+		parts := []string{v.Text}
+		if v.SingleFileName != "" {
+			parts = append(parts, v.SingleFileName)
+		}
+		for _, f := range v.Files {
+			parts = append(parts, filepath.Base(f))
+		}
+		return strings.TrimSpace(strings.Join(parts, " "))
+	}
+	return ""
+}
+
+// tokenizeSearchText lowercases text and splits it into unique letter/digit runs.
+func tokenizeSearchText(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	seen := make(map[string]bool, len(fields))
+	terms := make([]string, 0, len(fields))
+	for _, f := range fields {
+		if !seen[f] {
+			seen[f] = true
+			terms = append(terms, f)
+		}
+	}
+	return terms
+}
+
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n]) + "…"
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"GOproject/clip_helper"
+)
+
+// Ensures the index tracks adds, edits and removals and applies the query filters.
+func TestHistoryPoolSearch(t *testing.T) {
+	hp := NewHistoryPool()
+	room := "room-search"
+
+	chatOp := hp.AddOperation(room, OpAdd, "m1", &Item{ID: "m1", Type: ItemChat, Data: &ChatMessage{ID: "m1", Message: "Deploy the staging server"}}, "alice", "Alice")
+	hp.AddOperation(room, OpAdd, "m2", &Item{ID: "m2", Type: ItemChat, Data: &ChatMessage{ID: "m2", Message: "lunch?"}}, "bob", "Bob")
+	fileOp := hp.AddOperation(room, OpAdd, "c1", &Item{ID: "c1", Type: ItemClipboard, Data: &clip_helper.ClipboardItem{
+		Type: clip_helper.ClipboardFile, Files: []string{"/home/bob/Quarterly-Report.pdf"},
+	}}, "bob", "Bob")
+
+	if res := hp.Search(room, SearchQuery{Text: "staging"}); len(res) != 1 || res[0].OperationID != chatOp.ID {
+		t.Fatalf("expected chat hit pointing at %s, got %+v", chatOp.ID, res)
+	}
+	if res := hp.Search(room, SearchQuery{Text: "quarterly rep"}); len(res) != 1 || res[0].OperationID != fileOp.ID {
+		t.Fatalf("expected file name prefix hit, got %+v", res)
+	}
+	if res := hp.Search(room, SearchQuery{Text: "staging", UserID: "bob"}); len(res) != 0 {
+		t.Fatalf("author filter should exclude alice's message, got %+v", res)
+	}
+	if res := hp.Search(room, SearchQuery{Text: "report", Type: ItemChat}); len(res) != 0 {
+		t.Fatalf("type filter should exclude clipboard items, got %+v", res)
+	}
+	if res := hp.Search(room, SearchQuery{Text: "staging", Since: chatOp.Timestamp + 1}); len(res) != 0 {
+		t.Fatalf("time filter should exclude older items, got %+v", res)
+	}
+
+	editOp := hp.AddOperation(room, OpModify, "m1", &Item{ID: "m1", Type: ItemChat, Data: &ChatMessage{ID: "m1", Message: "Deploy the production server"}}, "alice", "Alice")
+	if res := hp.Search(room, SearchQuery{Text: "staging"}); len(res) != 0 {
+		t.Fatalf("edited-away text should no longer match, got %+v", res)
+	}
+	if res := hp.Search(room, SearchQuery{Text: "production"}); len(res) != 1 || res[0].OperationID != chatOp.ID || res[0].LatestOperationID != editOp.ID {
+		t.Fatalf("expected edited hit to reference both operations, got %+v", res)
+	}
+
+	hp.AddOperation(room, OpRemove, "c1", &Item{ID: "c1", Type: ItemClipboard}, "bob", "Bob")
+	if res := hp.Search(room, SearchQuery{Text: "report"}); len(res) != 0 {
+		t.Fatalf("removed items should not match, got %+v", res)
+	}
+}
+
+// Ensures items folded into a snapshot stay searchable under their original operation.
+func TestHistoryPoolSearchAfterCompaction(t *testing.T) {
+	hp := NewHistoryPool()
+	room := "room-search-compact"
+
+	clipOp := hp.AddOperation(room, OpAdd, "c1", &Item{ID: "c1", Type: ItemClipboard, Data: &clip_helper.ClipboardItem{Type: clip_helper.ClipboardText, Text: "needle in haystack"}}, "u1", "")
+	for i := 0; i <= maxOperationsPerRoom; i++ {
+		id := fmt.Sprintf("m%d", i)
+		hp.AddOperation(room, OpAdd, id, &Item{ID: id, Type: ItemChat, Data: &ChatMessage{ID: id, Message: "hay", Timestamp: int64(i)}}, "u1", "")
+	}
+
+	if res := hp.Search(room, SearchQuery{Text: "needle"}); len(res) != 1 || res[0].OperationID != clipOp.ID {
+		t.Fatalf("expected compacted clipboard item to stay searchable, got %+v", res)
+	}
+	if res := hp.Search(room, SearchQuery{Text: "hay", Limit: 500}); len(res) > maxSearchLimit {
+		t.Fatalf("expected results capped at %d, got %d", maxSearchLimit, len(res))
+	}
+}
+
+// Verifies GET /api/rooms/{id}/search requires membership and returns matches.
+func TestHandleRoomSearch(t *testing.T) {
+	app := newTestApp()
+	member := app.CreateUser("Member")
+	outsider := app.CreateUser("Outsider")
+	room := app.CreateRoom("Searchable", "host")
+	if _, err := app.JoinRoom(member.ID, room.ID); err != nil {
+		t.Fatalf("JoinRoom error: %v", err)
+	}
+	app.SendChatMessage(room.ID, member.ID, "the wifi password is on the fridge")
+
+	outsiderToken, _ := app.issueToken(outsider.ID)
+	req := httptest.NewRequest(http.MethodGet, "/api/rooms/"+room.ID+"/search?q=wifi", nil)
+	req.Header.Set("Authorization", "Bearer "+outsiderToken)
+	rr := httptest.NewRecorder()
+	app.handleRoomRoutes(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("non-member search expected 403, got %d", rr.Code)
+	}
+
+	memberToken, _ := app.issueToken(member.ID)
+	req = httptest.NewRequest(http.MethodGet, "/api/rooms/"+room.ID+"/search?q=wifi&type=chat&author="+member.ID, nil)
+	req.Header.Set("Authorization", "Bearer "+memberToken)
+	rr = httptest.NewRecorder()
+	app.handleRoomRoutes(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("search expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	results := decodeResponseBody[[]*SearchResult](t, rr)
+	if len(results) != 1 || results[0].OperationID == "" || results[0].UserID != member.ID {
+		t.Fatalf("expected one result pointing at an operation, got %+v", results)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/rooms/"+room.ID+"/search?q=wifi&since=yesterday", nil)
+	req.Header.Set("Authorization", "Bearer "+memberToken)
+	rr = httptest.NewRecorder()
+	app.handleRoomRoutes(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("invalid since expected 400, got %d", rr.Code)
+	}
+}
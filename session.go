@@ -0,0 +1,322 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	errSessionNotFound = errors.New("session not found")
+	errRefreshReused   = errors.New("refresh token reused")
+)
+
+// authSession is one login of a user. Access tokens name their session, so
+// revoking it invalidates every token minted for it, and a token outlives neither
+// the session nor the user it was issued to.
+type authSession struct {
+	ID      string
+	UserID  string
+	expires time.Time // refresh token expiry; rotation extends it
+
+	refreshHash  string
+	previousHash string // rotated-out refresh token; presenting it again revokes the session
+}
+
+// sessionSweepInterval is how often expired sessions and revoked token IDs are
+// dropped while new sessions are being opened.
+const sessionSweepInterval = 10 * time.Minute
+
+// sessionStore keeps live sessions and the IDs (jti) of revoked access tokens.
+// Revoked IDs are dropped once the token would have expired anyway.
+type sessionStore struct {
+	sessions  map[string]*authSession
+	revoked   map[string]time.Time // jti -> token expiry
+	local     map[string]string    // userID -> access token handed to the host's own UI
+	lastSweep time.Time
+	mu        sync.Mutex
+}
+
+func newSessionStore() *sessionStore {
+	return &sessionStore{
+		sessions:  make(map[string]*authSession),
+		revoked:   make(map[string]time.Time),
+		local:     make(map[string]string),
+		lastSweep: time.Now(),
+	}
+}
+
+func randomToken(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func hashRefreshToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// newRefreshToken returns "<sessionID>.<secret>" and the hash of the secret.
+func newRefreshToken(sessionID string) (string, string, error) {
+	secret, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	return sessionID + "." + secret, hashRefreshToken(secret), nil
+}
+
+// create opens a session for userID and returns it with its first refresh token.
+func (s *sessionStore) create(userID string) (*authSession, string, error) {
+	id, err := randomToken(16)
+	if err != nil {
+		return nil, "", err
+	}
+	refresh, hash, err := newRefreshToken(id)
+	if err != nil {
+		return nil, "", err
+	}
+	session := &authSession{
+		ID:          id,
+		UserID:      userID,
+		expires:     time.Now().Add(refreshTokenExpiry),
+		refreshHash: hash,
+	}
+
+	s.mu.Lock()
+	if now := time.Now(); now.Sub(s.lastSweep) >= sessionSweepInterval {
+		s.sweepLocked(now)
+	}
+	s.sessions[id] = session
+	s.mu.Unlock()
+	return session, refresh, nil
+}
+
+// sweepLocked drops sessions whose refresh token expired and revoked token IDs
+// whose token expired. Callers must hold s.mu.
+func (s *sessionStore) sweepLocked(now time.Time) {
+	s.lastSweep = now
+	for id, session := range s.sessions {
+		if now.After(session.expires) {
+			delete(s.sessions, id)
+		}
+	}
+	for jti, expires := range s.revoked {
+		if now.After(expires) {
+			delete(s.revoked, jti)
+		}
+	}
+}
+
+// rotate exchanges a refresh token for a new one. Refresh tokens are single use:
+// presenting the previous one again means it leaked, and the session is revoked.
+func (s *sessionStore) rotate(refresh string) (*authSession, string, error) {
+	sessionID, secret, ok := strings.Cut(strings.TrimSpace(refresh), ".")
+	if !ok || sessionID == "" || secret == "" {
+		return nil, "", errSessionNotFound
+	}
+	hash := hashRefreshToken(secret)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, exists := s.sessions[sessionID]
+	if !exists || time.Now().After(session.expires) {
+		delete(s.sessions, sessionID)
+		return nil, "", errSessionNotFound
+	}
+	if session.previousHash != "" && subtle.ConstantTimeCompare([]byte(hash), []byte(session.previousHash)) == 1 {
+		delete(s.sessions, sessionID)
+		return nil, "", errRefreshReused
+	}
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(session.refreshHash)) != 1 {
+		return nil, "", errSessionNotFound
+	}
+
+	next, nextHash, err := newRefreshToken(sessionID)
+	if err != nil {
+		return nil, "", err
+	}
+	session.previousHash = session.refreshHash
+	session.refreshHash = nextHash
+	session.expires = time.Now().Add(refreshTokenExpiry)
+	copied := *session
+	return &copied, next, nil
+}
+
+// holdsRefresh reports whether refresh is the current refresh token of sessionID,
+// without rotating it.
+func (s *sessionStore) holdsRefresh(sessionID, refresh string) bool {
+	id, secret, ok := strings.Cut(strings.TrimSpace(refresh), ".")
+	if !ok || id != sessionID || secret == "" {
+		return false
+	}
+	hash := hashRefreshToken(secret)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, exists := s.sessions[sessionID]
+	return exists && time.Now().Before(session.expires) &&
+		subtle.ConstantTimeCompare([]byte(hash), []byte(session.refreshHash)) == 1
+}
+
+// revokeSession ends a session and blocks the access token jti until it expires.
+func (s *sessionStore) revokeSession(sessionID, jti string, tokenExpiry time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, sessionID)
+	if jti != "" {
+		s.revoked[jti] = tokenExpiry
+	}
+	now := time.Now()
+	for id, expires := range s.revoked {
+		if now.After(expires) {
+			delete(s.revoked, id)
+		}
+	}
+}
+
+// revokeUser ends every session of userID and returns their IDs.
+func (s *sessionStore) revokeUser(userID string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.local, userID)
+	var ended []string
+	for id, session := range s.sessions {
+		if session.UserID == userID {
+			delete(s.sessions, id)
+			ended = append(ended, id)
+		}
+	}
+	return ended
+}
+
+// allows reports whether an access token's session is live, belongs to the
+// token's user and the token itself has not been revoked.
+func (s *sessionStore) allows(claims *JWTClaims) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, revoked := s.revoked[claims.ID]; revoked {
+		return false
+	}
+	session, exists := s.sessions[claims.SessionID]
+	return exists && session.UserID == claims.UserID && time.Now().Before(session.expires)
+}
+
+// issueSession logs userID in, returning a short-lived access token and the
+// refresh token that renews it.
+func (a *App) issueSession(userID string) (*SessionTokens, error) {
+	session, refresh, err := a.sessions.create(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+	return a.sessionTokens(session, refresh)
+}
+
+// issueToken opens a new session for userID and returns only its access token.
+func (a *App) issueToken(userID string) (string, error) {
+	tokens, err := a.issueSession(userID)
+	if err != nil {
+		return "", err
+	}
+	return tokens.Token, nil
+}
+
+// refreshSession rotates a refresh token and mints a new access token for its session.
+func (a *App) refreshSession(refresh string) (*SessionTokens, error) {
+	session, next, err := a.sessions.rotate(refresh)
+	if err != nil {
+		if errors.Is(err, errRefreshReused) {
+			sessionID := sessionIDFromRefresh(refresh)
+			fmt.Printf("Refresh token reuse detected; revoked session %s\n", sessionID)
+			a.sseManager.CloseSession(sessionID)
+		}
+		return nil, err
+	}
+
+	a.mu.RLock()
+	_, userExists := a.users[session.UserID]
+	a.mu.RUnlock()
+	if !userExists {
+		a.sessions.revokeSession(session.ID, "", time.Time{})
+		return nil, errors.New("user not found")
+	}
+	return a.sessionTokens(session, next)
+}
+
+func sessionIDFromRefresh(refresh string) string {
+	sessionID, _, _ := strings.Cut(strings.TrimSpace(refresh), ".")
+	return sessionID
+}
+
+func (a *App) sessionTokens(session *authSession, refresh string) (*SessionTokens, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create token ID: %w", err)
+	}
+	now := time.Now()
+	expires := now.Add(accessTokenExpiry)
+	claims := JWTClaims{
+		UserID:    session.UserID,
+		SessionID: session.ID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Subject:   session.UserID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expires),
+		},
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(a.jwtSecret)
+	if err != nil {
+		return nil, err
+	}
+	return &SessionTokens{Token: token, RefreshToken: refresh, ExpiresAt: expires.Unix()}, nil
+}
+
+// logout revokes the session behind an access token, along with the token itself,
+// and closes event streams opened with it.
+func (a *App) logout(claims *JWTClaims) {
+	var expires time.Time
+	if claims.ExpiresAt != nil {
+		expires = claims.ExpiresAt.Time
+	}
+	a.sessions.revokeSession(claims.SessionID, claims.ID, expires)
+	a.sseManager.CloseSession(claims.SessionID)
+	fmt.Printf("User %s logged out of session %s\n", claims.UserID, claims.SessionID)
+}
+
+// localToken returns an access token for a user of the host's own UI, reusing
+// the previous one while it is valid so repeated downloads do not pile up sessions.
+func (a *App) localToken(userID string) (string, error) {
+	a.sessions.mu.Lock()
+	token := a.sessions.local[userID]
+	a.sessions.mu.Unlock()
+	if token != "" {
+		if user, err := a.authenticateToken(token); err == nil && user.ID == userID {
+			return token, nil
+		}
+	}
+
+	token, err := a.issueToken(userID)
+	if err != nil {
+		return "", err
+	}
+	a.sessions.mu.Lock()
+	a.sessions.local[userID] = token
+	a.sessions.mu.Unlock()
+	return token, nil
+}
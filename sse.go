@@ -4,91 +4,271 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/gorilla/websocket"
 )
 
 // SSE Event Types
 type SSEEventType string
 
 const (
-	EventConnected        SSEEventType = "connected"
-	EventUserCreated      SSEEventType = "user_created"
-	EventUserLeft         SSEEventType = "user_left"
-	EventRoomCreated      SSEEventType = "room_created"
-	EventRoomDeleted      SSEEventType = "room_deleted"
-	EventUserInvited      SSEEventType = "user_invited"
-	EventUserJoined       SSEEventType = "user_joined"
-	EventChatMessage      SSEEventType = "chat_message"
-	EventHeartbeat        SSEEventType = "heartbeat"
-	EventClipboardCopied  SSEEventType = "clipboard_copied"
-	EventClipboardUpdated SSEEventType = "clipboard_updated"
-	EventUserOffline      SSEEventType = "user_offline"
-	EventJoinRequest      SSEEventType = "join_request"
+	EventConnected           SSEEventType = "connected"
+	EventUserCreated         SSEEventType = "user_created"
+	EventUserLeft            SSEEventType = "user_left"
+	EventRoomCreated         SSEEventType = "room_created"
+	EventRoomDeleted         SSEEventType = "room_deleted"
+	EventUserInvited         SSEEventType = "user_invited"
+	EventUserJoined          SSEEventType = "user_joined"
+	EventChatMessage         SSEEventType = "chat_message"
+	EventHeartbeat           SSEEventType = "heartbeat"
+	EventClipboardCopied     SSEEventType = "clipboard_copied"
+	EventClipboardUpdated    SSEEventType = "clipboard_updated"
+	EventUserOffline         SSEEventType = "user_offline" // stream gone; the user keeps its identity for userTimeout
+	EventUserOnline          SSEEventType = "user_online"
+	EventUserRemoved         SSEEventType = "user_removed"
+	EventJoinRequest         SSEEventType = "join_request"
+	EventChatMessageEdited   SSEEventType = "chat_message_edited"
+	EventClipboardModified   SSEEventType = "clipboard_modified"
+	EventChatMessageDeleted  SSEEventType = "chat_message_deleted"
+	EventClipboardRemoved    SSEEventType = "clipboard_removed"
+	EventRoomSettingsUpdated SSEEventType = "room_settings_updated"
+	EventRetentionExpired    SSEEventType = "retention_expired"
+	EventResyncRequired      SSEEventType = "resync_required"
+	EventTyping              SSEEventType = "typing"
+	EventPresence            SSEEventType = "presence"
+	EventRoomModeration      SSEEventType = "room_moderation"
+	EventRoomKeyNeeded       SSEEventType = "room_key_needed"
+	EventRoomKeyUpdated      SSEEventType = "room_key_updated"
 )
 
+const (
+	// sseReplayBufferSize is how many recent events are kept per user for replay after a reconnect.
+	sseReplayBufferSize = 256
+	// sseReconnectGrace is how long a dropped user is kept around to resume its stream.
+	sseReconnectGrace = 15 * time.Second
+)
+
+// ephemeralEvents are delivered live only; replaying them after a reconnect is meaningless.
+var ephemeralEvents = map[SSEEventType]bool{
+	EventConnected:      true,
+	EventHeartbeat:      true,
+	EventResyncRequired: true,
+	EventTyping:         true,
+	EventPresence:       true,
+}
+
 // SSEEvent represents a server-sent event
 type SSEEvent struct {
+	ID        uint64       `json:"id"`
 	Type      SSEEventType `json:"type"`
 	Data      interface{}  `json:"data"`
 	Timestamp int64        `json:"timestamp"`
 }
 
-// SSEClient represents a connected SSE client
+// ResyncRequiredEvent tells a reconnecting client that events were lost and it
+// must refetch its state instead of relying on replay.
+type ResyncRequiredEvent struct {
+	LastEventID uint64 `json:"lastEventId"`
+	Reason      string `json:"reason"`
+}
+
+// sseMessage is an encoded event, shared by every recipient and the replay buffers.
+type sseMessage struct {
+	ID      uint64
+	Type    SSEEventType
+	Payload []byte
+}
+
+// replayBuffer holds a user's most recent events in ID order.
+type replayBuffer struct {
+	events  []*sseMessage
+	evicted uint64 // highest ID dropped to respect sseReplayBufferSize
+}
+
+// SSEClient represents a connected event stream client. Events are written to
+// Writer as SSE, or as WebSocket text frames when the client connected over /api/ws.
 type SSEClient struct {
-	UserID  string
-	Writer  http.ResponseWriter
-	Flusher http.Flusher
-	mu      sync.Mutex
+	UserID    string
+	Writer    http.ResponseWriter
+	Flusher   http.Flusher
+	conn      *websocket.Conn
+	queue     *clientQueue
+	sessionID string     // auth session the stream was opened with; guarded by SSEManager.mu
+	mu        sync.Mutex // serializes writes to the connection
 }
 
 // SSEManager manages SSE connections and broadcasts events
 type SSEManager struct {
-	clients map[string]*SSEClient
-	mu      sync.RWMutex
+	clients   map[string]*SSEClient
+	replay    map[string]*replayBuffer // userID -> recent events
+	lastID    uint64
+	queueSize int
+	overflow  OverflowPolicy
+	mu        sync.RWMutex
 }
 
 // NewSSEManager creates a new SSE manager
 func NewSSEManager() *SSEManager {
 	return &SSEManager{
-		clients: make(map[string]*SSEClient),
+		clients:   make(map[string]*SSEClient),
+		replay:    make(map[string]*replayBuffer),
+		queueSize: defaultClientQueueSize,
+		overflow:  defaultOverflowPolicy,
 	}
 }
 
 // AddClient adds a new SSE client
 func (sm *SSEManager) AddClient(userID string, w http.ResponseWriter, flusher http.Flusher) *SSEClient {
-	sm.mu.Lock()
-	defer sm.mu.Unlock()
+	client, _ := sm.attach(&SSEClient{UserID: userID, Writer: w, Flusher: flusher}, 0)
+	return client
+}
+
+// ResumeClient adds a client that reconnected after lastEventID and writes every
+// buffered event it missed before any new event reaches it. If the missed events
+// are no longer buffered a resync_required event is written instead, and false returned.
+func (sm *SSEManager) ResumeClient(userID string, w http.ResponseWriter, flusher http.Flusher, lastEventID uint64) (*SSEClient, bool) {
+	return sm.attach(&SSEClient{UserID: userID, Writer: w, Flusher: flusher}, lastEventID)
+}
 
-	client := &SSEClient{
-		UserID:  userID,
-		Writer:  w,
-		Flusher: flusher,
+// ResumeWebSocketClient is ResumeClient for a client connected over WebSocket.
+func (sm *SSEManager) ResumeWebSocketClient(userID string, conn *websocket.Conn, lastEventID uint64) (*SSEClient, bool) {
+	return sm.attach(&SSEClient{UserID: userID, conn: conn}, lastEventID)
+}
+
+func (sm *SSEManager) attach(client *SSEClient, lastEventID uint64) (*SSEClient, bool) {
+	userID := client.UserID
+
+	// Hold the client's lock until the replay is written so live events queue behind it
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	sm.mu.Lock()
+	client.queue = newClientQueue(sm.queueSize, sm.overflow)
+	go client.run(sm)
+	if previous, ok := sm.clients[userID]; ok {
+		previous.close()
 	}
 	sm.clients[userID] = client
-	return client
+	buf, ok := sm.replay[userID]
+	if !ok {
+		buf = &replayBuffer{}
+		sm.replay[userID] = buf
+	}
+	if lastEventID == 0 {
+		sm.mu.Unlock()
+		return client, true
+	}
+
+	var missed []*sseMessage
+	reason := ""
+	switch {
+	case lastEventID > sm.lastID:
+		reason = "unknown_event_id" // IDs from before a host restart
+	case buf.evicted > lastEventID || (!ok && lastEventID < sm.lastID):
+		reason = "replay_gap"
+	default:
+		missed = buf.since(lastEventID)
+	}
+	var resync *sseMessage
+	if reason != "" {
+		sm.lastID++
+		resync, _ = newSSEMessage(sm.lastID, EventResyncRequired, ResyncRequiredEvent{LastEventID: lastEventID, Reason: reason})
+	}
+	sm.mu.Unlock()
+
+	if resync != nil {
+		if err := client.writeLocked(resync); err != nil {
+			fmt.Printf("Failed to send resync to %s: %v\n", userID, err)
+		}
+		return client, false
+	}
+	for _, msg := range missed {
+		if err := client.writeLocked(msg); err != nil {
+			fmt.Printf("Replay to %s stopped: %v\n", userID, err)
+			break
+		}
+	}
+	if len(missed) > 0 {
+		fmt.Printf("Replayed %d SSE events to %s after %d\n", len(missed), userID, lastEventID)
+	}
+	return client, true
 }
 
-// RemoveClient removes an SSE client if it matches the provided client instance
-func (sm *SSEManager) RemoveClient(client *SSEClient) {
+// AckEvents releases a user's buffered events up to lastEventID, which the client
+// has confirmed it processed.
+func (sm *SSEManager) AckEvents(userID string, lastEventID uint64) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if buf, ok := sm.replay[userID]; ok {
+		buf.release(lastEventID)
+	}
+}
+
+// ForgetUser drops a user's replay buffer once the user is gone for good.
+func (sm *SSEManager) ForgetUser(userID string) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
+	delete(sm.replay, userID)
+}
 
+// RemoveClient removes an SSE client if it matches the provided client instance
+// and stops its writer.
+func (sm *SSEManager) RemoveClient(client *SSEClient) {
+	sm.mu.Lock()
 	if current, ok := sm.clients[client.UserID]; ok && current == client {
 		delete(sm.clients, client.UserID)
 	}
+	sm.mu.Unlock()
+
+	if client.queue != nil {
+		client.close()
+	}
+}
+
+// bindSession records the auth session a client's stream was opened with.
+func (sm *SSEManager) bindSession(client *SSEClient, sessionID string) {
+	sm.mu.Lock()
+	client.sessionID = sessionID
+	sm.mu.Unlock()
+}
+
+// CloseSession disconnects streams opened with a revoked auth session.
+func (sm *SSEManager) CloseSession(sessionID string) {
+	if sessionID == "" {
+		return
+	}
+	sm.mu.RLock()
+	var matched []*SSEClient
+	for _, client := range sm.clients {
+		if client.sessionID == sessionID {
+			matched = append(matched, client)
+		}
+	}
+	sm.mu.RUnlock()
+
+	for _, client := range matched {
+		sm.RemoveClient(client)
+	}
 }
 
 // SendToClient sends an event to a specific client
 func (sm *SSEManager) SendToClient(userID string, eventType SSEEventType, data interface{}) error {
-	client, err := sm.getClient(userID)
+	msg, clients, err := sm.publish(eventType, data, []string{userID}, "")
 	if err != nil {
 		return err
 	}
+	if len(clients) == 0 {
+		return fmt.Errorf("client %s not connected", userID)
+	}
 
-	if err := client.sendEvent(eventType, data); err != nil {
+	client := clients[0]
+	if !client.enqueue(msg) {
 		sm.RemoveClient(client)
-		return fmt.Errorf("failed to send event to client %s: %w", userID, err)
+		return fmt.Errorf("client %s send queue overflowed", userID)
 	}
 
 	return nil
@@ -96,38 +276,124 @@ func (sm *SSEManager) SendToClient(userID string, eventType SSEEventType, data i
 
 // BroadcastToAll sends an event to all connected clients
 func (sm *SSEManager) BroadcastToAll(eventType SSEEventType, data interface{}) {
-	clients := sm.snapshotClients(nil)
+	msg, clients, err := sm.publish(eventType, data, nil, "")
+	if err != nil {
+		fmt.Printf("BroadcastToAll: %v\n", err)
+		return
+	}
+	sm.deliver("BroadcastToAll", msg, clients)
+}
+
+// BroadcastToUsers sends an event to the provided user IDs
+func (sm *SSEManager) BroadcastToUsers(userIDs []string, eventType SSEEventType, data interface{}, excludeUserID string) {
+	if userIDs == nil {
+		userIDs = []string{}
+	}
+	msg, clients, err := sm.publish(eventType, data, userIDs, excludeUserID)
+	if err != nil {
+		fmt.Printf("BroadcastToUsers: %v\n", err)
+		return
+	}
+	sm.deliver("BroadcastToUsers", msg, clients)
+}
+
+// deliver queues msg for each client, dropping clients whose queue overflowed.
+func (sm *SSEManager) deliver(caller string, msg *sseMessage, clients []*SSEClient) {
 	for _, client := range clients {
-		if err := client.sendEvent(eventType, data); err != nil {
-			fmt.Printf("BroadcastToAll: dropping client %s due to send error: %v\n", client.UserID, err)
+		if !client.enqueue(msg) {
+			fmt.Printf("%s: dropping client %s, send queue overflowed\n", caller, client.UserID)
 			sm.RemoveClient(client)
 		}
 	}
 }
 
-// BroadcastToUsers sends an event to the provided user IDs
-func (sm *SSEManager) BroadcastToUsers(userIDs []string, eventType SSEEventType, data interface{}, excludeUserID string) {
-	targetSet := make(map[string]struct{}, len(userIDs))
-	for _, id := range userIDs {
-		targetSet[id] = struct{}{}
+// publish assigns the next event ID, records the event in each recipient's replay
+// buffer and returns the connected clients to write it to. A nil userIDs targets
+// every connected user and every user with a replay buffer.
+func (sm *SSEManager) publish(eventType SSEEventType, data interface{}, userIDs []string, excludeUserID string) (*sseMessage, []*SSEClient, error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	sm.lastID++
+	msg, err := newSSEMessage(sm.lastID, eventType, data)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	filter := func(client *SSEClient) bool {
-		if client.UserID == excludeUserID {
-			return false
+	targets := make(map[string]struct{}, len(userIDs))
+	if userIDs == nil {
+		for id := range sm.replay {
+			targets[id] = struct{}{}
+		}
+		for id := range sm.clients {
+			targets[id] = struct{}{}
+		}
+	} else {
+		for _, id := range userIDs {
+			targets[id] = struct{}{}
+		}
+	}
+	delete(targets, excludeUserID)
+
+	clients := make([]*SSEClient, 0, len(targets))
+	for id := range targets {
+		if !ephemeralEvents[eventType] {
+			buf, ok := sm.replay[id]
+			if !ok {
+				buf = &replayBuffer{}
+				sm.replay[id] = buf
+			}
+			buf.push(msg)
+		}
+		if client, ok := sm.clients[id]; ok {
+			clients = append(clients, client)
 		}
-		_, ok := targetSet[client.UserID]
-		return ok
 	}
+	return msg, clients, nil
+}
+
+func newSSEMessage(id uint64, eventType SSEEventType, data interface{}) (*sseMessage, error) {
+	payload, err := json.Marshal(SSEEvent{
+		ID:        id,
+		Type:      eventType,
+		Data:      data,
+		Timestamp: time.Now().Unix(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshal event: %w", err)
+	}
+	return &sseMessage{ID: id, Type: eventType, Payload: payload}, nil
+}
 
-	clients := sm.snapshotClients(filter)
+func (b *replayBuffer) push(msg *sseMessage) {
+	if len(b.events) >= sseReplayBufferSize {
+		b.evicted = b.events[0].ID
+		b.events = append(b.events[:0], b.events[1:]...)
+	}
+	b.events = append(b.events, msg)
+}
 
-	for _, client := range clients {
-		if err := client.sendEvent(eventType, data); err != nil {
-			fmt.Printf("BroadcastToUsers: dropping client %s due to send error: %v\n", client.UserID, err)
-			sm.RemoveClient(client)
+// release drops the events up to and including id once the client confirmed them.
+func (b *replayBuffer) release(id uint64) {
+	n := 0
+	for n < len(b.events) && b.events[n].ID <= id {
+		n++
+	}
+	if n == 0 {
+		return
+	}
+	b.evicted = max(b.evicted, b.events[n-1].ID)
+	b.events = append(b.events[:0], b.events[n:]...)
+}
+
+// since returns the buffered events after lastID, oldest first.
+func (b *replayBuffer) since(lastID uint64) []*sseMessage {
+	for i, msg := range b.events {
+		if msg.ID > lastID {
+			return append([]*sseMessage(nil), b.events[i:]...)
 		}
 	}
+	return nil
 }
 
 // IsConnected checks if a user has an active SSE connection
@@ -169,26 +435,23 @@ func (sm *SSEManager) snapshotClients(filter func(*SSEClient) bool) []*SSEClient
 	return clients
 }
 
-func (client *SSEClient) sendEvent(eventType SSEEventType, data interface{}) error {
-	event := SSEEvent{
-		Type:      eventType,
-		Data:      data,
-		Timestamp: time.Now().Unix(),
-	}
-
-	payload, err := json.Marshal(event)
-	if err != nil {
-		return fmt.Errorf("marshal event: %w", err)
-	}
-
+func (client *SSEClient) writeMessage(msg *sseMessage) error {
 	client.mu.Lock()
 	defer client.mu.Unlock()
+	return client.writeLocked(msg)
+}
+
+func (client *SSEClient) writeLocked(msg *sseMessage) error {
+	if client.conn != nil {
+		client.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		return client.conn.WriteMessage(websocket.TextMessage, msg.Payload)
+	}
 
-	if _, err := fmt.Fprintf(client.Writer, "event: %s\n", eventType); err != nil {
+	if _, err := fmt.Fprintf(client.Writer, "id: %d\nevent: %s\n", msg.ID, msg.Type); err != nil {
 		return err
 	}
 
-	if _, err := fmt.Fprintf(client.Writer, "data: %s\n\n", payload); err != nil {
+	if _, err := fmt.Fprintf(client.Writer, "data: %s\n\n", msg.Payload); err != nil {
 		return err
 	}
 
@@ -196,22 +459,32 @@ func (client *SSEClient) sendEvent(eventType SSEEventType, data interface{}) err
 	return nil
 }
 
-// handleSSE handles Server-Sent Events connections
-func (a *App) handleSSE(w http.ResponseWriter, r *http.Request) {
-	userID := r.URL.Query().Get("userId")
-	if userID == "" {
-		http.Error(w, "userId required", http.StatusBadRequest)
-		return
+// lastEventID reads the reconnect position from the Last-Event-ID header, which
+// browsers send automatically, or the lastEventId query parameter.
+func lastEventID(r *http.Request) uint64 {
+	raw := strings.TrimSpace(r.Header.Get("Last-Event-ID"))
+	if raw == "" {
+		raw = strings.TrimSpace(r.URL.Query().Get("lastEventId"))
+	}
+	id, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		return 0
 	}
+	return id
+}
 
+// handleSSE handles Server-Sent Events connections
+func (a *App) handleSSE(w http.ResponseWriter, r *http.Request) {
 	token := r.URL.Query().Get("token")
-	authUser, err := a.authenticateToken(token)
+	authUser, claims, err := a.verifyToken(token)
 	if err != nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	if _, err := enforceUserMatch(userID, authUser); err != nil {
+	// userId is optional; when present it must match the token
+	userID, err := enforceUserMatch(r.URL.Query().Get("userId"), authUser)
+	if err != nil {
 		http.Error(w, "Forbidden", http.StatusForbidden)
 		return
 	}
@@ -221,7 +494,7 @@ func (a *App) handleSSE(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Headers", "Cache-Control")
+	w.Header().Set("Access-Control-Allow-Headers", "Cache-Control, Last-Event-ID")
 
 	// Get flusher for SSE
 	flusher, ok := w.(http.Flusher)
@@ -230,8 +503,10 @@ func (a *App) handleSSE(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Add client to SSE manager
-	client := a.sseManager.AddClient(userID, w, flusher)
+	// Add client to SSE manager, replaying anything missed since the last event it saw
+	client, _ := a.sseManager.ResumeClient(userID, w, flusher, lastEventID(r))
+	a.sseManager.bindSession(client, claims.SessionID)
+	a.markUserOnline(userID)
 	fmt.Printf("SSE connected for user: %s\n", userID)
 
 	// Send initial connection event
@@ -242,31 +517,12 @@ func (a *App) handleSSE(w http.ResponseWriter, r *http.Request) {
 	// Handle connection cleanup
 	defer func() {
 		a.sseManager.RemoveClient(client)
+		// The writer may still be mid-write; the ResponseWriter must not outlive the handler
+		<-client.Done()
 		fmt.Printf("SSE disconnected for user: %s\n", userID)
 
-		// Check if user has reconnected (has a valid client in SSE manager)
-		if a.sseManager.IsConnected(userID) {
-			fmt.Printf("User %s reconnected, skipping cleanup\n", userID)
-			return
-		}
-
-		// Handle user cleanup
-		a.mu.Lock()
-		user, exists := a.users[userID]
-		a.mu.Unlock()
-
-		if exists {
-			if user.RoomID != nil {
-				a.LeaveRoom(userID)
-			}
-
-			a.mu.Lock()
-			delete(a.users, userID)
-			a.mu.Unlock()
-
-			// Notify others to update their user list
-			a.sseManager.BroadcastToAll(EventUserOffline, map[string]string{"userId": userID})
-		}
+		// Give the client a chance to reconnect and resume before treating it as gone
+		time.AfterFunc(sseReconnectGrace, func() { a.cleanupDisconnectedUser(userID) })
 	}()
 
 	ticker := time.NewTicker(30 * time.Second)
@@ -276,6 +532,9 @@ func (a *App) handleSSE(w http.ResponseWriter, r *http.Request) {
 		select {
 		case <-r.Context().Done():
 			return
+		case <-client.Done():
+			// Writer stopped: the connection failed or its queue overflowed
+			return
 		case <-ticker.C:
 			if err := a.sseManager.SendHeartbeat(userID); err != nil {
 				fmt.Printf("Heartbeat send failed for user %s: %v\n", userID, err)
@@ -284,3 +543,14 @@ func (a *App) handleSSE(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 }
+
+// cleanupDisconnectedUser marks a user whose SSE stream has not come back as
+// offline. The user keeps its identity and rooms until userTimeout passes.
+func (a *App) cleanupDisconnectedUser(userID string) {
+	// Check if user has reconnected (has a valid client in SSE manager)
+	if a.sseManager.IsConnected(userID) {
+		fmt.Printf("User %s reconnected, skipping cleanup\n", userID)
+		return
+	}
+	a.markUserOffline(userID)
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// OverflowPolicy decides what happens when a client's outbound queue is full.
+type OverflowPolicy string
+
+const (
+	// OverflowDropOldest discards the oldest queued event to make room.
+	OverflowDropOldest OverflowPolicy = "drop_oldest"
+	// OverflowDisconnect closes the connection; the client resumes via Last-Event-ID replay.
+	OverflowDisconnect OverflowPolicy = "disconnect"
+	// OverflowCoalesceHeartbeats never queues a heartbeat behind another and
+	// frees room by dropping queued heartbeats, disconnecting if there are none.
+	OverflowCoalesceHeartbeats OverflowPolicy = "coalesce_heartbeats"
+)
+
+const (
+	defaultClientQueueSize = 512
+	defaultOverflowPolicy  = OverflowDisconnect
+)
+
+// ClientQueueStats describes one connected client's outbound queue.
+type ClientQueueStats struct {
+	UserID    string         `json:"userId"`
+	Transport string         `json:"transport"` // "sse" or "websocket"
+	Depth     int            `json:"depth"`
+	Capacity  int            `json:"capacity"`
+	HighWater int            `json:"highWater"`
+	Delivered uint64         `json:"delivered"`
+	Dropped   uint64         `json:"dropped"`
+	Coalesced uint64         `json:"coalesced"`
+	Policy    OverflowPolicy `json:"policy"`
+}
+
+// clientQueue buffers a client's events so broadcasts never wait on its connection.
+type clientQueue struct {
+	mu       sync.Mutex
+	cond     *sync.Cond // signalled when events arrive, the queue drains, or it closes
+	pending  []*sseMessage
+	capacity int
+	policy   OverflowPolicy
+	writing  bool
+	closed   bool
+	done     chan struct{} // closed when the writer goroutine exits
+
+	highWater int
+	delivered uint64
+	dropped   uint64
+	coalesced uint64
+}
+
+func newClientQueue(capacity int, policy OverflowPolicy) *clientQueue {
+	q := &clientQueue{
+		capacity: capacity,
+		policy:   policy,
+		done:     make(chan struct{}),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+func parseOverflowPolicy(raw string) (OverflowPolicy, error) {
+	switch policy := OverflowPolicy(strings.ToLower(strings.TrimSpace(raw))); policy {
+	case "":
+		return defaultOverflowPolicy, nil
+	case OverflowDropOldest, OverflowDisconnect, OverflowCoalesceHeartbeats:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown overflow policy %q", raw)
+	}
+}
+
+// SetQueuePolicy configures the outbound queue of clients that connect from now on.
+func (sm *SSEManager) SetQueuePolicy(capacity int, policy OverflowPolicy) error {
+	if capacity <= 0 {
+		return fmt.Errorf("queue size must be positive")
+	}
+	if _, err := parseOverflowPolicy(string(policy)); err != nil {
+		return err
+	}
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.queueSize = capacity
+	sm.overflow = policy
+	return nil
+}
+
+// QueueStats reports the outbound queue of every connected client, ordered by user ID.
+func (sm *SSEManager) QueueStats() []ClientQueueStats {
+	clients := sm.snapshotClients(nil)
+	stats := make([]ClientQueueStats, 0, len(clients))
+	for _, client := range clients {
+		stats = append(stats, client.queueStats())
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].UserID < stats[j].UserID })
+	return stats
+}
+
+// GetEventQueueStats reports the outbound event queue of every connected client.
+func (a *App) GetEventQueueStats() []ClientQueueStats {
+	return a.sseManager.QueueStats()
+}
+
+// enqueue adds msg to the client's queue, applying the overflow policy when it is
+// full. It returns false when the client was closed instead.
+func (client *SSEClient) enqueue(msg *sseMessage) bool {
+	q := client.queue
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return false
+	}
+	if q.policy == OverflowCoalesceHeartbeats && msg.Type == EventHeartbeat && q.indexOf(EventHeartbeat) >= 0 {
+		q.coalesced++
+		return true
+	}
+
+	if len(q.pending) >= q.capacity {
+		switch q.policy {
+		case OverflowDropOldest:
+			q.pending = q.pending[1:]
+			q.dropped++
+		case OverflowCoalesceHeartbeats:
+			i := q.indexOf(EventHeartbeat)
+			if i < 0 {
+				q.closeLocked()
+				return false
+			}
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			q.coalesced++
+		default:
+			q.closeLocked()
+			return false
+		}
+	}
+
+	q.pending = append(q.pending, msg)
+	q.highWater = max(q.highWater, len(q.pending))
+	q.cond.Broadcast()
+	return true
+}
+
+func (q *clientQueue) indexOf(eventType SSEEventType) int {
+	for i, msg := range q.pending {
+		if msg.Type == eventType {
+			return i
+		}
+	}
+	return -1
+}
+
+func (q *clientQueue) closeLocked() {
+	if !q.closed {
+		q.closed = true
+		q.cond.Broadcast()
+	}
+}
+
+// close stops the client's writer and ends its stream.
+func (client *SSEClient) close() {
+	client.queue.mu.Lock()
+	client.queue.closeLocked()
+	client.queue.mu.Unlock()
+}
+
+// Done is closed once the client's writer has stopped, because the client was
+// removed, its connection failed, or its queue overflowed.
+func (client *SSEClient) Done() <-chan struct{} {
+	return client.queue.done
+}
+
+// run drains the client's queue onto its connection until the client is closed.
+func (client *SSEClient) run(sm *SSEManager) {
+	q := client.queue
+	defer func() {
+		if client.conn != nil {
+			// Unblocks the WebSocket read loop so the handler can clean up
+			client.conn.Close()
+		}
+		close(q.done)
+	}()
+
+	for {
+		q.mu.Lock()
+		for len(q.pending) == 0 && !q.closed {
+			q.writing = false
+			q.cond.Broadcast()
+			q.cond.Wait()
+		}
+		if q.closed {
+			q.writing = false
+			q.pending = nil
+			q.cond.Broadcast()
+			q.mu.Unlock()
+			sm.RemoveClient(client)
+			return
+		}
+		msg := q.pending[0]
+		q.pending = q.pending[1:]
+		q.writing = true
+		q.mu.Unlock()
+
+		if err := client.writeMessage(msg); err != nil {
+			fmt.Printf("Dropping client %s due to send error: %v\n", client.UserID, err)
+			client.close()
+			continue
+		}
+
+		q.mu.Lock()
+		q.delivered++
+		q.mu.Unlock()
+	}
+}
+
+// waitIdle blocks until every queued event has been written or the client closed.
+func (client *SSEClient) waitIdle() {
+	q := client.queue
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for (len(q.pending) > 0 || q.writing) && !q.closed {
+		q.cond.Wait()
+	}
+}
+
+func (client *SSEClient) queueStats() ClientQueueStats {
+	q := client.queue
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	transport := "sse"
+	if client.conn != nil {
+		transport = "websocket"
+	}
+	return ClientQueueStats{
+		UserID:    client.UserID,
+		Transport: transport,
+		Depth:     len(q.pending),
+		Capacity:  q.capacity,
+		HighWater: q.highWater,
+		Delivered: q.delivered,
+		Dropped:   q.dropped,
+		Coalesced: q.coalesced,
+		Policy:    q.policy,
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stalledWriter blocks every write until released, like a receiver that stopped reading.
+type stalledWriter struct {
+	header  http.Header
+	release chan struct{}
+	mu      sync.Mutex
+	buf     bytes.Buffer
+}
+
+func newStalledWriter() *stalledWriter {
+	return &stalledWriter{header: http.Header{}, release: make(chan struct{})}
+}
+
+func (s *stalledWriter) Header() http.Header { return s.header }
+func (s *stalledWriter) WriteHeader(int)     {}
+func (s *stalledWriter) Flush()              {}
+func (s *stalledWriter) Write(b []byte) (int, error) {
+	<-s.release
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.Write(b)
+}
+
+// Ensures a stalled receiver does not hold up delivery to other clients.
+func TestSSEQueueIsolatesSlowClient(t *testing.T) {
+	sm := NewSSEManager()
+	slow := newStalledWriter()
+	defer close(slow.release)
+	sm.AddClient("slow", slow, slow)
+	fast := addMockClient(sm, "fast")
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			sm.BroadcastToAll(EventChatMessage, i)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("broadcast blocked on a stalled client")
+	}
+
+	if events := fast.Events(); len(events) != 10 {
+		t.Fatalf("expected fast client to get all 10 events, got %d", len(events))
+	}
+}
+
+// Exercises each overflow policy against a client whose writer is stuck.
+func TestSSEQueueOverflowPolicies(t *testing.T) {
+	fill := func(t *testing.T, policy OverflowPolicy) (*SSEManager, *SSEClient, *stalledWriter) {
+		t.Helper()
+		sm := NewSSEManager()
+		if err := sm.SetQueuePolicy(2, policy); err != nil {
+			t.Fatalf("SetQueuePolicy error: %v", err)
+		}
+		w := newStalledWriter()
+		client := sm.AddClient("u", w, w)
+		// The first event is taken by the writer and blocks there
+		sm.SendToClient("u", EventChatMessage, "in flight")
+		deadline := time.Now().Add(2 * time.Second)
+		for client.queueStats().Depth != 0 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		return sm, client, w
+	}
+
+	t.Run("drop_oldest", func(t *testing.T) {
+		sm, client, w := fill(t, OverflowDropOldest)
+		for _, msg := range []string{"a", "b", "c"} {
+			if err := sm.SendToClient("u", EventChatMessage, msg); err != nil {
+				t.Fatalf("SendToClient error: %v", err)
+			}
+		}
+		stats := client.queueStats()
+		if stats.Depth != 2 || stats.Dropped != 1 || stats.HighWater != 2 {
+			t.Fatalf("expected one dropped event and a full queue, got %+v", stats)
+		}
+		close(w.release)
+		client.waitIdle()
+		if got := w.buf.String(); strings.Contains(got, `"data":"a"`) || !strings.Contains(got, `"data":"c"`) {
+			t.Fatalf("expected the oldest queued event dropped, got %s", got)
+		}
+	})
+
+	t.Run("disconnect", func(t *testing.T) {
+		sm, client, w := fill(t, OverflowDisconnect)
+		defer close(w.release)
+		sm.SendToClient("u", EventChatMessage, "a")
+		sm.SendToClient("u", EventChatMessage, "b")
+		if err := sm.SendToClient("u", EventChatMessage, "c"); err == nil {
+			t.Fatalf("expected overflow to be reported")
+		}
+		if sm.IsConnected("u") {
+			t.Fatalf("expected overflowing client disconnected")
+		}
+		select {
+		case <-client.Done():
+			t.Fatalf("writer should still be stuck on the in-flight event")
+		default:
+		}
+	})
+
+	t.Run("coalesce_heartbeats", func(t *testing.T) {
+		sm, client, w := fill(t, OverflowCoalesceHeartbeats)
+		defer close(w.release)
+		sm.SendHeartbeat("u")
+		sm.SendHeartbeat("u")
+		sm.SendToClient("u", EventChatMessage, "a")
+		sm.SendToClient("u", EventChatMessage, "b")
+		stats := client.queueStats()
+		if stats.Coalesced != 2 || stats.Depth != 2 || !sm.IsConnected("u") {
+			t.Fatalf("expected heartbeats coalesced away to make room, got %+v", stats)
+		}
+		if err := sm.SendToClient("u", EventChatMessage, "c"); err == nil || sm.IsConnected("u") {
+			t.Fatalf("expected disconnect once no heartbeat can be dropped")
+		}
+	})
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+// Ensures every event carries an increasing id: line shared by all of its recipients.
+func TestSSEEventIDsMonotonic(t *testing.T) {
+	sm := NewSSEManager()
+	alice := addMockClient(sm, "alice")
+	bob := addMockClient(sm, "bob")
+
+	sm.BroadcastToAll(EventUserCreated, map[string]string{"id": "carol"})
+	sm.BroadcastToUsers([]string{"alice"}, EventChatMessage, "hi", "")
+	sm.BroadcastToAll(EventUserOffline, map[string]string{"userId": "carol"})
+
+	events := alice.Events()
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events for alice, got %d", len(events))
+	}
+	for i := 1; i < len(events); i++ {
+		if events[i].ID <= events[i-1].ID {
+			t.Fatalf("expected increasing ids, got %d then %d", events[i-1].ID, events[i].ID)
+		}
+	}
+	if bobEvents := bob.Events(); len(bobEvents) != 2 || bobEvents[0].ID != events[0].ID || bobEvents[1].ID != events[2].ID {
+		t.Fatalf("expected bob to see the broadcasts under the same ids, got %+v", bobEvents)
+	}
+}
+
+// Verifies a client resuming from Last-Event-ID receives exactly the events it missed, in order.
+func TestSSEReplayAfterReconnect(t *testing.T) {
+	sm := NewSSEManager()
+	first := addMockClient(sm, "alice")
+	sm.BroadcastToUsers([]string{"alice"}, EventChatMessage, "seen", "")
+	seen := first.Events()[0].ID
+
+	sm.RemoveClient(first.client)
+	sm.BroadcastToUsers([]string{"alice"}, EventChatMessage, "missed one", "")
+	sm.BroadcastToAll(EventUserOffline, map[string]string{"userId": "bob"})
+	sm.SendHeartbeat("alice")
+
+	second, ok := resumeMockClient(sm, "alice", seen)
+	if !ok {
+		t.Fatalf("expected replay to succeed")
+	}
+	events := second.Events()
+	if len(events) != 2 || events[0].Name != string(EventChatMessage) || events[1].Name != string(EventUserOffline) {
+		t.Fatalf("expected the two missed events replayed, got %+v", events)
+	}
+	if payload := decodeEventPayload[string](t, events[0]); payload != "missed one" {
+		t.Fatalf("expected missed message first, got %q", payload)
+	}
+}
+
+// Ensures a client that fell behind the replay buffer is told to resync instead.
+func TestSSEReplayGapRequiresResync(t *testing.T) {
+	sm := NewSSEManager()
+	first := addMockClient(sm, "alice")
+	sm.BroadcastToUsers([]string{"alice"}, EventChatMessage, "seen", "")
+	seen := first.Events()[0].ID
+	sm.RemoveClient(first.client)
+
+	for i := 0; i < sseReplayBufferSize+1; i++ {
+		sm.BroadcastToUsers([]string{"alice"}, EventChatMessage, "flood", "")
+	}
+
+	second, ok := resumeMockClient(sm, "alice", seen)
+	if ok {
+		t.Fatalf("expected replay to be refused after the buffer overflowed")
+	}
+	events := second.Events()
+	if len(events) != 1 || events[0].Name != string(EventResyncRequired) {
+		t.Fatalf("expected a single resync_required event, got %d events", len(events))
+	}
+	if payload := decodeEventPayload[ResyncRequiredEvent](t, events[0]); payload.LastEventID != seen || payload.Reason != "replay_gap" {
+		t.Fatalf("unexpected resync payload %+v", payload)
+	}
+
+	// IDs from a previous host process cannot be replayed either
+	if _, ok := resumeMockClient(sm, "alice", 1<<40); ok {
+		t.Fatalf("expected unknown event id to require a resync")
+	}
+}
+
+// Exercises reading the resume position from the header and the query fallback.
+func TestLastEventID(t *testing.T) {
+	r := httptest.NewRequest("GET", "/api/sse?lastEventId=7", nil)
+	if id := lastEventID(r); id != 7 {
+		t.Fatalf("expected query fallback 7, got %d", id)
+	}
+	r.Header.Set("Last-Event-ID", "42")
+	if id := lastEventID(r); id != 42 {
+		t.Fatalf("expected header to win with 42, got %d", id)
+	}
+	if id := lastEventID(httptest.NewRequest("GET", "/api/sse?lastEventId=bogus", nil)); id != 0 {
+		t.Fatalf("expected malformed id ignored, got %d", id)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"net/http"
+	"strconv"
 	"strings"
 	"testing"
 )
@@ -13,6 +14,7 @@ type mockSSEConn struct {
 	buf     bytes.Buffer
 	status  int
 	flushes int
+	client  *SSEClient
 }
 
 func newMockSSEConn() *mockSSEConn {
@@ -35,17 +37,27 @@ func (m *mockSSEConn) Flush() {
 	m.flushes++
 }
 
+// waitIdle lets the client's writer goroutine finish delivering queued events.
+func (m *mockSSEConn) waitIdle() {
+	if m.client != nil {
+		m.client.waitIdle()
+	}
+}
+
 func (m *mockSSEConn) Reset() {
+	m.waitIdle()
 	m.buf.Reset()
 	m.flushes = 0
 }
 
 type recordedEvent struct {
+	ID   uint64
 	Name string
 	Data string
 }
 
 func (m *mockSSEConn) Events() []recordedEvent {
+	m.waitIdle()
 	raw := strings.TrimSpace(m.buf.String())
 	if raw == "" {
 		return nil
@@ -64,6 +76,8 @@ func (m *mockSSEConn) Events() []recordedEvent {
 		for _, line := range lines {
 			line = strings.TrimSpace(line)
 			switch {
+			case strings.HasPrefix(line, "id:"):
+				evt.ID, _ = strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(line, "id:")), 10, 64)
 			case strings.HasPrefix(line, "event:"):
 				evt.Name = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
 			case strings.HasPrefix(line, "data:"):
@@ -80,11 +94,22 @@ func (m *mockSSEConn) Events() []recordedEvent {
 }
 
 func attachClient(app *App, userID string) *mockSSEConn {
+	return addMockClient(app.sseManager, userID)
+}
+
+func addMockClient(sm *SSEManager, userID string) *mockSSEConn {
 	conn := newMockSSEConn()
-	app.sseManager.AddClient(userID, conn, conn)
+	conn.client = sm.AddClient(userID, conn, conn)
 	return conn
 }
 
+func resumeMockClient(sm *SSEManager, userID string, lastEventID uint64) (*mockSSEConn, bool) {
+	conn := newMockSSEConn()
+	client, ok := sm.ResumeClient(userID, conn, conn, lastEventID)
+	conn.client = client
+	return conn, ok
+}
+
 func findEvent(events []recordedEvent, eventType SSEEventType) (recordedEvent, bool) {
 	for _, evt := range events {
 		if evt.Name == string(eventType) {
@@ -95,6 +120,7 @@ func findEvent(events []recordedEvent, eventType SSEEventType) (recordedEvent, b
 }
 
 type sseEnvelope struct {
+	ID        uint64          `json:"id"`
 	Type      string          `json:"type"`
 	Data      json.RawMessage `json:"data"`
 	Timestamp int64           `json:"timestamp"`
@@ -24,6 +24,49 @@ func newTestApp() *App {
 	return app
 }
 
+// newTestRoom creates a room called name holding a user for each given name.
+// With an owner name the room is private: the owner joins it and the members
+// redeem a join code. Without one the room belongs to the host and the members
+// join it directly. The owner, if any, comes first in the returned users.
+func newTestRoom(t *testing.T, name, ownerName string, memberNames ...string) (*App, *Room, []*User) {
+	t.Helper()
+	app := newTestApp()
+	if ownerName == "" {
+		room := app.CreateRoom(name, "host")
+		var users []*User
+		for _, memberName := range memberNames {
+			user := app.CreateUser(memberName)
+			if _, err := app.JoinRoom(user.ID, room.ID); err != nil {
+				t.Fatalf("JoinRoom error: %v", err)
+			}
+			users = append(users, user)
+		}
+		return app, room, users
+	}
+
+	owner := app.CreateUser(ownerName)
+	room := app.CreateRoom(name, owner.ID)
+	if _, err := app.JoinRoom(owner.ID, room.ID); err != nil {
+		t.Fatalf("JoinRoom error: %v", err)
+	}
+	users := []*User{owner}
+	if len(memberNames) == 0 {
+		return app, room, users
+	}
+	code, err := app.CreateJoinCode(room.ID, owner.ID, 0, 0)
+	if err != nil {
+		t.Fatalf("CreateJoinCode error: %v", err)
+	}
+	for _, memberName := range memberNames {
+		user := app.CreateUser(memberName)
+		if _, err := app.RedeemJoinCode(user.ID, code.Code); err != nil {
+			t.Fatalf("RedeemJoinCode error: %v", err)
+		}
+		users = append(users, user)
+	}
+	return app, room, users
+}
+
 func mustLoadTestJSON(t *testing.T, filename string, placeholders map[string]string) []byte {
 	t.Helper()
 
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	tlsDirName             = "tls"
+	tlsCertFileName        = "cert.pem"
+	tlsKeyFileName         = "key.pem"
+	selfSignedCertValidity = 5 * 365 * 24 * time.Hour
+)
+
+// TLSOptions configures HTTPS for the host API. When enabled without CertFile the
+// host serves a self-signed certificate kept in the data directory, so clients
+// that pinned its fingerprint keep trusting it across restarts.
+type TLSOptions struct {
+	Enabled  bool
+	CertFile string
+	KeyFile  string
+}
+
+// certFingerprint identifies a certificate by the SHA-256 of its DER encoding.
+func certFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// hostTLSConfig loads the host certificate and records its fingerprint for the
+// zeroconf announcement.
+func (a *App) hostTLSConfig() (*tls.Config, error) {
+	var cert tls.Certificate
+	var err error
+	if a.tlsOptions.CertFile != "" || a.tlsOptions.KeyFile != "" {
+		cert, err = tls.LoadX509KeyPair(a.tlsOptions.CertFile, a.tlsOptions.KeyFile)
+	} else {
+		dir := ""
+		if a.dataDir != "" {
+			dir = filepath.Join(a.dataDir, tlsDirName)
+		}
+		cert, err = loadOrCreateSelfSigned(dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	a.tlsFingerprint = certFingerprint(cert.Certificate[0])
+	a.mu.Unlock()
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// loadOrCreateSelfSigned returns the self-signed certificate kept in dir,
+// creating it on first use. Without a dir the certificate lasts until the app exits.
+func loadOrCreateSelfSigned(dir string) (tls.Certificate, error) {
+	certPath := filepath.Join(dir, tlsCertFileName)
+	keyPath := filepath.Join(dir, tlsKeyFileName)
+	if dir != "" {
+		cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err == nil {
+			return cert, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return tls.Certificate{}, fmt.Errorf("failed to load certificate from %s: %w", dir, err)
+		}
+	}
+
+	certPEM, keyPEM, err := generateSelfSigned()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return tls.Certificate{}, err
+		}
+		if err := os.WriteFile(keyPath, keyPEM, 0o600); err != nil {
+			return tls.Certificate{}, err
+		}
+		if err := os.WriteFile(certPath, certPEM, 0o644); err != nil {
+			return tls.Certificate{}, err
+		}
+		fmt.Printf("Created self-signed certificate in %s\n", dir)
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+func generateSelfSigned() ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "GoTeamWork host"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(selfSignedCertValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		template.DNSNames = append(template.DNSNames, hostname)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// zeroconfText is the TXT record announced with the host, carrying the
+// certificate fingerprint clients pin when the API is served over HTTPS.
+func (a *App) zeroconfText() []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	text := []string{"version=1.0"}
+	if a.tlsFingerprint != "" {
+		text = append(text, "scheme=https", "fingerprint="+a.tlsFingerprint)
+	}
+	return text
+}
+
+// localBaseURL is where the host's own API answers on this machine.
+func (a *App) localBaseURL() string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.tlsFingerprint != "" {
+		return "https://localhost:8080"
+	}
+	return "http://localhost:8080"
+}
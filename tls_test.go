@@ -0,0 +1,224 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/grandcat/zeroconf"
+)
+
+// newPinningTestServer starts an HTTPS server with a certificate of its own, as
+// httptest servers otherwise share one. A nil handler answers 200 to everything.
+func newPinningTestServer(t *testing.T, handler http.HandlerFunc) (*httptest.Server, string) {
+	t.Helper()
+	cert, err := loadOrCreateSelfSigned("")
+	if err != nil {
+		t.Fatalf("loadOrCreateSelfSigned error: %v", err)
+	}
+	if handler == nil {
+		handler = func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	}
+	ts := httptest.NewUnstartedServer(handler)
+	ts.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+	return ts, certFingerprint(cert.Certificate[0])
+}
+
+// Verifies that the self-signed host certificate survives a restart and that
+// its fingerprint is announced over zeroconf.
+func TestHostSelfSignedCertificate(t *testing.T) {
+	app := newTestApp()
+	app.dataDir = t.TempDir()
+	app.tlsOptions = TLSOptions{Enabled: true}
+
+	if text := app.zeroconfText(); len(text) != 1 || app.localBaseURL() != "http://localhost:8080" {
+		t.Fatalf("expected a plain HTTP announcement before TLS is set up, got %v", text)
+	}
+	config, err := app.hostTLSConfig()
+	if err != nil {
+		t.Fatalf("hostTLSConfig error: %v", err)
+	}
+	first := app.tlsFingerprint
+	if !strings.HasPrefix(first, "sha256:") || first != certFingerprint(config.Certificates[0].Certificate[0]) {
+		t.Fatalf("unexpected fingerprint %q", first)
+	}
+	text := strings.Join(app.zeroconfText(), " ")
+	if !strings.Contains(text, "scheme=https") || !strings.Contains(text, "fingerprint="+first) {
+		t.Fatalf("expected the TXT record to carry the fingerprint, got %q", text)
+	}
+
+	restarted := newTestApp()
+	restarted.dataDir = app.dataDir
+	if _, err := restarted.hostTLSConfig(); err != nil {
+		t.Fatalf("hostTLSConfig after restart error: %v", err)
+	}
+	if restarted.tlsFingerprint != first {
+		t.Fatalf("expected the certificate to be reused, got %s want %s", restarted.tlsFingerprint, first)
+	}
+
+	mismatched := newTestApp()
+	mismatched.tlsOptions = TLSOptions{Enabled: true, CertFile: filepath.Join(app.dataDir, tlsDirName, tlsCertFileName)}
+	if _, err := mismatched.hostTLSConfig(); err == nil {
+		t.Fatalf("expected a certificate without its key to be refused")
+	}
+}
+
+// Verifies that NetworkClient only talks to the host whose fingerprint it
+// pinned, and pins a self-signed host on first use.
+func TestNetworkClientPinsHostCertificate(t *testing.T) {
+	ts, fingerprint := newPinningTestServer(t, nil)
+	other, otherFingerprint := newPinningTestServer(t, nil)
+
+	nc := NewNetworkClient(ts.URL)
+	nc.PinFingerprint(fingerprint, nil)
+	if err := nc.Ping(); err != nil {
+		t.Fatalf("expected the pinned host to be trusted, got %v", err)
+	}
+
+	nc.PinFingerprint(otherFingerprint, nil)
+	if err := nc.Ping(); !errors.Is(err, errFingerprintMismatch) {
+		t.Fatalf("expected a different certificate to be refused, got %v", err)
+	}
+
+	var firstUse string
+	nc.PinFingerprint("", func(seen string) { firstUse = seen })
+	if err := nc.Ping(); err != nil {
+		t.Fatalf("expected trust on first use, got %v", err)
+	}
+	if firstUse != fingerprint || nc.PinnedFingerprint() != fingerprint {
+		t.Fatalf("expected %s to be pinned on first use, got %q", fingerprint, firstUse)
+	}
+	nc.serverURL = other.URL
+	if err := nc.Ping(); !errors.Is(err, errFingerprintMismatch) {
+		t.Fatalf("expected a swapped certificate to be refused after first use, got %v", err)
+	}
+}
+
+// Verifies that pins learned on first use and through discovery are kept per
+// host across restarts, and that a pin can be forgotten.
+func TestClientKnownHosts(t *testing.T) {
+	ts, fingerprint := newPinningTestServer(t, nil)
+	dataDir := t.TempDir()
+	newClient := func() *App {
+		app := newTestApp()
+		app.Mode = "client"
+		app.dataDir = dataDir
+		app.networkClient = NewNetworkClient("")
+		app.SetServerURL(ts.URL)
+		return app
+	}
+
+	app := newClient()
+	if err := app.networkClient.Ping(); err != nil {
+		t.Fatalf("Ping error: %v", err)
+	}
+	restarted := newClient()
+	if restarted.networkClient.PinnedFingerprint() != fingerprint {
+		t.Fatalf("expected the pin to survive a restart, got %q", restarted.networkClient.PinnedFingerprint())
+	}
+	if err := restarted.ForgetServerFingerprint(); err != nil || restarted.networkClient.PinnedFingerprint() != "" {
+		t.Fatalf("expected the pin to be forgotten, got %q err %v", restarted.networkClient.PinnedFingerprint(), err)
+	}
+	restarted.SetServerURL("http://localhost:8080")
+	if err := restarted.ForgetServerFingerprint(); err == nil {
+		t.Fatalf("expected plain HTTP servers to have no pin to forget")
+	}
+
+	entry := zeroconf.NewServiceEntry(zeroconfInstance, zeroconfService, zeroconfDomain)
+	entry.AddrIPv4 = []net.IP{net.IPv4(192, 168, 1, 20)}
+	entry.Port = 8080
+	entry.Text = []string{"version=1.0", "scheme=https", "fingerprint=" + fingerprint}
+	host, ok := discoveredHost(entry)
+	if !ok || host.URL != "https://192.168.1.20:8080" || host.Fingerprint != fingerprint {
+		t.Fatalf("unexpected discovered host %+v", host)
+	}
+	entry.Text = []string{"version=1.0"}
+	if host, _ := discoveredHost(entry); host.URL != "http://192.168.1.20:8080" || host.Fingerprint != "" {
+		t.Fatalf("expected a plain HTTP host, got %+v", host)
+	}
+	entry.Instance = "SomethingElse"
+	if _, ok := discoveredHost(entry); ok {
+		t.Fatalf("expected other services to be ignored")
+	}
+
+	if pinned := restarted.hostPins().remember("192.168.1.20:8080", fingerprint); pinned != fingerprint {
+		t.Fatalf("expected the discovered fingerprint to be pinned, got %q", pinned)
+	}
+	if pinned := restarted.hostPins().remember("192.168.1.20:8080", "sha256:other"); pinned != fingerprint {
+		t.Fatalf("expected an existing pin to win over a new announcement, got %q", pinned)
+	}
+}
+
+// Verifies that the UI reaches an HTTPS host through the loopback proxy, which
+// trusts only the pinned certificate, and a plain HTTP host directly.
+func TestHostAPIURLProxiesPinnedHost(t *testing.T) {
+	host := newTestApp()
+	ts, fingerprint := newPinningTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/ws" {
+			host.handleWebSocket(w, r)
+			return
+		}
+		w.Write([]byte("host saw " + r.URL.Path + "?" + r.URL.RawQuery))
+	})
+	other, _ := newPinningTestServer(t, nil)
+
+	app := newTestApp()
+	app.Mode = "client"
+	app.networkClient = NewNetworkClient("")
+	app.SetServerURL("http://localhost:8080")
+	if base, err := app.HostAPIURL(); err != nil || base != "http://localhost:8080" {
+		t.Fatalf("expected plain HTTP hosts to be used directly, got %q (%v)", base, err)
+	}
+
+	app.SetServerURL(ts.URL)
+	base, err := app.HostAPIURL()
+	if err != nil || !strings.HasPrefix(base, "http://127.0.0.1:") {
+		t.Fatalf("expected a loopback proxy for an HTTPS host, got %q (%v)", base, err)
+	}
+	resp, err := http.Get(base + "/api/users?token=abc")
+	if err != nil {
+		t.Fatalf("proxy request error: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "host saw /api/users?token=abc" {
+		t.Fatalf("expected the request to reach the host, got %d %q", resp.StatusCode, body)
+	}
+	if app.networkClient.PinnedFingerprint() != fingerprint {
+		t.Fatalf("expected UI traffic to pin the host on first use")
+	}
+	user := host.CreateUser("Viewer")
+	token, _ := host.issueToken(user.ID)
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(base, "http")+"/api/ws?token="+token, nil)
+	if err != nil {
+		t.Fatalf("expected WebSockets to pass through the proxy: %v", err)
+	}
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if evt := readWSEvent(t, ws); evt.Type != string(EventConnected) {
+		t.Fatalf("expected the host's connected event, got %s", evt.Type)
+	}
+	ws.Close()
+	if resp, err := http.Get(base + "/index.html"); err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected only API paths to be proxied, got %v", err)
+	}
+
+	// Same pin, different certificate: as if the host were impersonated
+	app.networkClient.serverURL = other.URL
+	resp, err = http.Get(base + "/api/users")
+	if err != nil || resp.StatusCode != http.StatusBadGateway {
+		t.Fatalf("expected a different certificate to be refused, got %v", err)
+	}
+	if again, _ := app.HostAPIURL(); again != base {
+		t.Fatalf("expected the proxy to be started once, got %s and %s", base, again)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"sync"
 	"time"
 
@@ -9,19 +10,51 @@ import (
 
 // User represents a user in the system
 type User struct {
-	ID       string  `json:"id"`
-	Name     string  `json:"name"`
-	RoomID   *string `json:"roomId,omitempty"` // nil if not in any room
-	IsOnline bool    `json:"isOnline"`
+	ID        string  `json:"id"`
+	Name      string  `json:"name"`
+	RoomID    *string `json:"roomId,omitempty"` // nil if not in any room
+	IsOnline  bool    `json:"isOnline"`
+	PublicKey string  `json:"publicKey,omitempty"` // X25519 key for encrypted rooms, base64
+
+	OfflineSince int64 `json:"offlineSince,omitempty"` // unix seconds; offline users are removed after userTimeout
 }
 
 // Room represents a collaboration room
 type Room struct {
-	ID              string   `json:"id"`
-	Name            string   `json:"name"`
-	OwnerID         string   `json:"ownerId"`
-	UserIDs         []string `json:"userIds"`
-	ApprovedUserIDs []string `json:"approvedUserIds"` // Users allowed to join
+	ID              string         `json:"id"`
+	Name            string         `json:"name"`
+	OwnerID         string         `json:"ownerId"`
+	UserIDs         []string       `json:"userIds"`
+	ApprovedUserIDs []string       `json:"approvedUserIds"`     // Users allowed to join
+	Retention       *RoomRetention `json:"retention,omitempty"` // nil uses the host defaults
+	ModeratorIDs    []string       `json:"moderatorIds,omitempty"`
+	ViewerIDs       []string       `json:"viewerIds,omitempty"`     // read-only members
+	BannedUserIDs   []string       `json:"bannedUserIds,omitempty"` // may not rejoin until unbanned
+	MutedUserIDs    []string       `json:"mutedUserIds,omitempty"`
+	Encrypted       bool           `json:"encrypted,omitempty"` // members seal all content; the host sees only ciphertext
+	KeyEpoch        int            `json:"keyEpoch,omitempty"`  // current room key epoch of an encrypted room
+}
+
+// RoomRetention bounds how much history a room keeps. Zero fields use the host
+// default: maxOperationsPerRoom operations, no age limit and no file quota.
+type RoomRetention struct {
+	MaxOperations int   `json:"maxOperations,omitempty"`
+	MaxAgeSeconds int64 `json:"maxAgeSeconds,omitempty"` // items older than this are removed
+	MaxFileBytes  int64 `json:"maxFileBytes,omitempty"`  // oldest shared files are removed beyond this total
+}
+
+// RoomSettings is the body of the room settings API.
+type RoomSettings struct {
+	RoomID    string        `json:"roomId,omitempty"`
+	Retention RoomRetention `json:"retention"`
+}
+
+// RetentionNotice tells room members which items aged out under the room's retention policy.
+type RetentionNotice struct {
+	RoomID     string   `json:"roomId"`
+	Reason     string   `json:"reason"` // "max_age" or "max_file_bytes"
+	ItemIDs    []string `json:"itemIds"`
+	FreedBytes int64    `json:"freedBytes,omitempty"`
 }
 
 // ChatMessage represents a chat message
@@ -32,6 +65,9 @@ type ChatMessage struct {
 	UserName  string `json:"userName"`
 	Message   string `json:"message"`
 	Timestamp int64  `json:"timestamp"`
+	EditedAt  int64  `json:"editedAt,omitempty"`
+
+	ImportedFrom string `json:"importedFrom,omitempty"` // author named in the import bundle, shown but not trusted
 }
 
 // ChatPool manages chat history for all rooms
@@ -48,6 +84,12 @@ const (
 	OpAdd    OperationType = "add"
 	OpRemove OperationType = "remove"
 	OpModify OperationType = "modify"
+	// OpSnapshot stands in for compacted history; it carries the ID and hash of the
+	// last operation it replaced so the retained tail still links to it.
+	OpSnapshot OperationType = "snapshot"
+	// OpImport opens a room seeded from an exported bundle and records the
+	// source chain it was verified against.
+	OpImport OperationType = "import"
 )
 
 // ItemType represents the type of item
@@ -56,13 +98,110 @@ type ItemType string
 const (
 	ItemChat      ItemType = "chat"
 	ItemClipboard ItemType = "clipboard"
+	ItemSnapshot  ItemType = "snapshot"
+	ItemImport    ItemType = "import"
 )
 
 // Item represents a data item in the history
 type Item struct {
 	ID   string      `json:"id"`
 	Type ItemType    `json:"type"`
-	Data interface{} `json:"data"` // ChatMessage or ClipboardItem
+	Data interface{} `json:"data"` // ChatMessage, ClipboardItem, HistorySnapshot or ImportProvenance
+}
+
+// HistorySnapshot is the materialised room state at a compaction point.
+type HistorySnapshot struct {
+	Chat      []*SnapshotEntry `json:"chat"`
+	Clipboard []*SnapshotEntry `json:"clipboard"`
+}
+
+// SnapshotEntry is a live item captured in a snapshot, along with the
+// operation that produced it so downloads by operation ID keep working.
+type SnapshotEntry struct {
+	OpID      string `json:"opId"`
+	ItemID    string `json:"itemId"`
+	UserID    string `json:"userId,omitempty"`
+	UserName  string `json:"userName,omitempty"`
+	Timestamp int64  `json:"timestamp"`
+	Item      *Item  `json:"item"`
+}
+
+// ImportProvenance describes the exported history a room was seeded from.
+// It is hashed into the room's first operation, so the link to the source
+// chain is covered by the new room's own chain.
+type ImportProvenance struct {
+	SourceRoomID   string          `json:"sourceRoomId"`
+	SourceRoomName string          `json:"sourceRoomName"`
+	ExportedAt     int64           `json:"exportedAt"`
+	HeadHash       string          `json:"headHash"`           // last hash of the verified source chain
+	BaseHash       string          `json:"baseHash,omitempty"` // set when the source history was trimmed or compacted
+	Operations     int             `json:"operations"`
+	Items          []*ImportedItem `json:"items"`
+}
+
+// ImportedItem maps a recreated item to the source operation that added it and
+// the author that operation claims.
+type ImportedItem struct {
+	ItemID         string `json:"itemId"`
+	SourceItemID   string `json:"sourceItemId"`
+	SourceOpID     string `json:"sourceOpId"`
+	SourceUserID   string `json:"sourceUserId"`
+	SourceUserName string `json:"sourceUserName"`
+}
+
+// UnmarshalJSON decodes Data into the concrete type implied by Type so that
+// replayed or fetched operations materialise the same way as live ones.
+func (i *Item) UnmarshalJSON(b []byte) error {
+	var raw struct {
+		ID   string          `json:"id"`
+		Type ItemType        `json:"type"`
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+
+	i.ID = raw.ID
+	i.Type = raw.Type
+	i.Data = nil
+
+	if len(raw.Data) == 0 || string(raw.Data) == "null" {
+		return nil
+	}
+
+	switch raw.Type {
+	case ItemChat:
+		var msg ChatMessage
+		if err := json.Unmarshal(raw.Data, &msg); err != nil {
+			return err
+		}
+		i.Data = &msg
+	case ItemClipboard:
+		var clip clip_helper.ClipboardItem
+		if err := json.Unmarshal(raw.Data, &clip); err != nil {
+			return err
+		}
+		i.Data = &clip
+	case ItemSnapshot:
+		var snap HistorySnapshot
+		if err := json.Unmarshal(raw.Data, &snap); err != nil {
+			return err
+		}
+		i.Data = &snap
+	case ItemImport:
+		var prov ImportProvenance
+		if err := json.Unmarshal(raw.Data, &prov); err != nil {
+			return err
+		}
+		i.Data = &prov
+	default:
+		var generic interface{}
+		if err := json.Unmarshal(raw.Data, &generic); err != nil {
+			return err
+		}
+		i.Data = generic
+	}
+	return nil
 }
 
 // Operation represents a git-style operation on the history
@@ -77,18 +216,72 @@ type Operation struct {
 	Timestamp  int64         `json:"timestamp"`
 	UserID     string        `json:"userId,omitempty"`
 	UserName   string        `json:"userName,omitempty"`
+	Redacted   bool          `json:"redacted,omitempty"` // payload scrubbed after the item was deleted
 }
 
-// CreateUserResponse bundles a user with an auth token for client use.
+// ItemRemovedEvent is broadcast when a chat message or clipboard item is deleted.
+type ItemRemovedEvent struct {
+	RoomID      string `json:"roomId"`
+	ItemID      string `json:"itemId"`
+	OperationID string `json:"operationId"`
+	RemovedBy   string `json:"removedBy"`
+}
+
+// TypingEvent is relayed to room members while a user is composing a message.
+type TypingEvent struct {
+	RoomID   string `json:"roomId"`
+	UserID   string `json:"userId"`
+	UserName string `json:"userName"`
+	Typing   bool   `json:"typing"`
+}
+
+// PresenceEvent announces a connected user's self-reported status, e.g. "active" or "away".
+type PresenceEvent struct {
+	UserID string `json:"userId"`
+	Status string `json:"status"`
+}
+
+// ChainVerification reports the result of recomputing a room's operation hash chain.
+type ChainVerification struct {
+	RoomID        string `json:"roomId"`
+	Valid         bool   `json:"valid"`
+	Checked       int    `json:"checked"`                // operations verified before stopping
+	HeadHash      string `json:"headHash,omitempty"`     // hash of the last verified operation
+	TrimmedPrefix bool   `json:"trimmedPrefix"`          // older operations were dropped from history
+	BaseHash      string `json:"baseHash,omitempty"`     // parent hash of the oldest retained operation
+	SnapshotID    string `json:"snapshotId,omitempty"`   // set when the chain is anchored by a compaction snapshot
+	Redacted      int    `json:"redacted,omitempty"`     // operations whose payload was scrubbed; only their links are checked
+	BrokenAt      string `json:"brokenAt,omitempty"`     // ID of the first operation that failed
+	Reason        string `json:"reason,omitempty"`       // hash_mismatch, parent_mismatch, missing_parent or redacted_payload
+	ExpectedHash  string `json:"expectedHash,omitempty"` // recomputed hash, or the parent hash that was expected
+}
+
+// CreateUserResponse bundles a user with the tokens of its first session.
 type CreateUserResponse struct {
-	User  *User  `json:"user"`
-	Token string `json:"token"`
+	User *User `json:"user"`
+	SessionTokens
+}
+
+// SessionTokens are a short-lived access token and the single-use refresh token
+// that renews it through /api/auth/refresh.
+type SessionTokens struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
+	ExpiresAt    int64  `json:"expiresAt"` // access token expiry, unix seconds
+}
+
+// RefreshRequest represents a request to renew an access token
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
 }
 
 // HistoryPool manages operations for all rooms
 type HistoryPool struct {
 	operations map[string][]*Operation // roomID -> operations
 	counter    int
+	index      *searchIndex   // full-text index over live items
+	refs       *opIndex       // operation ID/hash lookups
+	limits     map[string]int // roomID -> operation limit, when not maxOperationsPerRoom
 	mu         sync.RWMutex
 }
 
@@ -100,7 +293,8 @@ type ClipboardUploadRequest struct {
 }
 
 type CreateUserRequest struct {
-	Name string `json:"name"`
+	Name      string `json:"name"`
+	DeviceKey string `json:"deviceKey,omitempty"` // restores the user created with the same key, if it still exists
 }
 
 type InviteUserRequest struct {
@@ -120,6 +314,19 @@ type ChatMessageRequest struct {
 	Message string `json:"message"`
 }
 
+type EditChatMessageRequest struct {
+	UserID  string `json:"userId"`
+	Message string `json:"message"`
+}
+
+// EditClipboardItemRequest changes the text or display metadata of a clipboard item.
+// Nil fields are left unchanged.
+type EditClipboardItemRequest struct {
+	UserID         string  `json:"userId"`
+	Text           *string `json:"text,omitempty"`
+	SingleFileName *string `json:"singleFileName,omitempty"`
+}
+
 type DownloadFileRequest struct {
 	OperationID string `json:"operationId"`
 	RoomID      string `json:"roomId"`
@@ -135,7 +342,8 @@ type JoinRoomRequest struct {
 }
 
 type CreateRoomRequest struct {
-	Name string `json:"name"`
+	Name      string `json:"name"`
+	Encrypted bool   `json:"encrypted,omitempty"`
 }
 
 type APIResponse struct {
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// WSFrameType identifies a client-to-host WebSocket frame, or a host reply to one.
+type WSFrameType string
+
+const (
+	WSFrameChat     WSFrameType = "chat"
+	WSFrameTyping   WSFrameType = "typing"
+	WSFramePresence WSFrameType = "presence"
+	WSFrameAck      WSFrameType = "ack"
+	WSFrameReply    WSFrameType = "reply"
+)
+
+const (
+	wsWriteTimeout      = 10 * time.Second
+	wsMaxFrameSize      = 64 * 1024
+	wsHeartbeatInterval = 30 * time.Second
+	maxPresenceStatus   = 32
+)
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	// Same policy as the REST API's CORS headers; requests are authorized by token
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// wsFrameRateClass is the rate limit class an upstream frame is charged to, the
+// same as the REST route with the same effect.
+func wsFrameRateClass(frameType WSFrameType) RateClass {
+	switch frameType {
+	case WSFrameChat:
+		return RateClassChat
+	case WSFrameAck:
+		return RateClassRead
+	default:
+		return RateClassDefault
+	}
+}
+
+// WSFrame is a framed message sent upstream over /api/ws. Ref is an optional
+// client-chosen correlation ID echoed in the host's reply.
+type WSFrame struct {
+	Type WSFrameType     `json:"type"`
+	Ref  string          `json:"ref,omitempty"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// WSReply answers a WSFrame. It is sent for every failed frame, and for
+// successful ones that carried a Ref.
+type WSReply struct {
+	Type  WSFrameType `json:"type"`
+	Ref   string      `json:"ref,omitempty"`
+	OK    bool        `json:"ok"`
+	Error string      `json:"error,omitempty"`
+	Data  interface{} `json:"data,omitempty"`
+}
+
+type wsChatFrame struct {
+	RoomID  string `json:"roomId"`
+	Message string `json:"message"`
+}
+
+type wsTypingFrame struct {
+	RoomID string `json:"roomId"`
+	Typing bool   `json:"typing"`
+}
+
+type wsPresenceFrame struct {
+	Status string `json:"status"`
+}
+
+type wsAckFrame struct {
+	LastEventID uint64 `json:"lastEventId"`
+}
+
+// handleWebSocket handles GET /api/ws. Downstream it carries the same events as
+// /api/sse, as JSON envelopes; upstream it accepts chat, typing, presence and ack frames.
+func (a *App) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	// Browsers cannot set headers on a WebSocket handshake, so the token may come as a query param
+	token := bearerToken(r)
+	if token == "" {
+		token = r.URL.Query().Get("token")
+	}
+	authUser, claims, err := a.verifyToken(token)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID, err := enforceUserMatch(r.URL.Query().Get("userId"), authUser)
+	if err != nil {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	// Frames are limited per user and address like the REST routes they stand in for
+	limitKeys := []string{"ip:" + clientIP(r), "user:" + userID}
+
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already written an error response
+		fmt.Printf("WebSocket upgrade failed for user %s: %v\n", userID, err)
+		return
+	}
+	defer conn.Close()
+	conn.SetReadLimit(wsMaxFrameSize)
+
+	client, _ := a.sseManager.ResumeWebSocketClient(userID, conn, lastEventID(r))
+	a.sseManager.bindSession(client, claims.SessionID)
+	a.markUserOnline(userID)
+	fmt.Printf("WebSocket connected for user: %s\n", userID)
+
+	if err := a.sseManager.SendToClient(userID, EventConnected, map[string]string{"status": "connected"}); err != nil {
+		fmt.Printf("Failed to send initial WebSocket event for user %s: %v\n", userID, err)
+	}
+
+	defer func() {
+		a.sseManager.RemoveClient(client)
+		// Wait for the writer so no frame is written once the handler has returned
+		<-client.Done()
+		fmt.Printf("WebSocket disconnected for user: %s\n", userID)
+		time.AfterFunc(sseReconnectGrace, func() { a.cleanupDisconnectedUser(userID) })
+	}()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(wsHeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := a.sseManager.SendHeartbeat(userID); err != nil {
+					conn.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		var frame WSFrame
+		if err := conn.ReadJSON(&frame); err != nil {
+			if _, ok := err.(*websocket.CloseError); !ok && !strings.Contains(err.Error(), "use of closed network connection") {
+				fmt.Printf("WebSocket read failed for user %s: %v\n", userID, err)
+			}
+			return
+		}
+
+		var reply *WSReply
+		if ok, wait := a.rateLimiter.allow(wsFrameRateClass(frame.Type), limitKeys); !ok {
+			reply = &WSReply{
+				Type:  WSFrameReply,
+				Ref:   frame.Ref,
+				Error: "Too many requests",
+				Data:  map[string]int{"retryAfter": int(math.Ceil(wait.Seconds()))},
+			}
+		} else {
+			reply = a.handleWSFrame(userID, &frame)
+		}
+		if reply.OK && frame.Ref == "" {
+			continue
+		}
+		if err := client.writeJSON(reply); err != nil {
+			fmt.Printf("WebSocket reply to %s failed: %v\n", userID, err)
+			return
+		}
+	}
+}
+
+// handleWSFrame applies one upstream frame on behalf of userID.
+func (a *App) handleWSFrame(userID string, frame *WSFrame) *WSReply {
+	reply := &WSReply{Type: WSFrameReply, Ref: frame.Ref}
+	fail := func(msg string) *WSReply {
+		reply.Error = msg
+		return reply
+	}
+
+	switch frame.Type {
+	case WSFrameChat:
+		var req wsChatFrame
+		if err := json.Unmarshal(frame.Data, &req); err != nil || req.RoomID == "" {
+			return fail("Invalid chat frame")
+		}
+		result := a.SendChatMessage(req.RoomID, userID, req.Message)
+		if strings.HasPrefix(result, "Error") {
+			return fail(result)
+		}
+		reply.Data = map[string]string{"messageId": strings.TrimPrefix(result, "Message sent: ")}
+
+	case WSFrameTyping:
+		var req wsTypingFrame
+		if err := json.Unmarshal(frame.Data, &req); err != nil || req.RoomID == "" {
+			return fail("Invalid typing frame")
+		}
+		a.mu.RLock()
+		room, exists := a.rooms[req.RoomID]
+		user := a.users[userID]
+		var members []string
+		if exists {
+			members = append(members, room.UserIDs...)
+		}
+		a.mu.RUnlock()
+		if !exists || !contains(members, userID) || user == nil {
+			return fail("Error: User is not in this room")
+		}
+		a.sseManager.BroadcastToUsers(members, EventTyping, &TypingEvent{
+			RoomID:   req.RoomID,
+			UserID:   userID,
+			UserName: user.Name,
+			Typing:   req.Typing,
+		}, userID)
+
+	case WSFramePresence:
+		var req wsPresenceFrame
+		if err := json.Unmarshal(frame.Data, &req); err != nil {
+			return fail("Invalid presence frame")
+		}
+		status := strings.TrimSpace(req.Status)
+		if status == "" || len(status) > maxPresenceStatus {
+			return fail("Invalid presence status")
+		}
+		a.sseManager.BroadcastToAll(EventPresence, &PresenceEvent{UserID: userID, Status: status})
+
+	case WSFrameAck:
+		var req wsAckFrame
+		if err := json.Unmarshal(frame.Data, &req); err != nil {
+			return fail("Invalid ack frame")
+		}
+		a.sseManager.AckEvents(userID, req.LastEventID)
+
+	default:
+		return fail(fmt.Sprintf("Unknown frame type %q", frame.Type))
+	}
+
+	reply.OK = true
+	return reply
+}
+
+// writeJSON writes a non-event frame, such as a reply, to a WebSocket client.
+func (client *SSEClient) writeJSON(v interface{}) error {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	if client.conn == nil {
+		return fmt.Errorf("client %s is not a WebSocket client", client.UserID)
+	}
+	client.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	return client.conn.WriteJSON(v)
+}
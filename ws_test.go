@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func dialTestWebSocket(t *testing.T, app *App, userID string) *websocket.Conn {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(app.handleWebSocket))
+	t.Cleanup(srv.Close)
+
+	token, _ := app.issueToken(userID)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"?token="+token, nil)
+	if err != nil {
+		t.Fatalf("dial error: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+func readWSEvent(t *testing.T, conn *websocket.Conn) sseEnvelope {
+	t.Helper()
+	var envelope sseEnvelope
+	if err := conn.ReadJSON(&envelope); err != nil {
+		t.Fatalf("read event: %v", err)
+	}
+	return envelope
+}
+
+func readWSReply(t *testing.T, conn *websocket.Conn) WSReply {
+	t.Helper()
+	var reply WSReply
+	if err := conn.ReadJSON(&reply); err != nil {
+		t.Fatalf("read reply: %v", err)
+	}
+	return reply
+}
+
+// Ensures upstream chat and typing frames reach SSE members through the shared
+// routing, and downstream events arrive as numbered envelopes.
+func TestWebSocketSharesEventRouting(t *testing.T) {
+	app, room, members := newTestRoom(t, "Archive", "", "Alice")
+	alice := members[0]
+	bob := app.CreateUser("Bob")
+	if _, err := app.JoinRoom(bob.ID, room.ID); err != nil {
+		t.Fatalf("JoinRoom error: %v", err)
+	}
+	bobConn := attachClient(app, bob.ID)
+
+	ws := dialTestWebSocket(t, app, alice.ID)
+	if evt := readWSEvent(t, ws); evt.Type != string(EventConnected) {
+		t.Fatalf("expected connected event first, got %s", evt.Type)
+	}
+
+	ws.WriteJSON(WSFrame{Type: WSFrameChat, Ref: "c1", Data: []byte(`{"roomId":"` + room.ID + `","message":"over the socket"}`)})
+	reply := readWSReply(t, ws)
+	if !reply.OK || reply.Ref != "c1" {
+		t.Fatalf("expected chat frame acknowledged, got %+v", reply)
+	}
+	evt, ok := findEvent(bobConn.Events(), EventChatMessage)
+	if !ok {
+		t.Fatalf("expected SSE member to receive the chat message")
+	}
+	if msg := decodeEventPayload[ChatMessage](t, evt); msg.Message != "over the socket" || msg.UserID != alice.ID {
+		t.Fatalf("unexpected relayed message %+v", msg)
+	}
+
+	ws.WriteJSON(WSFrame{Type: WSFrameTyping, Ref: "t1", Data: []byte(`{"roomId":"` + room.ID + `","typing":true}`)})
+	if reply := readWSReply(t, ws); !reply.OK {
+		t.Fatalf("expected typing frame accepted, got %+v", reply)
+	}
+	if evt, ok := findEvent(bobConn.Events(), EventTyping); !ok || !decodeEventPayload[TypingEvent](t, evt).Typing {
+		t.Fatalf("expected typing event relayed to SSE member")
+	}
+
+	app.SendChatMessage(room.ID, bob.ID, "back over SSE routing")
+	down := readWSEvent(t, ws)
+	if down.Type != string(EventChatMessage) || down.ID == 0 {
+		t.Fatalf("expected numbered chat_message envelope, got %+v", down)
+	}
+
+	ws.WriteJSON(WSFrame{Type: WSFrameAck, Ref: "a1", Data: []byte(fmt.Sprintf(`{"lastEventId":%d}`, down.ID))})
+	if reply := readWSReply(t, ws); !reply.OK {
+		t.Fatalf("expected ack accepted, got %+v", reply)
+	}
+	app.sseManager.mu.RLock()
+	buffered := len(app.sseManager.replay[alice.ID].since(0))
+	app.sseManager.mu.RUnlock()
+	if buffered != 0 {
+		t.Fatalf("expected acknowledged events released from the replay buffer, %d left", buffered)
+	}
+
+	ws.WriteJSON(WSFrame{Type: "shout"})
+	if reply := readWSReply(t, ws); reply.OK || !strings.Contains(reply.Error, "Unknown frame type") {
+		t.Fatalf("expected unknown frame rejected, got %+v", reply)
+	}
+}
+
+// Ensures the WebSocket endpoint requires the same token as the REST API.
+func TestWebSocketRequiresToken(t *testing.T) {
+	app := newTestApp()
+	srv := httptest.NewServer(http.HandlerFunc(app.handleWebSocket))
+	defer srv.Close()
+
+	_, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a token, got err=%v resp=%v", err, resp)
+	}
+}
+
+// Ensures upstream frames are charged to the rate limit class of the matching
+// REST route, and that a refused frame is answered without being applied.
+func TestWebSocketFramesAreRateLimited(t *testing.T) {
+	app, room, members := newTestRoom(t, "Archive", "", "Alice")
+	alice := members[0]
+	app.rateLimiter.SetLimits(map[RateClass]RateLimit{RateClassChat: {Rate: 0.01, Burst: 2}})
+	ws := dialTestWebSocket(t, app, alice.ID)
+	readWSEvent(t, ws)
+
+	chat := func(ref string) WSReply {
+		ws.WriteJSON(WSFrame{Type: WSFrameChat, Ref: ref, Data: []byte(`{"roomId":"` + room.ID + `","message":"flood ` + ref + `"}`)})
+		return readWSReply(t, ws)
+	}
+	for i := 0; i < 2; i++ {
+		if reply := chat(fmt.Sprint(i)); !reply.OK {
+			t.Fatalf("expected chat frame %d within the burst to pass, got %+v", i, reply)
+		}
+	}
+	reply := chat("over")
+	retry, _ := reply.Data.(map[string]interface{})["retryAfter"].(float64)
+	if reply.OK || reply.Ref != "over" || reply.Error != "Too many requests" || retry <= 0 {
+		t.Fatalf("expected the third chat frame to be refused with a retry delay, got %+v", reply)
+	}
+	if msgs := app.GetChatHistory(room.ID); len(msgs) != 2 {
+		t.Fatalf("expected only 2 messages to be applied, got %d", len(msgs))
+	}
+
+	ws.WriteJSON(WSFrame{Type: WSFrameTyping, Ref: "t", Data: []byte(`{"roomId":"` + room.ID + `","typing":true}`)})
+	if reply := readWSReply(t, ws); !reply.OK {
+		t.Fatalf("expected typing frames to keep their own limit, got %+v", reply)
+	}
+}